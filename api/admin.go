@@ -0,0 +1,257 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// isAdmin returns true if the user performing the request has admin privileges.
+func (a *API) isAdmin(r *Request) bool {
+	if r.UserID == "" {
+		return false
+	}
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return false
+	}
+	return user.Admin
+}
+
+// includeDeleted returns true if the request asks to include the deleted entities with
+// includeDeleted=true and the user performing it is an admin. It is ignored for anybody else.
+func (a *API) includeDeleted(r *Request) bool {
+	return r.Context.QueryParam("includeDeleted") == "true" && a.isAdmin(r)
+}
+
+// adminHandler wraps a handler so it is only executed for admin users.
+func (a *API) adminHandler(handlerFunc RouterHandlerFn) RouterHandlerFn {
+	return func(r *Request) (interface{}, error) {
+		if r.UserID == "" {
+			return nil, ErrUnauthorized
+		}
+		if !a.isAdmin(r) {
+			return nil, ErrAdminRequired
+		}
+		return handlerFunc(r)
+	}
+}
+
+// adminActiveBookingsHandler handles GET /admin/bookings/active
+// It returns the bookings currently active across the platform, sorted by start date.
+// The sort query parameter accepts asc (default) or desc.
+func (a *API) adminActiveBookingsHandler(r *Request) (interface{}, error) {
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	ascending := true
+	switch r.Context.QueryParam("sort") {
+	case "", "asc":
+	case "desc":
+		ascending = false
+	default:
+		return nil, ErrInvalidSortOrder
+	}
+
+	ctx := r.Context.Request.Context()
+	bookings, total, err := a.database.BookingService.GetActive(ctx, page, pageSize, ascending)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	usersByID, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	response := make([]AdminBookingResponse, len(bookings))
+	for i, booking := range bookings {
+		response[i] = convertBookingToAdminResponse(booking, usersByID, toolsByID)
+	}
+	return &AdminBookingsResponse{
+		Bookings: response,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// adminOrphanBookingsHandler handles GET /admin/bookings/orphans
+// It reports the bookings whose tool, requester or owner no longer exist.
+func (a *API) adminOrphanBookingsHandler(r *Request) (interface{}, error) {
+	orphans, err := a.database.BookingService.GetOrphans(r.Context.Request.Context())
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	response := make([]AdminOrphanBookingResponse, len(orphans))
+	for i, orphan := range orphans {
+		response[i] = AdminOrphanBookingResponse{
+			BookingResponse: convertBookingToResponse(&orphan.Booking),
+			MissingTool:     orphan.MissingTool,
+			MissingFromUser: orphan.MissingFromUser,
+			MissingToUser:   orphan.MissingToUser,
+		}
+	}
+	return &AdminOrphansResponse{Bookings: response}, nil
+}
+
+// adminFixOrphanBookingsHandler handles POST /admin/bookings/orphans/fix
+// It marks the orphan bookings as such and cancels the ones still in progress.
+func (a *API) adminFixOrphanBookingsHandler(r *Request) (interface{}, error) {
+	ctx := r.Context.Request.Context()
+	orphans, err := a.database.BookingService.GetOrphans(ctx)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	ids := make([]primitive.ObjectID, len(orphans))
+	for i, orphan := range orphans {
+		ids[i] = orphan.ID
+	}
+	cancelled, err := a.database.BookingService.MarkOrphans(ctx, ids)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("marked %d orphan bookings, %d cancelled", len(ids), cancelled)
+	a.audit(ctx, r, db.AuditOrphansFixed, "", "",
+		fmt.Sprintf("%d orphan bookings marked, %d cancelled", len(ids), cancelled))
+	return &AdminOrphansFixResponse{Orphaned: len(ids), Cancelled: cancelled}, nil
+}
+
+// adminFeatureToolHandler handles PUT /admin/tools/{id}/featured
+// It features or unfeatures a tool in the community of its owner.
+func (a *API) adminFeatureToolHandler(r *Request) (interface{}, error) {
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	var req FeaturedToolRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if _, err := a.tool(id); err != nil {
+		return nil, err
+	}
+	if err := a.database.ToolService.UpdateToolFields(r.Context.Request.Context(), id,
+		map[string]interface{}{"featured": req.Featured}); err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("tool %d featured set to %t by %s", id, req.Featured, r.UserID)
+	a.audit(r.Context.Request.Context(), r, db.AuditToolFeatured, db.AuditTargetTool,
+		strconv.FormatInt(id, 10), fmt.Sprintf("featured: %t", req.Featured))
+	return a.tool(id)
+}
+
+// adminRestoreToolHandler handles POST /admin/tools/{id}/restore
+// It clears the deleted and archived flags of a tool, making it visible again.
+func (a *API) adminRestoreToolHandler(r *Request) (interface{}, error) {
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if _, err := a.findTool(id, true); err != nil {
+		return nil, err
+	}
+	if err := a.database.ToolService.UpdateToolFields(r.Context.Request.Context(), id,
+		map[string]interface{}{"deleted": false, "archived": false}); err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("tool %d restored by %s", id, r.UserID)
+	a.audit(r.Context.Request.Context(), r, db.AuditToolRestored, db.AuditTargetTool, strconv.FormatInt(id, 10), "")
+	return a.tool(id)
+}
+
+// adminRestoreUserHandler handles POST /admin/users/{id}/restore
+// It clears the deleted flag of a user, making it visible again.
+func (a *API) adminRestoreUserHandler(r *Request) (interface{}, error) {
+	id, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+	result, err := a.database.UserService.UpdateUser(ctx, id, bson.M{"deleted": false})
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
+	user, err := a.database.UserService.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("user %s restored by %s", id.Hex(), r.UserID)
+	a.audit(ctx, r, db.AuditUserRestored, db.AuditTargetUser, id.Hex(), "")
+	return a.userResponse(ctx, user)
+}
+
+// adminDeactivateUserHandler handles POST /admin/users/{id}/deactivate
+// It deactivates an abusive account: the user can no longer log in and its tools are hidden from
+// the search.
+func (a *API) adminDeactivateUserHandler(r *Request) (interface{}, error) {
+	id, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+	result, err := a.database.UserService.UpdateUser(ctx, id, bson.M{"deactivated": true})
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
+	user, err := a.database.UserService.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("user %s deactivated by %s", id.Hex(), r.UserID)
+	a.audit(ctx, r, db.AuditUserDeactivated, db.AuditTargetUser, id.Hex(), "")
+	return a.userResponse(ctx, user)
+}
+
+// adminDeleteToolHandler handles DELETE /admin/tools/{id}
+// It removes any tool regardless of its owner, archiving it if bookings reference it.
+func (a *API) adminDeleteToolHandler(r *Request) (interface{}, error) {
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if _, err := a.tool(id); err != nil {
+		return nil, err
+	}
+	ctx := r.Context.Request.Context()
+	if err := a.removeTool(ctx, id); err != nil {
+		return nil, err
+	}
+	log.Info().Msgf("tool %d deleted by %s", id, r.UserID)
+	a.audit(ctx, r, db.AuditToolDeleted, db.AuditTargetTool, strconv.FormatInt(id, 10), "")
+	return nil, nil
+}
+
+// convertBookingToAdminResponse converts a db.Booking to an AdminBookingResponse using the
+// users and tools previously fetched.
+func convertBookingToAdminResponse(
+	booking *db.Booking,
+	usersByID map[primitive.ObjectID]*db.User,
+	toolsByID map[string]*db.Tool,
+) AdminBookingResponse {
+	resp := AdminBookingResponse{BookingResponse: convertBookingToResponse(booking)}
+	if tool, ok := toolsByID[booking.ToolID]; ok {
+		resp.Tool = convertToolToSummary(tool)
+	}
+	if fromUser, ok := usersByID[booking.FromUserID]; ok {
+		resp.FromUser = convertUserToSummary(fromUser)
+	}
+	if toUser, ok := usersByID[booking.ToUserID]; ok {
+		resp.ToUser = convertUserToSummary(toUser)
+	}
+	return resp
+}
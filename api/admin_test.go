@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+var testAdmin = db.User{
+	Name:  "admin",
+	Email: "admin@emprius.cat",
+	Admin: true,
+}
+
+func TestAdminActiveBookings(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	newBooking := func(startOffset time.Duration, status db.BookingStatus) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(startOffset),
+			EndDate:   time.Now().Add(startOffset + 24*time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		if status != db.BookingStatusPending {
+			setBookingStatusForTest(t, a, booking.ID, status)
+		}
+		return booking
+	}
+	later := newBooking(96*time.Hour, db.BookingStatusAccepted)
+	sooner := newBooking(24*time.Hour, db.BookingStatusAccepted)
+	newBooking(48*time.Hour, db.BookingStatusPending)
+	newBooking(144*time.Hour, db.BookingStatusReturned)
+	newBooking(192*time.Hour, db.BookingStatusRejected)
+
+	handler := a.adminHandler(a.adminActiveBookingsHandler)
+
+	// Regular users are not allowed
+	_, err = handler(testRequest(t, "GET", "/admin/bookings/active", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+
+	// Only the accepted bookings are returned, sorted by start date
+	resp, err := handler(testRequest(t, "GET", "/admin/bookings/active", testAdmin.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	active := resp.(*AdminBookingsResponse)
+	qt.Assert(t, active.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, active.Bookings, qt.HasLen, 2)
+	qt.Assert(t, active.Bookings[0].ID, qt.Equals, sooner.ID.Hex())
+	qt.Assert(t, active.Bookings[1].ID, qt.Equals, later.ID.Hex())
+	for _, booking := range active.Bookings {
+		qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+		qt.Assert(t, booking.Tool.ID, qt.Equals, id)
+		qt.Assert(t, booking.FromUser.Name, qt.Equals, testUser2.Name)
+		qt.Assert(t, booking.ToUser.Name, qt.Equals, testUser1.Name)
+	}
+
+	// Descending order with pagination
+	resp, err = handler(testRequest(t, "GET", "/admin/bookings/active?sort=desc&pageSize=1",
+		testAdmin.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	active = resp.(*AdminBookingsResponse)
+	qt.Assert(t, active.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, active.Bookings, qt.HasLen, 1)
+	qt.Assert(t, active.Bookings[0].ID, qt.Equals, later.ID.Hex())
+
+	_, err = handler(testRequest(t, "GET", "/admin/bookings/active?sort=up", testAdmin.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrInvalidSortOrder)
+}
+
+func TestAdminOrphanBookings(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	deleted := testTool1
+	deleted.Title = "deleted tool"
+	deletedID, err := a.addTool(&deleted, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	newBooking := func(toolID int64, fromUserID primitive.ObjectID) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID: fmt.Sprintf("%d", toolID),
+		}, fromUserID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return booking
+	}
+	healthy := newBooking(toolID, requester.ID)
+	missingTool := newBooking(deletedID, requester.ID)
+	missingUser := newBooking(toolID, primitive.NewObjectID())
+	qt.Assert(t, a.deleteTool(deletedID), qt.IsNil)
+
+	// Only admins can see them
+	_, err = a.adminHandler(a.adminOrphanBookingsHandler)(testRequest(t, "GET", "/admin/bookings/orphans",
+		testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+
+	list := func() []AdminOrphanBookingResponse {
+		resp, err := a.adminHandler(a.adminOrphanBookingsHandler)(testRequest(t, "GET", "/admin/bookings/orphans",
+			testAdmin.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*AdminOrphansResponse).Bookings
+	}
+	orphans := list()
+	qt.Assert(t, orphans, qt.HasLen, 2)
+	qt.Assert(t, orphans[0].ID, qt.Equals, missingTool.ID.Hex())
+	qt.Assert(t, orphans[0].MissingTool, qt.IsTrue)
+	qt.Assert(t, orphans[0].MissingFromUser, qt.IsFalse)
+	qt.Assert(t, orphans[1].ID, qt.Equals, missingUser.ID.Hex())
+	qt.Assert(t, orphans[1].MissingTool, qt.IsFalse)
+	qt.Assert(t, orphans[1].MissingFromUser, qt.IsTrue)
+
+	// Fixing cancels and flags them, so they are no longer reported
+	resp, err := a.adminHandler(a.adminFixOrphanBookingsHandler)(testRequest(t, "POST",
+		"/admin/bookings/orphans/fix", testAdmin.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*AdminOrphansFixResponse).Orphaned, qt.Equals, 2)
+	qt.Assert(t, resp.(*AdminOrphansFixResponse).Cancelled, qt.Equals, int64(2))
+	qt.Assert(t, list(), qt.HasLen, 0)
+
+	fixed, err := a.database.BookingService.Get(ctx, missingTool.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, fixed.Orphaned, qt.IsTrue)
+	qt.Assert(t, fixed.BookingStatus, qt.Equals, db.BookingStatusCancelled)
+	untouched, err := a.database.BookingService.Get(ctx, healthy.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, untouched.Orphaned, qt.IsFalse)
+	qt.Assert(t, untouched.BookingStatus, qt.Equals, db.BookingStatusOpen)
+}
+
+func TestAdminDeletedEntities(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	deletedUser, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.ToolService.UpdateToolFields(ctx, toolID,
+		map[string]interface{}{"deleted": true}), qt.IsNil)
+	_, err = a.database.UserService.UpdateUser(ctx, deletedUser.ID, map[string]interface{}{"deleted": true})
+	qt.Assert(t, err, qt.IsNil)
+
+	toolPath := fmt.Sprintf("/tools/%d", toolID)
+	toolParams := map[string]string{"id": fmt.Sprintf("%d", toolID)}
+	getTool := func(email, target string) error {
+		_, err := a.toolHandler(testRequest(t, "GET", target, email, nil, toolParams))
+		return err
+	}
+	getUser := func(email, target string) error {
+		_, err := a.getUserHandler(testRequest(t, "GET", target, email, nil,
+			map[string]string{"id": deletedUser.ID.Hex()}))
+		return err
+	}
+	listUsers := func(email, target string) []UserResponse {
+		resp, err := a.usersHandler(testRequest(t, "GET", target, email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*UsersWrapper).Users
+	}
+
+	// Non admins never see them, with or without the flag
+	qt.Assert(t, getTool(testUser1.Email, toolPath+"?includeDeleted=true"), qt.Equals, ErrToolNotFound)
+	qt.Assert(t, getUser(testUser1.Email, "/users/"+deletedUser.ID.Hex()+"?includeDeleted=true"), qt.IsNotNil)
+	qt.Assert(t, listUsers(testUser1.Email, "/users?includeDeleted=true"), qt.HasLen, 2)
+	tools, err := a.toolsByUerID(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tools, qt.HasLen, 0)
+
+	// Admins only see them when asking for them
+	qt.Assert(t, getTool(testAdmin.Email, toolPath), qt.Equals, ErrToolNotFound)
+	qt.Assert(t, getTool(testAdmin.Email, toolPath+"?includeDeleted=true"), qt.IsNil)
+	qt.Assert(t, getUser(testAdmin.Email, "/users/"+deletedUser.ID.Hex()), qt.IsNotNil)
+	qt.Assert(t, getUser(testAdmin.Email, "/users/"+deletedUser.ID.Hex()+"?includeDeleted=true"), qt.IsNil)
+	qt.Assert(t, listUsers(testAdmin.Email, "/users"), qt.HasLen, 2)
+	qt.Assert(t, listUsers(testAdmin.Email, "/users?includeDeleted=true"), qt.HasLen, 3)
+
+	// Restoring is admin only and makes them visible again
+	_, err = a.adminHandler(a.adminRestoreToolHandler)(testRequest(t, "POST", toolPath+"/restore",
+		testUser1.Email, nil, toolParams))
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+	resp, err := a.adminHandler(a.adminRestoreToolHandler)(testRequest(t, "POST", toolPath+"/restore",
+		testAdmin.Email, nil, toolParams))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*db.Tool).Deleted, qt.IsFalse)
+	qt.Assert(t, getTool(testUser1.Email, toolPath), qt.IsNil)
+
+	_, err = a.adminHandler(a.adminRestoreUserHandler)(testRequest(t, "POST",
+		"/admin/users/"+deletedUser.ID.Hex()+"/restore", testAdmin.Email, nil,
+		map[string]string{"id": deletedUser.ID.Hex()}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, getUser(testUser1.Email, "/users/"+deletedUser.ID.Hex()), qt.IsNil)
+	qt.Assert(t, listUsers(testUser1.Email, "/users"), qt.HasLen, 3)
+
+	_, err = a.adminHandler(a.adminRestoreUserHandler)(testRequest(t, "POST",
+		"/admin/users/"+primitive.NewObjectID().Hex()+"/restore", testAdmin.Email, nil,
+		map[string]string{"id": primitive.NewObjectID().Hex()}))
+	qt.Assert(t, err, qt.Equals, ErrUserNotFound)
+}
+
+func TestAdminModeration(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	router := a.router()
+	abuser := testUser2
+	abuser.Password = hashPassword("secret")
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&abuser), qt.IsNil)
+	abuserToolID, err := a.addTool(&testTool1, abuser.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ownToolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	abuserID, err := a.database.UserService.GetUserByEmail(ctx, abuser.Email)
+	qt.Assert(t, err, qt.IsNil)
+	deactivatePath := "/admin/users/" + abuserID.ID.Hex() + "/deactivate"
+	deleteToolPath := fmt.Sprintf("/admin/tools/%d", ownToolID)
+
+	session, err := a.loginHandler(testRequest(t, "POST", "/login", "",
+		&Login{Email: abuser.Email, Password: "secret"}, nil))
+	qt.Assert(t, err, qt.IsNil)
+
+	// The first admin is bootstrapped from the options
+	bob := testToken(t, a, testUser1.Email)
+	code, _ := testHTTPRequest(t, router, "POST", deactivatePath, bob, nil)
+	qt.Assert(t, code, qt.Equals, ErrAdminRequired.Code)
+	code, _ = testHTTPRequest(t, router, "DELETE", deleteToolPath, bob, nil)
+	qt.Assert(t, code, qt.Equals, ErrAdminRequired.Code)
+	New("secret", "", a.database, &Options{Admins: []string{testUser1.Email}})
+
+	// Unless the email was not verified, since anybody can register with it
+	squatter := db.User{Name: "mallory", Email: "mallory@emprius.cat", Active: true}
+	qt.Assert(t, a.addUser(&squatter), qt.IsNil)
+	New("secret", "", a.database, &Options{Admins: []string{testUser1.Email, squatter.Email}})
+	squatterUser, err := a.database.UserService.GetUserByEmail(ctx, squatter.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, squatterUser.Admin, qt.IsFalse)
+
+	code, resp := testHTTPRequest(t, router, "POST", deactivatePath, bob, nil)
+	qt.Assert(t, testHTTPData[UserResponse](t, code, resp).Deactivated, qt.IsTrue)
+	_, err = a.loginHandler(testRequest(t, "POST", "/login", "",
+		&Login{Email: abuser.Email, Password: "secret"}, nil))
+	qt.Assert(t, err, qt.Equals, ErrUserDeactivated)
+	_, err = a.refreshHandler(testRequest(t, "POST", "/refresh", "",
+		&RefreshRequest{RefreshToken: session.(*LoginResponse).RefreshToken}, nil))
+	qt.Assert(t, err, qt.Equals, ErrUserDeactivated)
+	code, _ = testHTTPRequest(t, router, "GET", "/profile", session.(*LoginResponse).Token, nil)
+	qt.Assert(t, code, qt.Equals, ErrUserDeactivated.Code)
+
+	// The tools of the deactivated user are no longer found
+	found, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?distance=50000",
+		testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	tools := found.(*ToolsWrapper).Tools
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].ID, qt.Equals, ownToolID)
+
+	// Admins remove any tool, whoever the owner is
+	code, _ = testHTTPRequest(t, router, "DELETE", fmt.Sprintf("/admin/tools/%d", abuserToolID), bob, nil)
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	_, err = a.tool(abuserToolID)
+	qt.Assert(t, err, qt.Equals, ErrToolNotFound)
+
+	code, resp = testHTTPRequest(t, router, "POST", "/admin/users/"+primitive.NewObjectID().Hex()+"/deactivate", bob, nil)
+	qt.Assert(t, code, qt.Equals, ErrUserNotFound.Code)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrUserNotFound.ErrorCode)
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/emprius/emprius-app-backend/db"
@@ -17,25 +19,302 @@ import (
 )
 
 const (
-	jwtExpiration = 720 * time.Hour // 30 days
-	passwordSalt  = "emprius"       // salt for password hashing
+	passwordSalt          = "emprius"   // salt for password hashing
+	defaultSearchDistance = 50000       // m
+	minSearchDistance     = 100         // m
+	maxSearchDistance     = 1000000     // m
+	minSearchTermLength   = 2           // characters
+	searchThrottleLimit   = 20          // concurrent search requests
+	maxRatingComment      = 500         // characters
+	maxRatingImages       = 5           // photos attached to a rating
+	maxBookingMessage     = 1000        // characters
+	maxImageBatch         = 10          // images uploaded in a single request
+	maxRecommendedTools   = 20          // tools returned by the recommendations
+	anonymousRaterName    = "Anonymous" // rater name shown on anonymous ratings
+	maxTrendingTools      = 20          // tools returned by the trending listing
+	maxSearchResults      = 10          // results of each type returned by the unified search
+	minLeaderboardCount   = 3           // ratings or lends needed to enter the leaderboards
+	communityFilterMine   = "mine"      // community filter of the caller's own community
+
+	defaultJWTExpiration       = time.Hour
+	defaultRefreshExpiration   = 720 * time.Hour // 30 days
+	defaultRatingGracePeriod   = 24 * time.Hour
+	defaultDailyTransferCap    = 500 // tokens
+	defaultTrendingWindow      = 7 * 24 * time.Hour
+	defaultWorkerInterval      = 10 * time.Minute
+	defaultAutoReturnDelay     = time.Hour
+	defaultReminderWindow      = 24 * time.Hour
+	defaultMaxBookingDays      = 90
+	defaultAvailabilityHorizon = 90 * 24 * time.Hour
+	defaultMaxActiveBookings   = 50
+	defaultPetitionTTL         = 7 * 24 * time.Hour
+	defaultResponseDeadline    = 48 * time.Hour
+	defaultMaxImageBytes       = 5 << 20 // 5 MiB
+	defaultMaxImageDimension   = 4096    // pixels
+	defaultMaxBodyBytes        = 1 << 20 // 1 MiB
+	defaultPasswordResetTTL    = time.Hour
+	defaultVerificationTTL     = 48 * time.Hour
+	defaultRateLimit           = 300 // requests per window
+	defaultRateLimitWindow     = time.Minute
+	defaultLocationPrecision   = 1000 // meters
+
+	// infoCacheTTL is the time the /info response is served from memory
+	infoCacheTTL = 30 * time.Second
+)
+
+// RegistrationMode is how new users are allowed to register.
+type RegistrationMode string
+
+const (
+	// RegistrationToken requires the registration token shared by all the users, given to New.
+	RegistrationToken RegistrationMode = "token"
+	// RegistrationOpen lets anybody register, without any token.
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInvite requires a single-use invite minted by an admin on POST /admin/invites.
+	RegistrationInvite RegistrationMode = "invite"
 )
 
+// Valid returns true if m is a known registration mode.
+func (m RegistrationMode) Valid() bool {
+	return m == RegistrationToken || m == RegistrationOpen || m == RegistrationInvite
+}
+
+// Options holds the optional settings of the API. Zero values are replaced by the defaults.
+type Options struct {
+	// JWTExpiration is the lifetime of the access tokens issued on login, register and refresh.
+	JWTExpiration time.Duration
+	// RefreshExpiration is the lifetime of the refresh tokens, exchanged for new access tokens.
+	RefreshExpiration time.Duration
+	// DefaultSearchDistance is the tool search radius (in meters) applied when the request
+	// does not specify one and the caller's community has no override.
+	DefaultSearchDistance int
+	// CommunitySearchDistance overrides DefaultSearchDistance for the users of a community.
+	CommunitySearchDistance map[string]int
+	// ExclusivePendingBookings rejects new bookings overlapping a pending one, instead of
+	// only checking conflicts against accepted bookings.
+	ExclusivePendingBookings bool
+	// AnonymousRatings hides who wrote the ratings shown on user profiles.
+	AnonymousRatings bool
+	// RatingGracePeriod is the time after creation during which a rating can be edited or
+	// deleted by its author.
+	RatingGracePeriod time.Duration
+	// RatingDecayHalfLife makes recent ratings count more in the weighted user rating: a rating
+	// this old weights half. Zero (the default) weights all ratings the same.
+	RatingDecayHalfLife time.Duration
+	// DailyTransferCap is the maximum amount of tokens a user can transfer to other users
+	// per day (UTC).
+	DailyTransferCap uint64
+	// TrendingWindow is how far back the bookings are considered to rank the trending tools.
+	TrendingWindow time.Duration
+	// ReliabilityWeights configure the reliability score of the users, see db.ReliabilityStats.Score.
+	// Nil uses db.DefaultReliabilityWeights.
+	ReliabilityWeights *db.ReliabilityWeights
+	// WorkerInterval is how often the background worker runs its tasks.
+	WorkerInterval time.Duration
+	// AutoReturnDelay is the time after the end date the bookings of auto-return tools are returned.
+	AutoReturnDelay time.Duration
+	// ReminderWindow is how long before the start date the parties of an accepted booking are reminded
+	// of it by the background worker.
+	ReminderWindow time.Duration
+	// OverdueReminderDelay is the time after the end date the parties of a booking not yet returned are
+	// reminded of it by the background worker. Zero reminds them as soon as it ends.
+	OverdueReminderDelay time.Duration
+	// Notifier sends the booking reminders. If nil, they are only logged, see LogNotifier.
+	Notifier Notifier
+	// UniqueToolTitles rejects new tools titled as another tool of the same owner, ignoring case,
+	// with ErrDuplicateToolTitle. Otherwise they are only logged as a warning.
+	UniqueToolTitles bool
+	// MaxBookingDays is the longest a single booking can last, in days.
+	MaxBookingDays int
+	// AvailabilityHorizon is how far from the requested start the next available dates of a tool
+	// are looked for, see toolNextAvailableHandler.
+	AvailabilityHorizon time.Duration
+	// MaxActiveBookings is the number of accepted bookings a user can have at the same time as
+	// requester. Accepting more petitions of the user fails with ErrTooManyActiveBookings.
+	MaxActiveBookings int
+	// PetitionTTL is the age after which the pending petitions whose start date passed are expired
+	// by the background worker.
+	PetitionTTL time.Duration
+	// ResponseDeadline is the time the owners have to answer the petitions of the tools without their
+	// own deadline, see db.Tool.ResponseDeadlineHours. The petitions answered later lower their
+	// response rate.
+	ResponseDeadline time.Duration
+	// AutoRejectUnanswered makes the background worker reject the petitions not answered by their
+	// response deadline, so the requesters can look elsewhere.
+	AutoRejectUnanswered bool
+	// MaxImageBytes is the largest image that can be uploaded, in bytes.
+	MaxImageBytes int
+	// MaxImageDimension is the largest width or height, in pixels, of the uploaded images.
+	MaxImageDimension int
+	// MaxBodyBytes is the largest request body accepted, in bytes. The routes receiving images accept
+	// MaxImageBytes more per image, see uploadHandler. Larger bodies get ErrRequestTooLarge.
+	MaxBodyBytes int
+	// PasswordResetTTL is the time a password reset token can be used after it is requested.
+	PasswordResetTTL time.Duration
+	// RateLimit is the number of requests each user, or each IP address on the public routes, can
+	// make per RateLimitWindow. Further requests get a 429 response. A negative value disables it.
+	RateLimit int
+	// RateLimitWindow is the period the RateLimit applies to.
+	RateLimitWindow time.Duration
+	// RegistrationMode is how new users are allowed to register, RegistrationToken by default.
+	RegistrationMode RegistrationMode
+	// Admins are the emails of the users granted admin privileges, on startup if they already
+	// exist and verified their email, or when they verify it. It bootstraps the first admins.
+	Admins []string
+	// AllowedOrigins are the origins of the frontends allowed to make cross-origin requests, which get
+	// their own origin back in Access-Control-Allow-Origin. If empty, any origin is allowed ("*").
+	AllowedOrigins []string
+	// MetricsAddr is the address GET /metrics listens on, apart from the API so the metrics are not
+	// exposed with it. If empty, /metrics is served by the API router, without authentication.
+	MetricsAddr string
+	// SendPasswordReset delivers the password reset token to the user with the given email, see
+	// SMTPMailer. If nil, password resets fail with ErrMailUnavailable.
+	SendPasswordReset func(email, token string) error
+	// VerificationTTL is the time an email verification token can be used after it is sent.
+	VerificationTTL time.Duration
+	// RequireVerification only lets the users who verified their email create bookings.
+	RequireVerification bool
+	// SendVerification delivers the email verification token to the user with the given email, see
+	// SMTPMailer. If nil, no verification is sent: the verifications fail with ErrMailUnavailable.
+	SendVerification func(email, token string) error
+	// LocationPrecision is the size in meters of the grid the locations of the tools and users are
+	// snapped to when shown to other users, so their homes are not disclosed. The owners see the
+	// exact locations. A negative value disables it.
+	LocationPrecision int
+}
+
+// withDefaults returns a copy of the options with the unset values replaced by the defaults.
+func (o *Options) withDefaults() Options {
+	opts := Options{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.JWTExpiration <= 0 {
+		opts.JWTExpiration = defaultJWTExpiration
+	}
+	if opts.RefreshExpiration <= 0 {
+		opts.RefreshExpiration = defaultRefreshExpiration
+	}
+	if opts.DefaultSearchDistance <= 0 {
+		opts.DefaultSearchDistance = defaultSearchDistance
+	}
+	if opts.RatingGracePeriod <= 0 {
+		opts.RatingGracePeriod = defaultRatingGracePeriod
+	}
+	if opts.DailyTransferCap == 0 {
+		opts.DailyTransferCap = defaultDailyTransferCap
+	}
+	if opts.TrendingWindow <= 0 {
+		opts.TrendingWindow = defaultTrendingWindow
+	}
+	if opts.WorkerInterval <= 0 {
+		opts.WorkerInterval = defaultWorkerInterval
+	}
+	if opts.AutoReturnDelay <= 0 {
+		opts.AutoReturnDelay = defaultAutoReturnDelay
+	}
+	if opts.ReminderWindow <= 0 {
+		opts.ReminderWindow = defaultReminderWindow
+	}
+	if opts.Notifier == nil {
+		opts.Notifier = LogNotifier{}
+	}
+	if opts.MaxBookingDays <= 0 {
+		opts.MaxBookingDays = defaultMaxBookingDays
+	}
+	if opts.AvailabilityHorizon <= 0 {
+		opts.AvailabilityHorizon = defaultAvailabilityHorizon
+	}
+	if opts.MaxActiveBookings <= 0 {
+		opts.MaxActiveBookings = defaultMaxActiveBookings
+	}
+	if opts.PetitionTTL <= 0 {
+		opts.PetitionTTL = defaultPetitionTTL
+	}
+	if opts.ResponseDeadline <= 0 {
+		opts.ResponseDeadline = defaultResponseDeadline
+	}
+	if opts.MaxImageBytes <= 0 {
+		opts.MaxImageBytes = defaultMaxImageBytes
+	}
+	if opts.MaxImageDimension <= 0 {
+		opts.MaxImageDimension = defaultMaxImageDimension
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	if opts.PasswordResetTTL <= 0 {
+		opts.PasswordResetTTL = defaultPasswordResetTTL
+	}
+	if opts.VerificationTTL <= 0 {
+		opts.VerificationTTL = defaultVerificationTTL
+	}
+	if opts.RegistrationMode == "" {
+		opts.RegistrationMode = RegistrationToken
+	}
+	if len(opts.AllowedOrigins) == 0 {
+		opts.AllowedOrigins = []string{"*"}
+	}
+	if opts.RateLimit == 0 {
+		opts.RateLimit = defaultRateLimit
+	}
+	if opts.RateLimitWindow <= 0 {
+		opts.RateLimitWindow = defaultRateLimitWindow
+	}
+	if opts.LocationPrecision == 0 {
+		opts.LocationPrecision = defaultLocationPrecision
+	}
+	if opts.ReliabilityWeights == nil {
+		weights := db.DefaultReliabilityWeights
+		opts.ReliabilityWeights = &weights
+	}
+	return opts
+}
+
 // API type represents the API HTTP server with JWT authentication capabilities.
 type API struct {
 	Router            *chi.Mux
 	auth              *jwtauth.JWTAuth
 	registerAuthToken string
 	database          *db.Database
+	opts              Options
+	infoCache         infoCache
+	imageSizes        imageSizeCache
+	metrics           *metrics
+}
+
+// infoCache keeps the /info response, whose values change slowly, for infoCacheTTL.
+type infoCache struct {
+	mu      sync.Mutex
+	info    *Info
+	expires time.Time
 }
 
 // New creates a new API HTTP server. It does not start the server. Use Start() for that.
-func New(secret, registerAuthToken string, database *db.Database) *API {
-	return &API{
+// If opts is nil, the default options are used.
+func New(secret, registerAuthToken string, database *db.Database, opts *Options) *API {
+	a := &API{
 		auth:              jwtauth.New("HS256", []byte(secret), nil),
 		database:          database,
 		registerAuthToken: registerAuthToken,
+		opts:              opts.withDefaults(),
+	}
+	var hub *db.BookingEventHub
+	if database != nil && database.BookingService != nil {
+		hub = database.BookingService.Events
+	}
+	a.metrics = newMetrics(hub)
+	if database != nil {
+		database.BookingService.ExclusivePending = a.opts.ExclusivePendingBookings
+		database.BookingService.MaxDuration = time.Duration(a.opts.MaxBookingDays) * 24 * time.Hour
+		database.RatingService.DecayHalfLife = a.opts.RatingDecayHalfLife
+		if len(a.opts.Admins) > 0 {
+			if err := database.UserService.GrantAdmin(context.Background(), a.opts.Admins); err != nil {
+				log.Error().Err(err).Msg("could not grant admin privileges")
+			}
+		}
 	}
+	return a
 }
 
 // Start starts the API HTTP server (non blocking).
@@ -45,44 +324,99 @@ func (a *API) Start(host string, port int) {
 			log.Fatal().Err(err).Msg("failed to start api router")
 		}
 	}()
+	if a.opts.MetricsAddr != "" {
+		log.Info().Msgf("serving metrics on %s", a.opts.MetricsAddr)
+		a.startMetrics(a.opts.MetricsAddr)
+	}
 }
 
 // router creates the router with all the routes and middleware.
 func (a *API) router() http.Handler {
 	// Create the router with a basic middleware stack
 	r := chi.NewRouter()
+	// Credentialed requests are only allowed from known origins, browsers reject them with a wildcard
+	wildcard := slices.Contains(a.opts.AllowedOrigins, "*")
 	r.Use(cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
+		AllowedOrigins:   a.opts.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		AllowCredentials: true,
+		AllowCredentials: !wildcard,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}).Handler)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Throttle(100))
-	r.Use(middleware.ThrottleBacklog(5000, 40000, 30*time.Second))
-	r.Use(middleware.Timeout(30 * time.Second))
-	// Protected routes
+	r.Use(a.metrics.middleware)
+	limiter := newRateLimiter(a.opts.RateLimit, a.opts.RateLimitWindow)
+	// Event streams are long lived, so they are kept out of the throttling and the request timeout
 	r.Group(func(r chi.Router) {
+		r.Use(jwtauth.Verifier(a.auth))
+		r.Use(a.authenticator)
+		r.Use(limiter.byUser)
+		// GET /bookings/events
+		log.Info().Msg("register route GET /bookings/events")
+		r.Get("/bookings/events", a.bookingEventsHandler)
+	})
+	limited := r.With(
+		middleware.Throttle(100),
+		middleware.ThrottleBacklog(5000, 40000, 30*time.Second),
+		middleware.Timeout(30*time.Second),
+	)
+	// Protected routes
+	limited.Group(func(r chi.Router) {
 		// Seek, verify and validate JWT tokens
 		r.Use(jwtauth.Verifier(a.auth))
 
 		// Handle valid JWT tokens.
 		r.Use(a.authenticator)
 
+		// Limit the requests of each user
+		r.Use(limiter.byUser)
+
 		// Endpoints
 		// Users
 		log.Info().Msg("register route GET /profile")
 		r.Get("/profile", a.routerHandler(a.userProfileHandler))
-		log.Info().Msg("register route GET /refresh")
-		r.Get("/refresh", a.routerHandler(a.refreshHandler))
+		log.Info().Msg("register route GET /dashboard")
+		r.Get("/dashboard", a.routerHandler(a.dashboardHandler))
+		log.Info().Msg("register route POST /logout")
+		r.Post("/logout", a.routerHandler(a.logoutHandler))
+		log.Info().Msg("register route POST /verify/resend")
+		r.Post("/verify/resend", a.routerHandler(a.verifyResendHandler))
 		log.Info().Msg("register route POST /profile")
-		r.Post("/profile", a.routerHandler(a.userProfileUpdateHandler))
+		r.Post("/profile", a.uploadHandler(a.userProfileUpdateHandler, 1))
+		log.Info().Msg("register route DELETE /profile")
+		r.Delete("/profile", a.routerHandler(a.deleteAccountHandler))
+		log.Info().Msg("register route GET /profile/reach")
+		r.Get("/profile/reach", a.routerHandler(a.userReachHandler))
+		log.Info().Msg("register route GET /profile/notifications")
+		r.Get("/profile/notifications", a.routerHandler(a.notificationPreferencesHandler))
+		log.Info().Msg("register route POST /profile/notifications")
+		r.Post("/profile/notifications", a.routerHandler(a.notificationPreferencesUpdateHandler))
+		log.Info().Msg("register route GET /notifications")
+		r.Get("/notifications", a.routerHandler(a.notificationsHandler))
+		// Tokens
+		log.Info().Msg("register route POST /tokens/transfer")
+		r.Post("/tokens/transfer", a.routerHandler(a.tokenTransferHandler))
 		log.Info().Msg("register route GET /users")
 		r.Get("/users", a.routerHandler(a.usersHandler))
+		log.Info().Msg("register route GET /users/top")
+		r.Get("/users/top", a.routerHandler(a.topUsersHandler))
 		log.Info().Msg("register route GET /users/{id}")
 		r.Get("/users/{id}", a.routerHandler(a.getUserHandler))
+		log.Info().Msg("register route GET /users/{id}/summary")
+		r.Get("/users/{id}/summary", a.routerHandler(a.getUserSummaryHandler))
+		log.Info().Msg("register route GET /users/{id}/ratings/histogram")
+		r.Get("/users/{id}/ratings/histogram", a.routerHandler(a.getUserRatingsHistogramHandler))
+		log.Info().Msg("register route GET /users/{id}/ratings")
+		r.Get("/users/{id}/ratings", a.routerHandler(a.getUserRatingsHandler))
+
+		// Community
+		log.Info().Msg("register route GET /community/{community}/feed")
+		r.Get("/community/{community}/feed", a.routerHandler(a.communityFeedHandler))
+
+		// Search
+		log.Info().Msg("register route GET /search")
+		r.With(middleware.Throttle(searchThrottleLimit)).Get("/search", a.routerHandler(a.searchHandler))
 
 		// Images
 		// GET /images/{hash}
@@ -90,7 +424,10 @@ func (a *API) router() http.Handler {
 		r.Get("/images/{hash}", a.routerHandler(a.imageHandler))
 		// POST /images
 		log.Info().Msg("register route POST /images")
-		r.Post("/images", a.routerHandler(a.imageUploadHandler))
+		r.Post("/images", a.uploadHandler(a.imageUploadHandler, 1))
+		// POST /images/batch
+		log.Info().Msg("register route POST /images/batch")
+		r.Post("/images/batch", a.uploadHandler(a.imageBatchUploadHandler, maxImageBatch))
 
 		// Tools
 		// GET /tools
@@ -98,19 +435,64 @@ func (a *API) router() http.Handler {
 		r.Get("/tools", a.routerHandler(a.ownToolsHandler))
 		// GET /tools/search
 		log.Info().Msg("register route GET /tools/search")
-		r.Get("/tools/search", a.routerHandler(a.toolSearchHandler))
+		r.With(middleware.Throttle(searchThrottleLimit)).Get("/tools/search", a.routerHandler(a.toolSearchHandler))
+		// GET /tools/recommended
+		log.Info().Msg("register route GET /tools/recommended")
+		r.Get("/tools/recommended", a.routerHandler(a.recommendedToolsHandler))
+		// GET /tools/trending
+		log.Info().Msg("register route GET /tools/trending")
+		r.Get("/tools/trending", a.routerHandler(a.trendingToolsHandler))
+		// GET /tools/featured
+		log.Info().Msg("register route GET /tools/featured")
+		r.Get("/tools/featured", a.routerHandler(a.featuredToolsHandler))
+		// GET /tools/on-loan
+		log.Info().Msg("register route GET /tools/on-loan")
+		r.Get("/tools/on-loan", a.routerHandler(a.toolsOnLoanHandler))
+		// GET /tools/pending-action
+		log.Info().Msg("register route GET /tools/pending-action")
+		r.Get("/tools/pending-action", a.routerHandler(a.toolsPendingActionHandler))
 		// GET /tools/user/{id}
 		log.Info().Msg("register route GET /tools/user/{id}")
 		r.Get("/tools/user/{id}", a.routerHandler(a.userToolsHandler))
+		// GET /tools/category/{categoryId}
+		log.Info().Msg("register route GET /tools/category/{categoryId}")
+		r.Get("/tools/category/{categoryId}", a.routerHandler(a.toolsByCategoryHandler))
 		// GET /tools/{id}
 		log.Info().Msg("register route GET /tools/{id}")
 		r.Get("/tools/{id}", a.routerHandler(a.toolHandler))
+		// GET /tools/{id}/stats
+		log.Info().Msg("register route GET /tools/{id}/stats")
+		r.Get("/tools/{id}/stats", a.routerHandler(a.toolStatsHandler))
+		// GET /tools/{id}/bookings
+		log.Info().Msg("register route GET /tools/{id}/bookings")
+		r.Get("/tools/{id}/bookings", a.routerHandler(a.toolBookingsHandler))
+		// GET /tools/{id}/next-available
+		log.Info().Msg("register route GET /tools/{id}/next-available")
+		r.Get("/tools/{id}/next-available", a.routerHandler(a.toolNextAvailableHandler))
+		// GET /tools/{id}/history
+		log.Info().Msg("register route GET /tools/{id}/history")
+		r.Get("/tools/{id}/history", a.routerHandler(a.toolHistoryHandler))
 		// POST /tools
 		log.Info().Msg("register route POST /tools")
 		r.Post("/tools", a.routerHandler(a.addToolHandler))
+		// POST /tools/import/csv
+		log.Info().Msg("register route POST /tools/import/csv")
+		r.Post("/tools/import/csv", a.routerHandler(a.adminHandler(a.toolImportCSVHandler)))
 		// PUT /tools/{id}
 		log.Info().Msg("register route PUT /tools/{id}")
 		r.Put("/tools/{id}", a.routerHandler(a.editToolHandler))
+		// POST /tools/{id}/favorite
+		log.Info().Msg("register route POST /tools/{id}/favorite")
+		r.Post("/tools/{id}/favorite", a.routerHandler(a.addFavoriteHandler))
+		// DELETE /tools/{id}/favorite
+		log.Info().Msg("register route DELETE /tools/{id}/favorite")
+		r.Delete("/tools/{id}/favorite", a.routerHandler(a.removeFavoriteHandler))
+		// GET /favorites
+		log.Info().Msg("register route GET /favorites")
+		r.Get("/favorites", a.routerHandler(a.favoritesHandler))
+		// POST /tools/{id}/unavailability
+		log.Info().Msg("register route POST /tools/{id}/unavailability")
+		r.Post("/tools/{id}/unavailability", a.routerHandler(a.toolUnavailabilityHandler))
 		// DELETE /tools/{id}
 		log.Info().Msg("register route DELETE /tools/{id}")
 		r.Delete("/tools/{id}", a.routerHandler(a.deleteToolHandler))
@@ -118,7 +500,7 @@ func (a *API) router() http.Handler {
 		// Bookings
 		// POST /bookings
 		log.Info().Msg("register route POST /bookings")
-		r.Post("/bookings", a.routerHandler(func(r *Request) (interface{}, error) {
+		r.Post("/bookings", a.routerHandler(a.metrics.countBookings(a.verifiedHandler(func(r *Request) (interface{}, error) {
 			if r.UserID == "" {
 				return nil, fmt.Errorf("unauthorized")
 			}
@@ -128,6 +510,18 @@ func (a *API) router() http.Handler {
 				return nil, fmt.Errorf("invalid request body")
 			}
 
+			// Several tools requested at once are booked as a kit
+			if len(req.ToolIDs) > 0 {
+				if req.Transfer || req.Transport != 0 {
+					return nil, ErrInvalidBookingGroup
+				}
+				fromUser, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+				if err != nil {
+					return nil, fmt.Errorf("invalid user ID: %w", err)
+				}
+				return a.createBookingGroup(r.Context.Request.Context(), fromUser, &req)
+			}
+
 			// Get tool to verify it exists and get owner ID
 			toolID, err := strconv.ParseInt(req.ToolID, 10, 64)
 			if err != nil {
@@ -138,7 +532,7 @@ func (a *API) router() http.Handler {
 			if err != nil {
 				return nil, err
 			}
-			if tool == nil {
+			if tool == nil || tool.Deleted {
 				return nil, fmt.Errorf("tool not found")
 			}
 
@@ -156,55 +550,161 @@ func (a *API) router() http.Handler {
 			// Convert tool ID to string
 			toolIDStr := fmt.Sprintf("%d", tool.ID)
 
+			// Without dates, the booking is created as an open request
+			startDate, endDate, err := a.bookingDates(req.StartDate, req.EndDate)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkLeadTime(tool, startDate); err != nil {
+				return nil, err
+			}
+			terms, err := acceptedTerms(req.AcceptedTerms, tool)
+			if err != nil {
+				return nil, err
+			}
+			transport, err := a.bookingTransport(r.Context.Request.Context(), tool, req.Transport, fromUser.Location)
+			if err != nil {
+				return nil, err
+			}
+
 			// Create booking request
 			dbReq := &db.CreateBookingRequest{
-				ToolID:    toolIDStr,
-				StartDate: time.Unix(req.StartDate, 0),
-				EndDate:   time.Unix(req.EndDate, 0),
-				Contact:   req.Contact,
-				Comments:  req.Comments,
+				ToolID:        toolIDStr,
+				StartDate:     startDate,
+				EndDate:       endDate,
+				Contact:       req.Contact,
+				Comments:      req.Comments,
+				Transfer:      req.Transfer,
+				AcceptedTerms: terms,
+				Transport:     transport,
 			}
 
 			booking, err := a.database.BookingService.Create(r.Context.Request.Context(), dbReq, fromUser.ID, toUser.ID)
 			if err != nil {
-				return nil, err
+				return nil, bookingServiceError(err)
 			}
+			a.notify(r.Context.Request.Context(), booking.ToUserID, db.NotificationBookingCreated, booking.ID)
 
 			return convertBookingToResponse(booking), nil
-		}))
+		}))))
 		// GET /bookings/requests
 		log.Info().Msg("register route GET /bookings/requests")
 		r.Get("/bookings/requests", a.routerHandler(a.HandleGetBookingRequests))
 		// GET /bookings/petitions
 		log.Info().Msg("register route GET /bookings/petitions")
 		r.Get("/bookings/petitions", a.routerHandler(a.HandleGetBookingPetitions))
+		// GET /bookings/history
+		log.Info().Msg("register route GET /bookings/history")
+		r.Get("/bookings/history", a.routerHandler(a.HandleGetBookingHistory))
 		// GET /bookings/{bookingId}
 		log.Info().Msg("register route GET /bookings/{bookingId}")
 		r.Get("/bookings/{bookingId}", a.routerHandler(a.HandleGetBooking))
+		// POST /bookings/{bookingId}/handover
+		log.Info().Msg("register route POST /bookings/{bookingId}/handover")
+		r.Post("/bookings/{bookingId}/handover", a.routerHandler(a.HandleHandOverBooking))
+		// POST /bookings/{bookingId}/cancel
+		log.Info().Msg("register route POST /bookings/{bookingId}/cancel")
+		r.Post("/bookings/{bookingId}/cancel", a.routerHandler(a.HandleCancelBooking))
+		// POST /bookings/{bookingId}/reschedule
+		log.Info().Msg("register route POST /bookings/{bookingId}/reschedule")
+		r.Post("/bookings/{bookingId}/reschedule", a.routerHandler(a.HandleRescheduleBooking))
+		// POST /bookings/{bookingId}/reschedule/accept
+		log.Info().Msg("register route POST /bookings/{bookingId}/reschedule/accept")
+		r.Post("/bookings/{bookingId}/reschedule/accept", a.routerHandler(a.HandleAcceptReschedule))
 		// POST /bookings/{bookingId}/return
 		log.Info().Msg("register route POST /bookings/{bookingId}/return")
 		r.Post("/bookings/{bookingId}/return", a.routerHandler(a.HandleReturnBooking))
+		// GET /bookings/{bookingId}/messages
+		log.Info().Msg("register route GET /bookings/{bookingId}/messages")
+		r.Get("/bookings/{bookingId}/messages", a.routerHandler(a.HandleGetBookingMessages))
+		// POST /bookings/{bookingId}/messages
+		log.Info().Msg("register route POST /bookings/{bookingId}/messages")
+		r.Post("/bookings/{bookingId}/messages", a.routerHandler(a.HandleCreateBookingMessage))
+		// GET /bookings/{bookingId}/history
+		log.Info().Msg("register route GET /bookings/{bookingId}/history")
+		r.Get("/bookings/{bookingId}/history", a.routerHandler(a.HandleGetBookingStatusHistory))
+		// GET /bookings/{bookingId}/receipt
+		log.Info().Msg("register route GET /bookings/{bookingId}/receipt")
+		r.Get("/bookings/{bookingId}/receipt", a.routerHandler(a.HandleGetBookingReceipt))
 		// GET /bookings/rates
 		log.Info().Msg("register route GET /bookings/rates")
 		r.Get("/bookings/rates", a.routerHandler(a.HandleGetPendingRatings))
 		// POST /bookings/rates
 		log.Info().Msg("register route POST /bookings/rates")
 		r.Post("/bookings/rates", a.routerHandler(a.HandleRateBooking))
+		// GET /bookings/{bookingId}/rate/preview
+		log.Info().Msg("register route GET /bookings/{bookingId}/rate/preview")
+		r.Get("/bookings/{bookingId}/rate/preview", a.routerHandler(a.HandleRatePreview))
+		// PUT /bookings/{bookingId}/rate
+		log.Info().Msg("register route PUT /bookings/{bookingId}/rate")
+		r.Put("/bookings/{bookingId}/rate", a.routerHandler(a.HandleEditRating))
+		// DELETE /bookings/{bookingId}/rate
+		log.Info().Msg("register route DELETE /bookings/{bookingId}/rate")
+		r.Delete("/bookings/{bookingId}/rate", a.routerHandler(a.HandleDeleteRating))
 
 		// New booking endpoints
+		// GET /bookings/petitions/overdue
+		log.Info().Msg("register route GET /bookings/petitions/overdue")
+		r.Get("/bookings/petitions/overdue", a.routerHandler(a.HandleGetOverduePetitions))
 		// POST /bookings/petitions/{petitionId}/accept
 		log.Info().Msg("register route POST /bookings/petitions/{petitionId}/accept")
 		r.Post("/bookings/petitions/{petitionId}/accept", a.routerHandler(a.HandleAcceptPetition))
+		// GET /bookings/petitions/{petitionId}/accept/preview
+		log.Info().Msg("register route GET /bookings/petitions/{petitionId}/accept/preview")
+		r.Get("/bookings/petitions/{petitionId}/accept/preview", a.routerHandler(a.HandleAcceptPreview))
 		// POST /bookings/petitions/{petitionId}/deny
 		log.Info().Msg("register route POST /bookings/petitions/{petitionId}/deny")
 		r.Post("/bookings/petitions/{petitionId}/deny", a.routerHandler(a.HandleDenyPetition))
+		// POST /bookings/petitions/{petitionId}/dates
+		log.Info().Msg("register route POST /bookings/petitions/{petitionId}/dates")
+		r.Post("/bookings/petitions/{petitionId}/dates", a.routerHandler(a.HandleSetPetitionDates))
 		// POST /bookings/request/{petitionId}/cancel
 		log.Info().Msg("register route POST /bookings/request/{petitionId}/cancel")
 		r.Post("/bookings/request/{petitionId}/cancel", a.routerHandler(a.HandleCancelRequest))
+
+		// Admin
+		// GET /admin/bookings/active
+		log.Info().Msg("register route GET /admin/bookings/active")
+		r.Get("/admin/bookings/active", a.routerHandler(a.adminHandler(a.adminActiveBookingsHandler)))
+		// PUT /admin/tools/{id}/featured
+		log.Info().Msg("register route PUT /admin/tools/{id}/featured")
+		r.Put("/admin/tools/{id}/featured", a.routerHandler(a.adminHandler(a.adminFeatureToolHandler)))
+		// GET /admin/audit
+		log.Info().Msg("register route GET /admin/audit")
+		r.Get("/admin/audit", a.routerHandler(a.adminHandler(a.adminAuditHandler)))
+		// POST /admin/tools/{id}/restore
+		log.Info().Msg("register route POST /admin/tools/{id}/restore")
+		r.Post("/admin/tools/{id}/restore", a.routerHandler(a.adminHandler(a.adminRestoreToolHandler)))
+		// POST /admin/users/{id}/restore
+		log.Info().Msg("register route POST /admin/users/{id}/restore")
+		r.Post("/admin/users/{id}/restore", a.routerHandler(a.adminHandler(a.adminRestoreUserHandler)))
+		// POST /admin/users/{id}/deactivate
+		log.Info().Msg("register route POST /admin/users/{id}/deactivate")
+		r.Post("/admin/users/{id}/deactivate", a.routerHandler(a.adminHandler(a.adminDeactivateUserHandler)))
+		// DELETE /admin/tools/{id}
+		log.Info().Msg("register route DELETE /admin/tools/{id}")
+		r.Delete("/admin/tools/{id}", a.routerHandler(a.adminHandler(a.adminDeleteToolHandler)))
+		// GET /admin/bookings/orphans
+		log.Info().Msg("register route GET /admin/bookings/orphans")
+		r.Get("/admin/bookings/orphans", a.routerHandler(a.adminHandler(a.adminOrphanBookingsHandler)))
+		// POST /admin/bookings/orphans/fix
+		log.Info().Msg("register route POST /admin/bookings/orphans/fix")
+		r.Post("/admin/bookings/orphans/fix", a.routerHandler(a.adminHandler(a.adminFixOrphanBookingsHandler)))
+		// POST /admin/invites
+		log.Info().Msg("register route POST /admin/invites")
+		r.Post("/admin/invites", a.routerHandler(a.adminHandler(a.adminCreateInviteHandler)))
+		// GET /admin/invites
+		log.Info().Msg("register route GET /admin/invites")
+		r.Get("/admin/invites", a.routerHandler(a.adminHandler(a.adminInvitesHandler)))
+		// DELETE /admin/invites/{id}
+		log.Info().Msg("register route DELETE /admin/invites/{id}")
+		r.Delete("/admin/invites/{id}", a.routerHandler(a.adminHandler(a.adminRevokeInviteHandler)))
 	})
 
 	// Public routes
-	r.Group(func(r chi.Router) {
+	limited.Group(func(r chi.Router) {
+		// Limit the requests of each IP address
+		r.Use(limiter.byIP)
 		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			if _, err := w.Write([]byte(".")); err != nil {
 				log.Error().Err(err).Msg("failed to write response")
@@ -213,16 +713,51 @@ func (a *API) router() http.Handler {
 		log.Info().Msg("register route POST /login")
 		r.Post("/login", a.routerHandler(a.loginHandler))
 		log.Info().Msg("register route POST /register")
-		r.Post("/register", a.routerHandler(a.registerHandler))
+		r.Post("/register", a.uploadHandler(a.registerHandler, 1))
+		log.Info().Msg("register route POST /refresh")
+		r.Post("/refresh", a.routerHandler(a.refreshHandler))
+		log.Info().Msg("register route POST /password/reset/request")
+		r.Post("/password/reset/request", a.routerHandler(a.passwordResetRequestHandler))
+		log.Info().Msg("register route POST /password/reset/confirm")
+		r.Post("/password/reset/confirm", a.routerHandler(a.passwordResetConfirmHandler))
+		log.Info().Msg("register route POST /verify")
+		r.Post("/verify", a.routerHandler(a.verifyHandler))
 		log.Info().Msg("register route GET /info")
 		r.Get("/info", a.routerHandler(a.infoHandler))
+		log.Info().Msg("register route GET /info/booking-statuses")
+		r.Get("/info/booking-statuses", a.routerHandler(a.bookingStatusesHandler))
+		log.Info().Msg("register route GET /tools/{id}/preview")
+		r.Get("/tools/{id}/preview", a.routerHandler(a.toolPreviewHandler))
+		if a.opts.MetricsAddr == "" {
+			// GET /metrics
+			log.Info().Msg("register route GET /metrics")
+			r.Method(http.MethodGet, "/metrics", a.metrics.handler())
+		}
 	})
 
 	return r
 }
 
 // info handler returns the basic info about the API.
+// The response is cached for infoCacheTTL, the concurrent requests of an expired cache wait
+// for a single refresh.
 func (a *API) infoHandler(r *Request) (interface{}, error) {
+	a.infoCache.mu.Lock()
+	defer a.infoCache.mu.Unlock()
+	if a.infoCache.info != nil && time.Now().Before(a.infoCache.expires) {
+		return a.infoCache.info, nil
+	}
+	info, err := a.info()
+	if err != nil {
+		return nil, err
+	}
+	a.infoCache.info = info
+	a.infoCache.expires = time.Now().Add(infoCacheTTL)
+	return info, nil
+}
+
+// info queries the basic info about the API.
+func (a *API) info() (*Info, error) {
 	ctx := context.Background()
 
 	// Get user count
@@ -252,10 +787,38 @@ func (a *API) infoHandler(r *Request) (interface{}, error) {
 	// Get categories
 	categories := a.toolCategories()
 
+	// Count the tools of each category, listing the empty ones too
+	counts, err := a.database.ToolService.CountToolsByCategory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tools by category: %w", err)
+	}
+	toolsByCategory := make(map[int]int64, len(categories))
+	for _, category := range categories {
+		toolsByCategory[category.ID] = counts[category.ID]
+	}
+
 	return &Info{
-		Users:      int(userCount),
-		Tools:      int(toolCount),
-		Categories: categories,
-		Transports: transportList,
+		Users:           int(userCount),
+		Tools:           int(toolCount),
+		Categories:      categories,
+		Transports:      transportList,
+		ToolsByCategory: toolsByCategory,
 	}, nil
 }
+
+// bookingStatusesHandler returns the booking statuses and the allowed transitions between them,
+// as enforced by the booking handlers.
+func (a *API) bookingStatusesHandler(r *Request) (interface{}, error) {
+	info := &BookingStatusesInfo{
+		Statuses:    make([]string, len(db.BookingStatuses)),
+		Transitions: make(map[string][]string, len(db.BookingStatuses)),
+	}
+	for i, status := range db.BookingStatuses {
+		info.Statuses[i] = string(status)
+		info.Transitions[string(status)] = []string{}
+		for _, next := range db.BookingTransitions[status] {
+			info.Transitions[string(status)] = append(info.Transitions[string(status)], string(next))
+		}
+	}
+	return info, nil
+}
@@ -3,11 +3,16 @@ package api
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/emprius/emprius-app-backend/db"
 	"github.com/emprius/emprius-app-backend/types"
@@ -92,7 +97,29 @@ func testAPI(t *testing.T) *API {
 	err = database.CreateTables()
 	qt.Assert(t, err, qt.IsNil)
 
-	return New("secret", "authtoken", database)
+	return New("secret", "authtoken", database, nil)
+}
+
+// testRequest builds a Request for calling a handler directly, as the given user email.
+// The body is marshaled to JSON and urlParams are set as chi URL parameters.
+func testRequest(t *testing.T, method, target, userEmail string, body any, urlParams map[string]string) *Request {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	req := httptest.NewRequest(method, target, nil)
+	rctx := chi.NewRouteContext()
+	for k, v := range urlParams {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	return &Request{
+		Data:    data,
+		Context: &HTTPContext{Request: req, Writer: httptest.NewRecorder()},
+		UserID:  userEmail,
+	}
 }
 
 func TestBookingDateConflicts(t *testing.T) {
@@ -141,7 +168,8 @@ func TestBookingDateConflicts(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 
 	// Accept first booking
-	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking1.ID, db.BookingStatusAccepted)
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking1.ID, db.BookingStatusAccepted,
+		primitive.NilObjectID)
 	qt.Assert(t, err, qt.IsNil)
 
 	// Try to create third booking for same dates (should fail since there's an accepted booking)
@@ -155,8 +183,17 @@ func TestBookingDateConflicts(t *testing.T) {
 	_, err = a.database.BookingService.Create(context.Background(), booking3, user2.ID, user1.ID)
 	qt.Assert(t, err, qt.ErrorMatches, db.ErrBookingDatesConflict.Error())
 
+	// A partial overlap conflicts as well
+	_, err = a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+		ToolID:    toolIDStr,
+		StartDate: startDate.Add(12 * time.Hour).Unix(),
+		EndDate:   endDate.Add(12 * time.Hour).Unix(),
+	}, nil))
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+
 	// Verify the second booking can still be accepted or rejected
-	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking2.ID, db.BookingStatusRejected)
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking2.ID, db.BookingStatusRejected,
+		primitive.NilObjectID)
 	qt.Assert(t, err, qt.IsNil)
 }
 
@@ -208,7 +245,8 @@ func TestBookingStatusTransitions(t *testing.T) {
 	qt.Assert(t, bookings[0].ToolID, qt.Equals, toolIDStr)
 
 	// Test accepting a petition
-	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking.ID, db.BookingStatusAccepted)
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking.ID, db.BookingStatusAccepted,
+		primitive.NilObjectID)
 	qt.Assert(t, err, qt.IsNil)
 
 	// Verify booking status
@@ -228,7 +266,8 @@ func TestBookingStatusTransitions(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 
 	// Test denying a petition
-	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking2.ID, db.BookingStatusRejected)
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking2.ID, db.BookingStatusRejected,
+		primitive.NilObjectID)
 	qt.Assert(t, err, qt.IsNil)
 
 	// Verify booking status
@@ -248,13 +287,49 @@ func TestBookingStatusTransitions(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 
 	// Test canceling a request
-	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking3.ID, db.BookingStatusCancelled)
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking3.ID, db.BookingStatusCancelled,
+		primitive.NilObjectID)
 	qt.Assert(t, err, qt.IsNil)
 
 	// Verify booking status
 	updatedBooking3, err := a.database.BookingService.Get(context.Background(), createdBooking3.ID)
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, updatedBooking3.BookingStatus, qt.Equals, db.BookingStatusCancelled)
+
+	// Final statuses cannot change
+	for _, id := range []primitive.ObjectID{createdBooking2.ID, createdBooking3.ID} {
+		err = a.database.BookingService.UpdateStatus(context.Background(), id, db.BookingStatusAccepted, primitive.NilObjectID)
+		qt.Assert(t, err, qt.Equals, db.ErrInvalidTransition)
+	}
+
+	// Only accepted bookings can be returned, the owner cannot skip the acceptance
+	createdBooking4, err := a.database.BookingService.Create(context.Background(), &db.CreateBookingRequest{
+		ToolID:    toolIDStr,
+		StartDate: time.Now().Add(168 * time.Hour),
+		EndDate:   time.Now().Add(192 * time.Hour),
+	}, user2.ID, user1.ID)
+	qt.Assert(t, err, qt.IsNil)
+	for _, id := range []primitive.ObjectID{createdBooking2.ID, createdBooking4.ID} {
+		_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id.Hex()+"/return", testUser1.Email, nil,
+			map[string]string{"bookingId": id.Hex()}))
+		qt.Assert(t, err, qt.Equals, ErrCanOnlyReturnAccepted)
+	}
+	err = a.database.BookingService.UpdateStatus(context.Background(), createdBooking4.ID, db.BookingStatusReturned,
+		primitive.NilObjectID)
+	qt.Assert(t, err, qt.Equals, db.ErrInvalidTransition)
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+createdBooking.ID.Hex()+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": createdBooking.ID.Hex()}))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+// setBookingStatusForTest moves the booking to the status through the legal transitions,
+// accepting it first for the statuses only reachable from accepted.
+func setBookingStatusForTest(t *testing.T, a *API, id primitive.ObjectID, status db.BookingStatus) {
+	ctx := context.Background()
+	if !db.CanTransition(db.BookingStatusPending, status) {
+		qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, id, db.BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+	}
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, id, status, primitive.NilObjectID), qt.IsNil)
 }
 
 func TestImage(t *testing.T) {
@@ -269,3 +344,78 @@ func TestImage(t *testing.T) {
 	qt.Assert(t, err, qt.IsNil)
 	qt.Assert(t, image.Content, qt.DeepEquals, pngImageForTest())
 }
+
+func TestImageDeduplication(t *testing.T) {
+	a := testAPI(t)
+
+	// upload the same content twice, with different names
+	i1, err := a.addImage("image1", pngImageForTest())
+	qt.Assert(t, err, qt.IsNil)
+	i2, err := a.addImage("image2", pngImageForTest())
+	qt.Assert(t, err, qt.IsNil)
+
+	// the second upload returns the existing entry
+	qt.Assert(t, i2.Hash, qt.DeepEquals, i1.Hash)
+	qt.Assert(t, i2.Name, qt.Equals, "image1")
+
+	// and a single blob is stored
+	count, err := a.database.ImageService.Collection.CountDocuments(context.Background(), bson.M{})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, int64(1))
+}
+
+func TestTruncateString(t *testing.T) {
+	qt.Assert(t, truncateString("short", 10), qt.Equals, "short")
+	qt.Assert(t, truncateString("a longer description", 10), qt.Equals, "a longer…")
+	qt.Assert(t, truncateString("àèìòùàèìòù", 5), qt.Equals, "àèìò…")
+}
+
+func TestInfoCache(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	users := func() int {
+		resp, err := a.infoHandler(testRequest(t, "GET", "/info", "", nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*Info).Users
+	}
+	qt.Assert(t, users(), qt.Equals, 1)
+
+	// A rapid second call is served from the cache, without the new user
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, users(), qt.Equals, 1)
+
+	// Once expired it is refreshed
+	a.infoCache.expires = time.Now()
+	qt.Assert(t, users(), qt.Equals, 2)
+}
+
+func TestInfoToolsByCategory(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	addTool := func(title string, category int) int64 {
+		tool := testTool1
+		tool.Title, tool.Category = title, category
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	addTool("drill", 1)
+	deleted := addTool("old drill", 1)
+	addTool("tractor", 2)
+	_, err := a.database.ToolService.UpdateTool(context.Background(), deleted, bson.M{"deleted": true})
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, err := a.infoHandler(testRequest(t, "GET", "/info", "", nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	info := resp.(*Info)
+	qt.Assert(t, info.Tools, qt.Equals, 3)
+	// Every category is listed, the deleted tool is not counted
+	qt.Assert(t, info.ToolsByCategory, qt.HasLen, len(info.Categories))
+	for _, category := range info.Categories {
+		expected := int64(0)
+		if category.ID == 1 || category.ID == 2 {
+			expected = 1
+		}
+		qt.Assert(t, info.ToolsByCategory[category.ID], qt.Equals, expected, qt.Commentf("category %d", category.ID))
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// audit records an action of the user performing the request in the audit log.
+// Failures are logged but not returned, so they never break the audited operation.
+func (a *API) audit(
+	ctx context.Context,
+	r *Request,
+	action db.AuditAction,
+	targetType db.AuditTargetType,
+	targetID string,
+	details string,
+) {
+	actor, err := a.userByEmail(r.UserID)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not get user %s to audit %s", r.UserID, action)
+		return
+	}
+	if err := a.database.AuditService.Create(ctx, &db.AuditEntry{
+		ActorID:    actor.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+	}); err != nil {
+		log.Warn().Err(err).Msgf("could not audit %s by %s", action, r.UserID)
+	}
+}
+
+// adminAuditHandler handles GET /admin/audit
+// It returns the audit log, newest first. The entries can be filtered by actor (user ID), action,
+// targetType, targetId and a time range with from and to (UNIX time, inclusive).
+func (a *API) adminAuditHandler(r *Request) (interface{}, error) {
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := auditFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, total, err := a.database.AuditService.Get(r.Context.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return &AuditLogResponse{
+		Entries: entries,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// auditFilter parses the audit log filters of the request query.
+func auditFilter(r *Request) (*db.AuditFilter, error) {
+	filter := &db.AuditFilter{
+		Action:     db.AuditAction(r.Context.QueryParam("action")),
+		TargetType: db.AuditTargetType(r.Context.QueryParam("targetType")),
+		TargetID:   r.Context.QueryParam("targetId"),
+	}
+	if filter.Action != "" && !db.IsValidAuditAction(filter.Action) {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if actor := r.Context.QueryParam("actor"); actor != "" {
+		actorID, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			return nil, ErrInvalidRequestBodyData
+		}
+		filter.ActorID = actorID
+	}
+	for param, date := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := r.Context.QueryParam(param); value != "" {
+			unix, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || unix < 0 {
+				return nil, ErrInvalidRequestBodyData
+			}
+			*date = time.Unix(unix, 0)
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.To.Before(filter.From) {
+		return nil, ErrInvalidRequestBodyData
+	}
+	return filter, nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestAdminAuditLog(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	admin, err := a.database.UserService.GetUserByEmail(ctx, testAdmin.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Admin actions are recorded
+	idStr := fmt.Sprintf("%d", toolID)
+	req := testRequest(t, "PUT", "/admin/tools/"+idStr+"/featured", testAdmin.Email,
+		&FeaturedToolRequest{Featured: true}, map[string]string{"id": idStr})
+	_, err = a.adminHandler(a.adminFeatureToolHandler)(req)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Older entries to filter by time
+	base := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	for i, action := range []db.AuditAction{db.AuditUserRestored, db.AuditToolRestored, db.AuditToolRestored} {
+		qt.Assert(t, a.database.AuditService.Create(ctx, &db.AuditEntry{
+			ActorID:    admin.ID,
+			Action:     action,
+			TargetType: db.AuditTargetTool,
+			TargetID:   idStr,
+			Timestamp:  base.Add(time.Duration(i) * 24 * time.Hour),
+		}), qt.IsNil)
+	}
+
+	auditLog := func(email, query string) (*AuditLogResponse, error) {
+		resp, err := a.adminHandler(a.adminAuditHandler)(testRequest(t, "GET", "/admin/audit"+query,
+			email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*AuditLogResponse), nil
+	}
+	_, err = auditLog(testUser1.Email, "")
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+
+	// Newest first
+	resp, err := auditLog(testAdmin.Email, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(4))
+	qt.Assert(t, resp.Entries[0].Action, qt.Equals, db.AuditToolFeatured)
+	qt.Assert(t, resp.Entries[0].ActorID, qt.Equals, admin.ID)
+	qt.Assert(t, resp.Entries[0].TargetID, qt.Equals, idStr)
+
+	// By action type
+	resp, err = auditLog(testAdmin.Email, "?action=TOOL_RESTORED")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Entries, qt.HasLen, 2)
+	qt.Assert(t, resp.Entries[0].Timestamp.After(resp.Entries[1].Timestamp), qt.IsTrue)
+	_, err = auditLog(testAdmin.Email, "?action=UNKNOWN")
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+
+	// By time range, both ends included
+	resp, err = auditLog(testAdmin.Email, fmt.Sprintf("?from=%d&to=%d",
+		base.Unix(), base.Add(24*time.Hour).Unix()))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Entries, qt.HasLen, 2)
+	qt.Assert(t, resp.Entries[0].Action, qt.Equals, db.AuditToolRestored)
+	qt.Assert(t, resp.Entries[1].Action, qt.Equals, db.AuditUserRestored)
+	resp, err = auditLog(testAdmin.Email, fmt.Sprintf("?action=TOOL_RESTORED&from=%d", base.Add(time.Hour).Unix()))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Entries, qt.HasLen, 2)
+	_, err = auditLog(testAdmin.Email, fmt.Sprintf("?from=%d&to=%d", base.Unix(), base.Add(-time.Hour).Unix()))
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+
+	// Paginated
+	resp, err = auditLog(testAdmin.Email, "?pageSize=3&page=1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Entries, qt.HasLen, 1)
+	qt.Assert(t, resp.Entries[0].Action, qt.Equals, db.AuditUserRestored)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(4))
+}
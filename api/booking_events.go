@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// bookingEventsKeepAlive is how often a comment is sent on idle event streams, so proxies
+// do not close them.
+const bookingEventsKeepAlive = 15 * time.Second
+
+// bookingEventsHandler handles GET /bookings/events
+// It opens a Server-Sent Events stream with an event for every booking of the user, as requester
+// or owner, that is created or changes its status. The stream ends when the client disconnects.
+func (a *API) bookingEventsHandler(w http.ResponseWriter, req *http.Request) {
+	user, err := a.database.UserService.GetUserByEmail(req.Context(), userIDFromContext(req.Context()))
+	if err != nil {
+		http.Error(w, ErrUserNotFound.Message, ErrUserNotFound.Code)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := a.database.BookingService.Events.Subscribe(user.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(bookingEventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var msg string
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			msg = ": keep-alive\n\n"
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(&BookingEventResponse{
+				Type:    string(event.Type),
+				Booking: convertBookingToResponse(event.Booking),
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("failed to marshal booking event")
+				continue
+			}
+			msg = fmt.Sprintf("data: %s\n\n", data)
+		}
+		if _, err := fmt.Fprint(w, msg); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
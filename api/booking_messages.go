@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// bookingForParticipant returns the booking of the bookingId URL parameter if the user of the
+// request is its owner or its requester.
+func (a *API) bookingForParticipant(r *Request) (*db.Booking, *db.User, error) {
+	if r.UserID == "" {
+		return nil, nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	bookingID, err := primitive.ObjectIDFromHex(r.Context.URLParam("bookingId"))
+	if err != nil {
+		return nil, nil, ErrInvalidRequestBodyData
+	}
+	booking, err := a.getBooking(ctx, bookingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if booking.FromUserID != user.ID && booking.ToUserID != user.ID {
+		return nil, nil, ErrUserNotInvolved
+	}
+	return booking, user, nil
+}
+
+// HandleGetBookingMessages handles GET /bookings/{bookingId}/messages
+// It returns the messages of the booking in chronological order, only to the users involved.
+func (a *API) HandleGetBookingMessages(r *Request) (interface{}, error) {
+	booking, _, err := a.bookingForParticipant(r)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := a.database.BookingMessageService.GetBookingMessages(r.Context.Request.Context(), booking.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return messages, nil
+}
+
+// HandleCreateBookingMessage handles POST /bookings/{bookingId}/messages
+// The owner or the requester sends a message to the other party while the booking is pending or accepted.
+func (a *API) HandleCreateBookingMessage(r *Request) (interface{}, error) {
+	booking, user, err := a.bookingForParticipant(r)
+	if err != nil {
+		return nil, err
+	}
+	var req BookingMessageRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		return nil, ErrEmptyMessage
+	}
+	if len([]rune(text)) > maxBookingMessage {
+		return nil, ErrMessageTooLong
+	}
+	if booking.BookingStatus != db.BookingStatusPending && booking.BookingStatus != db.BookingStatusAccepted {
+		return nil, ErrCanOnlyMessageActive
+	}
+
+	message, err := a.database.BookingMessageService.Create(r.Context.Request.Context(), &db.BookingMessage{
+		BookingID: booking.ID,
+		AuthorID:  user.ID,
+		Text:      text,
+	})
+	if err != nil {
+		return nil, ErrCouldNotInsertToDatabase
+	}
+	return message, nil
+}
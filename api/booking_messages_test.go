@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestBookingMessages(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&db.User{Name: "carol", Email: "carol@emprius.cat"}), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", id),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	booking := resp.(BookingResponse)
+	params := map[string]string{"bookingId": booking.ID}
+	path := "/bookings/" + booking.ID + "/messages"
+
+	send := func(email, text string) (*db.BookingMessage, error) {
+		resp, err := a.HandleCreateBookingMessage(testRequest(t, "POST", path, email,
+			&BookingMessageRequest{Text: text}, params))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*db.BookingMessage), nil
+	}
+
+	// Both parties exchange messages while the booking is pending
+	first, err := send(testUser2.Email, "Can I pick it up at 9?")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, first.Text, qt.Equals, "Can I pick it up at 9?")
+	_, err = send(testUser1.Email, "  Sure, at the square  ")
+	qt.Assert(t, err, qt.IsNil)
+
+	// Empty and oversized messages are rejected
+	_, err = send(testUser1.Email, "   ")
+	qt.Assert(t, err, qt.Equals, ErrEmptyMessage)
+	_, err = send(testUser1.Email, strings.Repeat("a", maxBookingMessage+1))
+	qt.Assert(t, err, qt.Equals, ErrMessageTooLong)
+
+	// A non-participant can neither read nor write
+	_, err = send("carol@emprius.cat", "hello")
+	qt.Assert(t, err, qt.Equals, ErrUserNotInvolved)
+	_, err = a.HandleGetBookingMessages(testRequest(t, "GET", path, "carol@emprius.cat", nil, params))
+	qt.Assert(t, err, qt.Equals, ErrUserNotInvolved)
+
+	// The messages are returned in chronological order to both parties
+	for _, email := range []string{testUser1.Email, testUser2.Email} {
+		resp, err = a.HandleGetBookingMessages(testRequest(t, "GET", path, email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+		messages := resp.([]*db.BookingMessage)
+		qt.Assert(t, messages, qt.HasLen, 2)
+		qt.Assert(t, messages[0].ID, qt.Equals, first.ID)
+		qt.Assert(t, messages[1].Text, qt.Equals, "Sure, at the square")
+	}
+
+	// Messages can still be sent once accepted, but not after the return
+	bookingID, err := primitive.ObjectIDFromHex(booking.ID)
+	qt.Assert(t, err, qt.IsNil)
+	setBookingStatusForTest(t, a, bookingID, db.BookingStatusAccepted)
+	_, err = send(testUser2.Email, "On my way")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.BookingService.UpdateStatus(context.Background(), bookingID, db.BookingStatusReturned,
+		primitive.NilObjectID), qt.IsNil)
+	_, err = send(testUser2.Email, "Thanks!")
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyMessageActive)
+
+	// Unknown bookings are not found
+	unknown := primitive.NewObjectID().Hex()
+	_, err = a.HandleGetBookingMessages(testRequest(t, "GET", "/bookings/"+unknown+"/messages",
+		testUser1.Email, nil, map[string]string{"bookingId": unknown}))
+	qt.Assert(t, err, qt.Equals, ErrBookingNotFound)
+}
@@ -1,35 +1,249 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/emprius/emprius-app-backend/db"
+	"github.com/emprius/emprius-app-backend/types"
 )
 
 // convertBookingToResponse converts a db.Booking to a BookingResponse
 func convertBookingToResponse(booking *db.Booking) BookingResponse {
+	var startDate, endDate *int64
+	if !booking.StartDate.IsZero() {
+		start, end := booking.StartDate.Unix(), booking.EndDate.Unix()
+		startDate, endDate = &start, &end
+	}
+	groupID := ""
+	if !booking.GroupID.IsZero() {
+		groupID = booking.GroupID.Hex()
+	}
+	var responseDue *time.Time
+	if !booking.ResponseDue.IsZero() {
+		responseDue = &booking.ResponseDue
+	}
+	var cost *BookingCost
+	if booking.Charge != nil {
+		cost = &BookingCost{
+			Days:        booking.Charge.Days,
+			CostPerDay:  booking.Charge.CostPerDay,
+			Total:       booking.Charge.Total,
+			Free:        booking.Charge.Free,
+			AskWithFee:  booking.Charge.AskWithFee,
+			Outstanding: booking.Charge.Outstanding,
+		}
+	}
 	return BookingResponse{
-		ID:            booking.ID.Hex(),
-		ToolID:        booking.ToolID,
-		FromUserID:    booking.FromUserID.Hex(),
-		ToUserID:      booking.ToUserID.Hex(),
-		StartDate:     booking.StartDate.Unix(),
-		EndDate:       booking.EndDate.Unix(),
-		Contact:       booking.Contact,
-		Comments:      booking.Comments,
-		BookingStatus: string(booking.BookingStatus),
-		CreatedAt:     booking.CreatedAt,
-		UpdatedAt:     booking.UpdatedAt,
+		ID:              booking.ID.Hex(),
+		ToolID:          booking.ToolID,
+		FromUserID:      booking.FromUserID.Hex(),
+		ToUserID:        booking.ToUserID.Hex(),
+		StartDate:       startDate,
+		EndDate:         endDate,
+		Contact:         booking.Contact,
+		Comments:        booking.Comments,
+		BookingStatus:   string(booking.BookingStatus),
+		GroupID:         groupID,
+		CreatedAt:       booking.CreatedAt,
+		UpdatedAt:       booking.UpdatedAt,
+		Cost:            cost,
+		Orphaned:        booking.Orphaned,
+		Transfer:        booking.Transfer,
+		RejectionReason: booking.RejectionReason,
+		AcceptedTerms:   booking.AcceptedTerms,
+		Transport:       booking.Transport,
+		Free:            booking.Free,
+		AgreedCost:      booking.AgreedCost,
+		ResponseDue:     responseDue,
+		Reschedule:      booking.Reschedule,
+	}
+}
+
+// bookingDates converts the unix timestamps of a booking request to times. If both are zero the
+// booking is an open request and zero times are returned. Otherwise the booking must end after it
+// starts, not start in the past and last at most MaxBookingDays.
+func (a *API) bookingDates(startDate, endDate int64) (time.Time, time.Time, error) {
+	if startDate == 0 && endDate == 0 {
+		return time.Time{}, time.Time{}, nil
+	}
+	if startDate <= 0 || endDate <= startDate || startDate < time.Now().Unix() {
+		return time.Time{}, time.Time{}, ErrInvalidBookingDates
+	}
+	if endDate-startDate > int64(a.opts.MaxBookingDays)*24*60*60 {
+		return time.Time{}, time.Time{}, ErrBookingTooLong
+	}
+	return time.Unix(startDate, 0), time.Unix(endDate, 0), nil
+}
+
+// checkLeadTime returns ErrInsufficientLeadTime if the booking starts sooner than the minimum
+// notice required by the tool. Open bookings, without start date, are not checked.
+func checkLeadTime(tool *db.Tool, startDate time.Time) error {
+	if tool.MinLeadHours == 0 || startDate.IsZero() {
+		return nil
+	}
+	if startDate.Before(time.Now().Add(time.Duration(tool.MinLeadHours) * time.Hour)) {
+		return ErrInsufficientLeadTime
+	}
+	return nil
+}
+
+// acceptedTerms returns the acceptance of the terms of the tools to record on their bookings, indexed
+// by tool ID, or ErrTermsNotAccepted if any of them has terms and the requester did not accept them.
+func acceptedTerms(accepted bool, tools ...*db.Tool) (map[string]*db.TermsAcceptance, error) {
+	terms := map[string]*db.TermsAcceptance{}
+	now := time.Now()
+	for _, tool := range tools {
+		version := tool.TermsVersion()
+		if version == "" {
+			continue
+		}
+		if !accepted {
+			return nil, ErrTermsNotAccepted
+		}
+		terms[strconv.FormatInt(tool.ID, 10)] = &db.TermsAcceptance{Version: version, AcceptedAt: now}
+	}
+	return terms, nil
+}
+
+// bookingTransport checks the tool offers the transport option and estimates its cost over the
+// distance from the requester location to the tool. It returns nil if no transport was chosen.
+func (a *API) bookingTransport(
+	ctx context.Context,
+	tool *db.Tool,
+	transportID int64,
+	from db.Location,
+) (*db.BookingTransport, error) {
+	if transportID == 0 {
+		return nil, nil
+	}
+	offered := false
+	for _, option := range tool.TransportOptions {
+		if option.ID == transportID {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		return nil, ErrInvalidTransportOption
+	}
+	transport, err := a.database.TransportService.GetTransportByID(ctx, transportID)
+	if err != nil {
+		return nil, ErrInvalidTransportOption
+	}
+	var distance float64
+	if from != (db.Location{}) && tool.Location != (db.Location{}) {
+		distance = db.Distance(from, tool.Location)
+	}
+	return &db.BookingTransport{
+		ID:       transport.ID,
+		Name:     transport.Name,
+		Distance: int64(math.Round(distance)),
+		Cost:     transport.EstimateCost(distance),
+	}, nil
+}
+
+// bookingServiceError maps the date validation and availability errors of the booking service
+// to their HTTP errors, any other error is an internal one.
+func bookingServiceError(err error) error {
+	switch {
+	case errors.Is(err, db.ErrInvalidBookingDates):
+		return ErrInvalidBookingDates
+	case errors.Is(err, db.ErrBookingTooLong):
+		return ErrBookingTooLong
+	case errors.Is(err, db.ErrToolUnavailable):
+		return ErrToolUnavailable
+	case errors.Is(err, db.ErrBookingDatesConflict):
+		return ErrBookingDatesConflict
+	default:
+		return ErrInternalServerError
+	}
+}
+
+// getBooking returns the booking with the given ID. A booking that does not exist is
+// ErrBookingNotFound, any other failure of the database is an internal error.
+func (a *API) getBooking(ctx context.Context, id primitive.ObjectID) (*db.Booking, error) {
+	booking, err := a.database.BookingService.Get(ctx, id)
+	switch {
+	case errors.Is(err, db.ErrBookingNotFound):
+		return nil, ErrBookingNotFound
+	case err != nil:
+		log.Error().Err(err).Str("bookingId", id.Hex()).Msg("failed to get booking")
+		return nil, ErrInternalServerError
+	}
+	return booking, nil
+}
+
+// convertRatingToResponse converts a db.Rating to a RatingResponse
+func convertRatingToResponse(rating *db.Rating) *RatingResponse {
+	return &RatingResponse{
+		ID:         rating.ID.Hex(),
+		BookingID:  rating.BookingID.Hex(),
+		FromUserID: rating.FromUserID.Hex(),
+		ToUserID:   rating.ToUserID.Hex(),
+		Rating:     rating.Rating,
+		Comment:    rating.Comment,
+		Images:     rating.Images,
+		Anonymous:  rating.Anonymous,
+		CreatedAt:  rating.CreatedAt,
+	}
+}
+
+// ratingImages returns the hashes of the images of a rating, without repetitions, or
+// ErrTooManyRatingImages if there are more than maxRatingImages and ErrImageNotFound if any of
+// them was not uploaded.
+func (a *API) ratingImages(hashes []types.HexBytes) ([]types.HexBytes, error) {
+	if len(hashes) > maxRatingImages {
+		return nil, ErrTooManyRatingImages
+	}
+	images, err := a.imageListFromSlice(hashes)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.HexBytes, len(images))
+	for i, image := range images {
+		result[i] = image.Hash
+	}
+	return result, nil
+}
+
+// convertUserToSummary converts a db.User to its public UserSummary
+func convertUserToSummary(user *db.User) *UserSummary {
+	return &UserSummary{
+		ID:         user.ID.Hex(),
+		Name:       user.Name,
+		Community:  user.Community,
+		AvatarHash: user.AvatarHash,
+		Rating:     user.Rating,
+	}
+}
+
+// convertToolToSummary converts a db.Tool to a ToolSummary
+func convertToolToSummary(tool *db.Tool) *ToolSummary {
+	images := make([]types.HexBytes, len(tool.Images))
+	for i, image := range tool.Images {
+		images[i] = image.Hash
+	}
+	return &ToolSummary{
+		ID:           tool.ID,
+		Title:        tool.Title,
+		ToolCategory: tool.ToolCategory,
+		Images:       images,
 	}
 }
 
 // HandleGetBookingRequests handles GET /bookings/requests
+// The optional status query parameter keeps only the bookings in any of the comma separated statuses.
 func (a *API) HandleGetBookingRequests(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
@@ -41,7 +255,11 @@ func (a *API) HandleGetBookingRequests(r *Request) (interface{}, error) {
 		return nil, ErrUserNotFound
 	}
 
-	bookings, err := a.database.BookingService.GetUserRequests(r.Context.Request.Context(), user.ID)
+	statuses, err := bookingStatusesParam(r)
+	if err != nil {
+		return nil, err
+	}
+	bookings, err := a.database.BookingService.GetUserRequestsByStatus(r.Context.Request.Context(), user.ID, statuses)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
@@ -54,7 +272,72 @@ func (a *API) HandleGetBookingRequests(r *Request) (interface{}, error) {
 	return response, nil
 }
 
+// HandleGetBookingHistory handles GET /bookings/history
+// It returns a page of the bookings of the user as both requester and owner, newest first, each
+// with the role of the user. The optional status query parameter is the same as on the other listings.
+func (a *API) HandleGetBookingHistory(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	statuses, err := bookingStatusesParam(r)
+	if err != nil {
+		return nil, err
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	bookings, total, err := a.database.BookingService.GetUserBookings(ctx, user.ID, statuses, page, pageSize)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	entries := make([]BookingHistoryEntry, len(bookings))
+	for i, booking := range bookings {
+		role := BookingRoleRequester
+		if booking.ToUserID == user.ID {
+			role = BookingRoleOwner
+		}
+		entries[i] = BookingHistoryEntry{BookingResponse: convertBookingToResponse(booking), Role: role}
+	}
+	return &BookingHistoryResponse{
+		Bookings: entries,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// bookingStatusesParam parses the optional status query parameter, a comma separated list of
+// booking statuses. It returns nil if not set and ErrInvalidBookingStatus on unknown statuses.
+func bookingStatusesParam(r *Request) ([]db.BookingStatus, error) {
+	param := r.Context.QueryParam("status")
+	if param == "" {
+		return nil, nil
+	}
+	statuses := []db.BookingStatus{}
+	for _, s := range strings.Split(param, ",") {
+		status := db.BookingStatus(strings.TrimSpace(s))
+		if !db.IsValidBookingStatus(status) {
+			return nil, ErrInvalidBookingStatus
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
 // HandleGetBookingPetitions handles GET /bookings/petitions
+// The optional category query parameter keeps only the bookings of tools of that category, and
+// the status one the bookings in any of the comma separated statuses.
 func (a *API) HandleGetBookingPetitions(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
@@ -66,9 +349,26 @@ func (a *API) HandleGetBookingPetitions(r *Request) (interface{}, error) {
 		return nil, ErrUserNotFound
 	}
 
-	bookings, err := a.database.BookingService.GetUserPetitions(r.Context.Request.Context(), user.ID)
+	statuses, err := bookingStatusesParam(r)
 	if err != nil {
-		return nil, ErrInternalServerError
+		return nil, err
+	}
+	var bookings []*db.Booking
+	if categoryStr := r.Context.QueryParam("category"); categoryStr != "" {
+		category, err := strconv.Atoi(categoryStr)
+		if err != nil {
+			return nil, ErrInvalidRequestBodyData
+		}
+		bookings, err = a.database.BookingService.GetUserPetitionsByCategory(r.Context.Request.Context(),
+			user.ID, category, statuses)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+	} else {
+		bookings, err = a.database.BookingService.GetUserPetitionsByStatus(r.Context.Request.Context(), user.ID, statuses)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
 	}
 
 	response := make([]BookingResponse, len(bookings))
@@ -79,24 +379,135 @@ func (a *API) HandleGetBookingPetitions(r *Request) (interface{}, error) {
 	return response, nil
 }
 
+// HandleGetOverduePetitions handles GET /bookings/petitions/overdue
+// It returns the pending petitions of the tools of the user not answered by their response deadline,
+// the most overdue first.
+func (a *API) HandleGetOverduePetitions(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	bookings, err := a.database.BookingService.GetOverduePetitions(r.Context.Request.Context(), user.ID, time.Now())
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	response := make([]BookingResponse, len(bookings))
+	for i, booking := range bookings {
+		response[i] = convertBookingToResponse(booking)
+	}
+	return response, nil
+}
+
 // HandleGetBooking handles GET /bookings/{bookingId}
 func (a *API) HandleGetBooking(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// Get user from database
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
 	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
 	if err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), bookingID)
+	booking, err := a.getBooking(r.Context.Request.Context(), bookingID)
 	if err != nil {
-		return nil, ErrInternalServerError
+		return nil, err
 	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+
+	// Verify user is involved in the booking or is an admin
+	if booking.FromUserID != user.ID && booking.ToUserID != user.ID && !user.Admin {
+		return nil, ErrUserNotInvolved
 	}
 
 	return convertBookingToResponse(booking), nil
 }
 
+// HandleGetBookingStatusHistory handles GET /bookings/{bookingId}/history
+// It returns the status changes of the booking in chronological order, with the user who made each
+// of them, only to the users involved.
+func (a *API) HandleGetBookingStatusHistory(r *Request) (interface{}, error) {
+	booking, _, err := a.bookingForParticipant(r)
+	if err != nil {
+		return nil, err
+	}
+	history, err := a.database.BookingService.GetStatusHistory(r.Context.Request.Context(), booking.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return history, nil
+}
+
+// HandleGetBookingReceipt handles GET /bookings/{bookingId}/receipt
+// It returns the summary of a returned booking, only to the users involved.
+func (a *API) HandleGetBookingReceipt(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	bookingID, err := primitive.ObjectIDFromHex(r.Context.URLParam("bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	booking, err := a.getBooking(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.FromUserID != user.ID && booking.ToUserID != user.ID {
+		return nil, ErrUserNotInvolved
+	}
+	if booking.BookingStatus != db.BookingStatusReturned {
+		return nil, ErrBookingNotReturned
+	}
+
+	usersByID, toolsByID, err := a.bookingRelations(ctx, []*db.Booking{booking})
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	receipt := &BookingReceiptResponse{
+		Booking:    convertBookingToResponse(booking),
+		ReturnedAt: booking.UpdatedAt,
+	}
+	if tool, ok := toolsByID[booking.ToolID]; ok {
+		receipt.Tool = convertToolToSummary(tool)
+	}
+	if requester, ok := usersByID[booking.FromUserID]; ok {
+		receipt.Requester = convertUserToSummary(requester)
+	}
+	if owner, ok := usersByID[booking.ToUserID]; ok {
+		receipt.Owner = convertUserToSummary(owner)
+	}
+	ratingBy := func(userID primitive.ObjectID) (*RatingResponse, error) {
+		rating, err := a.database.RatingService.Get(ctx, booking.ID, userID)
+		if errors.Is(err, db.ErrRatingNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		return convertRatingToResponse(rating), nil
+	}
+	if receipt.RequesterRating, err = ratingBy(booking.FromUserID); err != nil {
+		return nil, err
+	}
+	if receipt.OwnerRating, err = ratingBy(booking.ToUserID); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
 // HandleAcceptPetition handles POST /bookings/petitions/{petitionId}/accept
 func (a *API) HandleAcceptPetition(r *Request) (interface{}, error) {
 	if r.UserID == "" {
@@ -114,12 +525,9 @@ func (a *API) HandleAcceptPetition(r *Request) (interface{}, error) {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), petitionID)
+	booking, err := a.getBooking(r.Context.Request.Context(), petitionID)
 	if err != nil {
-		return nil, ErrInternalServerError
-	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+		return nil, err
 	}
 
 	// Verify user is the tool owner
@@ -127,17 +535,163 @@ func (a *API) HandleAcceptPetition(r *Request) (interface{}, error) {
 		return nil, ErrOnlyOwnerCanAccept
 	}
 
+	// Accepting twice is not an error, the client may be retrying
+	if booking.BookingStatus == db.BookingStatusAccepted {
+		return convertBookingToResponse(booking), nil
+	}
+
 	// Verify booking is in PENDING state
-	if booking.BookingStatus != db.BookingStatusPending {
+	if !db.CanTransition(booking.BookingStatus, db.BookingStatusAccepted) {
 		return nil, ErrCanOnlyAcceptPending
 	}
 
-	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), petitionID, db.BookingStatusAccepted)
+	// The deposits of the tools are held from the requester until the return
+	bookings, err := a.bookingGroup(r.Context.Request.Context(), booking)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	// Checked again by the acceptance, this only saves holding the deposits
+	active, err := a.database.BookingService.CountActiveBookings(r.Context.Request.Context(), booking.FromUserID)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	if active+int64(len(bookings)) > int64(a.opts.MaxActiveBookings) {
+		return nil, ErrTooManyActiveBookings
+	}
+	// The holds are unique per booking, so a concurrent accept of the same petition fails here
+	// instead of holding the deposits twice
+	held, err := a.holdDeposits(r.Context.Request.Context(), bookings)
+	switch {
+	case errors.Is(err, db.ErrInsufficientTokens):
+		return nil, ErrInsufficientTokens
+	case errors.Is(err, db.ErrDepositAlreadyHeld):
+		return nil, ErrAcceptInProgress
+	case err != nil:
+		return nil, ErrInternalServerError
+	}
 
-	return nil, nil
+	rejected, err := a.database.BookingService.Accept(r.Context.Request.Context(), petitionID, user.ID,
+		int64(a.opts.MaxActiveBookings))
+	if err != nil {
+		// Another booking of the tool was accepted for the same dates meanwhile, or the petition
+		// changed. The deposits held by this request are given back, nobody else could hold them.
+		a.giveBackDeposits(r.Context.Request.Context(), held)
+		switch {
+		case errors.Is(err, db.ErrInvalidTransition):
+			return nil, ErrCanOnlyAcceptPending
+		case errors.Is(err, db.ErrBookingDatesConflict):
+			return nil, ErrBookingDatesConflict
+		case errors.Is(err, db.ErrTooManyActiveBookings):
+			return nil, ErrTooManyActiveBookings
+		}
+		return nil, ErrInternalServerError
+	}
+	a.notify(r.Context.Request.Context(), booking.FromUserID, db.NotificationBookingAccepted, booking.ID)
+	// The requesters of the petitions that can no longer go ahead are told, once per kit
+	notifiedGroups := map[primitive.ObjectID]bool{}
+	for _, b := range rejected {
+		if !b.GroupID.IsZero() {
+			if notifiedGroups[b.GroupID] {
+				continue
+			}
+			notifiedGroups[b.GroupID] = true
+		}
+		a.notify(r.Context.Request.Context(), b.FromUserID, db.NotificationBookingRejected, b.ID)
+	}
+
+	return a.bookingResponse(r.Context.Request.Context(), petitionID)
+}
+
+// Warnings of the accept preview
+const (
+	warningDepositNotCovered = "the requester does not have enough tokens for the deposit, accepting will fail"
+	warningCostNotCovered    = "the requester does not have enough tokens to pay the booking at the moment"
+	warningAlreadyStarted    = "the booking dates already started"
+	warningTooManyActive     = "the requester already has the maximum number of accepted bookings, accepting will fail"
+)
+
+// HandleAcceptPreview handles GET /bookings/petitions/{petitionId}/accept/preview
+// It returns what accepting the petition would imply for the owner, without changing anything.
+func (a *API) HandleAcceptPreview(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	petitionID, err := primitive.ObjectIDFromHex(r.Context.URLParam("petitionId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	booking, err := a.getBooking(ctx, petitionID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.ToUserID != user.ID {
+		return nil, ErrOnlyOwnerCanAccept
+	}
+	if !db.CanTransition(booking.BookingStatus, db.BookingStatusAccepted) {
+		return nil, ErrCanOnlyAcceptPending
+	}
+
+	bookings, err := a.bookingGroup(ctx, booking)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	_, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	inGroup := make(map[primitive.ObjectID]bool, len(bookings))
+	for _, b := range bookings {
+		inGroup[b.ID] = true
+	}
+
+	preview := &AcceptPreviewResponse{Conflicts: []BookingResponse{}, Warnings: []string{}}
+	for _, b := range bookings {
+		if tool, ok := toolsByID[b.ToolID]; ok {
+			charge := db.NewBookingCharge(tool, b)
+			if b.Transfer {
+				charge = db.NewTransferCharge(tool, b)
+			}
+			preview.Tokens += charge.Total
+			preview.Deposit += tool.DepositTokens
+		}
+		others, err := a.database.BookingService.GetOverlapping(ctx, b.ToolID, b.StartDate, b.EndDate,
+			[]db.BookingStatus{db.BookingStatusPending})
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		for _, other := range others {
+			if !inGroup[other.ID] {
+				preview.Conflicts = append(preview.Conflicts, convertBookingToResponse(other))
+			}
+		}
+	}
+
+	requester, err := a.database.UserService.GetUserByID(ctx, booking.FromUserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	switch {
+	case requester.Tokens < preview.Deposit:
+		preview.Warnings = append(preview.Warnings, warningDepositNotCovered)
+	case requester.Tokens-preview.Deposit < preview.Tokens:
+		preview.Warnings = append(preview.Warnings, warningCostNotCovered)
+	}
+	if booking.StartDate.Before(time.Now()) {
+		preview.Warnings = append(preview.Warnings, warningAlreadyStarted)
+	}
+	active, err := a.database.BookingService.CountActiveBookings(ctx, booking.FromUserID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if active+int64(len(bookings)) > int64(a.opts.MaxActiveBookings) {
+		preview.Warnings = append(preview.Warnings, warningTooManyActive)
+	}
+	return preview, nil
 }
 
 // HandleDenyPetition handles POST /bookings/petitions/{petitionId}/deny
@@ -157,12 +711,9 @@ func (a *API) HandleDenyPetition(r *Request) (interface{}, error) {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), petitionID)
+	booking, err := a.getBooking(r.Context.Request.Context(), petitionID)
 	if err != nil {
-		return nil, ErrInternalServerError
-	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+		return nil, err
 	}
 
 	// Verify user is the tool owner
@@ -170,17 +721,23 @@ func (a *API) HandleDenyPetition(r *Request) (interface{}, error) {
 		return nil, ErrOnlyOwnerCanDeny
 	}
 
-	// Verify booking is in PENDING state
-	if booking.BookingStatus != db.BookingStatusPending {
+	if booking.BookingStatus == db.BookingStatusRejected {
+		return convertBookingToResponse(booking), nil
+	}
+
+	// Verify booking is in PENDING state, open requests can be denied as well
+	if !db.CanTransition(booking.BookingStatus, db.BookingStatusRejected) {
 		return nil, ErrCanOnlyDenyPending
 	}
 
-	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), petitionID, db.BookingStatusRejected)
+	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), petitionID, db.BookingStatusRejected,
+		user.ID)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	a.notify(r.Context.Request.Context(), booking.FromUserID, db.NotificationBookingRejected, booking.ID)
 
-	return nil, nil
+	return a.bookingResponse(r.Context.Request.Context(), petitionID)
 }
 
 // HandleCancelRequest handles POST /bookings/request/{petitionId}/cancel
@@ -200,12 +757,9 @@ func (a *API) HandleCancelRequest(r *Request) (interface{}, error) {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), petitionID)
+	booking, err := a.getBooking(r.Context.Request.Context(), petitionID)
 	if err != nil {
-		return nil, ErrInternalServerError
-	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+		return nil, err
 	}
 
 	// Verify user is the requester
@@ -213,27 +767,37 @@ func (a *API) HandleCancelRequest(r *Request) (interface{}, error) {
 		return nil, ErrOnlyRequesterCanCancel
 	}
 
-	// Verify booking is in PENDING state
-	if booking.BookingStatus != db.BookingStatusPending {
+	if booking.BookingStatus == db.BookingStatusCancelled {
+		return convertBookingToResponse(booking), nil
+	}
+
+	// Verify booking is in PENDING state, open requests can be cancelled as well.
+	// Accepted bookings are only cancelled by the owner, see HandleCancelBooking.
+	if booking.BookingStatus == db.BookingStatusAccepted ||
+		!db.CanTransition(booking.BookingStatus, db.BookingStatusCancelled) {
 		return nil, ErrCanOnlyCancelPending
 	}
 
-	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), petitionID, db.BookingStatusCancelled)
+	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), petitionID, db.BookingStatusCancelled,
+		user.ID)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	a.notify(r.Context.Request.Context(), booking.ToUserID, db.NotificationBookingCancelled, booking.ID)
 
-	return nil, nil
+	return a.bookingResponse(r.Context.Request.Context(), petitionID)
 }
 
-// HandleReturnBooking handles POST /bookings/{bookingId}/return
-func (a *API) HandleReturnBooking(r *Request) (interface{}, error) {
+// HandleCancelBooking handles POST /bookings/{bookingId}/cancel
+// The tool owner cancels an accepted booking, or the whole kit it belongs to, and the deposits
+// held are given back to the requester.
+func (a *API) HandleCancelBooking(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
+	ctx := r.Context.Request.Context()
 
-	// Get user from database
-	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
@@ -243,56 +807,557 @@ func (a *API) HandleReturnBooking(r *Request) (interface{}, error) {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), bookingID)
+	booking, err := a.getBooking(ctx, bookingID)
 	if err != nil {
-		return nil, ErrInternalServerError
-	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+		return nil, err
 	}
 
-	// Verify user is the tool owner
 	if booking.ToUserID != user.ID {
-		return nil, ErrOnlyOwnerCanReturn
+		return nil, ErrOnlyOwnerCanCancel
+	}
+	if booking.BookingStatus == db.BookingStatusCancelled {
+		return convertBookingToResponse(booking), nil
+	}
+	if booking.BookingStatus != db.BookingStatusAccepted {
+		return nil, ErrCanOnlyCancelAccepted
 	}
 
-	err = a.database.BookingService.UpdateStatus(r.Context.Request.Context(), bookingID, db.BookingStatusReturned)
+	if err := a.database.BookingService.UpdateStatus(ctx, bookingID, db.BookingStatusCancelled, user.ID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	bookings, err := a.bookingGroup(ctx, booking)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	if err := a.releaseDeposits(ctx, bookings, primitive.NilObjectID, 0); err != nil {
+		return nil, ErrInternalServerError
+	}
+	a.notify(ctx, booking.FromUserID, db.NotificationBookingCancelled, booking.ID)
 
-	return nil, nil
+	return a.bookingResponse(ctx, bookingID)
 }
 
-// HandleGetPendingRatings handles GET /bookings/rates
-func (a *API) HandleGetPendingRatings(r *Request) (interface{}, error) {
+// HandleRescheduleBooking handles POST /bookings/{bookingId}/reschedule
+// Either party proposes moving a pending or accepted booking to new dates, which must be available
+// for the tool as for a new booking. The other party is notified, and the dates only change once it
+// accepts them on POST /bookings/{bookingId}/reschedule/accept.
+func (a *API) HandleRescheduleBooking(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
+	ctx := r.Context.Request.Context()
 
-	// Get user from database
-	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
 
-	bookings, err := a.database.BookingService.GetPendingRatings(r.Context.Request.Context(), user.ID)
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
 	if err != nil {
-		return nil, ErrInternalServerError
+		return nil, ErrInvalidRequestBodyData
 	}
 
-	response := make([]BookingResponse, len(bookings))
-	for i, booking := range bookings {
-		response[i] = convertBookingToResponse(booking)
+	var req BookingDatesRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	startDate, endDate, err := a.bookingDates(req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	if startDate.IsZero() {
+		return nil, ErrInvalidBookingDates
 	}
 
-	return response, nil
-}
-
+	booking, err := a.getBooking(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	var counterpart primitive.ObjectID
+	switch user.ID {
+	case booking.FromUserID:
+		counterpart = booking.ToUserID
+	case booking.ToUserID:
+		counterpart = booking.FromUserID
+	default:
+		return nil, ErrUserNotInvolved
+	}
+	if booking.BookingStatus == db.BookingStatusReturned {
+		return nil, ErrBookingAlreadyReturned
+	}
+
+	if err := a.checkRescheduleLeadTime(ctx, booking, startDate); err != nil {
+		return nil, err
+	}
+
+	err = a.database.BookingService.ProposeReschedule(ctx, bookingID, startDate, endDate, user.ID)
+	switch {
+	case errors.Is(err, db.ErrCannotReschedule):
+		return nil, ErrCanOnlyReschedule
+	case err != nil:
+		return nil, bookingServiceError(err)
+	}
+	a.notify(ctx, counterpart, db.NotificationRescheduleProposed, booking.ID)
+
+	return a.bookingResponse(ctx, bookingID)
+}
+
+// HandleAcceptReschedule handles POST /bookings/{bookingId}/reschedule/accept
+// The party who did not propose the new dates of the booking accepts them, and they replace the
+// current ones if still available, with the notice required by the tools. The proposer is notified.
+func (a *API) HandleAcceptReschedule(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+
+	booking, err := a.getBooking(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID != booking.FromUserID && user.ID != booking.ToUserID {
+		return nil, ErrUserNotInvolved
+	}
+	if booking.Reschedule == nil || booking.Reschedule.ProposedBy == user.ID {
+		return nil, ErrNoRescheduleProposal
+	}
+	if err := a.checkRescheduleLeadTime(ctx, booking, booking.Reschedule.StartDate); err != nil {
+		return nil, err
+	}
+
+	proposal, err := a.database.BookingService.AcceptReschedule(ctx, bookingID, user.ID)
+	switch {
+	case errors.Is(err, db.ErrNoReschedule):
+		return nil, ErrNoRescheduleProposal
+	case errors.Is(err, db.ErrCannotReschedule):
+		return nil, ErrCanOnlyReschedule
+	case err != nil:
+		return nil, bookingServiceError(err)
+	}
+	a.notify(ctx, proposal.ProposedBy, db.NotificationBookingRescheduled, booking.ID)
+
+	return a.bookingResponse(ctx, bookingID)
+}
+
+// checkRescheduleLeadTime checks the new start date of the booking gives the notice required by the
+// tools of its kit, see checkLeadTime.
+func (a *API) checkRescheduleLeadTime(ctx context.Context, booking *db.Booking, startDate time.Time) error {
+	bookings, err := a.bookingGroup(ctx, booking)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	_, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	for _, tool := range toolsByID {
+		if err := checkLeadTime(tool, startDate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleSetPetitionDates handles POST /bookings/petitions/{petitionId}/dates
+// The tool owner proposes the dates of an open request, which becomes a pending petition.
+func (a *API) HandleSetPetitionDates(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// Get user from database
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	petitionID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "petitionId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+
+	var req BookingDatesRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	startDate, endDate, err := a.bookingDates(req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	if startDate.IsZero() {
+		return nil, ErrInvalidBookingDates
+	}
+
+	booking, err := a.getBooking(r.Context.Request.Context(), petitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify user is the tool owner
+	if booking.ToUserID != user.ID {
+		return nil, ErrOnlyOwnerCanSetDates
+	}
+
+	err = a.database.BookingService.SetDates(r.Context.Request.Context(), petitionID, user.ID, startDate, endDate)
+	switch {
+	case errors.Is(err, db.ErrBookingNotOpen):
+		return nil, ErrCanOnlySetDatesOnOpen
+	case err != nil:
+		return nil, bookingServiceError(err)
+	}
+
+	booking, err = a.database.BookingService.Get(r.Context.Request.Context(), petitionID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return convertBookingToResponse(booking), nil
+}
+
+// HandleReturnBooking handles POST /bookings/{bookingId}/return
+// The deposits held are released, except the optional depositClaim the owner takes for damages.
+func (a *API) HandleReturnBooking(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// Get user from database
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+
+	booking, err := a.getBooking(r.Context.Request.Context(), bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify user is the tool owner
+	if booking.ToUserID != user.ID {
+		return nil, ErrOnlyOwnerCanReturn
+	}
+
+	// Returning twice must not charge again
+	if booking.BookingStatus == db.BookingStatusReturned {
+		return convertBookingToResponse(booking), nil
+	}
+	if booking.Transfer {
+		return nil, ErrTransferCannotBeReturned
+	}
+
+	// Verify booking is in ACCEPTED state
+	if !db.CanTransition(booking.BookingStatus, db.BookingStatusReturned) {
+		return nil, ErrCanOnlyReturnAccepted
+	}
+
+	// The owner can claim part of the deposit if the tool came back damaged
+	var returnReq ReturnBookingRequest
+	if len(r.Data) > 0 {
+		if err := json.Unmarshal(r.Data, &returnReq); err != nil {
+			return nil, ErrInvalidRequestBodyData
+		}
+	}
+	if returnReq.DepositClaim > 0 {
+		hold, err := a.database.TokenService.GetHold(r.Context.Request.Context(), booking.ID)
+		if err != nil && !errors.Is(err, db.ErrDepositNotHeld) {
+			return nil, ErrInternalServerError
+		}
+		if hold == nil || returnReq.DepositClaim > uint64(-hold.Amount) {
+			return nil, ErrInvalidDepositClaim
+		}
+	}
+
+	if err := a.returnBooking(r.Context.Request.Context(), booking, user.ID, returnReq.DepositClaim); err != nil {
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return nil, ErrCanOnlyReturnAccepted
+		}
+		return nil, ErrInternalServerError
+	}
+	return a.bookingResponse(r.Context.Request.Context(), bookingID)
+}
+
+// returnBooking marks the accepted booking as returned by the actor, records its charges, settles the
+// deposits of its kit and notifies the requester. The claim is taken from the booking deposit.
+func (a *API) returnBooking(
+	ctx context.Context,
+	booking *db.Booking,
+	actor primitive.ObjectID,
+	depositClaim uint64,
+) error {
+	if err := a.database.BookingService.UpdateStatus(ctx, booking.ID, db.BookingStatusReturned, actor); err != nil {
+		return err
+	}
+	bookings, err := a.bookingGroup(ctx, booking)
+	if err != nil {
+		return err
+	}
+	// Deposits are released first, so the requester can use them to pay the charges
+	if err := a.releaseDeposits(ctx, bookings, booking.ID, depositClaim); err != nil {
+		return err
+	}
+	if err := a.recordCharges(ctx, booking); err != nil {
+		return err
+	}
+	a.notify(ctx, booking.FromUserID, db.NotificationBookingReturned, booking.ID)
+	return nil
+}
+
+// HandleHandOverBooking handles POST /bookings/{bookingId}/handover
+// The owner hands over the tool of an accepted transfer booking, which becomes owned by the requester.
+func (a *API) HandleHandOverBooking(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	booking, err := a.getBooking(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.ToUserID != user.ID {
+		return nil, ErrOnlyOwnerCanHandOver
+	}
+	// Handing over twice must not move the tool again
+	if booking.BookingStatus == db.BookingStatusTransferred {
+		return convertBookingToResponse(booking), nil
+	}
+	if !booking.Transfer || !db.CanTransition(booking.BookingStatus, db.BookingStatusTransferred) {
+		return nil, ErrCanOnlyHandOverTransfers
+	}
+	if err := a.handOverBooking(ctx, booking); err != nil {
+		return nil, err
+	}
+	return a.bookingResponse(ctx, bookingID)
+}
+
+// handOverBooking closes a transfer booking: the tool is charged at its estimated value, the deposit
+// given back and the ownership moved to the requester, at their location.
+func (a *API) handOverBooking(ctx context.Context, booking *db.Booking) error {
+	toolID, err := strconv.ParseInt(booking.ToolID, 10, 64)
+	if err != nil {
+		return ErrToolNotFound
+	}
+	tool, err := a.tool(toolID)
+	if err != nil {
+		return err
+	}
+	requester, err := a.database.UserService.GetUserByID(ctx, booking.FromUserID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	// Only the owner hands tools over, see HandleHandOverBooking
+	err = a.database.BookingService.UpdateStatus(ctx, booking.ID, db.BookingStatusTransferred, booking.ToUserID)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	if err := a.database.BookingService.SetCharge(ctx, booking.ID, db.NewTransferCharge(tool, booking)); err != nil {
+		return ErrInternalServerError
+	}
+	if err := a.releaseDeposits(ctx, []*db.Booking{booking}, primitive.NilObjectID, 0); err != nil {
+		return ErrInternalServerError
+	}
+	if err := a.database.ToolService.TransferOwnership(ctx, tool.ID, requester.ID, requester.Location); err != nil {
+		return ErrInternalServerError
+	}
+	log.Info().Msgf("tool %d handed over from %s to %s", tool.ID, booking.ToUserID.Hex(), requester.ID.Hex())
+	a.notify(ctx, booking.FromUserID, db.NotificationBookingHandedOver, booking.ID)
+	return nil
+}
+
+// bookingResponse fetches the current state of the booking and converts it to a BookingResponse.
+func (a *API) bookingResponse(ctx context.Context, id primitive.ObjectID) (interface{}, error) {
+	booking, err := a.getBooking(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return convertBookingToResponse(booking), nil
+}
+
+// bookingRelations fetches in batch the users and tools involved in the bookings.
+// Tools are indexed by the string identifier used in the bookings.
+func (a *API) bookingRelations(
+	ctx context.Context,
+	bookings []*db.Booking,
+) (map[primitive.ObjectID]*db.User, map[string]*db.Tool, error) {
+	userIDs := []primitive.ObjectID{}
+	toolIDs := []int64{}
+	for _, booking := range bookings {
+		userIDs = append(userIDs, booking.FromUserID, booking.ToUserID)
+		if toolID, err := strconv.ParseInt(booking.ToolID, 10, 64); err == nil {
+			toolIDs = append(toolIDs, toolID)
+		}
+	}
+	users, err := a.database.UserService.GetUsersByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	usersByID := make(map[primitive.ObjectID]*db.User, len(users))
+	for _, u := range users {
+		usersByID[u.ID] = u
+	}
+	tools, err := a.database.ToolService.GetToolsByIDs(ctx, toolIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	toolsByID := make(map[string]*db.Tool, len(tools))
+	for _, t := range tools {
+		toolsByID[fmt.Sprintf("%d", t.ID)] = t
+	}
+	return usersByID, toolsByID, nil
+}
+
+// recordCharges computes and stores the token charge of the returned booking, or of all the
+// bookings of its kit, and pays it from the requester to the owner. Bookings already charged are
+// skipped. If the requester cannot afford it, the charge is recorded as outstanding and collected
+// later by the background worker, see collectCharges.
+func (a *API) recordCharges(ctx context.Context, booking *db.Booking) error {
+	bookings, err := a.bookingGroup(ctx, booking)
+	if err != nil {
+		return err
+	}
+	_, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return err
+	}
+	for _, b := range bookings {
+		tool, ok := toolsByID[b.ToolID]
+		if !ok {
+			log.Warn().Msgf("tool %s of booking %s not found, not charging", b.ToolID, b.ID.Hex())
+			continue
+		}
+		charge := db.NewBookingCharge(tool, b)
+		err := a.database.BookingService.SetCharge(ctx, b.ID, charge)
+		if errors.Is(err, db.ErrBookingCharged) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if charge.Total == 0 {
+			continue
+		}
+		if err := a.payCharge(ctx, b, charge.Total); err != nil && !errors.Is(err, db.ErrInsufficientTokens) {
+			return err
+		}
+	}
+	return nil
+}
+
+// payCharge pays the charge of the booking from the requester to the owner. If it cannot be paid,
+// the charge is marked as outstanding, so it is collected later.
+func (a *API) payCharge(ctx context.Context, booking *db.Booking, total uint64) error {
+	err := a.transferTokens(ctx, booking.FromUserID, booking.ToUserID, total, db.TokenTransactionBookingCharge, booking.ID)
+	if err == nil {
+		return nil
+	}
+	log.Warn().Err(err).Msgf("requester %s cannot pay the %d tokens of booking %s",
+		booking.FromUserID.Hex(), total, booking.ID.Hex())
+	if _, merr := a.database.BookingService.SetChargeOutstanding(ctx, booking.ID, true); merr != nil {
+		log.Error().Err(merr).Msgf("could not mark the charge of booking %s as outstanding", booking.ID.Hex())
+	}
+	return err
+}
+
+// collectCharges pays the outstanding charges of the bookings whose requesters have the tokens now.
+// Each charge is claimed before paying it, so it is not paid twice. It returns the number of charges
+// collected.
+func (a *API) collectCharges(ctx context.Context) (int, error) {
+	bookings, err := a.database.BookingService.GetOutstandingCharges(ctx)
+	if err != nil {
+		return 0, err
+	}
+	collected := 0
+	for _, booking := range bookings {
+		claimed, err := a.database.BookingService.SetChargeOutstanding(ctx, booking.ID, false)
+		if err != nil {
+			return collected, err
+		}
+		if !claimed {
+			continue
+		}
+		err = a.payCharge(ctx, booking, booking.Charge.Total)
+		if errors.Is(err, db.ErrInsufficientTokens) {
+			continue
+		}
+		if err != nil {
+			return collected, err
+		}
+		collected++
+	}
+	return collected, nil
+}
+
+// HandleGetPendingRatings handles GET /bookings/rates
+func (a *API) HandleGetPendingRatings(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// Get user from database
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	bookings, err := a.database.BookingService.GetPendingRatings(r.Context.Request.Context(), user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	usersByID, toolsByID, err := a.bookingRelations(r.Context.Request.Context(), bookings)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	response := make([]PendingRatingResponse, len(bookings))
+	for i, booking := range bookings {
+		response[i] = PendingRatingResponse{BookingResponse: convertBookingToResponse(booking)}
+		counterpartyID := booking.FromUserID
+		if booking.FromUserID == user.ID {
+			counterpartyID = booking.ToUserID
+		}
+		if counterparty, ok := usersByID[counterpartyID]; ok {
+			response[i].Counterparty = convertUserToSummary(counterparty)
+		}
+		if tool, ok := toolsByID[booking.ToolID]; ok {
+			response[i].Tool = convertToolToSummary(tool)
+		}
+	}
+
+	return response, nil
+}
+
 // RateRequest represents the request body for rating a booking
 type RateRequest struct {
 	Rating    int    `json:"rating"`
 	BookingID string `json:"bookingId"`
+	Comment   string `json:"comment,omitempty"`
+	// Anonymous hides the rater from the public listing of the ratings of the rated user
+	Anonymous bool `json:"anonymous,omitempty"`
+	// Images are the hashes of uploaded photos documenting the condition of the returned tool
+	Images []types.HexBytes `json:"images,omitempty"`
 }
 
 // HandleCreateBooking handles POST /bookings
@@ -311,6 +1376,15 @@ func (a *API) HandleCreateBooking(r *Request) (interface{}, error) {
 	if err := json.Unmarshal(r.Data, &req); err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
+	if len(req.ToolIDs) > 0 {
+		if req.Transfer {
+			return nil, ErrInvalidBookingGroup
+		}
+		if req.Cost != nil {
+			return nil, ErrInvalidBookingCost
+		}
+		return a.createBookingGroup(r.Context.Request.Context(), fromUser, &req)
+	}
 
 	toolID, err := strconv.ParseInt(req.ToolID, 10, 64)
 	if err != nil {
@@ -322,7 +1396,7 @@ func (a *API) HandleCreateBooking(r *Request) (interface{}, error) {
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
-	if tool == nil {
+	if tool == nil || tool.Deleted {
 		return nil, ErrToolNotFound
 	}
 
@@ -331,24 +1405,173 @@ func (a *API) HandleCreateBooking(r *Request) (interface{}, error) {
 		return nil, ErrUserNotFound
 	}
 
+	startDate, endDate, err := a.bookingDates(req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLeadTime(tool, startDate); err != nil {
+		return nil, err
+	}
+	terms, err := acceptedTerms(req.AcceptedTerms, tool)
+	if err != nil {
+		return nil, err
+	}
+	if req.Transfer && req.Cost != nil {
+		return nil, ErrInvalidBookingCost
+	}
+	free, cost, err := agreedCost(tool, req.Free, req.Cost)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create booking request
 	dbReq := &db.CreateBookingRequest{
-		ToolID:    fmt.Sprintf("%d", toolID),
-		StartDate: time.Unix(req.StartDate, 0),
-		EndDate:   time.Unix(req.EndDate, 0),
-		Contact:   req.Contact,
-		Comments:  req.Comments,
+		ToolID:        fmt.Sprintf("%d", toolID),
+		StartDate:     startDate,
+		EndDate:       endDate,
+		Contact:       req.Contact,
+		Comments:      req.Comments,
+		Transfer:      req.Transfer,
+		AcceptedTerms: terms,
+		Free:          free,
+		AgreedCosts:   map[string]uint64{fmt.Sprintf("%d", toolID): cost},
+	}
+	if !startDate.IsZero() {
+		// Open requests have no deadline, the owner answers them with the dates
+		dbReq.ResponseDue = map[string]time.Time{dbReq.ToolID: a.responseDue(tool, time.Now())}
 	}
 
 	booking, err := a.database.BookingService.Create(r.Context.Request.Context(), dbReq, fromUser.ID, toUser.ID)
+	if errors.Is(err, db.ErrBookingDatesConflict) || errors.Is(err, db.ErrToolUnavailable) {
+		// Suggest the next dates the tool can be booked for as long, see toolNextAvailableHandler
+		suggestion, _ := a.nextAvailable(r.Context.Request.Context(), tool, startDate, endDate.Sub(startDate))
+		return suggestion, bookingServiceError(err)
+	}
 	if err != nil {
-		if err.Error() == "booking dates conflict with existing booking" {
-			return nil, ErrBookingDatesConflict
+		return nil, bookingServiceError(err)
+	}
+	a.notify(r.Context.Request.Context(), booking.ToUserID, db.NotificationBookingCreated, booking.ID)
+
+	return convertBookingToResponse(booking), nil
+}
+
+// agreedCost validates the cost a requester offers for the tool and returns whether the booking is
+// free and its cost per day. The cost defaults to the one of the tool and cannot exceed it. Asking
+// for free, or offering nothing for a tool that has a cost, is only possible if the tool may be free.
+func agreedCost(tool *db.Tool, free bool, cost *uint64) (bool, uint64, error) {
+	if cost != nil {
+		if *cost > tool.Cost || (free && *cost > 0) {
+			return false, 0, ErrInvalidBookingCost
+		}
+		free = free || (*cost == 0 && tool.Cost > 0)
+	}
+	switch {
+	case free && !tool.MayBeFree:
+		return false, 0, ErrToolNotFree
+	case free:
+		return true, 0, nil
+	case cost != nil:
+		return false, *cost, nil
+	}
+	return false, tool.Cost, nil
+}
+
+// responseDue returns when the owner of the tool is expected to answer a petition made at the given
+// time, after the deadline of the tool or the default ResponseDeadline.
+func (a *API) responseDue(tool *db.Tool, at time.Time) time.Time {
+	deadline := a.opts.ResponseDeadline
+	if tool.ResponseDeadlineHours > 0 {
+		deadline = time.Duration(tool.ResponseDeadlineHours) * time.Hour
+	}
+	return at.Add(deadline)
+}
+
+// createBookingGroup creates a kit with the tools of the request, which must all belong to the
+// same owner. If any of the tools is not available for the dates, no booking is created.
+func (a *API) createBookingGroup(ctx context.Context, fromUser *db.User, req *CreateBookingRequest) (interface{}, error) {
+	toolIDs := make([]int64, len(req.ToolIDs))
+	for i, id := range req.ToolIDs {
+		toolID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidRequestBodyData
 		}
+		toolIDs[i] = toolID
+	}
+	tools, err := a.database.ToolService.GetToolsByIDs(ctx, toolIDs)
+	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	toolsByID := make(map[int64]*db.Tool, len(tools))
+	for _, tool := range tools {
+		toolsByID[tool.ID] = tool
+	}
+	owner := primitive.NilObjectID
+	dbToolIDs := make([]string, len(toolIDs))
+	for i, id := range toolIDs {
+		tool, ok := toolsByID[id]
+		if !ok || tool.Deleted {
+			return nil, ErrToolNotFound
+		}
+		if !owner.IsZero() && tool.UserID != owner {
+			return nil, ErrInvalidBookingGroup
+		}
+		owner = tool.UserID
+		dbToolIDs[i] = fmt.Sprintf("%d", id)
+	}
 
-	return convertBookingToResponse(booking), nil
+	startDate, endDate, err := a.bookingDates(req.StartDate, req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	if startDate.IsZero() {
+		// Kits cannot be open requests
+		return nil, ErrInvalidBookingDates
+	}
+	for _, tool := range tools {
+		if err := checkLeadTime(tool, startDate); err != nil {
+			return nil, err
+		}
+	}
+	terms, err := acceptedTerms(req.AcceptedTerms, tools...)
+	if err != nil {
+		return nil, err
+	}
+	costs := make(map[string]uint64, len(tools))
+	due := make(map[string]time.Time, len(tools))
+	now := time.Now()
+	for _, tool := range tools {
+		if _, costs[fmt.Sprintf("%d", tool.ID)], err = agreedCost(tool, req.Free, nil); err != nil {
+			return nil, err
+		}
+		due[fmt.Sprintf("%d", tool.ID)] = a.responseDue(tool, now)
+	}
+
+	bookings, err := a.database.BookingService.CreateGroup(ctx, &db.CreateBookingRequest{
+		StartDate:     startDate,
+		EndDate:       endDate,
+		Contact:       req.Contact,
+		Comments:      req.Comments,
+		AcceptedTerms: terms,
+		Free:          req.Free,
+		AgreedCosts:   costs,
+		ResponseDue:   due,
+	}, dbToolIDs, fromUser.ID, owner)
+	switch {
+	case errors.Is(err, db.ErrInvalidBookingGroup):
+		return nil, ErrInvalidBookingGroup
+	case err != nil:
+		return nil, bookingServiceError(err)
+	}
+	a.notify(ctx, owner, db.NotificationBookingCreated, bookings[0].ID)
+
+	response := &BookingGroupResponse{
+		GroupID:  bookings[0].GroupID.Hex(),
+		Bookings: make([]BookingResponse, len(bookings)),
+	}
+	for i, booking := range bookings {
+		response.Bookings[i] = convertBookingToResponse(booking)
+	}
+	return response, nil
 }
 
 // HandleRateBooking handles POST /bookings/rates
@@ -373,12 +1596,87 @@ func (a *API) HandleRateBooking(r *Request) (interface{}, error) {
 		return nil, ErrInvalidRequestBodyData
 	}
 
-	booking, err := a.database.BookingService.Get(r.Context.Request.Context(), bookingID)
+	booking, err := a.getBooking(r.Context.Request.Context(), bookingID)
 	if err != nil {
+		return nil, err
+	}
+
+	// Verify user is involved in the booking
+	if booking.FromUserID != user.ID && booking.ToUserID != user.ID {
+		return nil, ErrUserNotInvolved
+	}
+
+	// A tool that was never returned cannot be rated
+	if booking.BookingStatus != db.BookingStatusReturned {
+		return nil, ErrCanOnlyRateReturned
+	}
+
+	// Verify rating value
+	if rateReq.Rating < db.MinRating || rateReq.Rating > db.MaxRating {
+		return nil, ErrInvalidRating
+	}
+	rateReq.Comment = strings.TrimSpace(rateReq.Comment)
+	if len([]rune(rateReq.Comment)) > maxRatingComment {
+		return nil, ErrRatingCommentTooLong
+	}
+	images, err := a.ratingImages(rateReq.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rated user is the other party of the booking
+	toUserID := booking.ToUserID
+	if booking.ToUserID == user.ID {
+		toUserID = booking.FromUserID
+	}
+
+	rating, err := a.database.RatingService.Create(r.Context.Request.Context(), &db.Rating{
+		BookingID:  booking.ID,
+		FromUserID: user.ID,
+		ToUserID:   toUserID,
+		Rating:     rateReq.Rating,
+		Comment:    rateReq.Comment,
+		Anonymous:  rateReq.Anonymous,
+		Images:     images,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrAlreadyRated) {
+			return nil, ErrBookingAlreadyRated
+		}
 		return nil, ErrInternalServerError
 	}
-	if booking == nil {
-		return nil, ErrBookingNotFound
+	a.updateUserRating(r.Context.Request.Context(), toUserID)
+
+	return convertRatingToResponse(rating), nil
+}
+
+// HandleRatePreview handles GET /bookings/{bookingId}/rate/preview?rating=
+// It returns the aggregated rating the rated user would have if the rating was submitted,
+// without storing anything.
+func (a *API) HandleRatePreview(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	// Get user from database
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+
+	rating, err := strconv.Atoi(r.Context.QueryParam("rating"))
+	if err != nil || rating < db.MinRating || rating > db.MaxRating {
+		return nil, ErrInvalidRating
+	}
+
+	booking, err := a.getBooking(r.Context.Request.Context(), bookingID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Verify user is involved in the booking
@@ -386,12 +1684,112 @@ func (a *API) HandleRateBooking(r *Request) (interface{}, error) {
 		return nil, ErrUserNotInvolved
 	}
 
-	// Verify rating value
-	if rateReq.Rating < 1 || rateReq.Rating > 5 {
+	// The rated user is the other party of the booking
+	toUserID := booking.ToUserID
+	if booking.ToUserID == user.ID {
+		toUserID = booking.FromUserID
+	}
+
+	average, err := a.database.RatingService.GetUserAverage(r.Context.Request.Context(), toUserID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	projected := average.With(rating)
+
+	response := &RatingPreviewResponse{
+		UserID:       toUserID.Hex(),
+		NewRating:    projected.Value(),
+		RatingsCount: projected.Count,
+	}
+	if average.Count > 0 {
+		current := average.Value()
+		response.CurrentRating = &current
+	}
+	return response, nil
+}
+
+// updateUserRating recomputes the rating of the user from all the ratings received.
+// Failures are logged, the rating is refreshed again on the next change.
+func (a *API) updateUserRating(ctx context.Context, userID primitive.ObjectID) {
+	if err := a.database.UserService.RecalculateRating(ctx, userID); err != nil {
+		log.Warn().Err(err).Msgf("could not update rating of user %s", userID.Hex())
+	}
+}
+
+// editableRating returns the rating given by the user to the booking of the request, checking
+// it is still within the grace period.
+func (a *API) editableRating(r *Request) (*db.Rating, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.database.UserService.GetUserByEmail(r.Context.Request.Context(), r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	bookingID, err := primitive.ObjectIDFromHex(chi.URLParam(r.Context.Request, "bookingId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	rating, err := a.database.RatingService.Get(r.Context.Request.Context(), bookingID, user.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrRatingNotFound) {
+			return nil, ErrRatingNotFound
+		}
+		return nil, ErrInternalServerError
+	}
+	if time.Since(rating.CreatedAt) > a.opts.RatingGracePeriod {
+		return nil, ErrRatingLocked
+	}
+	return rating, nil
+}
+
+// HandleEditRating handles PUT /bookings/{bookingId}/rate
+// The author can change the rating during the grace period.
+func (a *API) HandleEditRating(r *Request) (interface{}, error) {
+	rating, err := a.editableRating(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rateReq RateRequest
+	if err := json.Unmarshal(r.Data, &rateReq); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if rateReq.Rating < db.MinRating || rateReq.Rating > db.MaxRating {
 		return nil, ErrInvalidRating
 	}
+	rateReq.Comment = strings.TrimSpace(rateReq.Comment)
+	if len([]rune(rateReq.Comment)) > maxRatingComment {
+		return nil, ErrRatingCommentTooLong
+	}
+	images, err := a.ratingImages(rateReq.Images)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: Implement rating logic once rating schema is defined
+	ctx := r.Context.Request.Context()
+	if err := a.database.RatingService.Update(ctx, rating.ID, rateReq.Rating, rateReq.Comment, images); err != nil {
+		return nil, ErrInternalServerError
+	}
+	a.updateUserRating(ctx, rating.ToUserID)
 
+	rating.Rating = rateReq.Rating
+	rating.Comment = rateReq.Comment
+	rating.Images = images
+	return convertRatingToResponse(rating), nil
+}
+
+// HandleDeleteRating handles DELETE /bookings/{bookingId}/rate
+// The author can retract the rating during the grace period.
+func (a *API) HandleDeleteRating(r *Request) (interface{}, error) {
+	rating, err := a.editableRating(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context.Request.Context()
+	if err := a.database.RatingService.Delete(ctx, rating.ID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	a.updateUserRating(ctx, rating.ToUserID)
 	return nil, nil
 }
@@ -0,0 +1,1956 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+	"github.com/emprius/emprius-app-backend/types"
+)
+
+// returnedBookingForTest creates a booking of testTool1 from testUser2 to testUser1 and marks it as returned.
+func returnedBookingForTest(t *testing.T, a *API, startOffset time.Duration) *db.Booking {
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID(testUser1.Email, testTool1.Title)),
+		StartDate: time.Now().Add(startOffset),
+		EndDate:   time.Now().Add(startOffset + 24*time.Hour),
+		Contact:   "test@test.com",
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	setBookingStatusForTest(t, a, booking.ID, db.BookingStatusReturned)
+	return booking
+}
+
+// moveBookingForTest sets the dates of the booking directly in the database, as bookings cannot
+// be created in the past.
+func moveBookingForTest(t *testing.T, a *API, id string, start, end time.Time) {
+	bookingID, err := primitive.ObjectIDFromHex(id)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.Database.Collection("bookings").UpdateOne(context.Background(),
+		bson.M{"_id": bookingID}, bson.M{"$set": bson.M{"startDate": start, "endDate": end}})
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestRateBooking(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	rate := func(email, bookingID string, rating int) (*RatingResponse, error) {
+		resp, err := a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", email,
+			&RateRequest{BookingID: bookingID, Rating: rating, Comment: " thanks "}, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*RatingResponse), nil
+	}
+	pending := func(email string) []PendingRatingResponse {
+		resp, err := a.HandleGetPendingRatings(testRequest(t, "GET", "/bookings/rates", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.([]PendingRatingResponse)
+	}
+
+	// Bookings not returned cannot be rated
+	accepted, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID(testUser1.Email, testTool1.Title)),
+		StartDate: time.Now().Add(240 * time.Hour),
+		EndDate:   time.Now().Add(264 * time.Hour),
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, accepted.ID, db.BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+	_, err = rate(testUser2.Email, accepted.ID.Hex(), 4)
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyRateReturned)
+
+	booking := returnedBookingForTest(t, a, 24*time.Hour)
+	qt.Assert(t, pending(testUser1.Email), qt.HasLen, 1)
+	qt.Assert(t, pending(testUser2.Email), qt.HasLen, 1)
+
+	// The stored rating is returned
+	rating, err := rate(testUser2.Email, booking.ID.Hex(), 4)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, rating.ID, qt.Not(qt.Equals), "")
+	qt.Assert(t, rating.BookingID, qt.Equals, booking.ID.Hex())
+	qt.Assert(t, rating.FromUserID, qt.Equals, requester.ID.Hex())
+	qt.Assert(t, rating.ToUserID, qt.Equals, owner.ID.Hex())
+	qt.Assert(t, rating.Rating, qt.Equals, 4)
+	qt.Assert(t, rating.Comment, qt.Equals, "thanks")
+	_, err = rate(testUser2.Email, booking.ID.Hex(), 5)
+	qt.Assert(t, err, qt.Equals, ErrBookingAlreadyRated)
+
+	// The booking is pending only for the party that did not rate yet
+	qt.Assert(t, pending(testUser2.Email), qt.HasLen, 0)
+	qt.Assert(t, pending(testUser1.Email), qt.HasLen, 1)
+	_, err = rate(testUser1.Email, booking.ID.Hex(), 5)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, pending(testUser1.Email), qt.HasLen, 0)
+
+	// Photos can be attached as evidence, once uploaded and up to maxRatingImages
+	image, err := a.addImage("scratch", pngImageForTest())
+	qt.Assert(t, err, qt.IsNil)
+	withImages := returnedBookingForTest(t, a, 72*time.Hour)
+	rateWithImages := func(images ...types.HexBytes) (interface{}, error) {
+		return a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+			&RateRequest{BookingID: withImages.ID.Hex(), Rating: 2, Images: images}, nil))
+	}
+	_, err = rateWithImages(types.HexBytes("unknown"))
+	qt.Assert(t, err, qt.Equals, ErrImageNotFound)
+	tooMany := make([]types.HexBytes, maxRatingImages+1)
+	for i := range tooMany {
+		tooMany[i] = image.Hash
+	}
+	_, err = rateWithImages(tooMany...)
+	qt.Assert(t, err, qt.Equals, ErrTooManyRatingImages)
+	resp, err := rateWithImages(image.Hash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*RatingResponse).Images, qt.DeepEquals, []types.HexBytes{image.Hash})
+
+	// And they are shown along with the rating in the profile of the rated user
+	resp, err = a.getUserRatingsHandler(testRequest(t, "GET", "/users/"+owner.ID.Hex()+"/ratings", testUser1.Email,
+		nil, map[string]string{"id": owner.ID.Hex()}))
+	qt.Assert(t, err, qt.IsNil)
+	ratings := resp.(*UserRatingsResponse).Ratings
+	qt.Assert(t, ratings, qt.HasLen, 2)
+	qt.Assert(t, ratings[0].Images, qt.DeepEquals, []types.HexBytes{image.Hash})
+	qt.Assert(t, ratings[1].Images, qt.HasLen, 0)
+}
+
+func TestRecalculateUserRating(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	profile := func() *UserResponse {
+		resp, err := a.getUserHandler(testRequest(t, "GET", "/users/"+owner.ID.Hex(), testUser2.Email, nil,
+			map[string]string{"id": owner.ID.Hex()}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*UserResponse)
+	}
+
+	// Without ratings there is no rating to show, not the stored neutral one
+	qt.Assert(t, profile().Rating, qt.IsNil)
+	body, err := json.Marshal(profile())
+	qt.Assert(t, err, qt.IsNil)
+	fields := map[string]interface{}{}
+	qt.Assert(t, json.Unmarshal(body, &fields), qt.IsNil)
+	qt.Assert(t, fields["rating"], qt.IsNil)
+
+	for i, rating := range []int{5, 2} {
+		booking := returnedBookingForTest(t, a, time.Duration(i+1)*48*time.Hour)
+		_, err := a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+			&RateRequest{BookingID: booking.ID.Hex(), Rating: rating}, nil))
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	// 7 out of 10 is 70 in the 0-100 range
+	resp := profile()
+	qt.Assert(t, *resp.Rating, qt.Equals, int32(70))
+	qt.Assert(t, resp.RatingCount, qt.Equals, int64(2))
+	own, err := a.userProfileHandler(testRequest(t, "GET", "/profile", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *own.(*UserResponse).Rating, qt.Equals, int32(70))
+}
+
+func TestRatePreview(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&db.User{Name: "carol", Email: "carol@emprius.cat"}), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// A first rating, so the preview has an existing aggregate to change
+	first := returnedBookingForTest(t, a, 24*time.Hour)
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: first.ID.Hex(), Rating: 5}, nil))
+	qt.Assert(t, err, qt.IsNil)
+
+	second := returnedBookingForTest(t, a, 72*time.Hour)
+	params := map[string]string{"bookingId": second.ID.Hex()}
+	target := fmt.Sprintf("/bookings/%s/rate/preview?rating=2", second.ID.Hex())
+
+	// Only the involved users can preview
+	_, err = a.HandleRatePreview(testRequest(t, "GET", target, "carol@emprius.cat", nil, params))
+	qt.Assert(t, err, qt.Equals, ErrUserNotInvolved)
+
+	// Invalid rating values are rejected
+	_, err = a.HandleRatePreview(testRequest(t, "GET",
+		fmt.Sprintf("/bookings/%s/rate/preview?rating=9", second.ID.Hex()), testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrInvalidRating)
+
+	resp, err := a.HandleRatePreview(testRequest(t, "GET", target, testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+	preview := resp.(*RatingPreviewResponse)
+	qt.Assert(t, *preview.CurrentRating, qt.Equals, int32(100))
+	qt.Assert(t, preview.RatingsCount, qt.Equals, int64(2))
+
+	// The preview must not store anything
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	average, err := a.database.RatingService.GetUserAverage(context.Background(), owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, average.Count, qt.Equals, int64(1))
+
+	// Submit the rating and check the actual aggregate matches the preview
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: second.ID.Hex(), Rating: 2}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	average, err = a.database.RatingService.GetUserAverage(context.Background(), owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, average.Value(), qt.Equals, preview.NewRating)
+	qt.Assert(t, average.Count, qt.Equals, preview.RatingsCount)
+}
+
+func TestOpenBookingRequest(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Create a booking request without dates
+	resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+		&CreateBookingRequest{ToolID: fmt.Sprintf("%d", id), Contact: "test@test.com"}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	open := resp.(BookingResponse)
+	qt.Assert(t, open.BookingStatus, qt.Equals, string(db.BookingStatusOpen))
+	qt.Assert(t, open.StartDate, qt.IsNil)
+	qt.Assert(t, open.EndDate, qt.IsNil)
+
+	// Open requests cannot be accepted before having dates
+	params := map[string]string{"petitionId": open.ID}
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+open.ID+"/accept",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyAcceptPending)
+
+	// Open requests do not conflict with other bookings
+	start, end := time.Now().Add(24*time.Hour).Unix(), time.Now().Add(48*time.Hour).Unix()
+	resp, err = a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+		&CreateBookingRequest{ToolID: fmt.Sprintf("%d", id), StartDate: start, EndDate: end}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	dated := resp.(BookingResponse)
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+dated.ID+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": dated.ID}))
+	qt.Assert(t, err, qt.IsNil)
+
+	// Only the owner can propose dates
+	target := "/bookings/petitions/" + open.ID + "/dates"
+	_, err = a.HandleSetPetitionDates(testRequest(t, "POST", target, testUser2.Email,
+		&BookingDatesRequest{StartDate: start, EndDate: end}, params))
+	qt.Assert(t, err, qt.Equals, ErrOnlyOwnerCanSetDates)
+
+	// Proposed dates are checked for conflicts
+	_, err = a.HandleSetPetitionDates(testRequest(t, "POST", target, testUser1.Email,
+		&BookingDatesRequest{StartDate: start, EndDate: end}, params))
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+
+	start, end = time.Now().Add(72*time.Hour).Unix(), time.Now().Add(96*time.Hour).Unix()
+	resp, err = a.HandleSetPetitionDates(testRequest(t, "POST", target, testUser1.Email,
+		&BookingDatesRequest{StartDate: start, EndDate: end}, params))
+	qt.Assert(t, err, qt.IsNil)
+	filled := resp.(BookingResponse)
+	qt.Assert(t, filled.BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	qt.Assert(t, *filled.StartDate, qt.Equals, start)
+	qt.Assert(t, *filled.EndDate, qt.Equals, end)
+
+	// Dates can only be set once
+	_, err = a.HandleSetPetitionDates(testRequest(t, "POST", target, testUser1.Email,
+		&BookingDatesRequest{StartDate: start, EndDate: end}, params))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlySetDatesOnOpen)
+
+	// Once dated, the petition follows the regular flow
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+open.ID+"/accept",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestBookingKit(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string, owner string) string {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, owner)
+		qt.Assert(t, err, qt.IsNil)
+		return fmt.Sprintf("%d", id)
+	}
+	drill := addTool("drill", testUser1.Email)
+	saw := addTool("saw", testUser1.Email)
+	ladder := addTool("ladder", testUser1.Email)
+	foreign := addTool("hammer", testUser2.Email)
+
+	start, end := time.Now().Add(24*time.Hour).Unix(), time.Now().Add(48*time.Hour).Unix()
+	createBooking := func(req *CreateBookingRequest) (interface{}, error) {
+		req.StartDate, req.EndDate = start, end
+		return a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, req, nil))
+	}
+
+	// The saw is already booked for the dates
+	resp, err := createBooking(&CreateBookingRequest{ToolID: saw})
+	qt.Assert(t, err, qt.IsNil)
+	sawBooking := resp.(BookingResponse)
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+sawBooking.ID+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": sawBooking.ID}))
+	qt.Assert(t, err, qt.IsNil)
+
+	// A kit with a single conflicting tool fails as a whole
+	_, err = createBooking(&CreateBookingRequest{ToolIDs: []string{drill, saw}})
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	petitions, err := a.database.BookingService.GetUserPetitions(ctx, requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, petitions, qt.HasLen, 1)
+
+	// Kits need different tools of a single owner
+	_, err = createBooking(&CreateBookingRequest{ToolIDs: []string{drill, foreign}})
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingGroup)
+	_, err = createBooking(&CreateBookingRequest{ToolIDs: []string{drill, drill}})
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingGroup)
+
+	resp, err = createBooking(&CreateBookingRequest{ToolIDs: []string{drill, ladder}})
+	qt.Assert(t, err, qt.IsNil)
+	kit := resp.(*BookingGroupResponse)
+	qt.Assert(t, kit.Bookings, qt.HasLen, 2)
+	for _, booking := range kit.Bookings {
+		qt.Assert(t, booking.GroupID, qt.Equals, kit.GroupID)
+		qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	}
+
+	// Accepting one booking of the kit accepts the whole group
+	first := kit.Bookings[0].ID
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+first+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": first}))
+	qt.Assert(t, err, qt.IsNil)
+	for _, booking := range kit.Bookings {
+		resp, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+booking.ID, testUser2.Email, nil,
+			map[string]string{"bookingId": booking.ID}))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.(BookingResponse).BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+	}
+}
+
+func TestBookingCost(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	paidTool := testTool1
+	paidTool.Title = "paid tool"
+	paidTool.MayBeFree = boolPtr(false)
+	paid, err := a.addTool(&paidTool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	free, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.UserService.AdjustTokens(ctx, requester.ID, 40), qt.IsNil)
+	balance := func(email string) uint64 {
+		resp, err := a.userProfileHandler(testRequest(t, "GET", "/profile", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*UserResponse).Tokens
+	}
+
+	returnBooking := func(toolID int64, days int) BookingResponse {
+		start := time.Now().Add(24 * time.Hour)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: start.Unix(),
+			EndDate:   start.Add(time.Duration(days) * 24 * time.Hour).Unix(),
+			Free:      toolID == free,
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		id := resp.(BookingResponse).ID
+		params := map[string]string{"petitionId": id, "bookingId": id}
+		_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			testUser1.Email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+
+		// No cost is reported before the return
+		resp, err = a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+id, testUser2.Email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.(BookingResponse).Cost, qt.IsNil)
+
+		_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id+"/return",
+			testUser1.Email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+		resp, err = a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+id, testUser2.Email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse)
+	}
+
+	booking := returnBooking(paid, 3)
+	qt.Assert(t, booking.Cost, qt.DeepEquals, &BookingCost{
+		Days:       3,
+		CostPerDay: *paidTool.Cost,
+		Total:      3 * *paidTool.Cost,
+		AskWithFee: false,
+	})
+	// The cost is paid by the requester to the owner, only once
+	qt.Assert(t, balance(testUser2.Email), qt.Equals, 40-3**paidTool.Cost)
+	qt.Assert(t, balance(testUser1.Email), qt.Equals, owner.Tokens+3**paidTool.Cost)
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+booking.ID+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": booking.ID}))
+	qt.Assert(t, err, qt.IsNil)
+	bookingID, err := primitive.ObjectIDFromHex(booking.ID)
+	qt.Assert(t, err, qt.IsNil)
+	returned, err := a.database.BookingService.Get(ctx, bookingID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.recordCharges(ctx, returned), qt.IsNil)
+	qt.Assert(t, balance(testUser2.Email), qt.Equals, 40-3**paidTool.Cost)
+
+	booking = returnBooking(free, 2)
+	qt.Assert(t, booking.Cost, qt.DeepEquals, &BookingCost{
+		Days:       2,
+		CostPerDay: 0,
+		Total:      0,
+		Free:       true,
+	})
+	qt.Assert(t, booking.Free, qt.IsTrue)
+	qt.Assert(t, *booking.AgreedCost, qt.Equals, uint64(0))
+	qt.Assert(t, balance(testUser2.Email), qt.Equals, 40-3**paidTool.Cost)
+
+	// Tools that may not be free cannot be asked for free
+	_, err = a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", paid),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+		Free:      true,
+	}, nil))
+	qt.Assert(t, err, qt.Equals, ErrToolNotFree)
+
+	// Without enough tokens nothing is moved, the cost is recorded as outstanding
+	booking = returnBooking(paid, 2)
+	qt.Assert(t, booking.Cost.Total, qt.Equals, 2**paidTool.Cost)
+	qt.Assert(t, booking.Cost.Outstanding, qt.IsTrue)
+	qt.Assert(t, balance(testUser2.Email), qt.Equals, 40-3**paidTool.Cost)
+	qt.Assert(t, balance(testUser1.Email), qt.Equals, owner.Tokens+3**paidTool.Cost)
+	collected, err := a.collectCharges(ctx)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, collected, qt.Equals, 0)
+
+	// And collected once the requester has the tokens, only once
+	_, err = a.database.UserService.UpdateUser(ctx, requester.ID, bson.M{"tokens": 100})
+	qt.Assert(t, err, qt.IsNil)
+	for _, want := range []int{1, 0} {
+		collected, err = a.collectCharges(ctx)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, collected, qt.Equals, want)
+	}
+	qt.Assert(t, balance(testUser2.Email), qt.Equals, 100-2**paidTool.Cost)
+	qt.Assert(t, balance(testUser1.Email), qt.Equals, owner.Tokens+5**paidTool.Cost)
+	resp, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+booking.ID, testUser2.Email, nil,
+		map[string]string{"bookingId": booking.ID}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(BookingResponse).Cost.Outstanding, qt.IsFalse)
+}
+
+func TestAgreedCost(t *testing.T) {
+	paid := &db.Tool{Cost: 10}
+	mayBeFree := &db.Tool{Cost: 10, MayBeFree: true}
+	costless := &db.Tool{}
+	for _, tc := range []struct {
+		name string
+		tool *db.Tool
+		free bool
+		cost *uint64
+		want uint64
+		err  error
+	}{
+		{name: "tool cost by default", tool: paid, want: 10},
+		{name: "tool that may be free", tool: mayBeFree, want: 10},
+		{name: "lower offer", tool: paid, cost: uint64Ptr(4), want: 4},
+		{name: "offer above the tool cost", tool: paid, cost: uint64Ptr(11), err: ErrInvalidBookingCost},
+		{name: "free", tool: mayBeFree, free: true},
+		{name: "free with a cost", tool: mayBeFree, free: true, cost: uint64Ptr(5), err: ErrInvalidBookingCost},
+		{name: "zero offer", tool: mayBeFree, cost: uint64Ptr(0)},
+		{name: "free on a paid tool", tool: paid, free: true, err: ErrToolNotFree},
+		{name: "zero offer on a paid tool", tool: paid, cost: uint64Ptr(0), err: ErrToolNotFree},
+		{name: "tool without cost", tool: costless, cost: uint64Ptr(0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			free, cost, err := agreedCost(tc.tool, tc.free, tc.cost)
+			if tc.err != nil {
+				qt.Assert(t, err, qt.Equals, tc.err)
+				return
+			}
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, cost, qt.Equals, tc.want)
+			qt.Assert(t, free, qt.Equals, tc.free || (tc.cost != nil && *tc.cost == 0 && tc.tool.Cost > 0))
+		})
+	}
+}
+
+func TestBookingMinLeadTime(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	tool := testTool1
+	leadHours := uint32(48)
+	tool.MinLeadHours = &leadHours
+	id, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	book := func(startOffset time.Duration) error {
+		start := time.Now().Add(startOffset)
+		_, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: start.Unix(),
+			EndDate:   start.Add(24 * time.Hour).Unix(),
+		}, nil))
+		return err
+	}
+
+	// Starting before the required notice fails
+	qt.Assert(t, book(24*time.Hour), qt.Equals, ErrInsufficientLeadTime)
+	// Starting after it succeeds
+	qt.Assert(t, book(72*time.Hour), qt.IsNil)
+
+	// Without minimum lead time, bookings can start right away
+	qt.Assert(t, a.editTool(id, &Tool{MinLeadHours: new(uint32)}, primitive.NilObjectID), qt.IsNil)
+	qt.Assert(t, book(time.Hour), qt.IsNil)
+}
+
+func TestBookingDatesValidation(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	book := func(start, end time.Time) error {
+		_, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: start.Unix(),
+			EndDate:   end.Unix(),
+		}, nil))
+		return err
+	}
+
+	start := time.Now().Add(24 * time.Hour)
+	qt.Assert(t, book(start, start), qt.Equals, ErrInvalidBookingDates)
+	qt.Assert(t, book(start, start.Add(-time.Hour)), qt.Equals, ErrInvalidBookingDates)
+	qt.Assert(t, book(time.Now().Add(-48*time.Hour), start), qt.Equals, ErrInvalidBookingDates)
+	qt.Assert(t, book(start, start.Add(91*24*time.Hour)), qt.Equals, ErrBookingTooLong)
+	qt.Assert(t, book(start, start.Add(90*24*time.Hour)), qt.IsNil)
+}
+
+func TestUserRatingsListing(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	comments := []string{"great", "", "careful with the battery"}
+	for i, comment := range comments {
+		booking := returnedBookingForTest(t, a, time.Duration(i+1)*48*time.Hour)
+		_, err := a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+			&RateRequest{BookingID: booking.ID.Hex(), Rating: i + 3, Comment: comment}, nil))
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	list := func(query string) *UserRatingsResponse {
+		resp, err := a.getUserRatingsHandler(testRequest(t, "GET", "/users/"+owner.ID.Hex()+"/ratings"+query,
+			testUser2.Email, nil, map[string]string{"id": owner.ID.Hex()}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*UserRatingsResponse)
+	}
+
+	// Newest ratings first, two per page
+	page := list("?pageSize=2")
+	qt.Assert(t, page.Pagination.Total, qt.Equals, int64(3))
+	qt.Assert(t, page.Ratings, qt.HasLen, 2)
+	qt.Assert(t, page.Ratings[0].Rating, qt.Equals, 5)
+	qt.Assert(t, page.Ratings[0].Comment, qt.Equals, comments[2])
+	qt.Assert(t, page.Ratings[0].Rater.Name, qt.Equals, testUser2.Name)
+	qt.Assert(t, page.Ratings[1].Rating, qt.Equals, 4)
+	qt.Assert(t, page.Ratings[1].Comment, qt.Equals, "")
+
+	page = list("?pageSize=2&page=1")
+	qt.Assert(t, page.Ratings, qt.HasLen, 1)
+	qt.Assert(t, page.Ratings[0].Comment, qt.Equals, comments[0])
+
+	page = list("?pageSize=2&page=2")
+	qt.Assert(t, page.Ratings, qt.HasLen, 0)
+
+	// Anonymous ratings do not reveal the rater
+	a.opts.AnonymousRatings = true
+	page = list("")
+	qt.Assert(t, page.Ratings, qt.HasLen, 3)
+	for _, rating := range page.Ratings {
+		qt.Assert(t, rating.Rater, qt.IsNil)
+	}
+}
+
+func TestEditRating(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	booking := returnedBookingForTest(t, a, 24*time.Hour)
+	params := map[string]string{"bookingId": booking.ID.Hex()}
+	target := fmt.Sprintf("/bookings/%s/rate", booking.ID.Hex())
+
+	// Nothing to edit before rating
+	_, err = a.HandleEditRating(testRequest(t, "PUT", target, testUser2.Email, &RateRequest{Rating: 3}, params))
+	qt.Assert(t, err, qt.Equals, ErrRatingNotFound)
+
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: booking.ID.Hex(), Rating: 5}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	owner, err = a.database.UserService.GetUserByID(ctx, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, owner.Rating, qt.Equals, int32(100))
+
+	// Within the grace period the rating can be changed
+	resp, err := a.HandleEditRating(testRequest(t, "PUT", target, testUser2.Email,
+		&RateRequest{Rating: 1, Comment: "changed my mind"}, params))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*RatingResponse).Rating, qt.Equals, 1)
+	qt.Assert(t, resp.(*RatingResponse).Comment, qt.Equals, "changed my mind")
+	owner, err = a.database.UserService.GetUserByID(ctx, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, owner.Rating, qt.Equals, int32(0))
+
+	// Only the author can edit it
+	_, err = a.HandleEditRating(testRequest(t, "PUT", target, testUser1.Email, &RateRequest{Rating: 3}, params))
+	qt.Assert(t, err, qt.Equals, ErrRatingNotFound)
+
+	// Once the grace period is over the rating is locked
+	_, err = a.database.RatingService.Collection.UpdateOne(ctx,
+		bson.M{"bookingId": booking.ID},
+		bson.M{"$set": bson.M{"createdAt": time.Now().Add(-2 * a.opts.RatingGracePeriod)}})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.HandleEditRating(testRequest(t, "PUT", target, testUser2.Email, &RateRequest{Rating: 4}, params))
+	qt.Assert(t, err, qt.Equals, ErrRatingLocked)
+	_, err = a.HandleDeleteRating(testRequest(t, "DELETE", target, testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrRatingLocked)
+}
+
+func TestDeleteRating(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	booking := returnedBookingForTest(t, a, 24*time.Hour)
+	params := map[string]string{"bookingId": booking.ID.Hex()}
+	target := fmt.Sprintf("/bookings/%s/rate", booking.ID.Hex())
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: booking.ID.Hex(), Rating: 1}, nil))
+	qt.Assert(t, err, qt.IsNil)
+
+	_, err = a.HandleDeleteRating(testRequest(t, "DELETE", target, testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+
+	// The aggregate goes back to the default and the booking can be rated again
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, owner.Rating, qt.Equals, int32(db.DefaultUserRating))
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: booking.ID.Hex(), Rating: 4}, nil))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestIdempotentStatusTransitions(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+		&CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+			Contact:   "test@test.com",
+		}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	bookingID := resp.(BookingResponse).ID
+	params := map[string]string{"petitionId": bookingID, "bookingId": bookingID}
+
+	// Accepting twice returns the accepted booking both times
+	for i := 0; i < 2; i++ {
+		resp, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+bookingID+"/accept",
+			testUser1.Email, nil, params))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp.(BookingResponse).BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+	}
+
+	// Transitions from another state still fail
+	_, err = a.HandleDenyPetition(testRequest(t, "POST", "/bookings/petitions/"+bookingID+"/deny",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyDenyPending)
+	_, err = a.HandleCancelRequest(testRequest(t, "POST", "/bookings/request/"+bookingID+"/cancel",
+		testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyCancelPending)
+
+	// Returning twice keeps the first charge
+	resp, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+bookingID+"/return",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+	returned := resp.(BookingResponse)
+	qt.Assert(t, returned.BookingStatus, qt.Equals, string(db.BookingStatusReturned))
+	qt.Assert(t, returned.Cost, qt.IsNotNil)
+	resp, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+bookingID+"/return",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(BookingResponse).Cost, qt.DeepEquals, returned.Cost)
+}
+
+func TestBookingNotFound(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	token := testToken(t, a, testUser1.Email)
+	missing := primitive.NewObjectID().Hex()
+
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/bookings/" + missing},
+		{http.MethodGet, "/bookings/" + missing + "/receipt"},
+		{http.MethodPost, "/bookings/" + missing + "/return"},
+		{http.MethodPost, "/bookings/" + missing + "/cancel"},
+		{http.MethodPost, "/bookings/petitions/" + missing + "/accept"},
+		{http.MethodPost, "/bookings/petitions/" + missing + "/deny"},
+		{http.MethodPost, "/bookings/request/" + missing + "/cancel"},
+	} {
+		code, resp := testHTTPRequest(t, router, tc.method, tc.path, token, nil)
+		qt.Assert(t, code, qt.Equals, http.StatusNotFound, qt.Commentf("%s %s", tc.method, tc.path))
+		qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrBookingNotFound.ErrorCode)
+	}
+}
+
+func TestBookingStatusesInfo(t *testing.T) {
+	resp, err := (&API{}).bookingStatusesHandler(nil)
+	qt.Assert(t, err, qt.IsNil)
+	info := resp.(*BookingStatusesInfo)
+	qt.Assert(t, info.Statuses, qt.HasLen, len(db.BookingStatuses))
+	qt.Assert(t, info.Transitions[string(db.BookingStatusPending)], qt.Contains, string(db.BookingStatusAccepted))
+	qt.Assert(t, info.Transitions[string(db.BookingStatusAccepted)], qt.DeepEquals,
+		[]string{string(db.BookingStatusReturned), string(db.BookingStatusTransferred)})
+	// Final statuses are listed without transitions
+	qt.Assert(t, info.Transitions[string(db.BookingStatusReturned)], qt.HasLen, 0)
+	qt.Assert(t, db.CanTransition(db.BookingStatusOpen, db.BookingStatusAccepted), qt.IsFalse)
+}
+
+func TestBookingPetitionsByCategory(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string, category int) int64 {
+		tool := testTool1
+		tool.Title = title
+		tool.Category = category
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	drill := addTool("drill", 1)
+	rake := addTool("rake", 2)
+	hoe := addTool("hoe", 2)
+
+	for _, id := range []int64{drill, rake, hoe} {
+		_, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+			&CreateBookingRequest{ToolID: fmt.Sprintf("%d", id), Contact: "test@test.com"}, nil))
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	petitions := func(query string) []BookingResponse {
+		resp, err := a.HandleGetBookingPetitions(testRequest(t, "GET", "/bookings/petitions"+query,
+			testUser2.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.([]BookingResponse)
+	}
+	qt.Assert(t, petitions(""), qt.HasLen, 3)
+	garden := petitions("?category=2")
+	qt.Assert(t, garden, qt.HasLen, 2)
+	for _, booking := range garden {
+		qt.Assert(t, booking.ToolID, qt.Not(qt.Equals), fmt.Sprintf("%d", drill))
+	}
+	qt.Assert(t, petitions("?category=3"), qt.HasLen, 0)
+
+	_, err := a.HandleGetBookingPetitions(testRequest(t, "GET", "/bookings/petitions?category=garden",
+		testUser2.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+}
+
+func TestBookingRescheduleAndOwnerCancel(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	owner, requester := testUser1, testUser2
+	owner.Tokens, requester.Tokens = 0, 100
+	qt.Assert(t, a.addUser(&owner), qt.IsNil)
+	qt.Assert(t, a.addUser(&requester), qt.IsNil)
+	tool := testTool1
+	tool.DepositTokens = uint64Ptr(40)
+	toolID, err := a.addTool(&tool, owner.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	tokens := func(email string) uint64 {
+		user, err := a.database.UserService.GetUserByEmail(ctx, email)
+		qt.Assert(t, err, qt.IsNil)
+		return user.Tokens
+	}
+	start := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	day := func(n int) time.Time { return start.Add(time.Duration(n) * 24 * time.Hour) }
+	book := func(from, to time.Time) (string, error) {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", requester.Email,
+			&CreateBookingRequest{ToolID: fmt.Sprintf("%d", toolID), StartDate: from.Unix(), EndDate: to.Unix()}, nil))
+		if err != nil {
+			return "", err
+		}
+		return resp.(BookingResponse).ID, nil
+	}
+	accept := func(id string) {
+		_, err := a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			owner.Email, nil, map[string]string{"petitionId": id}))
+		qt.Assert(t, err, qt.IsNil)
+	}
+	reschedule := func(email, id string, from, to time.Time) (BookingResponse, error) {
+		resp, err := a.HandleRescheduleBooking(testRequest(t, "POST", "/bookings/"+id+"/reschedule", email,
+			&BookingDatesRequest{StartDate: from.Unix(), EndDate: to.Unix()}, map[string]string{"bookingId": id}))
+		if err != nil {
+			return BookingResponse{}, err
+		}
+		return resp.(BookingResponse), nil
+	}
+	acceptReschedule := func(email, id string) (BookingResponse, error) {
+		resp, err := a.HandleAcceptReschedule(testRequest(t, "POST", "/bookings/"+id+"/reschedule/accept", email, nil,
+			map[string]string{"bookingId": id}))
+		if err != nil {
+			return BookingResponse{}, err
+		}
+		return resp.(BookingResponse), nil
+	}
+	cancel := func(email, id string) (BookingResponse, error) {
+		resp, err := a.HandleCancelBooking(testRequest(t, "POST", "/bookings/"+id+"/cancel", email, nil,
+			map[string]string{"bookingId": id}))
+		if err != nil {
+			return BookingResponse{}, err
+		}
+		return resp.(BookingResponse), nil
+	}
+
+	first, err := book(day(0), day(1))
+	qt.Assert(t, err, qt.IsNil)
+	accept(first)
+	second, err := book(day(3), day(4))
+	qt.Assert(t, err, qt.IsNil)
+	accept(second)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(20))
+
+	// Rescheduling runs the same checks as creating a booking
+	_, err = reschedule(requester.Email, first, day(2), day(4))
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+	_, err = reschedule(requester.Email, first, day(2), day(1))
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingDates)
+	_, err = reschedule(requester.Email, first, day(-3), day(1))
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingDates)
+
+	// Either party proposes new dates, which only apply once the other one accepts them, and the
+	// booking stays accepted
+	proposed, err := reschedule(requester.Email, first, day(1), day(3))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *proposed.StartDate, qt.Equals, day(0).Unix())
+	qt.Assert(t, proposed.Reschedule.StartDate.Unix(), qt.Equals, day(1).Unix())
+	_, err = acceptReschedule(requester.Email, first)
+	qt.Assert(t, err, qt.Equals, ErrNoRescheduleProposal)
+	moved, err := acceptReschedule(owner.Email, first)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *moved.StartDate, qt.Equals, day(1).Unix())
+	qt.Assert(t, *moved.EndDate, qt.Equals, day(3).Unix())
+	qt.Assert(t, moved.BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+	qt.Assert(t, moved.Reschedule, qt.IsNil)
+	_, err = acceptReschedule(owner.Email, first)
+	qt.Assert(t, err, qt.Equals, ErrNoRescheduleProposal)
+	requesterUser, err := a.database.UserService.GetUserByEmail(ctx, requester.Email)
+	qt.Assert(t, err, qt.IsNil)
+	unread, err := a.database.NotificationService.CountUnread(ctx, requesterUser.ID)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = reschedule(owner.Email, first, day(5), day(6))
+	qt.Assert(t, err, qt.IsNil)
+	notified, err := a.database.NotificationService.CountUnread(ctx, requesterUser.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, notified, qt.Equals, unread+1)
+	moved, err = acceptReschedule(requester.Email, first)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *moved.StartDate, qt.Equals, day(5).Unix())
+
+	// The dates are checked again when accepted
+	_, err = reschedule(requester.Email, first, day(8), day(9))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = reschedule(requester.Email, second, day(8), day(9))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = acceptReschedule(owner.Email, first)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = acceptReschedule(owner.Email, second)
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+	_, err = reschedule(requester.Email, second, day(0), day(1))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.ToolService.UpdateTool(ctx, toolID, bson.M{"minLeadHours": 48})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = acceptReschedule(owner.Email, second)
+	qt.Assert(t, err, qt.Equals, ErrInsufficientLeadTime)
+	_, err = a.database.ToolService.UpdateTool(ctx, toolID, bson.M{"minLeadHours": 0})
+	qt.Assert(t, err, qt.IsNil)
+	moved, err = acceptReschedule(owner.Email, second)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *moved.StartDate, qt.Equals, day(0).Unix())
+
+	// Only the owner cancels accepted bookings, and the deposit is given back
+	_, err = a.HandleCancelRequest(testRequest(t, "POST", "/bookings/request/"+first+"/cancel",
+		requester.Email, nil, map[string]string{"petitionId": first}))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyCancelPending)
+	_, err = cancel(requester.Email, first)
+	qt.Assert(t, err, qt.Equals, ErrOnlyOwnerCanCancel)
+	cancelled, err := cancel(owner.Email, first)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, cancelled.BookingStatus, qt.Equals, string(db.BookingStatusCancelled))
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(60))
+	qt.Assert(t, tokens(owner.Email), qt.Equals, uint64(0))
+	_, err = cancel(owner.Email, first)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(60))
+
+	// The dates of the cancelled booking are free again, and it can no longer be moved
+	_, err = book(day(8), day(9))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = reschedule(requester.Email, first, day(7), day(8))
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyReschedule)
+
+	// Returned bookings can neither be moved nor cancelled
+	returned := returnedBookingForTest(t, a, 48*time.Hour).ID.Hex()
+	_, err = reschedule(testUser2.Email, returned, day(10), day(11))
+	qt.Assert(t, err, qt.Equals, ErrBookingAlreadyReturned)
+	_, err = cancel(testUser1.Email, returned)
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyCancelAccepted)
+}
+
+func TestBookingListingsByStatus(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string, category int) string {
+		tool := testTool1
+		tool.Title = title
+		tool.Category = category
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return fmt.Sprintf("%d", id)
+	}
+	drill, rake, hoe := addTool("drill", 1), addTool("rake", 2), addTool("hoe", 2)
+
+	start := time.Now().Add(24 * time.Hour)
+	book := func(toolID string, dated bool) string {
+		req := &CreateBookingRequest{ToolID: toolID}
+		if dated {
+			req.StartDate, req.EndDate = start.Unix(), start.Add(24*time.Hour).Unix()
+		}
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, req, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	pending := book(drill, true)
+	accepted := book(rake, true)
+	_, err := a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+accepted+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": accepted}))
+	qt.Assert(t, err, qt.IsNil)
+	open := book(hoe, false)
+
+	ids := func(bookings []BookingResponse) []string {
+		result := []string{}
+		for _, b := range bookings {
+			result = append(result, b.ID)
+		}
+		return result
+	}
+	petitions := func(query string) ([]string, error) {
+		resp, err := a.HandleGetBookingPetitions(testRequest(t, "GET", "/bookings/petitions"+query,
+			testUser2.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return ids(resp.([]BookingResponse)), nil
+	}
+	requests := func(query string) ([]string, error) {
+		resp, err := a.HandleGetBookingRequests(testRequest(t, "GET", "/bookings/requests"+query,
+			testUser1.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return ids(resp.([]BookingResponse)), nil
+	}
+
+	for _, list := range []func(string) ([]string, error){petitions, requests} {
+		all, err := list("")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, all, qt.HasLen, 3)
+		got, err := list("?status=PENDING")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, got, qt.DeepEquals, []string{pending})
+		// Newest first
+		got, err = list("?status=PENDING,OPEN")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, got, qt.DeepEquals, []string{open, pending})
+		got, err = list("?status=RETURNED")
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, got, qt.HasLen, 0)
+		_, err = list("?status=PENDING,LOST")
+		qt.Assert(t, err, qt.Equals, ErrInvalidBookingStatus)
+	}
+
+	// Combined with the category
+	got, err := petitions("?category=2&status=ACCEPTED")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got, qt.DeepEquals, []string{accepted})
+}
+
+func TestBookingHistory(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	book := func(owner, requester, title string) string {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, owner)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", requester, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	lent := book(testUser1.Email, testUser2.Email, "drill")
+	borrowed := book(testUser2.Email, testUser1.Email, "rake")
+	_, err := a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+lent+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": lent}))
+	qt.Assert(t, err, qt.IsNil)
+
+	history := func(query string) (*BookingHistoryResponse, error) {
+		resp, err := a.HandleGetBookingHistory(testRequest(t, "GET", "/bookings/history"+query,
+			testUser1.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*BookingHistoryResponse), nil
+	}
+
+	// Both roles, newest first
+	resp, err := history("")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, resp.Bookings, qt.HasLen, 2)
+	qt.Assert(t, resp.Bookings[0].ID, qt.Equals, borrowed)
+	qt.Assert(t, resp.Bookings[0].Role, qt.Equals, BookingRoleRequester)
+	qt.Assert(t, resp.Bookings[1].ID, qt.Equals, lent)
+	qt.Assert(t, resp.Bookings[1].Role, qt.Equals, BookingRoleOwner)
+
+	resp, err = history("?status=ACCEPTED")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Bookings, qt.HasLen, 1)
+	qt.Assert(t, resp.Bookings[0].ID, qt.Equals, lent)
+
+	resp, err = history("?page=1&pageSize=1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, resp.Bookings, qt.HasLen, 1)
+	qt.Assert(t, resp.Bookings[0].ID, qt.Equals, lent)
+
+	_, err = history("?status=LOST")
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingStatus)
+	_, err = history("?page=-1")
+	qt.Assert(t, err, qt.Equals, ErrInvalidPagination)
+}
+
+func TestAnonymousRating(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	named := returnedBookingForTest(t, a, 24*time.Hour)
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: named.ID.Hex(), Rating: 5}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	anonymous := returnedBookingForTest(t, a, 72*time.Hour)
+	resp, err := a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: anonymous.ID.Hex(), Rating: 1, Anonymous: true}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*RatingResponse).Anonymous, qt.IsTrue)
+
+	// Rating the same booking again is still rejected
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: anonymous.ID.Hex(), Rating: 1, Anonymous: true}, nil))
+	qt.Assert(t, err, qt.Equals, ErrBookingAlreadyRated)
+
+	// The listing hides the rater of the anonymous rating only
+	list, err := a.getUserRatingsHandler(testRequest(t, "GET", "/users/"+owner.ID.Hex()+"/ratings",
+		testUser1.Email, nil, map[string]string{"id": owner.ID.Hex()}))
+	qt.Assert(t, err, qt.IsNil)
+	ratings := list.(*UserRatingsResponse).Ratings
+	qt.Assert(t, ratings, qt.HasLen, 2)
+	qt.Assert(t, ratings[0].Anonymous, qt.IsTrue)
+	qt.Assert(t, ratings[0].Rater.Name, qt.Equals, anonymousRaterName)
+	qt.Assert(t, ratings[0].Rater.ID, qt.Equals, "")
+	qt.Assert(t, ratings[1].Anonymous, qt.IsFalse)
+	qt.Assert(t, ratings[1].Rater.Name, qt.Equals, testUser2.Name)
+
+	// The identity is kept and the rating counts in the aggregates
+	rater, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	stored, err := a.database.RatingService.Get(ctx, anonymous.ID, rater.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored.Anonymous, qt.IsTrue)
+	owner, err = a.database.UserService.GetUserByID(ctx, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, owner.Rating, qt.Equals, int32(60))
+}
+
+func TestConcurrentRatingSubmissions(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	booking := returnedBookingForTest(t, a, 24*time.Hour)
+
+	const submissions = 2
+	errs := make(chan error, submissions)
+	var wg sync.WaitGroup
+	for i := 0; i < submissions; i++ {
+		req := testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+			&RateRequest{BookingID: booking.ID.Hex(), Rating: 4}, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.HandleRateBooking(req)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		qt.Assert(t, err, qt.Equals, ErrBookingAlreadyRated)
+	}
+	qt.Assert(t, succeeded, qt.Equals, 1)
+	count, err := a.database.RatingService.Collection.CountDocuments(context.Background(),
+		bson.M{"bookingId": booking.ID})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, count, qt.Equals, int64(1))
+}
+
+func TestConcurrentAccept(t *testing.T) {
+	a := testAPI(t)
+	requester := testUser2
+	requester.Tokens = 1000
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&requester), qt.IsNil)
+	tool := testTool1
+	tool.DepositTokens = uint64Ptr(10)
+	id, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	balance := func() uint64 {
+		user, err := a.database.UserService.GetUserByEmail(context.Background(), requester.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return user.Tokens
+	}
+	accept := func(petitions ...string) (succeeded int) {
+		errs := make(chan error, len(petitions))
+		var wg sync.WaitGroup
+		for _, petitionID := range petitions {
+			req := testRequest(t, "POST", "/bookings/petitions/"+petitionID+"/accept", testUser1.Email, nil,
+				map[string]string{"petitionId": petitionID})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := a.HandleAcceptPetition(req)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			// The loser either found the other accepted, was already rejected by it or is still accepting it
+			qt.Assert(t, err == ErrBookingDatesConflict || err == ErrCanOnlyAcceptPending || err == ErrAcceptInProgress,
+				qt.IsTrue, qt.Commentf("%v", err))
+		}
+		return succeeded
+	}
+	book := func(start time.Time) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: start.Unix(),
+			EndDate:   start.Add(48 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+
+	// Each round accepts two petitions overlapping by a day at the same time
+	for round := 0; round < 5; round++ {
+		start := time.Now().Add(time.Duration(24*(1+4*round)) * time.Hour)
+		petitions := []string{book(start), book(start.Add(24 * time.Hour))}
+		qt.Assert(t, accept(petitions...), qt.Equals, 1, qt.Commentf("round %d", round))
+		accepted := 0
+		for _, petitionID := range petitions {
+			resp, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+petitionID, testUser1.Email, nil,
+				map[string]string{"bookingId": petitionID}))
+			qt.Assert(t, err, qt.IsNil)
+			if resp.(BookingResponse).BookingStatus == string(db.BookingStatusAccepted) {
+				accepted++
+			}
+		}
+		qt.Assert(t, accepted, qt.Equals, 1, qt.Commentf("round %d", round))
+		// Only the deposit of the accepted petition is held
+		qt.Assert(t, balance(), qt.Equals, uint64(1000-10*(round+1)), qt.Commentf("round %d", round))
+	}
+
+	// The same petition accepted twice at the same time, as a client retrying, holds a single deposit.
+	// Both requests succeed if the retry finds it already accepted.
+	for round := 0; round < 5; round++ {
+		petitionID := book(time.Now().Add(time.Duration(24*(30+4*round)) * time.Hour))
+		qt.Assert(t, accept(petitionID, petitionID) > 0, qt.IsTrue, qt.Commentf("round %d", round))
+		qt.Assert(t, balance(), qt.Equals, uint64(1000-10*(6+round)), qt.Commentf("round %d", round))
+	}
+}
+
+func TestBookingReceipt(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&db.User{Name: "carol", Email: "carol@emprius.cat"}), qt.IsNil)
+	tool := testTool1
+	tool.MayBeFree = boolPtr(false)
+	toolID, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	created, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+		&CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+			Contact:   "test@test.com",
+		}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	id := created.(BookingResponse).ID
+	receipt := func(email string) (*BookingReceiptResponse, error) {
+		resp, err := a.HandleGetBookingReceipt(testRequest(t, "GET", "/bookings/"+id+"/receipt",
+			email, nil, map[string]string{"bookingId": id}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*BookingReceiptResponse), nil
+	}
+
+	// Not available until the booking is returned
+	_, err = receipt(testUser2.Email)
+	qt.Assert(t, err, qt.Equals, ErrBookingNotReturned)
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": id}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": id}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: id, Rating: 4, Comment: "great drill"}, nil))
+	qt.Assert(t, err, qt.IsNil)
+
+	// Only the involved users can get it
+	_, err = receipt("carol@emprius.cat")
+	qt.Assert(t, err, qt.Equals, ErrUserNotInvolved)
+
+	for _, email := range []string{testUser1.Email, testUser2.Email} {
+		r, err := receipt(email)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, r.Booking.ID, qt.Equals, id)
+		qt.Assert(t, r.Booking.BookingStatus, qt.Equals, string(db.BookingStatusReturned))
+		qt.Assert(t, r.Booking.Cost, qt.DeepEquals, &BookingCost{Days: 1, CostPerDay: 10, Total: 10})
+		qt.Assert(t, r.Tool.ID, qt.Equals, toolID)
+		qt.Assert(t, r.Tool.Title, qt.Equals, tool.Title)
+		qt.Assert(t, r.Requester.Name, qt.Equals, testUser2.Name)
+		qt.Assert(t, r.Owner.Name, qt.Equals, testUser1.Name)
+		qt.Assert(t, r.ReturnedAt.IsZero(), qt.IsFalse)
+		qt.Assert(t, r.RequesterRating.Rating, qt.Equals, 4)
+		qt.Assert(t, r.RequesterRating.Comment, qt.Equals, "great drill")
+		qt.Assert(t, r.OwnerRating, qt.IsNil)
+	}
+}
+
+func TestTransferBooking(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	tool := testTool1
+	tool.MayBeFree = boolPtr(false)
+	toolID, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	created, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+		&CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+			Transfer:  true,
+		}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	id := created.(BookingResponse).ID
+	// Already ended, so it would be overdue if it was not a transfer
+	moveBookingForTest(t, a, id, time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour))
+	qt.Assert(t, created.(BookingResponse).Transfer, qt.IsTrue)
+	params := map[string]string{"bookingId": id}
+	handOver := func(email string) (interface{}, error) {
+		return a.HandleHandOverBooking(testRequest(t, "POST", "/bookings/"+id+"/handover", email, nil, params))
+	}
+
+	// Only accepted transfers can be handed over, and only by the owner
+	_, err = handOver(testUser1.Email)
+	qt.Assert(t, err, qt.Equals, ErrCanOnlyHandOverTransfers)
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": id}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = handOver(testUser2.Email)
+	qt.Assert(t, err, qt.Equals, ErrOnlyOwnerCanHandOver)
+
+	// Transfers never become overdue nor can be returned
+	stats, err := a.database.BookingService.GetUserReliabilityStats(ctx, requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stats.Overdue, qt.Equals, int64(0))
+	ended, err := a.database.BookingService.GetEnded(ctx, time.Now())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ended, qt.HasLen, 0)
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id+"/return",
+		testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrTransferCannotBeReturned)
+
+	// The handover closes the request, charging the value, and the requester becomes the owner
+	resp, err := handOver(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	booking := resp.(BookingResponse)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusTransferred))
+	qt.Assert(t, booking.Cost.Total, qt.Equals, tool.EstimatedValue)
+	transferred, err := a.tool(toolID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, transferred.UserID, qt.Equals, requester.ID)
+	qt.Assert(t, transferred.Location, qt.Equals, requester.Location)
+	notifications, err := a.database.NotificationService.GetUserNotifications(ctx, requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, notifications[0].Type, qt.Equals, db.NotificationBookingHandedOver)
+
+	// Handing over again changes nothing
+	resp, err = handOver(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(BookingResponse).BookingStatus, qt.Equals, string(db.BookingStatusTransferred))
+}
+
+func TestAcceptPreview(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := &db.User{Name: "carol", Email: "carol@emprius.cat"}
+	qt.Assert(t, a.addUser(carol), qt.IsNil)
+	tool := testTool1
+	tool.MayBeFree = boolPtr(false)
+	tool.DepositTokens = uint64Ptr(50)
+	id, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	day := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+
+	book := func(email string, start time.Time) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", id),
+				StartDate: start.Unix(),
+				EndDate:   start.Add(48 * time.Hour).Unix(),
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	petition := book(testUser2.Email, day)
+	conflicting := book(carol.Email, day.Add(24*time.Hour))
+	book(carol.Email, day.Add(96*time.Hour))
+
+	preview := func(email string) (*AcceptPreviewResponse, error) {
+		resp, err := a.HandleAcceptPreview(testRequest(t, "GET", "/bookings/petitions/"+petition+"/accept/preview",
+			email, nil, map[string]string{"petitionId": petition}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*AcceptPreviewResponse), nil
+	}
+
+	// Only the owner can preview
+	_, err = preview(testUser2.Email)
+	qt.Assert(t, err, qt.Equals, ErrOnlyOwnerCanAccept)
+
+	// Two days at 10 tokens, the deposit, and only the overlapping petition conflicts
+	resp, err := preview(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Tokens, qt.Equals, uint64(20))
+	qt.Assert(t, resp.Deposit, qt.Equals, uint64(50))
+	qt.Assert(t, resp.Conflicts, qt.HasLen, 1)
+	qt.Assert(t, resp.Conflicts[0].ID, qt.Equals, conflicting)
+	qt.Assert(t, resp.Warnings, qt.HasLen, 0)
+
+	// The requester cannot cover the deposit
+	requester, err := a.database.UserService.GetUserByEmail(context.Background(), testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.UserService.AdjustTokens(context.Background(), requester.ID,
+		-int64(requester.Tokens-10)), qt.IsNil)
+	resp, err = preview(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Warnings, qt.DeepEquals, []string{warningDepositNotCovered})
+
+	// Nothing changed
+	booking, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+petition, testUser1.Email, nil,
+		map[string]string{"bookingId": petition}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.(BookingResponse).BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	requester, err = a.database.UserService.GetUserByEmail(context.Background(), testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, requester.Tokens, qt.Equals, uint64(10))
+}
+
+func TestAcceptRejectsConflictingPetitions(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := &db.User{Name: "carol", Email: "carol@emprius.cat"}
+	qt.Assert(t, a.addUser(carol), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	day := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+
+	book := func(email string, start time.Time) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", id),
+				StartDate: start.Unix(),
+				EndDate:   start.Add(48 * time.Hour).Unix(),
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	get := func(id string) BookingResponse {
+		resp, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+id, testUser1.Email, nil,
+			map[string]string{"bookingId": id}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse)
+	}
+	accepted := book(testUser2.Email, day)
+	overlapping := book(carol.Email, day.Add(24*time.Hour))
+	later := book(carol.Email, day.Add(48*time.Hour))
+
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+accepted+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": accepted}))
+	qt.Assert(t, err, qt.IsNil)
+
+	// The overlapping petition is rejected with the reason, the one right after is kept
+	qt.Assert(t, get(accepted).BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+	qt.Assert(t, get(overlapping).BookingStatus, qt.Equals, string(db.BookingStatusRejected))
+	qt.Assert(t, get(overlapping).RejectionReason, qt.Equals, db.RejectionReasonToolBooked)
+	qt.Assert(t, get(later).BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	qt.Assert(t, get(later).RejectionReason, qt.Equals, "")
+
+	// The requester of the rejected petition is told
+	requester, err := a.database.UserService.GetUserByEmail(context.Background(), carol.Email)
+	qt.Assert(t, err, qt.IsNil)
+	notifications, err := a.database.NotificationService.GetUserNotifications(context.Background(), requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, notifications[0].Type, qt.Equals, db.NotificationBookingRejected)
+	qt.Assert(t, notifications[0].BookingID.Hex(), qt.Equals, overlapping)
+}
+
+func TestBookingTermsAcceptance(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string, terms *string) string {
+		tool := testTool1
+		tool.Title = title
+		tool.Terms = terms
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return fmt.Sprintf("%d", id)
+	}
+	terms := "Return it clean and oiled"
+	drill := addTool("drill", nil)
+	saw := addTool("saw", &terms)
+
+	day := 0
+	book := func(req *CreateBookingRequest) (interface{}, error) {
+		// Each booking on different dates, so they do not conflict
+		day++
+		start := time.Now().Add(time.Duration(day) * 24 * time.Hour)
+		req.StartDate, req.EndDate = start.Unix(), start.Add(12*time.Hour).Unix()
+		return a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, req, nil))
+	}
+
+	// Tools without terms are booked as usual, with nothing recorded
+	resp, err := book(&CreateBookingRequest{ToolID: drill})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(BookingResponse).AcceptedTerms, qt.IsNil)
+
+	// Tools with terms need them accepted
+	_, err = book(&CreateBookingRequest{ToolID: saw})
+	qt.Assert(t, err, qt.Equals, ErrTermsNotAccepted)
+	_, err = book(&CreateBookingRequest{ToolIDs: []string{drill, saw}})
+	qt.Assert(t, err, qt.Equals, ErrTermsNotAccepted)
+	resp, err = book(&CreateBookingRequest{ToolID: saw, AcceptedTerms: true})
+	qt.Assert(t, err, qt.IsNil)
+	accepted := resp.(BookingResponse).AcceptedTerms
+	qt.Assert(t, accepted, qt.Not(qt.IsNil))
+	qt.Assert(t, accepted.Version, qt.Not(qt.Equals), "")
+	qt.Assert(t, accepted.AcceptedAt.IsZero(), qt.IsFalse)
+
+	// The acceptance is stored with the booking
+	got, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+resp.(BookingResponse).ID, testUser2.Email,
+		nil, map[string]string{"bookingId": resp.(BookingResponse).ID}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got.(BookingResponse).AcceptedTerms.Version, qt.Equals, accepted.Version)
+
+	// In a kit, only the tools with terms record their acceptance
+	resp, err = book(&CreateBookingRequest{ToolIDs: []string{drill, saw}, AcceptedTerms: true})
+	qt.Assert(t, err, qt.IsNil)
+	for _, booking := range resp.(*BookingGroupResponse).Bookings {
+		if booking.ToolID == saw {
+			qt.Assert(t, booking.AcceptedTerms.Version, qt.Equals, accepted.Version)
+		} else {
+			qt.Assert(t, booking.AcceptedTerms, qt.IsNil)
+		}
+	}
+
+	// Changing the terms changes the version accepted
+	newTerms := "Return it clean"
+	sawID, err := strconv.ParseInt(saw, 10, 64)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.editTool(sawID, &Tool{Terms: &newTerms}, primitive.NilObjectID), qt.IsNil)
+	resp, err = book(&CreateBookingRequest{ToolID: saw, AcceptedTerms: true})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(BookingResponse).AcceptedTerms.Version, qt.Not(qt.Equals), accepted.Version)
+}
+
+func TestBookingTransportCost(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester := testToken(t, a, testUser2.Email)
+	// The default transports: car costs 1 token plus 1 per km, van 2 plus 2 per km
+	km := uint64(math.Ceil(db.Distance(testUser2.Location, testTool1.Location) / 1000))
+
+	book := func(transport int64, offset time.Duration) (int, *testHTTPResponse) {
+		return testHTTPRequest(t, router, http.MethodPost, "/bookings", requester, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Now().Add(offset).Unix(),
+			EndDate:   time.Now().Add(offset + 24*time.Hour).Unix(),
+			Transport: transport,
+		})
+	}
+
+	code, resp := book(2, 24*time.Hour)
+	booking := testHTTPData[BookingResponse](t, code, resp)
+	qt.Assert(t, booking.Transport, qt.IsNotNil)
+	qt.Assert(t, booking.Transport.Name, qt.Equals, "Van")
+	qt.Assert(t, booking.Transport.Cost, qt.Equals, 2+2*km)
+	id, err := primitive.ObjectIDFromHex(booking.ID)
+	qt.Assert(t, err, qt.IsNil)
+	stored, err := a.database.BookingService.Get(context.Background(), id)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored.Transport, qt.DeepEquals, booking.Transport)
+
+	// Without transport there is no estimation
+	code, resp = book(0, 72*time.Hour)
+	qt.Assert(t, testHTTPData[BookingResponse](t, code, resp).Transport, qt.IsNil)
+
+	// The truck is not one of the tool options
+	code, resp = book(3, 120*time.Hour)
+	qt.Assert(t, code, qt.Equals, ErrInvalidTransportOption.Code)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrInvalidTransportOption.ErrorCode)
+
+	// Searching by transport estimates the cheapest of the searched options the tool offers
+	search := func(options string) *uint64 {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET",
+			"/tools/search?distance=500000&transportOptions="+options, testUser2.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		tools := resp.(*ToolsWrapper).Tools
+		qt.Assert(t, tools, qt.HasLen, 1)
+		return tools[0].TransportCost
+	}
+	qt.Assert(t, *search("1,2"), qt.Equals, 1+km)
+	qt.Assert(t, *search("2,3"), qt.Equals, 2+2*km)
+}
+
+func TestNomadicTool(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := &db.User{Name: "carol", Email: "carol@emprius.cat"}
+	qt.Assert(t, a.addUser(carol), qt.IsNil)
+	nomadic := testTool1
+	nomadic.IsNomadic = boolPtr(true)
+	nomadicID, err := a.addTool(&nomadic, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	regular := testTool1
+	regular.Title = "tool2"
+	regularID, err := a.addTool(&regular, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	day := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+
+	book := func(toolID int64, email string, start time.Time) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", toolID),
+				StartDate: start.Unix(),
+				EndDate:   start.Add(24 * time.Hour).Unix(),
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		id := resp.(BookingResponse).ID
+		_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			testUser1.Email, nil, map[string]string{"petitionId": id}))
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	giveBack := func(id string) {
+		_, err := a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id+"/return",
+			testUser1.Email, nil, map[string]string{"bookingId": id}))
+		qt.Assert(t, err, qt.IsNil)
+	}
+	holder := func(toolID int64) primitive.ObjectID {
+		tool, err := a.database.ToolService.GetToolByID(ctx, toolID)
+		qt.Assert(t, err, qt.IsNil)
+		return tool.HolderID
+	}
+
+	// Two bookings of the nomadic tool are chained
+	first := book(nomadicID, testUser2.Email, day)
+	second := book(nomadicID, carol.Email, day.Add(48*time.Hour))
+	qt.Assert(t, holder(nomadicID).IsZero(), qt.IsTrue)
+
+	// Returning the first one passes the tool to the next requester
+	giveBack(first)
+	next, err := a.database.UserService.GetUserByEmail(ctx, carol.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, holder(nomadicID), qt.Equals, next.ID)
+
+	// Returning the last one gives it back to the owner
+	giveBack(second)
+	qt.Assert(t, holder(nomadicID).IsZero(), qt.IsTrue)
+
+	// Other tools always come back to the owner
+	regularFirst := book(regularID, testUser2.Email, day)
+	book(regularID, carol.Email, day.Add(48*time.Hour))
+	giveBack(regularFirst)
+	qt.Assert(t, holder(regularID).IsZero(), qt.IsTrue)
+}
+
+func TestMaxActiveBookings(t *testing.T) {
+	a := testAPI(t)
+	a.opts.MaxActiveBookings = 1
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	ids := make([]string, 2)
+	for i := range ids {
+		tool := testTool1
+		tool.Title = fmt.Sprintf("tool%d", i+1)
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		ids[i] = resp.(BookingResponse).ID
+	}
+	accept := func(id string) error {
+		_, err := a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			testUser1.Email, nil, map[string]string{"petitionId": id}))
+		return err
+	}
+
+	qt.Assert(t, accept(ids[0]), qt.IsNil)
+
+	// The requester is at the cap, the preview warns and accepting fails
+	resp, err := a.HandleAcceptPreview(testRequest(t, "GET", "/bookings/petitions/"+ids[1]+"/accept/preview",
+		testUser1.Email, nil, map[string]string{"petitionId": ids[1]}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*AcceptPreviewResponse).Warnings, qt.Contains, warningTooManyActive)
+	qt.Assert(t, accept(ids[1]), qt.Equals, ErrTooManyActiveBookings)
+
+	// Once the first booking is returned there is room again
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+ids[0]+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": ids[0]}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, accept(ids[1]), qt.IsNil)
+
+	// Owners accepting petitions of the requester at the same time do not exceed the cap together
+	a.opts.MaxActiveBookings = 2
+	carol := testUser1
+	carol.Name, carol.Email = "carol", "carol@emprius.cat"
+	qt.Assert(t, a.addUser(&carol), qt.IsNil)
+	owners := []string{testUser1.Email, carol.Email}
+	petitions := make([]string, len(owners))
+	for i, owner := range owners {
+		tool := testTool1
+		tool.Title = fmt.Sprintf("tool%d", i+3)
+		id, err := a.addTool(&tool, owner)
+		qt.Assert(t, err, qt.IsNil)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(72 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(96 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		petitions[i] = resp.(BookingResponse).ID
+	}
+	errs := make(chan error, len(owners))
+	var wg sync.WaitGroup
+	for i, owner := range owners {
+		req := testRequest(t, "POST", "/bookings/petitions/"+petitions[i]+"/accept", owner, nil,
+			map[string]string{"petitionId": petitions[i]})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.HandleAcceptPetition(req)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		qt.Assert(t, err, qt.Equals, ErrTooManyActiveBookings)
+	}
+	qt.Assert(t, succeeded, qt.Equals, 1)
+	requester, err := a.database.UserService.GetUserByEmail(context.Background(), testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	active, err := a.database.BookingService.CountActiveBookings(context.Background(), requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, active, qt.Equals, int64(2))
+}
+
+func TestPetitionResponseDeadline(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	book := func(days time.Duration) BookingResponse {
+		start := time.Now().Add(days * 24 * time.Hour)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: start.Unix(),
+			EndDate:   start.Add(24 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse)
+	}
+	overdue := func(email string) []BookingResponse {
+		resp, err := a.HandleGetOverduePetitions(testRequest(t, "GET", "/bookings/petitions/overdue", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.([]BookingResponse)
+	}
+	responseRate := func() *int32 {
+		user, err := a.userResponse(ctx, owner)
+		qt.Assert(t, err, qt.IsNil)
+		return user.ResponseRate
+	}
+	dueIn := func(booking BookingResponse) time.Duration {
+		qt.Assert(t, booking.ResponseDue, qt.IsNotNil)
+		return time.Until(*booking.ResponseDue).Round(time.Hour)
+	}
+
+	// The tools without a deadline use the default one
+	unanswered := book(5)
+	qt.Assert(t, dueIn(unanswered), qt.Equals, a.opts.ResponseDeadline)
+	hours := uint32(2)
+	qt.Assert(t, a.editTool(id, &Tool{ResponseDeadlineHours: &hours}, primitive.NilObjectID), qt.IsNil)
+	answered := book(10)
+	qt.Assert(t, dueIn(answered), qt.Equals, 2*time.Hour)
+	qt.Assert(t, overdue(testUser1.Email), qt.HasLen, 0)
+	qt.Assert(t, responseRate(), qt.IsNil)
+
+	// Once the deadline passes, the owner sees the petition as overdue
+	unansweredID, err := primitive.ObjectIDFromHex(unanswered.ID)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.Database.Collection("bookings").UpdateOne(ctx, bson.M{"_id": unansweredID},
+		bson.M{"$set": bson.M{"responseDue": time.Now().Add(-time.Hour)}})
+	qt.Assert(t, err, qt.IsNil)
+	petitions := overdue(testUser1.Email)
+	qt.Assert(t, petitions, qt.HasLen, 1)
+	qt.Assert(t, petitions[0].ID, qt.Equals, unanswered.ID)
+	qt.Assert(t, overdue(testUser2.Email), qt.HasLen, 0)
+
+	// Answering in time counts for the response rate, the overdue petition against it
+	_, err = a.HandleDenyPetition(testRequest(t, "POST", "/bookings/petitions/"+answered.ID+"/deny",
+		testUser1.Email, nil, map[string]string{"petitionId": answered.ID}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *responseRate(), qt.Equals, int32(50))
+
+	// The worker only rejects the unanswered petitions if enabled
+	a.runWorker(ctx, time.Now())
+	qt.Assert(t, overdue(testUser1.Email), qt.HasLen, 1)
+	a.opts.AutoRejectUnanswered = true
+	a.runWorker(ctx, time.Now())
+	qt.Assert(t, overdue(testUser1.Email), qt.HasLen, 0)
+	booking, err := a.database.BookingService.Get(ctx, unansweredID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, db.BookingStatusRejected)
+	qt.Assert(t, booking.RejectionReason, qt.Equals, db.RejectionReasonUnanswered)
+	qt.Assert(t, *responseRate(), qt.Equals, int32(50))
+}
+
+func TestBookingStatusHistory(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := testUser2
+	carol.Name, carol.Email = "carol", "carol@emprius.cat"
+	qt.Assert(t, a.addUser(&carol), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	book := func(days int) string {
+		start := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: start.Unix(),
+			EndDate:   start.Add(24 * time.Hour).Unix(),
+		}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	history := func(email, bookingID string) ([]*db.BookingStatusHistory, error) {
+		resp, err := a.HandleGetBookingStatusHistory(testRequest(t, "GET", "/bookings/"+bookingID+"/history",
+			email, nil, map[string]string{"bookingId": bookingID}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.([]*db.BookingStatusHistory), nil
+	}
+
+	// Creating the booking is not a change of status
+	bookingID := book(2)
+	changes, err := history(testUser2.Email, bookingID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, changes, qt.HasLen, 0)
+
+	// The owner accepts it and marks it as returned
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+bookingID+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": bookingID}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+bookingID+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": bookingID}))
+	qt.Assert(t, err, qt.IsNil)
+	changes, err = history(testUser2.Email, bookingID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, changes, qt.HasLen, 2)
+	qt.Assert(t, changes[0].FromStatus, qt.Equals, db.BookingStatusPending)
+	qt.Assert(t, changes[0].ToStatus, qt.Equals, db.BookingStatusAccepted)
+	qt.Assert(t, changes[0].ActorUserID, qt.Equals, owner.ID)
+	qt.Assert(t, changes[1].FromStatus, qt.Equals, db.BookingStatusAccepted)
+	qt.Assert(t, changes[1].ToStatus, qt.Equals, db.BookingStatusReturned)
+	qt.Assert(t, changes[1].ActorUserID, qt.Equals, owner.ID)
+	qt.Assert(t, changes[1].Timestamp.Before(changes[0].Timestamp), qt.IsFalse)
+
+	// The requester cancelling is recorded as such
+	cancelledID := book(5)
+	_, err = a.HandleCancelRequest(testRequest(t, "POST", "/bookings/request/"+cancelledID+"/cancel",
+		testUser2.Email, nil, map[string]string{"petitionId": cancelledID}))
+	qt.Assert(t, err, qt.IsNil)
+	changes, err = history(testUser1.Email, cancelledID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, changes, qt.HasLen, 1)
+	qt.Assert(t, changes[0].ToStatus, qt.Equals, db.BookingStatusCancelled)
+	qt.Assert(t, changes[0].ActorUserID, qt.Equals, requester.ID)
+
+	// The changes made by the worker have no actor
+	expiredID := book(8)
+	moveBookingForTest(t, a, expiredID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	expired, err := a.database.BookingService.ExpireStalePetitions(ctx, time.Now().Add(time.Hour))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, expired, qt.Equals, int64(1))
+	changes, err = history(testUser2.Email, expiredID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, changes, qt.HasLen, 1)
+	qt.Assert(t, changes[0].ToStatus, qt.Equals, db.BookingStatusRejected)
+	qt.Assert(t, changes[0].ActorUserID.IsZero(), qt.IsTrue)
+
+	// Only the parties of the booking see it
+	_, err = history(carol.Email, bookingID)
+	qt.Assert(t, err, qt.Equals, ErrUserNotInvolved)
+}
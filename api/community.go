@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// Community feed event types
+const (
+	CommunityEventNewTool      = "NEW_TOOL"
+	CommunityEventLendComplete = "LEND_COMPLETED"
+	CommunityEventNewMember    = "NEW_MEMBER"
+)
+
+// GET /community/{community}/feed returns the recent activity of a community: new members,
+// new tools and completed lends, newest first. Lends do not disclose who borrowed the tool.
+func (a *API) communityFeedHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	community, err := url.PathUnescape(r.Context.URLParam("community"))
+	if err != nil || community == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context.Request.Context()
+
+	members, err := a.database.UserService.GetUsersByCommunity(ctx, community)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	events := []CommunityEvent{}
+	memberIDs := make([]primitive.ObjectID, len(members))
+	membersByID := make(map[primitive.ObjectID]*db.User, len(members))
+	for i, member := range members {
+		memberIDs[i] = member.ID
+		membersByID[member.ID] = member
+		// Users are created along with their ID, so it holds the date they joined
+		events = append(events, CommunityEvent{
+			Type: CommunityEventNewMember,
+			Date: member.ID.Timestamp(),
+			User: convertUserToSummary(member),
+		})
+	}
+
+	if len(memberIDs) > 0 {
+		tools, err := a.database.ToolService.GetToolsByUserIDs(ctx, memberIDs)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		toolsByID := make(map[int64]*db.Tool, len(tools))
+		for _, tool := range tools {
+			toolsByID[tool.ID] = tool
+			// Tools added before the creation date was stored are left out
+			if tool.CreatedAt.IsZero() {
+				continue
+			}
+			events = append(events, CommunityEvent{
+				Type: CommunityEventNewTool,
+				Date: tool.CreatedAt,
+				Tool: convertToolToSummary(tool),
+				User: convertUserToSummary(membersByID[tool.UserID]),
+			})
+		}
+
+		lends, err := a.database.BookingService.GetReturnedByOwners(ctx, memberIDs)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		for _, lend := range lends {
+			event := CommunityEvent{
+				Type: CommunityEventLendComplete,
+				Date: lend.UpdatedAt,
+			}
+			if toolID, err := strconv.ParseInt(lend.ToolID, 10, 64); err == nil {
+				if tool, ok := toolsByID[toolID]; ok {
+					event.Tool = convertToolToSummary(tool)
+				}
+			}
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date.After(events[j].Date)
+	})
+	return &CommunityFeedResponse{
+		Community: community,
+		Events:    paginate(events, page, pageSize),
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    int64(len(events)),
+		},
+	}, nil
+}
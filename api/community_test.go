@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCommunityFeed(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	feed := func(community string) *CommunityFeedResponse {
+		resp, err := a.communityFeedHandler(testRequest(t, "GET", "/community/"+community+"/feed",
+			testUser1.Email, nil, map[string]string{"community": community}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*CommunityFeedResponse)
+	}
+
+	// The new member shows up in their community
+	events := feed(testUser1.Community).Events
+	qt.Assert(t, events, qt.HasLen, 1)
+	qt.Assert(t, events[0].Type, qt.Equals, CommunityEventNewMember)
+	qt.Assert(t, events[0].User.Name, qt.Equals, testUser1.Name)
+
+	// A newly added tool shows up first in its owner community feed
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	resp := feed(testUser1.Community)
+	qt.Assert(t, resp.Events, qt.HasLen, 2)
+	qt.Assert(t, resp.Events[0].Type, qt.Equals, CommunityEventNewTool)
+	qt.Assert(t, resp.Events[0].Tool.ID, qt.Equals, toolID)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(2))
+
+	// Other communities do not see it
+	qt.Assert(t, feed("othercommunity").Events, qt.HasLen, 0)
+}
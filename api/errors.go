@@ -1,11 +1,28 @@
 package api
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+)
 
-// HTTPError represents an error with an HTTP status code
+// HTTPError represents an error with an HTTP status code and a stable error code,
+// returned to clients as the errorCode field of the response header so they can
+// branch on it instead of on the message. Codes are grouped by the thousands:
+//
+//	1xxx authentication errors
+//	2xxx request validation errors
+//	3xxx resource not found errors
+//	4xxx permission errors
+//	5xxx conflict errors
+//	6xxx server errors
+//	7xxx tool validation errors
+//	8xxx rate limiting errors
+//
+// Codes are never reused or renumbered; new errors take the next free code of their group.
 type HTTPError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code      int    `json:"code"`
+	ErrorCode int    `json:"errorCode"`
+	Message   string `json:"message"`
 }
 
 func (e *HTTPError) Error() string {
@@ -15,167 +32,547 @@ func (e *HTTPError) Error() string {
 // Authentication errors
 var (
 	ErrUnauthorized = &HTTPError{
-		Code:    http.StatusUnauthorized,
-		Message: "unauthorized access",
+		Code:      http.StatusUnauthorized,
+		ErrorCode: 1001,
+		Message:   "unauthorized access",
 	}
 	ErrInvalidRegisterAuthToken = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid registration token",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 1002,
+		Message:   "invalid registration token",
 	}
 	ErrWrongLogin = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid email or password",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 1003,
+		Message:   "invalid email or password",
+	}
+	ErrInvalidRefreshToken = &HTTPError{
+		Code:      http.StatusUnauthorized,
+		ErrorCode: 1004,
+		Message:   "invalid or expired refresh token",
 	}
 )
 
 // Request validation errors
 var (
 	ErrInvalidRequestBodyData = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid request body data",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2001,
+		Message:   "invalid request body data",
 	}
 	ErrInvalidJSON = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid JSON body",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2002,
+		Message:   "invalid JSON body",
 	}
 	ErrInvalidImageFormat = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid image format",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2003,
+		Message:   "invalid image format",
+	}
+	ErrInvalidPasswordReset = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2004,
+		Message:   "invalid or expired password reset token",
+	}
+	ErrUnsupportedImageFormat = &HTTPError{
+		Code:      http.StatusUnsupportedMediaType,
+		ErrorCode: 2005,
+		Message:   "unsupported image format (must be png or jpeg)",
+	}
+	ErrImageTooLarge = &HTTPError{
+		Code:      http.StatusRequestEntityTooLarge,
+		ErrorCode: 2006,
+		Message:   "image is too large",
+	}
+	ErrInvalidImageSize = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2007,
+		Message:   "invalid image size (must be thumb, small or medium)",
 	}
 	ErrInvalidHash = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid hash",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2008,
+		Message:   "invalid hash",
 	}
 	ErrInvalidBookingDates = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid booking dates",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2009,
+		Message:   "invalid booking dates",
+	}
+	ErrBookingTooLong = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2010,
+		Message:   "booking exceeds the maximum duration",
 	}
 	ErrInvalidRating = &HTTPError{
-		Code:    http.StatusBadRequest,
-		Message: "invalid rating value (must be between 1 and 5)",
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2011,
+		Message:   "invalid rating value (must be between 1 and 5)",
+	}
+	ErrRatingCommentTooLong = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2012,
+		Message:   "rating comment too long",
+	}
+	ErrInvalidPagination = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2013,
+		Message:   "invalid pagination parameters",
+	}
+	ErrInsufficientLeadTime = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2014,
+		Message:   "booking starts too soon, the tool owner needs more notice",
+	}
+	ErrInvalidBookingGroup = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2015,
+		Message:   "a kit needs at least two different tools of the same owner",
+	}
+	ErrTermsNotAccepted = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2016,
+		Message:   "the terms of the tool must be accepted to book it",
+	}
+	ErrInvalidNotificationType = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2017,
+		Message:   "invalid notification type",
+	}
+	ErrInvalidBookingStatus = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2018,
+		Message:   "invalid booking status",
+	}
+	ErrSearchTermTooShort = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2019,
+		Message:   "search term too short",
+	}
+	ErrInvalidCommunityFilter = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2020,
+		Message:   "invalid community filter (must be mine)",
+	}
+	ErrNoCommunity = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2021,
+		Message:   "user does not belong to any community",
+	}
+	ErrInvalidSortOrder = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2022,
+		Message:   "invalid sort order (must be asc or desc)",
+	}
+	ErrInvalidTokenAmount = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2023,
+		Message:   "invalid token amount",
+	}
+	ErrInvalidDepositClaim = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2024,
+		Message:   "deposit claim exceeds the deposit held",
+	}
+	ErrInvalidCSV = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2025,
+		Message:   "malformed CSV",
+	}
+	ErrSelfTransfer = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2026,
+		Message:   "cannot transfer tokens to yourself",
+	}
+	ErrEmptyMessage = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2027,
+		Message:   "message text is empty",
+	}
+	ErrMessageTooLong = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2028,
+		Message:   "message text too long",
+	}
+	ErrInvalidSearchDistance = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2029,
+		Message: fmt.Sprintf("invalid distance filter (must be between %d and %d meters)",
+			minSearchDistance, maxSearchDistance),
+	}
+	ErrInvalidMaxCost = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2030,
+		Message:   fmt.Sprintf("invalid maxCost filter (must be between 0 and %d tokens)", maxToolCost),
+	}
+	ErrInvalidAvailableFrom = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2031,
+		Message:   "invalid availableFrom filter (must be a positive unix time)",
+	}
+	ErrInvalidAvailableTo = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2032,
+		Message:   "invalid availableTo filter (must be a positive unix time)",
+	}
+	ErrInvalidVerification = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2033,
+		Message:   "invalid or expired verification token",
+	}
+	ErrTooManyImages = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2034,
+		Message:   fmt.Sprintf("too many images in the batch (max %d)", maxImageBatch),
+	}
+	ErrToolNotFree = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2035,
+		Message:   "tool cannot be borrowed for free",
+	}
+	ErrInvalidBookingCost = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2036,
+		Message:   "invalid booking cost (must not exceed the tool cost, nor be set on free requests, kits or transfers)",
+	}
+	ErrRequestTooLarge = &HTTPError{
+		Code:      http.StatusRequestEntityTooLarge,
+		ErrorCode: 2037,
+		Message:   "request body is too large",
+	}
+	ErrTooManyRatingImages = &HTTPError{
+		Code:      http.StatusBadRequest,
+		ErrorCode: 2038,
+		Message:   fmt.Sprintf("too many images in the rating (max %d)", maxRatingImages),
 	}
 )
 
 // Resource not found errors
 var (
 	ErrImageNotFound = &HTTPError{
-		Code:    http.StatusNotFound,
-		Message: "image not found",
+		Code:      http.StatusNotFound,
+		ErrorCode: 3001,
+		Message:   "image not found",
 	}
 	ErrToolNotFound = &HTTPError{
-		Code:    http.StatusNotFound,
-		Message: "tool not found",
+		Code:      http.StatusNotFound,
+		ErrorCode: 3002,
+		Message:   "tool not found",
 	}
 	ErrBookingNotFound = &HTTPError{
-		Code:    http.StatusNotFound,
-		Message: "booking not found",
+		Code:      http.StatusNotFound,
+		ErrorCode: 3003,
+		Message:   "booking not found",
+	}
+	ErrRatingNotFound = &HTTPError{
+		Code:      http.StatusNotFound,
+		ErrorCode: 3004,
+		Message:   "rating not found",
 	}
 	ErrUserNotFound = &HTTPError{
-		Code:    http.StatusNotFound,
-		Message: "user not found",
+		Code:      http.StatusNotFound,
+		ErrorCode: 3005,
+		Message:   "user not found",
+	}
+	ErrInviteNotFound = &HTTPError{
+		Code:      http.StatusNotFound,
+		ErrorCode: 3006,
+		Message:   "invite not found",
 	}
 )
 
 // Permission errors
 var (
 	ErrToolNotOwnedByUser = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "tool not owned by user",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4001,
+		Message:   "tool not owned by user",
 	}
 	ErrOnlyOwnerCanReturn = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "only tool owner can mark as returned",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4002,
+		Message:   "only tool owner can mark as returned",
+	}
+	ErrOnlyOwnerCanHandOver = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4003,
+		Message:   "only tool owner can hand over the tool",
 	}
 	ErrOnlyOwnerCanAccept = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "only tool owner can accept petitions",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4004,
+		Message:   "only tool owner can accept petitions",
 	}
 	ErrOnlyOwnerCanDeny = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "only tool owner can deny petitions",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4005,
+		Message:   "only tool owner can deny petitions",
+	}
+	ErrOnlyOwnerCanSetDates = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4006,
+		Message:   "only tool owner can set the dates of open requests",
 	}
 	ErrOnlyRequesterCanCancel = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "only requester can cancel their requests",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4007,
+		Message:   "only requester can cancel their requests",
+	}
+	ErrOnlyOwnerCanCancel = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4008,
+		Message:   "only tool owner can cancel accepted bookings",
+	}
+	ErrRatingLocked = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4009,
+		Message:   "rating can no longer be modified",
 	}
 	ErrUserNotInvolved = &HTTPError{
-		Code:    http.StatusForbidden,
-		Message: "user not involved in booking",
+		Code:      http.StatusForbidden,
+		ErrorCode: 4010,
+		Message:   "user not involved in booking",
+	}
+	ErrAdminRequired = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4011,
+		Message:   "admin privileges required",
+	}
+	ErrTransferCapExceeded = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4012,
+		Message:   "daily token transfer limit exceeded",
+	}
+	ErrUserDeactivated = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4013,
+		Message:   "user deactivated by an admin",
+	}
+	ErrUserNotVerified = &HTTPError{
+		Code:      http.StatusForbidden,
+		ErrorCode: 4014,
+		Message:   "email not verified",
 	}
 )
 
 // Conflict errors
 var (
 	ErrBookingDatesConflict = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "booking dates conflict with existing booking",
+		Code:      http.StatusConflict,
+		ErrorCode: 5001,
+		Message:   "booking dates conflict with existing booking",
+	}
+	ErrToolUnavailable = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5002,
+		Message:   "tool marked as unavailable for the booking dates",
 	}
 	ErrBookingAlreadyReturned = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "booking already marked as returned",
+		Code:      http.StatusConflict,
+		ErrorCode: 5003,
+		Message:   "booking already marked as returned",
 	}
 	ErrBookingAlreadyRated = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "booking already rated",
+		Code:      http.StatusConflict,
+		ErrorCode: 5004,
+		Message:   "booking already rated",
 	}
 	ErrCanOnlyAcceptPending = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "can only accept pending petitions",
+		Code:      http.StatusConflict,
+		ErrorCode: 5005,
+		Message:   "can only accept pending petitions",
 	}
 	ErrCanOnlyDenyPending = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "can only deny pending petitions",
+		Code:      http.StatusConflict,
+		ErrorCode: 5006,
+		Message:   "can only deny pending petitions",
 	}
 	ErrCanOnlyCancelPending = &HTTPError{
-		Code:    http.StatusConflict,
-		Message: "can only cancel pending requests",
+		Code:      http.StatusConflict,
+		ErrorCode: 5007,
+		Message:   "can only cancel pending requests",
+	}
+	ErrCanOnlyCancelAccepted = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5008,
+		Message:   "can only cancel accepted bookings, pending petitions are denied",
+	}
+	ErrCanOnlyReschedule = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5009,
+		Message:   "can only reschedule pending or accepted bookings",
+	}
+	ErrCanOnlyReturnAccepted = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5010,
+		Message:   "can only return accepted bookings",
+	}
+	ErrTransferCannotBeReturned = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5011,
+		Message:   "transfer bookings are handed over, not returned",
+	}
+	ErrCanOnlyHandOverTransfers = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5012,
+		Message:   "can only hand over accepted transfer bookings",
+	}
+	ErrBookingNotReturned = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5013,
+		Message:   "receipt only available for returned bookings",
+	}
+	ErrCanOnlyRateReturned = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5014,
+		Message:   "can only rate returned bookings",
+	}
+	ErrCanOnlySetDatesOnOpen = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5015,
+		Message:   "can only set dates on open requests",
+	}
+	ErrInsufficientTokens = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5016,
+		Message:   "insufficient tokens",
+	}
+	ErrConflictsWithBookings = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5017,
+		Message:   "the period conflicts with accepted bookings",
+	}
+	ErrDuplicateToolTitle = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5018,
+		Message:   "you already have a tool with this title",
+	}
+	ErrCanOnlyMessageActive = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5019,
+		Message:   "can only send messages on pending or accepted bookings",
+	}
+	ErrTooManyActiveBookings = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5020,
+		Message:   "the requester already has the maximum number of accepted bookings",
+	}
+	ErrAlreadyVerified = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5021,
+		Message:   "email already verified",
+	}
+	ErrAccountHasAcceptedBookings = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5022,
+		Message:   "the account has accepted bookings, return or cancel them first",
+	}
+	ErrInviteNotAvailable = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5023,
+		Message:   "invite already used or revoked",
+	}
+	ErrAcceptInProgress = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5024,
+		Message:   "the petition is being accepted by another request",
+	}
+	ErrNoRescheduleProposal = &HTTPError{
+		Code:      http.StatusConflict,
+		ErrorCode: 5025,
+		Message:   "no new dates proposed by the other party",
 	}
 )
 
 // Server errors
 var (
 	ErrCouldNotInsertToDatabase = &HTTPError{
-		Code:    http.StatusInternalServerError,
-		Message: "could not insert to database",
+		Code:      http.StatusInternalServerError,
+		ErrorCode: 6001,
+		Message:   "could not insert to database",
 	}
 	ErrInternalServerError = &HTTPError{
-		Code:    http.StatusInternalServerError,
-		Message: "internal server error",
+		Code:      http.StatusInternalServerError,
+		ErrorCode: 6002,
+		Message:   "internal server error",
+	}
+	ErrMailUnavailable = &HTTPError{
+		Code:      http.StatusServiceUnavailable,
+		ErrorCode: 6003,
+		Message:   "email delivery is not configured",
 	}
 )
 
 // Tool validation errors
 var (
 	ErrEmptyTitleOrDescription = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "title and description must not be empty",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7001,
+		Message:   "title and description must not be empty",
 	}
 	ErrInvalidEstimatedValue = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "estimated value must be greater than 0",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7002,
+		Message:   "estimated value must be greater than 0",
 	}
 	ErrMayBeFreeRequired = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "may be free must not be nil",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7003,
+		Message:   "may be free must not be nil",
 	}
 	ErrAskWithFeeRequired = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "ask with fee must not be nil",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7004,
+		Message:   "ask with fee must not be nil",
 	}
 	ErrCostRequired = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "cost must not be nil",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7005,
+		Message:   "cost must not be nil",
 	}
 	ErrToolLocationTooFar = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "tool location is too far away",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7006,
+		Message:   "tool location is too far away",
 	}
 	ErrInvalidToolCategory = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "invalid tool category",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7007,
+		Message:   "invalid tool category",
+	}
+	ErrInvalidToolCondition = &HTTPError{
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7008,
+		Message:   "invalid tool condition (must be new, good, fair or poor)",
 	}
 	ErrInvalidTransportOption = &HTTPError{
-		Code:    http.StatusUnprocessableEntity,
-		Message: "invalid transport option",
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7009,
+		Message:   "invalid transport option",
+	}
+	ErrToolCostTooHigh = &HTTPError{
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7010,
+		Message:   fmt.Sprintf("cost must not be greater than %d", maxToolCost),
+	}
+	ErrEstimatedValueTooHigh = &HTTPError{
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7011,
+		Message:   fmt.Sprintf("estimated value must not be greater than %d", maxToolEstimatedValue),
+	}
+	ErrToolDepositTooHigh = &HTTPError{
+		Code:      http.StatusUnprocessableEntity,
+		ErrorCode: 7012,
+		Message:   fmt.Sprintf("deposit must not be greater than %d", maxToolDeposit),
+	}
+)
+
+// Rate limiting errors
+var (
+	ErrTooManyRequests = &HTTPError{
+		Code:      http.StatusTooManyRequests,
+		ErrorCode: 8001,
+		Message:   "too many requests",
 	}
 )
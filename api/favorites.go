@@ -0,0 +1,95 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// favoriteParams returns the tool id of the URL and the user of the request.
+func (a *API) favoriteParams(r *Request) (int64, *db.User, error) {
+	if r.UserID == "" {
+		return 0, nil, ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return 0, nil, ErrInvalidRequestBodyData
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return 0, nil, ErrUserNotFound
+	}
+	return id, user, nil
+}
+
+// POST /tools/{id}/favorite adds the tool to the favorites of the user.
+// Adding it twice changes nothing. Deleted and archived tools are not found.
+func (a *API) addFavoriteHandler(r *Request) (interface{}, error) {
+	id, user, err := a.favoriteParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.tool(id); err != nil {
+		return nil, err
+	}
+	if err := a.database.FavoriteService.Add(r.Context.Request.Context(), user.ID, id); err != nil {
+		return nil, ErrInternalServerError
+	}
+	return nil, nil
+}
+
+// DELETE /tools/{id}/favorite removes the tool from the favorites of the user, if it was.
+func (a *API) removeFavoriteHandler(r *Request) (interface{}, error) {
+	id, user, err := a.favoriteParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.database.FavoriteService.Remove(r.Context.Request.Context(), user.ID, id); err != nil {
+		return nil, ErrInternalServerError
+	}
+	return nil, nil
+}
+
+// GET /favorites returns the favorite tools of the user, the last added first.
+// The tools deleted since they were added are left out.
+func (a *API) favoritesHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+	favorites, err := a.database.FavoriteService.GetUserFavorites(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	ids := make([]int64, len(favorites))
+	for i, f := range favorites {
+		ids[i] = f.ToolID
+	}
+	found, err := a.database.ToolService.GetToolsByIDs(ctx, ids)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	toolsByID := make(map[int64]*db.Tool, len(found))
+	for _, t := range found {
+		toolsByID[t.ID] = t
+	}
+	tools := []db.Tool{}
+	for _, id := range ids {
+		if t, ok := toolsByID[id]; ok && !t.Deleted {
+			tools = append(tools, *t)
+		}
+	}
+	response := toolsPage(tools, page, pageSize)
+	if err := a.setTimesBooked(ctx, response.Tools); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestFavorites(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	ids := make([]int64, 3)
+	for i := range ids {
+		tool := testTool1
+		tool.Title = fmt.Sprintf("tool%d", i+1)
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		ids[i] = id
+	}
+
+	favorite := func(method string, id int64) error {
+		idStr := strconv.FormatInt(id, 10)
+		req := testRequest(t, method, "/tools/"+idStr+"/favorite", testUser2.Email, nil, map[string]string{"id": idStr})
+		var err error
+		if method == "DELETE" {
+			_, err = a.removeFavoriteHandler(req)
+		} else {
+			_, err = a.addFavoriteHandler(req)
+		}
+		return err
+	}
+	favorites := func(query string) *ToolsWrapper {
+		resp, err := a.favoritesHandler(testRequest(t, "GET", "/favorites"+query, testUser2.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsWrapper)
+	}
+
+	// Favoriting twice is the same as once
+	for _, id := range ids {
+		qt.Assert(t, favorite("POST", id), qt.IsNil)
+		time.Sleep(time.Millisecond)
+	}
+	qt.Assert(t, favorite("POST", ids[0]), qt.IsNil)
+	resp := favorites("")
+	qt.Assert(t, resp.Tools, qt.HasLen, 3)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(3))
+	qt.Assert(t, resp.Tools[0].ID, qt.Equals, ids[2])
+
+	// Unknown tools are not found
+	qt.Assert(t, favorite("POST", 1), qt.Equals, ErrToolNotFound)
+
+	// The listing is paginated
+	resp = favorites("?page=1&pageSize=2")
+	qt.Assert(t, resp.Tools, qt.HasLen, 1)
+	qt.Assert(t, resp.Tools[0].ID, qt.Equals, ids[0])
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(3))
+
+	// Removing works once and is then a no-op
+	qt.Assert(t, favorite("DELETE", ids[1]), qt.IsNil)
+	qt.Assert(t, favorite("DELETE", ids[1]), qt.IsNil)
+	qt.Assert(t, favorites("").Tools, qt.HasLen, 2)
+
+	// Deleted tools drop out of the listing and cannot be favorited again
+	qt.Assert(t, a.removeTool(context.Background(), ids[0]), qt.IsNil)
+	resp = favorites("")
+	qt.Assert(t, resp.Tools, qt.HasLen, 1)
+	qt.Assert(t, resp.Tools[0].ID, qt.Equals, ids[2])
+	qt.Assert(t, favorite("POST", ids[0]), qt.Equals, ErrToolNotFound)
+
+	// Favorites are per user
+	resp2, err := a.favoritesHandler(testRequest(t, "GET", "/favorites", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp2.(*ToolsWrapper).Tools, qt.DeepEquals, []db.Tool{})
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +16,10 @@ import (
 // RouterHandlerFn is the function signature for adding handlers to the HTTProuter.
 type RouterHandlerFn = func(r *Request) (interface{}, error)
 
+// errNotModified is returned by the handlers when the client already has the current version of
+// the response, see the If-None-Match header. The client is replied 304 without body.
+var errNotModified = errors.New("not modified")
+
 // Request represents an HTTP request to the API.
 // It contains the request Body data, the URL path and the HTTP context.
 // The context can be used for obtaining URL parameters and sending responses.
@@ -35,6 +41,11 @@ func (h *HTTPContext) URLParam(key string) string {
 	return chi.URLParam(h.Request, key)
 }
 
+// QueryParam returns the value of the URL query parameter key, or an empty string if not present.
+func (h *HTTPContext) QueryParam(key string) string {
+	return h.Request.URL.Query().Get(key)
+}
+
 // Send replies the request with the provided message.
 func (h *HTTPContext) Send(msg []byte, httpStatusCode int) error {
 	defer func() {
@@ -67,12 +78,30 @@ func (h *HTTPContext) Send(msg []byte, httpStatusCode int) error {
 
 // routerHandler is a wrapper around the HTTP handler function to handle the request and response.
 // It reads the request body, calls the handler function and sends the response.
-// The errors are automatically logged and returned to the client.
+// The errors are automatically logged and returned to the client. The locations of other users
+// in the responses are approximated, see hideLocations.
+// The request bodies larger than Options.MaxBodyBytes are rejected with ErrRequestTooLarge.
 func (a *API) routerHandler(handlerFunc RouterHandlerFn) func(w http.ResponseWriter, req *http.Request) {
+	return a.limitedRouterHandler(handlerFunc, int64(a.opts.MaxBodyBytes))
+}
+
+// uploadHandler is the routerHandler of the routes receiving up to the given number of images,
+// base64 encoded in the JSON body, which accept Options.MaxImageBytes more per image.
+func (a *API) uploadHandler(handlerFunc RouterHandlerFn, images int) func(w http.ResponseWriter, req *http.Request) {
+	imageBytes := base64.StdEncoding.EncodedLen(a.opts.MaxImageBytes)
+	return a.limitedRouterHandler(handlerFunc, int64(a.opts.MaxBodyBytes+images*imageBytes))
+}
+
+// limitedRouterHandler is routerHandler with a maximum request body size of maxBodyBytes.
+func (a *API) limitedRouterHandler(
+	handlerFunc RouterHandlerFn,
+	maxBodyBytes int64,
+) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		hc := &HTTPContext{Request: req, Writer: w}
 		var body []byte
 		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, maxBodyBytes)
 			var err error
 			body, err = io.ReadAll(req.Body)
 			if err != nil {
@@ -83,9 +112,16 @@ func (a *API) routerHandler(handlerFunc RouterHandlerFn) func(w http.ResponseWri
 						Message: err.Error(),
 					},
 				}
+				statusCode := http.StatusBadRequest
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					statusCode = ErrRequestTooLarge.Code
+					resp.Header.ErrorCode = ErrRequestTooLarge.ErrorCode
+					resp.Header.Message = ErrRequestTooLarge.Message
+				}
 				msg, _ := json.Marshal(resp)
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusBadRequest)
+				w.WriteHeader(statusCode)
 				if _, err := w.Write(msg); err != nil {
 					log.Error().Err(err).Msg("failed to write response")
 				}
@@ -121,13 +157,24 @@ func (a *API) routerHandler(handlerFunc RouterHandlerFn) func(w http.ResponseWri
 				Data:    body,
 				Context: hc,
 				Path:    strings.Split(req.URL.Path, "/")[1:],
-				UserID:  req.Header.Get("X-User-ID"),
+				UserID:  userIDFromContext(req.Context()),
 			})
+		if errors.Is(err, errNotModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 		resp := new(Response)
 		if err != nil {
 			log.Warn().Err(err).Msg("failed request")
 			resp.Header.Success = false
 			resp.Header.Message = err.Error()
+			statusCode := http.StatusBadRequest
+			if httpErr, ok := err.(*HTTPError); ok {
+				statusCode = httpErr.Code
+				resp.Header.ErrorCode = httpErr.ErrorCode
+			}
+			// handlers can return data explaining the error, such as the conflicting items
+			resp.Data = handlerResp
 			msg, marshalErr := json.Marshal(resp)
 			if marshalErr != nil {
 				log.Error().Err(marshalErr).Msg("failed to marshal response")
@@ -138,10 +185,6 @@ func (a *API) routerHandler(handlerFunc RouterHandlerFn) func(w http.ResponseWri
 				}
 				return
 			}
-			statusCode := http.StatusBadRequest
-			if httpErr, ok := err.(*HTTPError); ok {
-				statusCode = httpErr.Code
-			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(statusCode)
 			if _, err := w.Write(msg); err != nil {
@@ -149,6 +192,7 @@ func (a *API) routerHandler(handlerFunc RouterHandlerFn) func(w http.ResponseWri
 			}
 			return
 		}
+		a.hideLocations(req.Context(), userIDFromContext(req.Context()), handlerResp)
 		resp.Header.Success = true
 		resp.Data = handlerResp
 		data, err := json.Marshal(resp)
@@ -1 +1,66 @@
 package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize          = 50
+	maxPageSize              = 200
+	previewDescriptionLength = 200
+)
+
+// paginationParams parses the page and pageSize query parameters of the request.
+// Page defaults to 0 and pageSize to defaultPageSize, which is capped at maxPageSize.
+// Negative or non numeric values return ErrInvalidPagination.
+func paginationParams(r *Request) (int, int, error) {
+	page, pageSize := 0, defaultPageSize
+	if pageStr := r.Context.QueryParam("page"); pageStr != "" {
+		p, err := strconv.Atoi(pageStr)
+		if err != nil || p < 0 {
+			return 0, 0, ErrInvalidPagination
+		}
+		page = p
+	}
+	if pageSizeStr := r.Context.QueryParam("pageSize"); pageSizeStr != "" {
+		ps, err := strconv.Atoi(pageSizeStr)
+		if err != nil || ps <= 0 {
+			return 0, 0, ErrInvalidPagination
+		}
+		pageSize = min(ps, maxPageSize)
+	}
+	return page, pageSize, nil
+}
+
+// boolParam parses the optional boolean query parameter key of the request, nil if not present.
+// Values other than the ones of strconv.ParseBool return ErrInvalidRequestBodyData.
+func boolParam(r *Request, key string) (*bool, error) {
+	str := r.Context.QueryParam(key)
+	if str == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(str)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	return &value, nil
+}
+
+// paginate returns the page of the slice for the given pagination parameters.
+func paginate[T any](items []T, page, pageSize int) []T {
+	start := page * pageSize
+	if start >= len(items) {
+		return []T{}
+	}
+	return items[start:min(start+pageSize, len(items))]
+}
+
+// truncateString returns s cut to maxLength runes, ending with an ellipsis if it was truncated.
+func truncateString(s string, maxLength int) string {
+	runes := []rune(s)
+	if len(runes) <= maxLength {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:maxLength-1])) + "…"
+}
@@ -6,10 +6,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"image"
-	_ "image/gif"  // Import image decoders for supported formats
-	_ "image/jpeg" // JPEG support
-	_ "image/png"  // PNG support
+	"image/color"
+	_ "image/gif" // GIF images uploaded before they were rejected can still be resized
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"sync"
 
 	"github.com/emprius/emprius-app-backend/db"
 	"github.com/emprius/emprius-app-backend/types"
@@ -17,56 +21,96 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// checkIfDataIsAnImage checks if the given data is an image.
-func checkIfDataIsAnImage(data []byte) error {
+// imageSizes are the maximum width and height, in pixels, of the sizes that can be requested
+// to GET /images/{hash}.
+var imageSizes = map[string]int{
+	"thumb":  128,
+	"small":  320,
+	"medium": 640,
+}
+
+// imageCacheControl lets the clients keep the images forever, since the hash in their URL
+// identifies the content.
+const imageCacheControl = "private, max-age=31536000, immutable"
+
+// maxCachedImageSizes is the number of resized images kept in memory. When reached the
+// cache is emptied.
+const maxCachedImageSizes = 1000
+
+// imageSizeCache keeps the resized images, keyed by the hash of the original image and the size.
+type imageSizeCache struct {
+	mu     sync.Mutex
+	images map[string]*db.Image
+}
+
+func (c *imageSizeCache) get(key string) *db.Image {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.images[key]
+}
+
+func (c *imageSizeCache) add(key string, image *db.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.images == nil || len(c.images) >= maxCachedImageSizes {
+		c.images = make(map[string]*db.Image)
+	}
+	c.images[key] = image
+}
+
+// checkIfDataIsAnImage checks if the given data is a PNG or JPEG image within the size limits
+// of the API. The dimensions are checked from the header before decoding the whole image.
+func (a *API) checkIfDataIsAnImage(data []byte) error {
 	if len(data) == 0 {
 		return ErrInvalidImageFormat
 	}
+	if len(data) > a.opts.MaxImageBytes {
+		return ErrImageTooLarge
+	}
 
-	// Create a reader from the byte slice
-	reader := bytes.NewReader(data)
-
-	// Decode the image
-	_, format, err := image.Decode(reader)
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
 		return ErrInvalidImageFormat
 	}
+	if format != "png" && format != "jpeg" {
+		return ErrUnsupportedImageFormat
+	}
+	if config.Width > a.opts.MaxImageDimension || config.Height > a.opts.MaxImageDimension {
+		return ErrImageTooLarge
+	}
 
-	// Ensure the format is not empty
-	if format == "" {
+	// Decode the whole image to reject truncated or corrupted data
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
 		return ErrInvalidImageFormat
 	}
-
 	return nil
 }
 
 // addImage returns the corresponding db.Image to the data content.
-// If the image is not in the database, it will be added.
-// If the image is already in the database, it will be returned.
+// Images are identified by the hash of their content, so if the image is not in
+// the database it will be added, and if it is already there the stored entry will
+// be returned without writing the content again.
 func (a *API) addImage(name string, data []byte) (*db.Image, error) {
-	if err := checkIfDataIsAnImage(data); err != nil {
+	if err := a.checkIfDataIsAnImage(data); err != nil {
 		log.Debug().Err(err).Msg("invalid image format")
 		return nil, err
 	}
 	hash := sha256.Sum256(data)
-	image, err := a.database.ImageService.GetImage(context.Background(), hash[:])
-	if err == mongo.ErrNoDocuments {
-		image := &db.Image{
-			Hash:    hash[:],
-			Content: data,
-			Name:    name,
-		}
-		_, err := a.database.ImageService.InsertImage(context.Background(), image)
-		if err != nil {
-			return nil, ErrCouldNotInsertToDatabase
-		}
-		log.Debug().Msgf("added image %s", image.Hash.String())
-		return image, nil
+	image := &db.Image{
+		Hash:    hash[:],
+		Content: data,
+		Name:    name,
 	}
+	inserted, err := a.database.ImageService.InsertImageIfNotExists(context.Background(), image)
 	if err != nil {
-		return nil, ErrInternalServerError
+		return nil, ErrCouldNotInsertToDatabase
 	}
-	return image, nil
+	if inserted {
+		log.Debug().Msgf("added image %s", image.Hash.String())
+		return image, nil
+	}
+	log.Debug().Msgf("image %s already stored", image.Hash.String())
+	return a.image(hash[:])
 }
 
 func (a *API) image(hash []byte) (*db.Image, error) {
@@ -80,10 +124,18 @@ func (a *API) image(hash []byte) (*db.Image, error) {
 	return image, nil
 }
 
+// imageListFromSlice returns the images with the given hashes, in the same order. The order is kept
+// when storing them on a tool, the first one being its primary image. Repeated hashes are only
+// included the first time.
 func (a *API) imageListFromSlice(hashes []types.HexBytes) ([]db.Image, error) {
 	var images []db.Image
 	ctx := context.Background()
+	seen := make(map[string]bool, len(hashes))
 	for _, hash := range hashes {
+		if seen[hash.String()] {
+			continue
+		}
+		seen[hash.String()] = true
 		image, err := a.database.ImageService.GetImage(ctx, hash)
 		if err != nil {
 			if err == mongo.ErrNoDocuments {
@@ -119,7 +171,46 @@ func (a *API) imageUploadHandler(r *Request) (interface{}, error) {
 	return dbImage, nil
 }
 
+// POST /images/batch uploads up to maxImageBatch images at once.
+// Each image is validated and stored as in POST /images, independently of the others: the response
+// has the result of each one in the order they were sent, so a rejected image does not lose the rest.
+func (a *API) imageBatchUploadHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	images := []db.Image{}
+	if err := json.Unmarshal(r.Data, &images); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	if len(images) == 0 {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if len(images) > maxImageBatch {
+		return nil, ErrTooManyImages
+	}
+
+	results := make([]ImageBatchResult, len(images))
+	for i, img := range images {
+		dbImage, err := a.addImage(img.Name, img.Content)
+		if err != nil {
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) {
+				httpErr = ErrInternalServerError
+			}
+			results[i] = ImageBatchResult{ErrorCode: httpErr.ErrorCode, Error: httpErr.Message}
+			continue
+		}
+		results[i] = ImageBatchResult{Hash: dbImage.Hash}
+	}
+	return results, nil
+}
+
 // GET /image/:hash returns the image with the given hash.
+// The optional size query parameter (thumb, small or medium) returns the image scaled down to
+// fit that size instead of the original one.
+// The response has an ETag made of the hash and the size, and is not sent again to the clients
+// that already have it, see If-None-Match.
 func (a *API) imageHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
@@ -131,10 +222,110 @@ func (a *API) imageHandler(r *Request) (interface{}, error) {
 		return nil, ErrInvalidHash
 	}
 
+	size := r.Context.QueryParam("size")
+	maxSide, ok := imageSizes[size]
+	if size != "" && !ok {
+		return nil, ErrInvalidImageSize
+	}
+
+	etag := `"` + hex.EncodeToString(hashBytes) + `"`
+	if size != "" {
+		etag = `"` + hex.EncodeToString(hashBytes) + "-" + size + `"`
+	}
+	r.Context.Writer.Header().Set("ETag", etag)
+	r.Context.Writer.Header().Set("Cache-Control", imageCacheControl)
+	if etagMatches(r.Context.Request.Header.Get("If-None-Match"), etag) {
+		return nil, errNotModified
+	}
+
+	cacheKey := hash + ":" + size
+	if size != "" {
+		if resized := a.imageSizes.get(cacheKey); resized != nil {
+			return resized, nil
+		}
+	}
+
 	image, err := a.image(hashBytes)
 	if err != nil {
 		return nil, err
 	}
+	if size == "" {
+		return image, nil
+	}
 
-	return image, nil
+	content, err := resizeImage(image.Content, maxSide)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not resize image %s", hash)
+		return nil, ErrInternalServerError
+	}
+	resized := &db.Image{
+		Hash:    image.Hash,
+		Name:    image.Name,
+		Content: content,
+	}
+	a.imageSizes.add(cacheKey, resized)
+	return resized, nil
+}
+
+// etagMatches returns true if the If-None-Match header lists the ETag, or is "*".
+// Weak comparison is used, as the RFC requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resizeImage scales the image down to fit in a square of maxSide pixels, averaging the pixels
+// of the original covered by each new one. JPEG images are encoded again as JPEG and the rest as
+// PNG. Images that already fit are returned untouched.
+func resizeImage(data []byte, maxSide int) ([]byte, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return data, nil
+	}
+	newWidth, newHeight := maxSide, maxSide
+	if width > height {
+		newHeight = max(1, height*maxSide/width)
+	} else {
+		newWidth = max(1, width*maxSide/height)
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/newHeight, bounds.Min.Y+(y+1)*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/newWidth, bounds.Min.X+(x+1)*width/newWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n),
+			})
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	qt.Assert(t, png.Encode(&buf, img), qt.IsNil)
+	return buf.Bytes()
+}
+
+func TestImageSizes(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	original := testPNG(t, 1000, 500)
+	stored, err := a.addImage("wide", original)
+	qt.Assert(t, err, qt.IsNil)
+	hash := stored.Hash.String()
+
+	get := func(query string) (*db.Image, error) {
+		resp, err := a.imageHandler(testRequest(t, "GET", "/images/"+hash+query, testUser1.Email, nil,
+			map[string]string{"hash": hash}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*db.Image), nil
+	}
+
+	// Without size the original is returned
+	img, err := get("")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, img.Content, qt.DeepEquals, original)
+
+	thumb, err := get("?size=thumb")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, thumb.Hash.String(), qt.Equals, hash)
+	config, format, err := image.DecodeConfig(bytes.NewReader(thumb.Content))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, format, qt.Equals, "png")
+	qt.Assert(t, config.Width, qt.Equals, 128)
+	qt.Assert(t, config.Height, qt.Equals, 64)
+
+	// The second request is served from the cache
+	cached, err := get("?size=thumb")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, cached, qt.Equals, thumb)
+
+	medium, err := get("?size=medium")
+	qt.Assert(t, err, qt.IsNil)
+	config, _, err = image.DecodeConfig(bytes.NewReader(medium.Content))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, config.Width, qt.Equals, 640)
+	qt.Assert(t, config.Height, qt.Equals, 320)
+
+	_, err = get("?size=huge")
+	qt.Assert(t, err, qt.Equals, ErrInvalidImageSize)
+}
+
+func TestImageUploadValidation(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	upload := func(content []byte) (*db.Image, error) {
+		resp, err := a.imageUploadHandler(testRequest(t, "POST", "/images", testUser1.Email,
+			db.Image{Name: "upload", Content: content}, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*db.Image), nil
+	}
+
+	// A valid PNG is stored under the hash of the uploaded bytes
+	valid := testPNG(t, 200, 100)
+	img, err := upload(valid)
+	qt.Assert(t, err, qt.IsNil)
+	hash := sha256.Sum256(valid)
+	qt.Assert(t, []byte(img.Hash), qt.DeepEquals, hash[:])
+	stored, err := a.image(img.Hash)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored.Content, qt.DeepEquals, valid)
+
+	// Garbage and truncated payloads
+	_, err = upload([]byte("definitely not an image"))
+	qt.Assert(t, err, qt.Equals, ErrInvalidImageFormat)
+	_, err = upload(valid[:len(valid)/2])
+	qt.Assert(t, err, qt.Equals, ErrInvalidImageFormat)
+
+	// Other formats
+	buf := bytes.Buffer{}
+	qt.Assert(t, gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 10, 10), color.Palette{color.Black}), nil),
+		qt.IsNil)
+	_, err = upload(buf.Bytes())
+	qt.Assert(t, err, qt.Equals, ErrUnsupportedImageFormat)
+
+	// Oversized dimensions and bytes
+	a.opts.MaxImageDimension = 150
+	_, err = upload(valid)
+	qt.Assert(t, err, qt.Equals, ErrImageTooLarge)
+	_, err = upload(testPNG(t, 150, 150))
+	qt.Assert(t, err, qt.IsNil)
+	a.opts.MaxImageBytes = 50
+	_, err = upload(testPNG(t, 20, 20))
+	qt.Assert(t, err, qt.Equals, ErrImageTooLarge)
+}
+
+func TestImageBatchUpload(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	upload := func(images []db.Image) ([]ImageBatchResult, error) {
+		resp, err := a.imageBatchUploadHandler(testRequest(t, "POST", "/images/batch", testUser1.Email, images, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.([]ImageBatchResult), nil
+	}
+
+	// The good images are stored even if others are rejected, and the results keep the order
+	first, second := testPNG(t, 20, 10), testPNG(t, 10, 20)
+	results, err := upload([]db.Image{
+		{Name: "first", Content: first},
+		{Name: "garbage", Content: []byte("definitely not an image")},
+		{Name: "empty"},
+		{Name: "second", Content: second},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, 4)
+	for i, content := range map[int][]byte{0: first, 3: second} {
+		hash := sha256.Sum256(content)
+		qt.Assert(t, []byte(results[i].Hash), qt.DeepEquals, hash[:])
+		qt.Assert(t, results[i].ErrorCode, qt.Equals, 0)
+		stored, err := a.image(results[i].Hash)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, stored.Content, qt.DeepEquals, content)
+	}
+	for _, i := range []int{1, 2} {
+		qt.Assert(t, results[i].Hash, qt.IsNil)
+		qt.Assert(t, results[i].ErrorCode, qt.Equals, ErrInvalidImageFormat.ErrorCode)
+		qt.Assert(t, results[i].Error, qt.Equals, ErrInvalidImageFormat.Message)
+	}
+
+	// Empty and oversized batches are rejected as a whole
+	_, err = upload([]db.Image{})
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+	batch := make([]db.Image, maxImageBatch+1)
+	for i := range batch {
+		batch[i] = db.Image{Name: "many", Content: first}
+	}
+	_, err = upload(batch)
+	qt.Assert(t, err, qt.Equals, ErrTooManyImages)
+	results, err = upload(batch[:maxImageBatch])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, results, qt.HasLen, maxImageBatch)
+}
+
+func TestResizeSmallImage(t *testing.T) {
+	small := testPNG(t, 100, 50)
+	resized, err := resizeImage(small, imageSizes["thumb"])
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resized, qt.DeepEquals, small)
+}
+
+func TestImageETag(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	stored, err := a.addImage("etag", testPNG(t, 10, 10))
+	qt.Assert(t, err, qt.IsNil)
+	token := testToken(t, a, testUser1.Email)
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := newTestHTTPRequest(t, http.MethodGet, path, token, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	path := "/images/" + stored.Hash.String()
+
+	// The first request gets the image with its ETag
+	rec := get(path, "")
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	qt.Assert(t, etag, qt.Equals, `"`+stored.Hash.String()+`"`)
+	qt.Assert(t, rec.Header().Get("Cache-Control"), qt.Equals, imageCacheControl)
+
+	// A second request with the ETag is not sent the image again
+	rec = get(path, etag)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusNotModified)
+	qt.Assert(t, rec.Body.Len(), qt.Equals, 0)
+	qt.Assert(t, get(path, `"other", W/`+etag).Code, qt.Equals, http.StatusNotModified)
+
+	// Each size has its own ETag
+	rec = get(path+"?size=thumb", etag)
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, rec.Header().Get("ETag"), qt.Equals, `"`+stored.Hash.String()+`-thumb"`)
+}
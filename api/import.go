@@ -0,0 +1,134 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// toolCSVColumns are the columns of a tool catalog CSV, in order. Coordinates are in degrees
+// and the transport options are IDs separated by semicolons. A header row is optional.
+var toolCSVColumns = []string{
+	"title", "description", "category", "cost", "value", "lat", "lng", "transport options",
+}
+
+// POST /tools/import/csv?owner={userId} creates the tools of a CSV catalog on behalf of the owner.
+// Each row is validated as a new tool, the rows that fail do not prevent the others from being imported.
+func (a *API) toolImportCSVHandler(r *Request) (interface{}, error) {
+	ownerID, err := primitive.ObjectIDFromHex(r.Context.QueryParam("owner"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	owner, err := a.database.UserService.GetUserByID(r.Context.Request.Context(), ownerID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	reader := csv.NewReader(bytes.NewReader(r.Data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	resp := &ToolImportResponse{Results: []ToolImportResult{}}
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, &HTTPError{
+				Code:      ErrInvalidCSV.Code,
+				ErrorCode: ErrInvalidCSV.ErrorCode,
+				Message:   fmt.Sprintf("%s: %v", ErrInvalidCSV.Message, err),
+			}
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), toolCSVColumns[0]) {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		result := ToolImportResult{Line: line}
+		tool, err := toolFromCSVRecord(record)
+		if err == nil {
+			result.ID, err = a.addTool(tool, owner.Email)
+		}
+		if err != nil {
+			result.Error = err.Error()
+			resp.Failed++
+		} else {
+			resp.Imported++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	if len(resp.Results) == 0 {
+		return nil, ErrInvalidCSV
+	}
+	log.Info().Msgf("imported %d tools for user %s, %d rows failed", resp.Imported, owner.Email, resp.Failed)
+	a.audit(r.Context.Request.Context(), r, db.AuditToolsImported, db.AuditTargetUser, owner.ID.Hex(),
+		fmt.Sprintf("%d tools imported, %d rows failed", resp.Imported, resp.Failed))
+	return resp, nil
+}
+
+// toolFromCSVRecord parses a row of a tool catalog CSV. Tools without cost may be free.
+func toolFromCSVRecord(record []string) (*Tool, error) {
+	if len(record) != len(toolCSVColumns) {
+		return nil, fmt.Errorf("expected %d columns (%s), got %d",
+			len(toolCSVColumns), strings.Join(toolCSVColumns, ", "), len(record))
+	}
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	category, err := strconv.Atoi(record[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid category %q", record[2])
+	}
+	cost, err := strconv.ParseUint(record[3], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cost %q", record[3])
+	}
+	value, err := strconv.ParseUint(record[4], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", record[4])
+	}
+	lat, err := strconv.ParseFloat(record[5], 64)
+	if err != nil || math.Abs(lat) > 90 {
+		return nil, fmt.Errorf("invalid latitude %q", record[5])
+	}
+	lng, err := strconv.ParseFloat(record[6], 64)
+	if err != nil || math.Abs(lng) > 180 {
+		return nil, fmt.Errorf("invalid longitude %q", record[6])
+	}
+	transports := []int{}
+	for _, field := range strings.Split(record[7], ";") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transport option %q", field)
+		}
+		transports = append(transports, id)
+	}
+	mayBeFree, askWithFee := cost == 0, false
+	return &Tool{
+		Title:          record[0],
+		Description:    record[1],
+		Category:       category,
+		Cost:           &cost,
+		MayBeFree:      &mayBeFree,
+		AskWithFee:     &askWithFee,
+		EstimatedValue: value,
+		Location: db.Location{
+			Latitude:  int64(math.Round(lat * 1e6)),
+			Longitude: int64(math.Round(lng * 1e6)),
+		},
+		TransportOptions: transports,
+	}, nil
+}
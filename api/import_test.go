@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestToolImportCSV(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	importCSV := func(email, csv string) (interface{}, error) {
+		req := testRequest(t, "POST", "/tools/import/csv?owner="+owner.ID.Hex(), email, nil, nil)
+		req.Data = []byte(csv)
+		return a.adminHandler(a.toolImportCSVHandler)(req)
+	}
+
+	catalog := `title,description,category,cost,value,lat,lng,transport options
+drill,cordless drill,1,10,200,41.688407,2.491027,1;2
+ladder,"aluminium ladder, 3m",2,0,150,41.69,2.49,
+saw,hand saw,99,5,30,41.69,2.49,1
+wheelbarrow,garden wheelbarrow,3,5,80,41.69,2.49,3
+`
+
+	// Only admins can import
+	_, err = importCSV(testUser1.Email, catalog)
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+
+	resp, err := importCSV(testAdmin.Email, catalog)
+	qt.Assert(t, err, qt.IsNil)
+	result := resp.(*ToolImportResponse)
+	qt.Assert(t, result.Imported, qt.Equals, 3)
+	qt.Assert(t, result.Failed, qt.Equals, 1)
+	qt.Assert(t, result.Results, qt.HasLen, 4)
+	// The bad row is reported with its line number
+	qt.Assert(t, result.Results[2].Line, qt.Equals, 4)
+	qt.Assert(t, result.Results[2].Error, qt.Equals, ErrInvalidToolCategory.Message)
+	qt.Assert(t, result.Results[2].ID, qt.Equals, int64(0))
+
+	// The other rows are created under the owner
+	for _, i := range []int{0, 1, 3} {
+		qt.Assert(t, result.Results[i].Error, qt.Equals, "")
+		tool, err := a.tool(result.Results[i].ID)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, tool.UserID, qt.Equals, owner.ID)
+	}
+	tool, err := a.tool(result.Results[1].ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tool.Description, qt.Equals, "aluminium ladder, 3m")
+	qt.Assert(t, tool.MayBeFree, qt.IsTrue)
+
+	// Malformed CSV is rejected as a whole
+	_, err = importCSV(testAdmin.Email, "title,description\n\"unterminated,row\n")
+	qt.Assert(t, err, qt.ErrorMatches, "malformed CSV: .*")
+	_, err = importCSV(testAdmin.Email, "")
+	qt.Assert(t, err, qt.Equals, ErrInvalidCSV)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// adminCreateInviteHandler handles POST /admin/invites
+// It mints a single-use registration invite, see RegistrationInvite. The token is only returned now.
+func (a *API) adminCreateInviteHandler(r *Request) (interface{}, error) {
+	ctx := r.Context.Request.Context()
+	admin, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("could not generate invite token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenHash := sha256.Sum256([]byte(token))
+	invite, err := a.database.InviteService.Create(ctx, admin.ID, tokenHash[:])
+	if err != nil {
+		return nil, ErrCouldNotInsertToDatabase
+	}
+	log.Info().Msgf("invite %s created by %s", invite.ID.Hex(), r.UserID)
+	a.audit(ctx, r, db.AuditInviteCreated, db.AuditTargetInvite, invite.ID.Hex(), "")
+	return &InviteResponse{Invite: invite, Token: token}, nil
+}
+
+// adminInvitesHandler handles GET /admin/invites
+// It returns the registration invites, newest first, with whether they were used or revoked.
+func (a *API) adminInvitesHandler(r *Request) (interface{}, error) {
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	invites, total, err := a.database.InviteService.Get(r.Context.Request.Context(), page, pageSize)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return &InvitesResponse{
+		Invites: invites,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// adminRevokeInviteHandler handles DELETE /admin/invites/{id}
+// It revokes an invite not used yet, so nobody can register with it.
+func (a *API) adminRevokeInviteHandler(r *Request) (interface{}, error) {
+	id, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
+	if err != nil {
+		return nil, ErrInviteNotFound
+	}
+	ctx := r.Context.Request.Context()
+	invite, err := a.database.InviteService.Revoke(ctx, id)
+	switch {
+	case errors.Is(err, db.ErrInviteNotFound):
+		return nil, ErrInviteNotFound
+	case errors.Is(err, db.ErrInviteNotAvailable):
+		return nil, ErrInviteNotAvailable
+	case err != nil:
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("invite %s revoked by %s", id.Hex(), r.UserID)
+	a.audit(ctx, r, db.AuditInviteRevoked, db.AuditTargetInvite, id.Hex(), "")
+	return &InviteResponse{Invite: invite}, nil
+}
+
+// checkRegistration checks the registration token of the user according to Options.RegistrationMode.
+// In invite mode the invite is consumed by the user, the returned function releases it again if the
+// registration fails afterwards.
+func (a *API) checkRegistration(ctx context.Context, token string, userID primitive.ObjectID) (func(), error) {
+	release := func() {}
+	switch a.opts.RegistrationMode {
+	case RegistrationOpen:
+		return release, nil
+	case RegistrationInvite:
+		if token == "" {
+			return nil, ErrInvalidRegisterAuthToken
+		}
+		tokenHash := sha256.Sum256([]byte(token))
+		invite, err := a.database.InviteService.Consume(ctx, tokenHash[:], userID)
+		if errors.Is(err, db.ErrInvalidInvite) {
+			return nil, ErrInvalidRegisterAuthToken
+		}
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		return func() {
+			if err := a.database.InviteService.Release(ctx, invite.ID, userID); err != nil {
+				log.Warn().Err(err).Msgf("could not release invite %s", invite.ID.Hex())
+			}
+		}, nil
+	default:
+		if token != a.registerAuthToken {
+			return nil, ErrInvalidRegisterAuthToken
+		}
+		return release, nil
+	}
+}
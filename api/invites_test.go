@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestRegistrationModes(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	register := func(email, token string) error {
+		_, err := a.registerHandler(testRequest(t, "POST", "/register", "", &Register{
+			UserEmail:         email,
+			RegisterAuthToken: token,
+			UserProfile:       UserProfile{Name: email, Password: "secret"},
+		}, nil))
+		return err
+	}
+	mint := func() *InviteResponse {
+		resp, err := a.adminHandler(a.adminCreateInviteHandler)(testRequest(t, "POST", "/admin/invites",
+			testAdmin.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*InviteResponse)
+	}
+	invites := func() []*db.Invite {
+		resp, err := a.adminHandler(a.adminInvitesHandler)(testRequest(t, "GET", "/admin/invites",
+			testAdmin.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*InvitesResponse).Invites
+	}
+	revoke := func(id string) error {
+		_, err := a.adminHandler(a.adminRevokeInviteHandler)(testRequest(t, "DELETE", "/admin/invites/"+id,
+			testAdmin.Email, nil, map[string]string{"id": id}))
+		return err
+	}
+
+	// The shared token is required by default
+	qt.Assert(t, a.opts.RegistrationMode, qt.Equals, RegistrationToken)
+	qt.Assert(t, register("erin@emprius.cat", "wrong"), qt.Equals, ErrInvalidRegisterAuthToken)
+	qt.Assert(t, register("erin@emprius.cat", "authtoken"), qt.IsNil)
+
+	// Only admins mint invites
+	_, err := a.adminHandler(a.adminCreateInviteHandler)(testRequest(t, "POST", "/admin/invites",
+		testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrAdminRequired)
+
+	// In invite mode the shared token is no longer valid, and each invite registers a single user
+	a.opts.RegistrationMode = RegistrationInvite
+	qt.Assert(t, register("frank@emprius.cat", "authtoken"), qt.Equals, ErrInvalidRegisterAuthToken)
+	qt.Assert(t, register("frank@emprius.cat", ""), qt.Equals, ErrInvalidRegisterAuthToken)
+	invite := mint()
+	qt.Assert(t, invite.Token, qt.Not(qt.Equals), "")
+	qt.Assert(t, register("frank@emprius.cat", invite.Token), qt.IsNil)
+	qt.Assert(t, register("grace@emprius.cat", invite.Token), qt.Equals, ErrInvalidRegisterAuthToken)
+	frank, err := a.database.UserService.GetUserByEmail(context.Background(), "frank@emprius.cat")
+	qt.Assert(t, err, qt.IsNil)
+	listed := invites()
+	qt.Assert(t, listed, qt.HasLen, 1)
+	qt.Assert(t, listed[0].UsedBy, qt.Equals, frank.ID)
+	qt.Assert(t, listed[0].UsedAt, qt.IsNotNil)
+
+	// A failed registration does not consume the invite
+	invite = mint()
+	qt.Assert(t, register("frank@emprius.cat", invite.Token), qt.IsNotNil)
+	qt.Assert(t, register("grace@emprius.cat", invite.Token), qt.IsNil)
+
+	// Revoked invites cannot be used, nor revoked again, and neither can the used ones
+	invite = mint()
+	qt.Assert(t, revoke(invite.ID.Hex()), qt.IsNil)
+	qt.Assert(t, register("heidi@emprius.cat", invite.Token), qt.Equals, ErrInvalidRegisterAuthToken)
+	qt.Assert(t, revoke(invite.ID.Hex()), qt.Equals, ErrInviteNotAvailable)
+	qt.Assert(t, revoke(listed[0].ID.Hex()), qt.Equals, ErrInviteNotAvailable)
+	qt.Assert(t, revoke(frank.ID.Hex()), qt.Equals, ErrInviteNotFound)
+	listed = invites()
+	qt.Assert(t, listed, qt.HasLen, 3)
+	qt.Assert(t, listed[0].RevokedAt, qt.IsNotNil)
+
+	// In open mode no token is needed
+	a.opts.RegistrationMode = RegistrationOpen
+	qt.Assert(t, register("heidi@emprius.cat", ""), qt.IsNil)
+}
@@ -2,17 +2,28 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/emprius/emprius-app-backend/db"
 )
 
+// userIDContextKey is the request context key holding the identifier of the authenticated user.
+type userIDContextKey struct{}
+
 // authHandler is a handler that authenticates the user and returns a JWT token.
-// If successful, the user identifier is added to the HTTP header as `X-User-Id`,
-// so that it can be used by the next handlers.
+// If successful, the user identifier of the verified token claims is added to the request
+// context, so that it can be used by the next handlers, see userIDFromContext. The tokens of
+// users deactivated by an admin are rejected, even if they have not expired yet.
 func (a *API) authenticator(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, claims, err := jwtauth.FromContext(r.Context())
@@ -24,26 +35,50 @@ func (a *API) authenticator(next http.Handler) http.Handler {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		// Retrieve the `userId` from the claims and add it to the HTTP header
-		r.Header.Add("X-User-Id", claims["userId"].(string))
+		userID, ok := claims["userId"].(string)
+		if !ok || userID == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		user, err := a.database.UserService.GetUserByEmail(r.Context(), userID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if user.Deactivated {
+			http.Error(w, ErrUserDeactivated.Message, ErrUserDeactivated.Code)
+			return
+		}
 		// Token is authenticated, pass it through
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDContextKey{}, userID)))
 	})
 }
 
+// userIDFromContext returns the identifier of the user authenticated by the authenticator middleware,
+// or an empty string on public routes. Client headers are never trusted for it.
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDContextKey{}).(string)
+	return userID
+}
+
 // makeToken creates a JWT token for the given user identifier.
 // The token is signed with the API secret, following the JWT specification.
-// The token is valid for the period specified on jwtExpiration constant.
+// The token is valid for the period specified on the JWTExpiration option.
 func (a *API) makeToken(id string) (*LoginResponse, error) {
 	j := jwt.New()
 	if err := j.Set("userId", id); err != nil {
 		return nil, err
 	}
-	if err := j.Set(jwt.ExpirationKey, time.Now().Add(jwtExpiration).Unix()); err != nil {
+	expiration := time.Now().Add(a.opts.JWTExpiration)
+	if err := j.Set(jwt.ExpirationKey, expiration.Unix()); err != nil {
 		return nil, err
 	}
 	lr := LoginResponse{}
-	lr.Expirity = time.Now().Add(jwtExpiration)
+	lr.Expirity = expiration
 	jmap, err := j.AsMap(context.Background())
 	if err != nil {
 		return nil, err
@@ -52,6 +87,31 @@ func (a *API) makeToken(id string) (*LoginResponse, error) {
 	return &lr, nil
 }
 
+// issueTokens creates an access token for the user along with a new refresh token, valid for the
+// period specified on the RefreshExpiration option. Only the hash of the refresh token is stored.
+func (a *API) issueTokens(ctx context.Context, user *db.User) (*LoginResponse, error) {
+	lr, err := a.makeToken(user.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("could not generate refresh token: %w", err)
+	}
+	lr.RefreshToken = hex.EncodeToString(tokenBytes)
+	if err := a.database.RefreshTokenService.Create(ctx, user.ID, refreshTokenHash(lr.RefreshToken),
+		time.Now().Add(a.opts.RefreshExpiration)); err != nil {
+		return nil, fmt.Errorf("could not store refresh token: %w", err)
+	}
+	return lr, nil
+}
+
+// refreshTokenHash returns the hash under which the refresh token is stored.
+func refreshTokenHash(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
 func hashPassword(password string) []byte {
 	return sha256.New().Sum([]byte(passwordSalt + password))
 }
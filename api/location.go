@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// hideLocations replaces the locations of the tools and users in the response that do not belong
+// to the viewer, identified by email, with their approximate ones, see Options.LocationPrecision.
+// The distances to the tools are rounded to the same precision, so they do not give the exact
+// locations away either. The searches and distances are computed before, with the exact locations.
+func (a *API) hideLocations(ctx context.Context, viewer string, response interface{}) {
+	precision := a.opts.LocationPrecision
+	if precision <= 0 {
+		return
+	}
+	var tools []*db.Tool
+	var users []*UserResponse
+	switch resp := response.(type) {
+	case *db.Tool:
+		tools = append(tools, resp)
+	case *ToolsWrapper:
+		for i := range resp.Tools {
+			tools = append(tools, &resp.Tools[i])
+		}
+	case *UserResponse:
+		users = append(users, resp)
+	case *UsersWrapper:
+		for i := range resp.Users {
+			users = append(users, &resp.Users[i])
+		}
+	case *DashboardResponse:
+		users = append(users, resp.Profile)
+	}
+
+	if len(tools) == 0 && len(users) == 0 {
+		return
+	}
+	viewerID := primitive.NilObjectID
+	if viewer != "" {
+		if user, err := a.database.UserService.GetUserByEmail(ctx, viewer); err == nil {
+			viewerID = user.ID
+		}
+	}
+	for _, user := range users {
+		if user != nil && user.ID != viewerID.Hex() {
+			user.Location = user.Location.Approximate(precision)
+		}
+	}
+	for _, tool := range tools {
+		if tool.UserID == viewerID {
+			continue
+		}
+		tool.Location = tool.Location.Approximate(precision)
+		if tool.Distance != nil {
+			distance := int64(math.Round(float64(*tool.Distance)/float64(precision))) * int64(precision)
+			tool.Distance = &distance
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestLocationPrivacy(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ownerToken, otherToken := testToken(t, a, testUser1.Email), testToken(t, a, testUser2.Email)
+	approximate := func(got, exact db.Location) {
+		t.Helper()
+		qt.Assert(t, got, qt.Not(qt.Equals), exact)
+		qt.Assert(t, got, qt.Equals, exact.Approximate(a.opts.LocationPrecision))
+	}
+
+	// Other users never get the exact location of the tool
+	toolPath := fmt.Sprintf("/tools/%d", toolID)
+	code, resp := testHTTPRequest(t, router, http.MethodGet, toolPath, otherToken, nil)
+	approximate(testHTTPData[db.Tool](t, code, resp).Location, testTool1.Location)
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/tools/user/"+testUser1.Email, otherToken, nil)
+	tools := testHTTPData[ToolsWrapper](t, code, resp).Tools
+	qt.Assert(t, tools, qt.HasLen, 1)
+	approximate(tools[0].Location, testTool1.Location)
+
+	// The search still filters by the exact location, which is 10km away from the owner
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/tools/search?distance=10100", ownerToken, nil)
+	qt.Assert(t, testHTTPData[ToolsWrapper](t, code, resp).Tools, qt.HasLen, 1)
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/tools/search?distance=9900", ownerToken, nil)
+	qt.Assert(t, testHTTPData[ToolsWrapper](t, code, resp).Tools, qt.HasLen, 0)
+
+	// Nor the exact location of the owner
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/users/"+owner.ID.Hex(), otherToken, nil)
+	approximate(testHTTPData[UserResponse](t, code, resp).Location, testUser1.Location)
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/users", otherToken, nil)
+	for _, user := range testHTTPData[UsersWrapper](t, code, resp).Users {
+		qt.Assert(t, user.Email, qt.Equals, "")
+		if user.ID == owner.ID.Hex() {
+			approximate(user.Location, testUser1.Location)
+		}
+	}
+
+	// The owner sees the exact locations
+	code, resp = testHTTPRequest(t, router, http.MethodGet, toolPath, ownerToken, nil)
+	qt.Assert(t, testHTTPData[db.Tool](t, code, resp).Location, qt.Equals, testTool1.Location)
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/profile", ownerToken, nil)
+	profile := testHTTPData[UserResponse](t, code, resp)
+	qt.Assert(t, profile.Location, qt.Equals, testUser1.Location)
+	qt.Assert(t, profile.Email, qt.Equals, testUser1.Email)
+
+	// Unless disabled
+	exact := New("secret", "authtoken", a.database, &Options{LocationPrecision: -1})
+	code, resp = testHTTPRequest(t, exact.router(), http.MethodGet, toolPath, otherToken, nil)
+	qt.Assert(t, testHTTPData[db.Tool](t, code, resp).Location, qt.Equals, testTool1.Location)
+}
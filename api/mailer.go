@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPMailer delivers the password reset and email verification tokens by email, through an SMTP
+// server. Its methods are meant for Options.SendPasswordReset and Options.SendVerification.
+type SMTPMailer struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// Username and Password authenticate with the server, if Username is set.
+	Username string
+	Password string
+	// From is the sender address of the emails.
+	From string
+	// AppURL is the frontend the links of the emails point to, with the token in the token query
+	// parameter. If empty, only the token is sent.
+	AppURL string
+}
+
+// SendPasswordReset sends the password reset token to the email.
+func (m *SMTPMailer) SendPasswordReset(email, token string) error {
+	return m.send(email, "Reset your Emprius password",
+		"Somebody asked to reset the password of your Emprius account. If it was not you, ignore this email.",
+		"/password/reset", token)
+}
+
+// SendVerification sends the email verification token to the email.
+func (m *SMTPMailer) SendVerification(email, token string) error {
+	return m.send(email, "Verify your Emprius email", "Welcome to Emprius! Verify your email to start booking tools.",
+		"/verify", token)
+}
+
+// send emails the token to the address, with a link to the path of AppURL if set.
+func (m *SMTPMailer) send(to, subject, text, path, token string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid email address %q", to)
+	}
+	body := fmt.Sprintf("%s\r\n\r\nYour code: %s\r\n", text, token)
+	if m.AppURL != "" {
+		body += fmt.Sprintf("\r\nOr follow this link: %s%s?token=%s\r\n", strings.TrimSuffix(m.AppURL, "/"), path, token)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s",
+		m.From, to, subject, body)
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %w", m.Addr, err)
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, []byte(msg))
+}
@@ -1,13 +1,158 @@
 package api
 
-import chiprometheus "github.com/766b/chi-prometheus"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
 
-// EnablePrometheusMetrics enables go-chi prometheus metrics under specified ID.
-// If ID empty, the default "gochi_http" is used.
-func (a *API) EnablePrometheusMetrics(prometheusID string) {
-	// Prometheus handler
-	if prometheusID == "" {
-		prometheusID = "gochi_http"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// metricsNamespace prefixes the names of all the metrics
+const metricsNamespace = "emprius"
+
+// metrics holds the Prometheus collectors of the API. Each API has its own registry, so several
+// instances (as in the tests) do not clash.
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	bookings        *prometheus.CounterVec
+	workerRuns      *prometheus.CounterVec
+	workerBookings  *prometheus.CounterVec
+}
+
+// newMetrics creates and registers the collectors. The booking events of the hub are exported too
+// if it is not nil.
+func newMetrics(hub *db.BookingEventHub) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to serve the HTTP requests by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		bookings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bookings_created_total",
+			Help:      "Booking creation attempts by outcome: created, or the error code of the rejection.",
+		}, []string{"outcome"}),
+		workerRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "worker_runs_total",
+			Help:      "Runs of the background tasks by task and result.",
+		}, []string{"task", "result"}),
+		workerBookings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "worker_bookings_total",
+			Help:      "Bookings updated by the background tasks by task.",
+		}, []string{"task"}),
 	}
-	a.Router.Use(chiprometheus.NewMiddleware(prometheusID))
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.bookings,
+		m.workerRuns,
+		m.workerBookings,
+	)
+	if hub != nil {
+		m.registry.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "booking_events_delivered_total",
+				Help:      "Booking events delivered to the subscribers.",
+			}, func() float64 { return float64(hub.Stats().Delivered) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "booking_events_dropped_total",
+				Help:      "Booking events missed by subscribers that fell behind.",
+			}, func() float64 { return float64(hub.Stats().Dropped) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: metricsNamespace,
+				Name:      "booking_events_subscribers",
+				Help:      "Subscribers currently listening to booking events.",
+			}, func() float64 { return float64(hub.Stats().Subscribers) }),
+		)
+	}
+	return m
+}
+
+// handler serves the metrics in the Prometheus text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware counts the requests and measures their latency. They are labeled with the route
+// pattern instead of the path, so the number of series does not grow with the ids.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// countBookings wraps the booking creation handler to count its outcomes.
+func (m *metrics) countBookings(handlerFunc RouterHandlerFn) RouterHandlerFn {
+	return func(r *Request) (interface{}, error) {
+		resp, err := handlerFunc(r)
+		outcome := "created"
+		if err != nil {
+			outcome = "error"
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				outcome = strconv.Itoa(httpErr.ErrorCode)
+			}
+		}
+		m.bookings.WithLabelValues(outcome).Inc()
+		return resp, err
+	}
+}
+
+// workerRun records a run of a background task and the bookings it updated.
+func (m *metrics) workerRun(task string, updated int, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.workerRuns.WithLabelValues(task, result).Inc()
+	m.workerBookings.WithLabelValues(task).Add(float64(updated))
+}
+
+// startMetrics serves GET /metrics on its own address (non blocking).
+func (a *API) startMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", a.metrics.handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatal().Err(err).Msg("failed to start metrics server")
+		}
+	}()
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	token := testToken(t, a, testUser2.Email)
+
+	// Requests are counted by route pattern, not by path
+	for i := 0; i < 2; i++ {
+		code, _ := testHTTPRequest(t, router, http.MethodGet, fmt.Sprintf("/tools/%d", id), token, nil)
+		qt.Assert(t, code, qt.Equals, http.StatusOK)
+	}
+	code, _ := testHTTPRequest(t, router, http.MethodGet, "/profile", "", nil)
+	qt.Assert(t, code, qt.Equals, http.StatusUnauthorized)
+	qt.Assert(t, testutil.ToFloat64(a.metrics.requests.WithLabelValues("/tools/{id}", "GET", "200")), qt.Equals, 2.0)
+	qt.Assert(t, testutil.ToFloat64(a.metrics.requests.WithLabelValues("/profile", "GET", "401")), qt.Equals, 1.0)
+
+	// Booking creations are counted by outcome
+	code, _ = testHTTPRequest(t, router, http.MethodPost, "/bookings", token, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", id),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	})
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	code, _ = testHTTPRequest(t, router, http.MethodPost, "/bookings", token, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", id),
+		StartDate: time.Now().Add(48 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(24 * time.Hour).Unix(),
+	})
+	qt.Assert(t, code, qt.Not(qt.Equals), http.StatusOK)
+	qt.Assert(t, testutil.ToFloat64(a.metrics.bookings.WithLabelValues("created")), qt.Equals, 1.0)
+	qt.Assert(t, testutil.CollectAndCount(a.metrics.bookings), qt.Equals, 2)
+
+	// The worker runs are counted by task
+	a.runWorker(context.Background(), time.Now())
+	qt.Assert(t, testutil.ToFloat64(a.metrics.workerRuns.WithLabelValues("expirePetitions", "ok")), qt.Equals, 1.0)
+
+	// The endpoint is public and uses the Prometheus text format
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusOK)
+	body, err := io.ReadAll(rec.Body)
+	qt.Assert(t, err, qt.IsNil)
+	for _, name := range []string{
+		"emprius_http_requests_total",
+		"emprius_http_request_duration_seconds_bucket",
+		"emprius_bookings_created_total",
+		"emprius_worker_runs_total",
+		"emprius_booking_events_delivered_total",
+	} {
+		qt.Assert(t, strings.Contains(string(body), name), qt.IsTrue, qt.Commentf("missing %s", name))
+	}
+
+	// With its own address, the API router does not serve it
+	separate := New("secret", "authtoken", a.database, &Options{MetricsAddr: "127.0.0.1:0"})
+	rec = httptest.NewRecorder()
+	separate.router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// notify creates a notification of the given type for the user, unless the user muted it.
+// Failures are logged but not returned, so they never break the operation that triggered them.
+func (a *API) notify(ctx context.Context, userID primitive.ObjectID, t db.NotificationType, bookingID primitive.ObjectID) {
+	user, err := a.database.UserService.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not get user %s to notify", userID.Hex())
+		return
+	}
+	if !user.NotificationEnabled(t) {
+		log.Debug().Msgf("notification %s muted by user %s", t, userID.Hex())
+		return
+	}
+	if _, err := a.database.NotificationService.Create(ctx, &db.Notification{
+		UserID:    userID,
+		Type:      t,
+		BookingID: bookingID,
+	}); err != nil {
+		log.Warn().Err(err).Msgf("could not create notification %s for user %s", t, userID.Hex())
+	}
+}
+
+// notificationPreferences returns the preferences of the user for every notification type.
+func notificationPreferences(user *db.User) map[db.NotificationType]bool {
+	prefs := make(map[db.NotificationType]bool, len(db.NotificationTypes))
+	for _, t := range db.NotificationTypes {
+		prefs[t] = user.NotificationEnabled(t)
+	}
+	return prefs
+}
+
+// GET /notifications returns the notifications of the user
+func (a *API) notificationsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	notifications, err := a.database.NotificationService.GetUserNotifications(r.Context.Request.Context(), user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return notifications, nil
+}
+
+// GET /profile/notifications returns the notification preferences of the user
+func (a *API) notificationPreferencesHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return notificationPreferences(user), nil
+}
+
+// POST /profile/notifications updates the notification preferences of the user.
+// Only the types present in the body are modified.
+func (a *API) notificationPreferencesUpdateHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	var updates map[db.NotificationType]bool
+	if err := json.Unmarshal(r.Data, &updates); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	for t := range updates {
+		if !db.IsValidNotificationType(t) {
+			return nil, ErrInvalidNotificationType
+		}
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	fields := bson.M{}
+	for t, enabled := range updates {
+		fields["notificationPreferences."+string(t)] = enabled
+	}
+	if len(fields) > 0 {
+		if _, err := a.database.UserService.UpdateUser(r.Context.Request.Context(), user.ID, fields); err != nil {
+			return nil, ErrInternalServerError
+		}
+	}
+	if user.NotificationPreferences == nil {
+		user.NotificationPreferences = make(map[db.NotificationType]bool, len(updates))
+	}
+	for t, enabled := range updates {
+		user.NotificationPreferences[t] = enabled
+	}
+	return notificationPreferences(user), nil
+}
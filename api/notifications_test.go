@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestNotificationPreferences(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Everything is enabled by default
+	resp, err := a.notificationPreferencesHandler(testRequest(t, "GET", "/profile/notifications",
+		testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	prefs := resp.(map[db.NotificationType]bool)
+	qt.Assert(t, prefs, qt.HasLen, len(db.NotificationTypes))
+	for _, enabled := range prefs {
+		qt.Assert(t, enabled, qt.IsTrue)
+	}
+
+	// Unknown types are rejected
+	_, err = a.notificationPreferencesUpdateHandler(testRequest(t, "POST", "/profile/notifications",
+		testUser1.Email, map[string]bool{"UNKNOWN": false}, nil))
+	qt.Assert(t, err, qt.Equals, ErrInvalidNotificationType)
+
+	// The owner mutes new booking notifications
+	resp, err = a.notificationPreferencesUpdateHandler(testRequest(t, "POST", "/profile/notifications",
+		testUser1.Email, map[db.NotificationType]bool{db.NotificationBookingCreated: false}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	prefs = resp.(map[db.NotificationType]bool)
+	qt.Assert(t, prefs[db.NotificationBookingCreated], qt.IsFalse)
+	qt.Assert(t, prefs[db.NotificationBookingCancelled], qt.IsTrue)
+
+	resp, err = a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", id),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	booking := resp.(BookingResponse)
+
+	// The muted notification is not created
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	notifications, err := a.database.NotificationService.GetUserNotifications(ctx, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, notifications, qt.HasLen, 0)
+
+	// The requester did not mute anything, so the accept notification is created
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+booking.ID+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": booking.ID}))
+	qt.Assert(t, err, qt.IsNil)
+	resp, err = a.notificationsHandler(testRequest(t, "GET", "/notifications", testUser2.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	notifications = resp.([]*db.Notification)
+	qt.Assert(t, notifications, qt.HasLen, 1)
+	qt.Assert(t, notifications[0].Type, qt.Equals, db.NotificationBookingAccepted)
+	qt.Assert(t, notifications[0].BookingID.Hex(), qt.Equals, booking.ID)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// rateLimiter limits the requests of each key, such as a user or an IP address, to a number of
+// requests per fixed window of time. A negative limit disables it.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	// now returns the current time, replaced on tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+// rateWindow is the count of requests of a key in the window started at start.
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a rate limiter allowing limit requests per window for each key.
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// allow counts a request of the key. If the key exceeded its limit, the request is not allowed
+// and the time until the window ends is returned.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.limit < 0 {
+		return true, 0
+	}
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget the keys whose window ended, so the map does not grow with every client ever seen
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// byUser limits the requests of each authenticated user, it must run after the authenticator.
+func (l *rateLimiter) byUser(next http.Handler) http.Handler {
+	return l.middleware(next, func(r *http.Request) string {
+		return "user:" + userIDFromContext(r.Context())
+	})
+}
+
+// byIP limits the requests of each client IP address, for the public routes.
+func (l *rateLimiter) byIP(next http.Handler) http.Handler {
+	return l.middleware(next, func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return "ip:" + host
+	})
+}
+
+// middleware rejects the requests over the limit of their key with ErrTooManyRequests and a
+// Retry-After header with the seconds until the client can try again.
+func (l *rateLimiter) middleware(next http.Handler, key func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := l.allow(key(r))
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg, _ := json.Marshal(&Response{
+			Header: ResponseHeader{
+				Success:   false,
+				Message:   ErrTooManyRequests.Message,
+				ErrorCode: ErrTooManyRequests.ErrorCode,
+			},
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(ErrTooManyRequests.Code)
+		if _, err := w.Write(msg); err != nil {
+			log.Error().Err(err).Msg("failed to write response")
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := newRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := l.allow("bob")
+		qt.Assert(t, allowed, qt.IsTrue)
+	}
+	allowed, retryAfter := l.allow("bob")
+	qt.Assert(t, allowed, qt.IsFalse)
+	qt.Assert(t, retryAfter, qt.Equals, time.Minute)
+
+	// Each key has its own limit
+	allowed, _ = l.allow("alice")
+	qt.Assert(t, allowed, qt.IsTrue)
+
+	now = now.Add(40 * time.Second)
+	allowed, retryAfter = l.allow("bob")
+	qt.Assert(t, allowed, qt.IsFalse)
+	qt.Assert(t, retryAfter, qt.Equals, 20*time.Second)
+
+	// A new window starts once the previous one ends, and the ended ones are forgotten
+	now = now.Add(20 * time.Second)
+	allowed, _ = l.allow("bob")
+	qt.Assert(t, allowed, qt.IsTrue)
+	qt.Assert(t, l.windows, qt.HasLen, 1)
+
+	disabled := newRateLimiter(-1, time.Minute)
+	for i := 0; i < 10; i++ {
+		allowed, _ := disabled.allow("bob")
+		qt.Assert(t, allowed, qt.IsTrue)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	l := newRateLimiter(1, time.Hour)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	do := func(handler http.Handler, remoteAddr, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req = req.WithContext(context.WithValue(req.Context(), userIDContextKey{}, userID))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Users are limited by identity, whatever their address
+	byUser := l.byUser(ok)
+	qt.Assert(t, do(byUser, "10.0.0.1:1234", "bob@emprius.cat").Code, qt.Equals, http.StatusOK)
+	rec := do(byUser, "10.0.0.2:1234", "bob@emprius.cat")
+	qt.Assert(t, rec.Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, rec.Header().Get("Retry-After"), qt.Equals, "3600")
+	var resp testHTTPResponse
+	qt.Assert(t, json.Unmarshal(rec.Body.Bytes(), &resp), qt.IsNil)
+	qt.Assert(t, resp.Header.Success, qt.IsFalse)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrTooManyRequests.ErrorCode)
+	qt.Assert(t, do(byUser, "10.0.0.1:1234", "alice@emprius.cat").Code, qt.Equals, http.StatusOK)
+
+	// Public routes are limited by address, whatever the port
+	byIP := l.byIP(ok)
+	qt.Assert(t, do(byIP, "10.0.0.1:1234", "").Code, qt.Equals, http.StatusOK)
+	qt.Assert(t, do(byIP, "10.0.0.1:5678", "").Code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, do(byIP, "10.0.0.2:1234", "").Code, qt.Equals, http.StatusOK)
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	a := testAPI(t)
+	a.opts.RateLimit = 2
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	bob, alice := testToken(t, a, testUser1.Email), testToken(t, a, testUser2.Email)
+
+	for i := 0; i < 2; i++ {
+		code, _ := testHTTPRequest(t, router, http.MethodGet, "/profile", bob, nil)
+		qt.Assert(t, code, qt.Equals, http.StatusOK)
+	}
+	code, resp := testHTTPRequest(t, router, http.MethodGet, "/profile", bob, nil)
+	qt.Assert(t, code, qt.Equals, http.StatusTooManyRequests)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrTooManyRequests.ErrorCode)
+	code, _ = testHTTPRequest(t, router, http.MethodGet, "/profile", alice, nil)
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+
+	// The public routes fall back to the address of the client
+	for i := 0; i < 2; i++ {
+		code, _ = testHTTPRequest(t, router, http.MethodGet, "/info", "", nil)
+		qt.Assert(t, code, qt.Equals, http.StatusOK)
+	}
+	code, _ = testHTTPRequest(t, router, http.MethodGet, "/info", "", nil)
+	qt.Assert(t, code, qt.Equals, http.StatusTooManyRequests)
+}
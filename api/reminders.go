@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// Reminder is a booking event its requester and owner are reminded of.
+type Reminder struct {
+	Type    db.BookingReminder
+	Booking *db.Booking
+}
+
+// Notifier delivers the booking reminders sent by the background worker.
+type Notifier interface {
+	Notify(ctx context.Context, reminder *Reminder) error
+}
+
+// NopNotifier discards the reminders.
+type NopNotifier struct{}
+
+// Notify implements Notifier.
+func (NopNotifier) Notify(context.Context, *Reminder) error {
+	return nil
+}
+
+// LogNotifier logs the reminders at info level.
+type LogNotifier struct{}
+
+// Notify implements Notifier.
+func (LogNotifier) Notify(_ context.Context, reminder *Reminder) error {
+	log.Info().
+		Str("reminder", string(reminder.Type)).
+		Str("booking", reminder.Booking.ID.Hex()).
+		Str("tool", reminder.Booking.ToolID).
+		Str("requester", reminder.Booking.FromUserID.Hex()).
+		Str("owner", reminder.Booking.ToUserID.Hex()).
+		Msg("booking reminder")
+	return nil
+}
+
+// sendReminders reminds of the accepted bookings starting within ReminderWindow from now and of the
+// ones that ended OverdueReminderDelay before now without being returned. The bookings of auto-return
+// tools are not overdue, the worker returns them. Each reminder is sent once per booking: it is marked
+// as sent before notifying, so a failed notification is logged but not retried.
+// It returns the number of reminders sent.
+func (a *API) sendReminders(ctx context.Context, now time.Time) (int, error) {
+	upcoming, err := a.database.BookingService.GetUpcoming(ctx, now, now.Add(a.opts.ReminderWindow))
+	if err != nil {
+		return 0, err
+	}
+	overdue, err := a.database.BookingService.GetOverdue(ctx, now.Add(-a.opts.OverdueReminderDelay))
+	if err != nil {
+		return 0, err
+	}
+	if len(overdue) > 0 {
+		_, toolsByID, err := a.bookingRelations(ctx, overdue)
+		if err != nil {
+			return 0, err
+		}
+		manual := make([]*db.Booking, 0, len(overdue))
+		for _, booking := range overdue {
+			if tool, ok := toolsByID[booking.ToolID]; !ok || !tool.AutoReturn {
+				manual = append(manual, booking)
+			}
+		}
+		overdue = manual
+	}
+
+	sent := 0
+	remind := func(reminderType db.BookingReminder, bookings []*db.Booking) error {
+		for _, booking := range bookings {
+			marked, err := a.database.BookingService.MarkReminded(ctx, booking.ID, reminderType)
+			if err != nil {
+				return err
+			}
+			if !marked {
+				continue
+			}
+			if err := a.opts.Notifier.Notify(ctx, &Reminder{Type: reminderType, Booking: booking}); err != nil {
+				log.Warn().Err(err).Msgf("could not send %s reminder of booking %s", reminderType, booking.ID.Hex())
+				continue
+			}
+			sent++
+		}
+		return nil
+	}
+	if err := remind(db.BookingReminderUpcoming, upcoming); err != nil {
+		return sent, err
+	}
+	return sent, remind(db.BookingReminderOverdue, overdue)
+}
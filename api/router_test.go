@@ -0,0 +1,365 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/go-chi/jwtauth/v5"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// testHTTPResponse is the envelope returned by the router, with the data left encoded.
+// It is nil for responses that are not JSON, such as the ones of the JWT middleware.
+type testHTTPResponse struct {
+	Header ResponseHeader  `json:"header"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// testToken returns a valid JWT for the user, as issued on login.
+func testToken(t *testing.T, a *API, email string) string {
+	token, err := a.makeToken(email)
+	qt.Assert(t, err, qt.IsNil)
+	return token.Token
+}
+
+// newTestHTTPRequest builds an HTTP request for the router, authenticated with the token
+// if not empty. The body is marshaled to JSON.
+func newTestHTTPRequest(t *testing.T, method, path, token string, body any) *http.Request {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// testHTTPDo sends the request to the router and returns the status code and the envelope.
+func testHTTPDo(t *testing.T, router http.Handler, req *http.Request) (int, *testHTTPResponse) {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var resp testHTTPResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		return rec.Code, nil
+	}
+	return rec.Code, &resp
+}
+
+// testHTTPRequest sends a request to the router, see newTestHTTPRequest and testHTTPDo.
+func testHTTPRequest(
+	t *testing.T,
+	router http.Handler,
+	method, path, token string,
+	body any,
+) (int, *testHTTPResponse) {
+	return testHTTPDo(t, router, newTestHTTPRequest(t, method, path, token, body))
+}
+
+// testHTTPData decodes the data of a successful response.
+func testHTTPData[T any](t *testing.T, code int, resp *testHTTPResponse) T {
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	qt.Assert(t, resp, qt.IsNotNil)
+	qt.Assert(t, resp.Header.Success, qt.IsTrue, qt.Commentf("%s", resp.Header.Message))
+	var data T
+	qt.Assert(t, json.Unmarshal(resp.Data, &data), qt.IsNil)
+	return data
+}
+
+func TestHTTPBookingFlow(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, requester := testToken(t, a, testUser1.Email), testToken(t, a, testUser2.Email)
+
+	tool := testTool1
+	tool.MayBeFree = boolPtr(false)
+	code, resp := testHTTPRequest(t, router, http.MethodPost, "/tools", owner, &tool)
+	toolID := testHTTPData[ToolID](t, code, resp).ID
+
+	// Create
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings", requester, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+		Contact:   "alice@emprius.cat",
+	})
+	booking := testHTTPData[BookingResponse](t, code, resp)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	id := booking.ID
+
+	// Accept
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings/petitions/"+id+"/accept", owner, nil)
+	booking = testHTTPData[BookingResponse](t, code, resp)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+
+	// There is no pickup step, the tool is lent once accepted
+
+	// Return
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings/"+id+"/return", owner, nil)
+	booking = testHTTPData[BookingResponse](t, code, resp)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, string(db.BookingStatusReturned))
+	qt.Assert(t, booking.Cost.Total, qt.Equals, uint64(10))
+
+	// Rate
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings/rates", requester,
+		&RateRequest{BookingID: id, Rating: 5, Comment: "thanks"})
+	rating := testHTTPData[RatingResponse](t, code, resp)
+	qt.Assert(t, rating.Rating, qt.Equals, 5)
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings/rates", requester,
+		&RateRequest{BookingID: id, Rating: 5})
+	qt.Assert(t, code, qt.Equals, http.StatusConflict)
+	qt.Assert(t, resp.Header.Success, qt.IsFalse)
+	qt.Assert(t, resp.Header.Message, qt.Equals, ErrBookingAlreadyRated.Message)
+
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/bookings/"+id+"/receipt", owner, nil)
+	receipt := testHTTPData[BookingReceiptResponse](t, code, resp)
+	qt.Assert(t, receipt.RequesterRating.Comment, qt.Equals, "thanks")
+}
+
+func TestHTTPAuthFailures(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, requester := testToken(t, a, testUser1.Email), testToken(t, a, testUser2.Email)
+	code, resp := testHTTPRequest(t, router, http.MethodPost, "/tools", owner, &testTool1)
+	toolID := testHTTPData[ToolID](t, code, resp).ID
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings", requester, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	})
+	id := testHTTPData[BookingResponse](t, code, resp).ID
+	accept := "/bookings/petitions/" + id + "/accept"
+
+	// Without token or with a token signed by another secret
+	code, _ = testHTTPRequest(t, router, http.MethodPost, accept, "", nil)
+	qt.Assert(t, code, qt.Equals, http.StatusUnauthorized)
+	_, forged, err := jwtauth.New("HS256", []byte("another secret"), nil).Encode(map[string]any{
+		"userId": testUser1.Email,
+	})
+	qt.Assert(t, err, qt.IsNil)
+	code, _ = testHTTPRequest(t, router, http.MethodPost, accept, forged, nil)
+	qt.Assert(t, code, qt.Equals, http.StatusUnauthorized)
+
+	// The requester cannot accept, not even claiming to be the owner
+	code, resp = testHTTPRequest(t, router, http.MethodPost, accept, requester, nil)
+	qt.Assert(t, code, qt.Equals, http.StatusForbidden)
+	qt.Assert(t, resp.Header.Success, qt.IsFalse)
+	qt.Assert(t, resp.Header.Message, qt.Equals, ErrOnlyOwnerCanAccept.Message)
+	req := newTestHTTPRequest(t, http.MethodPost, accept, requester, nil)
+	req.Header.Set("X-User-Id", testUser1.Email)
+	code, _ = testHTTPDo(t, router, req)
+	qt.Assert(t, code, qt.Equals, http.StatusForbidden)
+
+	// Public routes do not need a token
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/info", "", nil)
+	qt.Assert(t, testHTTPData[Info](t, code, resp).Users, qt.Equals, 2)
+}
+
+func TestHTTPForgedUserHeader(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+
+	// The user comes from the token, the header claiming to be someone else is ignored
+	req := newTestHTTPRequest(t, http.MethodGet, "/profile", testToken(t, a, testUser2.Email), nil)
+	req.Header.Set("X-User-Id", testUser1.Email)
+	code, resp := testHTTPDo(t, router, req)
+	qt.Assert(t, testHTTPData[UserResponse](t, code, resp).Email, qt.Equals, testUser2.Email)
+
+	// Without token the header does not authenticate anyone
+	req = newTestHTTPRequest(t, http.MethodGet, "/profile", "", nil)
+	req.Header.Set("X-User-Id", testUser1.Email)
+	code, _ = testHTTPDo(t, router, req)
+	qt.Assert(t, code, qt.Equals, http.StatusUnauthorized)
+}
+
+func TestJWTExpiration(t *testing.T) {
+	// The default lifetime is an hour
+	lr, err := New("secret", "", nil, nil).makeToken(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, time.Until(lr.Expirity) <= time.Hour, qt.IsTrue)
+	qt.Assert(t, time.Until(lr.Expirity) > 59*time.Minute, qt.IsTrue)
+
+	a := New("secret", "", nil, &Options{JWTExpiration: 10 * time.Minute})
+	lr, err = a.makeToken(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, time.Until(lr.Expirity) <= 10*time.Minute, qt.IsTrue)
+	qt.Assert(t, time.Until(lr.Expirity) > 9*time.Minute, qt.IsTrue)
+
+	// The token expires when the response says
+	token, err := a.auth.Decode(lr.Token)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, token.Expiration().Unix(), qt.Equals, lr.Expirity.Unix())
+}
+
+func TestRouterHandlerErrorCode(t *testing.T) {
+	a := &API{}
+	do := func(err error) (int, *testHTTPResponse) {
+		handler := a.routerHandler(func(*Request) (interface{}, error) { return nil, err })
+		return testHTTPDo(t, http.HandlerFunc(handler), httptest.NewRequest("GET", "/bookings/x", nil))
+	}
+
+	code, resp := do(ErrBookingNotFound)
+	qt.Assert(t, code, qt.Equals, http.StatusNotFound)
+	qt.Assert(t, resp.Header.Success, qt.IsFalse)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrBookingNotFound.ErrorCode)
+	qt.Assert(t, resp.Header.Message, qt.Equals, "booking not found")
+
+	code, resp = do(ErrInvalidRating)
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrInvalidRating.ErrorCode)
+
+	// Errors without a code keep the generic status and omit the error code
+	code, resp = do(fmt.Errorf("something failed"))
+	qt.Assert(t, code, qt.Equals, http.StatusBadRequest)
+	qt.Assert(t, resp.Header.ErrorCode, qt.Equals, 0)
+	qt.Assert(t, resp.Header.Message, qt.Equals, "something failed")
+}
+
+func TestRouterHandlerBodyLimit(t *testing.T) {
+	a := New("secret", "", nil, &Options{MaxBodyBytes: 100, MaxImageBytes: 300})
+	received := 0
+	handle := func(r *Request) (interface{}, error) {
+		received = len(r.Data)
+		return nil, nil
+	}
+	post := func(handler http.HandlerFunc, body any) int {
+		received = 0
+		code, resp := testHTTPDo(t, handler, newTestHTTPRequest(t, http.MethodPost, "/", "", body))
+		if code == http.StatusRequestEntityTooLarge {
+			qt.Assert(t, resp.Header.ErrorCode, qt.Equals, ErrRequestTooLarge.ErrorCode)
+			qt.Assert(t, received, qt.Equals, 0)
+		}
+		return code
+	}
+	// The image is base64 encoded in the JSON body
+	image := func(size int) *db.Image {
+		return &db.Image{Content: bytes.Repeat([]byte{1}, size)}
+	}
+
+	// JSON routes only accept small bodies
+	jsonHandler := a.routerHandler(handle)
+	qt.Assert(t, post(jsonHandler, &Login{Email: "bob@emprius.cat"}), qt.Equals, http.StatusOK)
+	qt.Assert(t, received > 0, qt.IsTrue)
+	qt.Assert(t, post(jsonHandler, &Login{Email: strings.Repeat("a", 100)}), qt.Equals,
+		http.StatusRequestEntityTooLarge)
+	qt.Assert(t, post(jsonHandler, image(300)), qt.Equals, http.StatusRequestEntityTooLarge)
+
+	// The upload routes accept the images on top, up to their number
+	uploadHandler := a.uploadHandler(handle, 1)
+	qt.Assert(t, post(uploadHandler, image(300)), qt.Equals, http.StatusOK)
+	qt.Assert(t, post(uploadHandler, image(400)), qt.Equals, http.StatusRequestEntityTooLarge)
+	qt.Assert(t, post(uploadHandler, []*db.Image{image(300), image(300)}), qt.Equals,
+		http.StatusRequestEntityTooLarge)
+	qt.Assert(t, post(a.uploadHandler(handle, 2), []*db.Image{image(300), image(300)}), qt.Equals,
+		http.StatusOK)
+}
+
+func TestHTTPBookingEvents(t *testing.T) {
+	a := testAPI(t)
+	server := httptest.NewServer(a.router())
+	defer server.Close()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, requester := testToken(t, a, testUser1.Email), testToken(t, a, testUser2.Email)
+
+	code, resp := testHTTPRequest(t, a.router(), http.MethodPost, "/tools", owner, &testTool1)
+	toolID := testHTTPData[ToolID](t, code, resp).ID
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/bookings/events", nil)
+	qt.Assert(t, err, qt.IsNil)
+	req.Header.Set("Authorization", "Bearer "+owner)
+	stream, err := http.DefaultClient.Do(req)
+	qt.Assert(t, err, qt.IsNil)
+	defer stream.Body.Close()
+	qt.Assert(t, stream.StatusCode, qt.Equals, http.StatusOK)
+	qt.Assert(t, stream.Header.Get("Content-Type"), qt.Equals, "text/event-stream")
+	lines := bufio.NewScanner(stream.Body)
+	nextEvent := func() BookingEventResponse {
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				var event BookingEventResponse
+				qt.Assert(t, json.Unmarshal([]byte(data), &event), qt.IsNil)
+				return event
+			}
+		}
+		t.Fatalf("event stream closed: %v", lines.Err())
+		return BookingEventResponse{}
+	}
+
+	code, resp = testHTTPRequest(t, a.router(), http.MethodPost, "/bookings", requester, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	})
+	booking := testHTTPData[BookingResponse](t, code, resp)
+	event := nextEvent()
+	qt.Assert(t, event.Type, qt.Equals, string(db.BookingEventCreated))
+	qt.Assert(t, event.Booking.ID, qt.Equals, booking.ID)
+
+	code, resp = testHTTPRequest(t, a.router(), http.MethodPost, "/bookings/petitions/"+booking.ID+"/accept", owner, nil)
+	testHTTPData[BookingResponse](t, code, resp)
+	event = nextEvent()
+	qt.Assert(t, event.Type, qt.Equals, string(db.BookingEventStatusChanged))
+	qt.Assert(t, event.Booking.BookingStatus, qt.Equals, string(db.BookingStatusAccepted))
+
+	// The stream requires authentication
+	code, _ = testHTTPRequest(t, a.router(), http.MethodGet, "/bookings/events", "", nil)
+	qt.Assert(t, code, qt.Equals, http.StatusUnauthorized)
+}
+
+func TestCORSOrigins(t *testing.T) {
+	// No database is needed to answer /ping
+	request := func(a *API, method, origin string) http.Header {
+		req := httptest.NewRequest(method, "/ping", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		a.router().ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	// Without configured origins any origin is allowed, without credentials
+	a := New("secret", "", nil, nil)
+	header := request(a, http.MethodGet, "https://anywhere.example")
+	qt.Assert(t, header.Get("Access-Control-Allow-Origin"), qt.Equals, "*")
+	qt.Assert(t, header.Get("Access-Control-Allow-Credentials"), qt.Equals, "")
+
+	// The configured origins get their own origin back, for simple and preflight requests
+	a = New("secret", "", nil, &Options{AllowedOrigins: []string{"https://app.emprius.cat"}})
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		header = request(a, method, "https://app.emprius.cat")
+		qt.Assert(t, header.Get("Access-Control-Allow-Origin"), qt.Equals, "https://app.emprius.cat",
+			qt.Commentf(method))
+		qt.Assert(t, header.Get("Access-Control-Allow-Credentials"), qt.Equals, "true", qt.Commentf(method))
+
+		// Other origins get no CORS headers at all
+		header = request(a, method, "https://evil.example")
+		for name := range header {
+			qt.Assert(t, strings.HasPrefix(name, "Access-Control-"), qt.IsFalse, qt.Commentf("%s %s", method, name))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// Unified search result types
+const (
+	SearchResultTool = "tool"
+	SearchResultUser = "user"
+)
+
+// GET /search?term= searches tools and users at once. Tools are matched as in the tool search,
+// within the default distance of the user community, and users by name. Each type is capped
+// to maxSearchResults and users only expose their public summary. The community parameter
+// restricts the tools as in the tool search.
+func (a *API) searchHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	term := strings.TrimSpace(db.SanitizeString(strings.TrimSpace(r.Context.QueryParam("term"))))
+	if term == "" {
+		return &SearchResponse{Results: []SearchResult{}}, nil
+	}
+	if len([]rune(term)) < minSearchTermLength {
+		return nil, ErrSearchTermTooShort
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	community, err := communityFilter(r, user)
+	if err != nil {
+		return nil, err
+	}
+
+	tools, err := a.toolSearch(&ToolSearch{
+		Term:      term,
+		Distance:  a.searchDistance(user.Community),
+		Community: community,
+	}, &user.Location)
+	if err != nil {
+		return nil, err
+	}
+	users, err := a.database.UserService.SearchUsersByName(r.Context.Request.Context(), term, maxSearchResults)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	results := []SearchResult{}
+	for i := range tools[:min(len(tools), maxSearchResults)] {
+		results = append(results, SearchResult{
+			Type: SearchResultTool,
+			Tool: convertToolToSummary(&tools[i]),
+		})
+	}
+	for _, u := range users {
+		results = append(results, SearchResult{
+			Type: SearchResultUser,
+			User: convertUserToSummary(u),
+		})
+	}
+	return &SearchResponse{Results: results}, nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestUnifiedSearch(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	tool := testTool1
+	tool.Title = "Bobcat loader"
+	tool.Location = testLatitudeA200km
+	id, err := a.addTool(&tool, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	search := func(term string) (*SearchResponse, error) {
+		resp, err := a.searchHandler(testRequest(t, "GET", "/search?term="+term, testUser2.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*SearchResponse), nil
+	}
+
+	// The term matches the tool title and the user name
+	resp, err := search("BOB")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Results, qt.HasLen, 2)
+	qt.Assert(t, resp.Results[0].Type, qt.Equals, SearchResultTool)
+	qt.Assert(t, resp.Results[0].Tool.ID, qt.Equals, id)
+	qt.Assert(t, resp.Results[0].User, qt.IsNil)
+	qt.Assert(t, resp.Results[1].Type, qt.Equals, SearchResultUser)
+	qt.Assert(t, resp.Results[1].User.Name, qt.Equals, testUser1.Name)
+	qt.Assert(t, resp.Results[1].Tool, qt.IsNil)
+
+	// Only matching items are returned
+	resp, err = search("alice")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Results, qt.HasLen, 1)
+	qt.Assert(t, resp.Results[0].User.Name, qt.Equals, testUser2.Name)
+
+	_, err = search("b")
+	qt.Assert(t, err, qt.Equals, ErrSearchTermTooShort)
+	resp, err = search("")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Results, qt.HasLen, 0)
+}
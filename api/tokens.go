@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+// transferTokens moves the amount of tokens from one user to another and records the movement
+// in the ledger of both, see db.UserService.TransferTokens. If the ledger entries cannot be
+// recorded the tokens are moved back.
+func (a *API) transferTokens(
+	ctx context.Context,
+	fromUserID, toUserID primitive.ObjectID,
+	amount uint64,
+	txType db.TokenTransactionType,
+	bookingID primitive.ObjectID,
+) error {
+	if err := a.database.UserService.TransferTokens(ctx, fromUserID, toUserID, amount); err != nil {
+		return err
+	}
+	if err := a.recordTransfer(ctx, fromUserID, toUserID, amount, txType, bookingID); err != nil {
+		if rerr := a.database.UserService.TransferTokens(ctx, toUserID, fromUserID, amount); rerr != nil {
+			log.Error().Err(rerr).Msgf("could not move back %d tokens from %s to %s",
+				amount, toUserID.Hex(), fromUserID.Hex())
+		}
+		return err
+	}
+	return nil
+}
+
+// recordTransfer records the movement of the amount of tokens in the ledger of both users.
+func (a *API) recordTransfer(
+	ctx context.Context,
+	fromUserID, toUserID primitive.ObjectID,
+	amount uint64,
+	txType db.TokenTransactionType,
+	bookingID primitive.ObjectID,
+) error {
+	if err := a.database.TokenService.Create(ctx,
+		&db.TokenTransaction{
+			UserID:         fromUserID,
+			CounterpartyID: toUserID,
+			Type:           txType,
+			Amount:         -int64(amount),
+			BookingID:      bookingID,
+		},
+		&db.TokenTransaction{
+			UserID:         toUserID,
+			CounterpartyID: fromUserID,
+			Type:           txType,
+			Amount:         int64(amount),
+			BookingID:      bookingID,
+		},
+	); err != nil {
+		return fmt.Errorf("could not record transfer of %d tokens from %s to %s: %w",
+			amount, fromUserID.Hex(), toUserID.Hex(), err)
+	}
+	return nil
+}
+
+// tokenTransferHandler handles POST /tokens/transfer
+// It sends tokens of the user to another user, up to the daily transfer cap, see
+// db.UserService.TransferTokensCapped.
+func (a *API) tokenTransferHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	var req TokenTransferRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if req.Amount == 0 || req.Amount > math.MaxInt64 {
+		return nil, ErrInvalidTokenAmount
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByEmail(ctx, r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	toUserID, err := primitive.ObjectIDFromHex(req.ToUserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if toUserID == user.ID {
+		return nil, ErrSelfTransfer
+	}
+	if _, err := a.database.UserService.GetUserByID(ctx, toUserID); err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	now := time.Now()
+	if err := a.database.UserService.TransferTokensCapped(ctx, user.ID, toUserID, req.Amount,
+		a.opts.DailyTransferCap, now); err != nil {
+		switch {
+		case errors.Is(err, db.ErrInsufficientTokens):
+			return nil, ErrInsufficientTokens
+		case errors.Is(err, db.ErrInvalidTokenAmount):
+			return nil, ErrInvalidTokenAmount
+		case errors.Is(err, db.ErrTransferCapExceeded):
+			return nil, ErrTransferCapExceeded
+		case errors.Is(err, db.ErrUserNotFound):
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInternalServerError
+	}
+	if err := a.recordTransfer(ctx, user.ID, toUserID, req.Amount,
+		db.TokenTransactionTransfer, primitive.NilObjectID); err != nil {
+		log.Error().Err(err).Msg("could not record token transfer")
+		if rerr := a.database.UserService.UndoTransferCapped(ctx, user.ID, toUserID, req.Amount, now); rerr != nil {
+			log.Error().Err(rerr).Msgf("could not move back %d tokens from %s to %s",
+				req.Amount, toUserID.Hex(), user.ID.Hex())
+		}
+		return nil, ErrInternalServerError
+	}
+
+	user, err = a.database.UserService.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return &TokenBalanceResponse{Tokens: user.Tokens}, nil
+}
+
+// bookingGroup returns the booking along with the other bookings of its kit, if any.
+func (a *API) bookingGroup(ctx context.Context, booking *db.Booking) ([]*db.Booking, error) {
+	if booking.GroupID.IsZero() {
+		return []*db.Booking{booking}, nil
+	}
+	return a.database.BookingService.GetGroup(ctx, booking.GroupID)
+}
+
+// holdDeposits takes the deposit of the tools of the bookings from the requester into escrow and
+// returns the holds. If any deposit cannot be held, the ones already held are given back.
+func (a *API) holdDeposits(ctx context.Context, bookings []*db.Booking) ([]*db.TokenTransaction, error) {
+	_, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return nil, err
+	}
+	held := []*db.TokenTransaction{}
+	for _, booking := range bookings {
+		tool, ok := toolsByID[booking.ToolID]
+		if !ok || tool.DepositTokens == 0 {
+			continue
+		}
+		hold, err := a.holdDeposit(ctx, booking, tool.DepositTokens)
+		if err != nil {
+			a.giveBackDeposits(ctx, held)
+			return nil, err
+		}
+		held = append(held, hold)
+	}
+	return held, nil
+}
+
+// giveBackDeposits settles the holds in full to the requester, when the bookings they were held
+// for could not be accepted. Failures are logged.
+func (a *API) giveBackDeposits(ctx context.Context, held []*db.TokenTransaction) {
+	for _, hold := range held {
+		if err := a.settleDeposit(ctx, hold, 0); err != nil {
+			log.Error().Err(err).Msgf("could not give back deposit of booking %s", hold.BookingID.Hex())
+		}
+	}
+}
+
+// holdDeposit takes the amount from the requester of the booking into escrow.
+func (a *API) holdDeposit(ctx context.Context, booking *db.Booking, amount uint64) (*db.TokenTransaction, error) {
+	if amount > math.MaxInt64 {
+		return nil, db.ErrInvalidTokenAmount
+	}
+	if err := a.database.UserService.AdjustTokens(ctx, booking.FromUserID, -int64(amount)); err != nil {
+		return nil, err
+	}
+	hold, err := a.database.TokenService.Hold(ctx, booking.FromUserID, booking.ToUserID, booking.ID, amount)
+	if err != nil {
+		if rerr := a.database.UserService.AdjustTokens(ctx, booking.FromUserID, int64(amount)); rerr != nil {
+			log.Error().Err(rerr).Msgf("could not refund deposit of booking %s", booking.ID.Hex())
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+// settleDeposit takes the deposit hold out of escrow, paying the claimed tokens to the tool owner
+// and giving the rest back to the requester.
+func (a *API) settleDeposit(ctx context.Context, hold *db.TokenTransaction, claimed uint64) error {
+	if err := a.database.TokenService.Settle(ctx, hold, claimed); err != nil {
+		return err
+	}
+	if released := uint64(-hold.Amount) - claimed; released > 0 {
+		if err := a.database.UserService.AdjustTokens(ctx, hold.UserID, int64(released)); err != nil {
+			return err
+		}
+	}
+	if claimed > 0 {
+		if err := a.database.UserService.AdjustTokens(ctx, hold.CounterpartyID, int64(claimed)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseDeposits settles the deposits held for the returned bookings. The claim is taken from
+// the deposit of the booking with claimBookingID, the other deposits are given back in full.
+func (a *API) releaseDeposits(
+	ctx context.Context,
+	bookings []*db.Booking,
+	claimBookingID primitive.ObjectID,
+	claim uint64,
+) error {
+	for _, booking := range bookings {
+		hold, err := a.database.TokenService.GetHold(ctx, booking.ID)
+		if errors.Is(err, db.ErrDepositNotHeld) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		claimed := uint64(0)
+		if booking.ID == claimBookingID {
+			claimed = claim
+		}
+		if err := a.settleDeposit(ctx, hold, claimed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
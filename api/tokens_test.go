@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestTokenTransfer(t *testing.T) {
+	a := testAPI(t)
+	a.opts.DailyTransferCap = 150
+	ctx := context.Background()
+	sender, receiver := testUser1, testUser2
+	sender.Tokens, receiver.Tokens = 100, 100
+	qt.Assert(t, a.addUser(&sender), qt.IsNil)
+	qt.Assert(t, a.addUser(&receiver), qt.IsNil)
+	from, err := a.database.UserService.GetUserByEmail(ctx, sender.Email)
+	qt.Assert(t, err, qt.IsNil)
+	to, err := a.database.UserService.GetUserByEmail(ctx, receiver.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	transfer := func(amount uint64, toUserID string) (interface{}, error) {
+		return a.tokenTransferHandler(testRequest(t, "POST", "/tokens/transfer", sender.Email,
+			&TokenTransferRequest{ToUserID: toUserID, Amount: amount}, nil))
+	}
+
+	resp, err := transfer(30, to.ID.Hex())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*TokenBalanceResponse).Tokens, qt.Equals, uint64(70))
+	to, err = a.database.UserService.GetUserByID(ctx, to.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, to.Tokens, qt.Equals, uint64(130))
+
+	// Both sides have a ledger entry
+	sent, err := a.database.TokenService.GetUserTransactions(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, sent, qt.HasLen, 1)
+	qt.Assert(t, sent[0].Amount, qt.Equals, int64(-30))
+	qt.Assert(t, sent[0].Type, qt.Equals, db.TokenTransactionTransfer)
+	received, err := a.database.TokenService.GetUserTransactions(ctx, to.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, received, qt.HasLen, 1)
+	qt.Assert(t, received[0].Amount, qt.Equals, int64(30))
+	qt.Assert(t, received[0].CounterpartyID, qt.Equals, from.ID)
+
+	// More than the balance is rejected and nothing moves
+	_, err = transfer(71, to.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrInsufficientTokens)
+	from, err = a.database.UserService.GetUserByID(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, from.Tokens, qt.Equals, uint64(70))
+
+	// Invalid transfers
+	_, err = transfer(10, from.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrSelfTransfer)
+	_, err = transfer(0, to.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrInvalidTokenAmount)
+
+	// The daily cap counts the previous transfers
+	_, err = transfer(60, to.ID.Hex())
+	qt.Assert(t, err, qt.IsNil)
+	from, err = a.database.UserService.GetUserByID(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, from.Tokens, qt.Equals, uint64(10))
+	_, err = a.database.UserService.UpdateUser(ctx, from.ID, bson.M{"tokens": 1000})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = transfer(61, to.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrTransferCapExceeded)
+
+	// Amounts wrapping around the cap, or negative once stored in the ledger, are rejected and
+	// nothing moves
+	_, err = transfer(math.MaxUint64-89, to.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrInvalidTokenAmount)
+	_, err = transfer(math.MaxInt64, to.ID.Hex())
+	qt.Assert(t, err, qt.Equals, ErrTransferCapExceeded)
+	qt.Assert(t, a.database.UserService.TransferTokens(ctx, from.ID, to.ID, math.MaxInt64+1),
+		qt.Equals, db.ErrInvalidTokenAmount)
+	from, err = a.database.UserService.GetUserByID(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, from.Tokens, qt.Equals, uint64(1000))
+	to, err = a.database.UserService.GetUserByID(ctx, to.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, to.Tokens, qt.Equals, uint64(190))
+}
+
+func TestConcurrentTokenTransfer(t *testing.T) {
+	a := testAPI(t)
+	a.opts.DailyTransferCap = 150
+	ctx := context.Background()
+	sender := testUser1
+	sender.Tokens = 1000
+	qt.Assert(t, a.addUser(&sender), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	from, err := a.database.UserService.GetUserByEmail(ctx, sender.Email)
+	qt.Assert(t, err, qt.IsNil)
+	to, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Transfers sent at once do not exceed the cap together
+	errs := make(chan error, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		req := testRequest(t, "POST", "/tokens/transfer", sender.Email,
+			&TokenTransferRequest{ToUserID: to.ID.Hex(), Amount: 50}, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.tokenTransferHandler(req)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		qt.Assert(t, err, qt.Equals, ErrTransferCapExceeded)
+	}
+	qt.Assert(t, succeeded, qt.Equals, 3)
+	from, err = a.database.UserService.GetUserByID(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, from.Tokens, qt.Equals, uint64(850))
+	sent, err := a.database.TokenService.GetUserTransactions(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, sent, qt.HasLen, 3)
+
+	// The cap starts again the next day, and undone transfers do not count
+	tomorrow := time.Now().Add(24 * time.Hour)
+	qt.Assert(t, a.database.UserService.TransferTokensCapped(ctx, from.ID, to.ID, 150, 150, tomorrow), qt.IsNil)
+	qt.Assert(t, a.database.UserService.UndoTransferCapped(ctx, from.ID, to.ID, 150, tomorrow), qt.IsNil)
+	qt.Assert(t, a.database.UserService.TransferTokensCapped(ctx, from.ID, to.ID, 150, 150, tomorrow), qt.IsNil)
+	qt.Assert(t, a.database.UserService.TransferTokensCapped(ctx, from.ID, to.ID, 1, 150, tomorrow),
+		qt.Equals, db.ErrTransferCapExceeded)
+	from, err = a.database.UserService.GetUserByID(ctx, from.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, from.Tokens, qt.Equals, uint64(700))
+}
+
+func TestBookingDeposit(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	owner, requester := testUser1, testUser2
+	owner.Tokens, requester.Tokens = 0, 100
+	qt.Assert(t, a.addUser(&owner), qt.IsNil)
+	qt.Assert(t, a.addUser(&requester), qt.IsNil)
+	tool := testTool1
+	tool.DepositTokens = uint64Ptr(40)
+	toolID, err := a.addTool(&tool, owner.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	tokens := func(email string) uint64 {
+		user, err := a.database.UserService.GetUserByEmail(ctx, email)
+		qt.Assert(t, err, qt.IsNil)
+		return user.Tokens
+	}
+	book := func(startOffset time.Duration) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", requester.Email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", toolID),
+				StartDate: time.Now().Add(startOffset).Unix(),
+				EndDate:   time.Now().Add(startOffset + 24*time.Hour).Unix(),
+				Contact:   "test@test.com",
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	accept := func(id string) error {
+		_, err := a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			owner.Email, nil, map[string]string{"petitionId": id}))
+		return err
+	}
+	giveBack := func(id string, body *ReturnBookingRequest) error {
+		_, err := a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+id+"/return",
+			owner.Email, body, map[string]string{"bookingId": id}))
+		return err
+	}
+
+	// Hold on acceptance
+	first := book(24 * time.Hour)
+	qt.Assert(t, accept(first), qt.IsNil)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(60))
+	firstID, err := primitive.ObjectIDFromHex(first)
+	qt.Assert(t, err, qt.IsNil)
+	hold, err := a.database.TokenService.GetHold(ctx, firstID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, hold.Amount, qt.Equals, int64(-40))
+
+	// Release in full on a regular return
+	qt.Assert(t, giveBack(first, nil), qt.IsNil)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(100))
+	qt.Assert(t, tokens(owner.Email), qt.Equals, uint64(0))
+	_, err = a.database.TokenService.GetHold(ctx, firstID)
+	qt.Assert(t, err, qt.Equals, db.ErrDepositNotHeld)
+
+	// Partial claim on a damaged return, never more than the deposit
+	second := book(72 * time.Hour)
+	qt.Assert(t, accept(second), qt.IsNil)
+	qt.Assert(t, giveBack(second, &ReturnBookingRequest{DepositClaim: 41}), qt.Equals, ErrInvalidDepositClaim)
+	qt.Assert(t, giveBack(second, &ReturnBookingRequest{DepositClaim: 15}), qt.IsNil)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(85))
+	qt.Assert(t, tokens(owner.Email), qt.Equals, uint64(15))
+
+	// Requesters who cannot cover the deposit cannot be accepted
+	_, err = a.database.UserService.UpdateUser(ctx, hold.UserID, bson.M{"tokens": 10})
+	qt.Assert(t, err, qt.IsNil)
+	third := book(120 * time.Hour)
+	qt.Assert(t, accept(third), qt.Equals, ErrInsufficientTokens)
+	qt.Assert(t, tokens(requester.Email), qt.Equals, uint64(10))
+	booking, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+third, requester.Email, nil,
+		map[string]string{"bookingId": third}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.(BookingResponse).BookingStatus, qt.Equals, string(db.BookingStatusPending))
+}
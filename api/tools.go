@@ -1,23 +1,36 @@
 package api
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/emprius/emprius-app-backend/db"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
 	maxAllowedToolDistance = 200000 // m
+	// maxToolCost, maxToolEstimatedValue and maxToolDeposit bound the tokens of a tool, so no sum overflows
+	maxToolCost           = 1000000
+	maxToolEstimatedValue = 100000000
+	maxToolDeposit        = 100000000
+	// toolStatsWindow is the period over which the utilization of a tool is computed
+	toolStatsWindow = 90 * 24 * time.Hour
+	// toolStatsRatings is the number of latest ratings shown in the tool statistics
+	toolStatsRatings = 5
 )
 
 func (a *API) toolCategories() []db.ToolCategory {
@@ -32,6 +45,45 @@ func (a *API) toolCategories() []db.ToolCategory {
 	return result
 }
 
+// validateTool checks the fields of a tool sent to be created or edited, before anything is stored.
+// Fields left empty are not checked, since an edit only changes the fields it sets: addTool checks
+// the required ones itself.
+func (a *API) validateTool(t *Tool) error {
+	if (t.Title != "" && strings.TrimSpace(t.Title) == "") ||
+		(t.Description != "" && strings.TrimSpace(t.Description) == "") {
+		return ErrEmptyTitleOrDescription
+	}
+	if t.Category != 0 && !slices.ContainsFunc(a.toolCategories(), func(c db.ToolCategory) bool {
+		return c.ID == t.Category
+	}) {
+		return ErrInvalidToolCategory
+	}
+	if t.Condition != "" && !db.ToolCondition(t.Condition).Valid() {
+		return ErrInvalidToolCondition
+	}
+	if t.Cost != nil && *t.Cost > maxToolCost {
+		return ErrToolCostTooHigh
+	}
+	if t.EstimatedValue > maxToolEstimatedValue {
+		return ErrEstimatedValueTooHigh
+	}
+	if t.DepositTokens != nil && *t.DepositTokens > maxToolDeposit {
+		return ErrToolDepositTooHigh
+	}
+	if len(t.TransportOptions) > 0 {
+		transports, err := a.database.TransportService.GetAllTransports(context.Background())
+		if err != nil {
+			return ErrInternalServerError
+		}
+		for _, id := range t.TransportOptions {
+			if !slices.ContainsFunc(transports, func(tr *db.Transport) bool { return tr.ID == int64(id) }) {
+				return ErrInvalidTransportOption
+			}
+		}
+	}
+	return nil
+}
+
 func (a *API) addTool(t *Tool, userEmail string) (int64, error) {
 	// check if images are in database
 	images, err := a.imageListFromSlice(t.Images)
@@ -61,6 +113,12 @@ func (a *API) addTool(t *Tool, userEmail string) (int64, error) {
 	if t.Cost == nil {
 		return 0, ErrCostRequired
 	}
+	if t.Category == 0 {
+		return 0, ErrInvalidToolCategory
+	}
+	if err := a.validateTool(t); err != nil {
+		return 0, err
+	}
 	user, err := a.userByEmail(userEmail)
 	if err != nil {
 		return 0, ErrUserNotFound
@@ -69,25 +127,19 @@ func (a *API) addTool(t *Tool, userEmail string) (int64, error) {
 		return 0, ErrToolLocationTooFar
 	}
 
-	if t.Category < 0 || t.Category >= len(a.toolCategories()) {
-		return 0, ErrInvalidToolCategory
-	}
-
-	// Validate and convert transport options
-	transports, err := a.database.TransportService.GetAllTransports(context.Background())
+	duplicate, err := a.hasToolTitled(user.ID, t.Title)
 	if err != nil {
-		return 0, ErrInternalServerError
+		return 0, err
 	}
-	validTransportIDs := make(map[int64]bool)
-	for _, t := range transports {
-		validTransportIDs[t.ID] = true
+	if duplicate {
+		if a.opts.UniqueToolTitles {
+			return 0, ErrDuplicateToolTitle
+		}
+		log.Warn().Msgf("user %s is adding a tool with a duplicate title: %s", userEmail, t.Title)
 	}
 
 	transportOptions := make([]db.Transport, len(t.TransportOptions))
 	for i, id := range t.TransportOptions {
-		if !validTransportIDs[int64(id)] {
-			return 0, ErrInvalidTransportOption
-		}
 		transportOptions[i] = db.Transport{ID: int64(id)}
 	}
 
@@ -108,10 +160,34 @@ func (a *API) addTool(t *Tool, userEmail string) (int64, error) {
 		Images:           dbImages,
 		Location:         t.Location,
 		TransportOptions: transportOptions,
+		CreatedAt:        time.Now(),
+		Condition:        db.ToolCondition(t.Condition),
+	}
+	if t.MinLeadHours != nil {
+		dbTool.MinLeadHours = *t.MinLeadHours
+	}
+	if t.DepositTokens != nil {
+		dbTool.DepositTokens = *t.DepositTokens
+	}
+	if t.AutoReturn != nil {
+		dbTool.AutoReturn = *t.AutoReturn
+	}
+	if t.IsNomadic != nil {
+		dbTool.IsNomadic = *t.IsNomadic
+	}
+	if t.Terms != nil {
+		dbTool.Terms = *t.Terms
+	}
+	if t.ResponseDeadlineHours != nil {
+		dbTool.ResponseDeadlineHours = *t.ResponseDeadlineHours
 	}
 	log.Info().Msgf("adding tool to database, title: %s, user: %s, id: %d", t.Title, userEmail, dbTool.ID)
 
 	_, err = a.database.ToolService.InsertTool(context.Background(), &dbTool)
+	if mongo.IsDuplicateKeyError(err) {
+		// the id is derived from the owner and the title, so the very same title can never be stored twice
+		return 0, ErrDuplicateToolTitle
+	}
 	if err != nil {
 		return 0, ErrCouldNotInsertToDatabase
 	}
@@ -119,6 +195,22 @@ func (a *API) addTool(t *Tool, userEmail string) (int64, error) {
 	return dbTool.ID, nil
 }
 
+// hasToolTitled returns true if the user already owns a not deleted tool with the same title,
+// ignoring case and surrounding spaces.
+func (a *API) hasToolTitled(userID primitive.ObjectID, title string) (bool, error) {
+	tools, err := a.database.ToolService.GetToolsByUserID(context.Background(), userID)
+	if err != nil {
+		return false, ErrInternalServerError
+	}
+	title = strings.TrimSpace(db.SanitizeString(title))
+	for _, tool := range tools {
+		if strings.EqualFold(strings.TrimSpace(tool.Title), title) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func toolID(ownerID string, title string) int64 {
 	hasher := sha256.New()
 	hasher.Write([]byte(fmt.Sprintf("%s-%s", ownerID, title)))
@@ -128,6 +220,12 @@ func toolID(ownerID string, title string) int64 {
 }
 
 func (a *API) tool(id int64) (*db.Tool, error) {
+	return a.findTool(id, false)
+}
+
+// findTool returns the tool with the given id. Deleted tools are reported as not found
+// unless includeDeleted is set.
+func (a *API) findTool(id int64, includeDeleted bool) (*db.Tool, error) {
 	tool, err := a.database.ToolService.GetToolByID(context.Background(), id)
 	if err == mongo.ErrNoDocuments {
 		return nil, ErrToolNotFound
@@ -135,6 +233,9 @@ func (a *API) tool(id int64) (*db.Tool, error) {
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
+	if tool.Deleted && !includeDeleted {
+		return nil, ErrToolNotFound
+	}
 	return tool, nil
 }
 
@@ -154,7 +255,93 @@ func (a *API) toolsByUerID(userEmail string) ([]db.Tool, error) {
 	return result, nil
 }
 
-func (a *API) editTool(id int64, newTool *Tool) error {
+// toolsPageByUserID returns a page of the tools of the user with the given email.
+func (a *API) toolsPageByUserID(userEmail string, page, pageSize int) (*ToolsWrapper, error) {
+	user, err := a.userByEmail(userEmail)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	tools, total, err := a.database.ToolService.GetToolsByUserIDPage(context.Background(), user.ID, page, pageSize)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	result := make([]db.Tool, len(tools))
+	for i, t := range tools {
+		result[i] = *t
+	}
+	return &ToolsWrapper{
+		Tools: result,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// toolEditableFields returns the database fields of the tool that can be modified on edit.
+func toolEditableFields(tool *db.Tool) map[string]interface{} {
+	return map[string]interface{}{
+		"title":                 tool.Title,
+		"description":           tool.Description,
+		"isAvailable":           tool.IsAvailable,
+		"mayBeFree":             tool.MayBeFree,
+		"askWithFee":            tool.AskWithFee,
+		"cost":                  tool.Cost,
+		"toolCategory":          tool.ToolCategory,
+		"estimatedValue":        tool.EstimatedValue,
+		"height":                tool.Height,
+		"weight":                tool.Weight,
+		"images":                tool.Images,
+		"location":              tool.Location,
+		"transportOptions":      tool.TransportOptions,
+		"minLeadHours":          tool.MinLeadHours,
+		"depositTokens":         tool.DepositTokens,
+		"autoReturn":            tool.AutoReturn,
+		"isNomadic":             tool.IsNomadic,
+		"condition":             tool.Condition,
+		"terms":                 tool.Terms,
+		"responseDeadlineHours": tool.ResponseDeadlineHours,
+	}
+}
+
+// toolEdits returns the history entries for the fields whose value differs between
+// the previous and the updated field maps, sorted by field name.
+func toolEdits(previous, updated map[string]interface{}, editorID primitive.ObjectID) []db.ToolEdit {
+	fields := make([]string, 0, len(updated))
+	for field := range updated {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	edits := []db.ToolEdit{}
+	now := time.Now()
+	for _, field := range fields {
+		oldValue, err := json.Marshal(previous[field])
+		if err != nil {
+			log.Warn().Err(err).Msgf("could not encode tool field %s", field)
+			continue
+		}
+		newValue, err := json.Marshal(updated[field])
+		if err != nil {
+			log.Warn().Err(err).Msgf("could not encode tool field %s", field)
+			continue
+		}
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		edits = append(edits, db.ToolEdit{
+			Field:    field,
+			OldValue: string(oldValue),
+			NewValue: string(newValue),
+			EditorID: editorID,
+			Date:     now,
+		})
+	}
+	return edits
+}
+
+func (a *API) editTool(id int64, newTool *Tool, editorID primitive.ObjectID) error {
 	tool, err := a.tool(id)
 	if err != nil {
 		return err
@@ -162,6 +349,10 @@ func (a *API) editTool(id int64, newTool *Tool) error {
 	if tool == nil {
 		return ErrToolNotFound
 	}
+	if err := a.validateTool(newTool); err != nil {
+		return err
+	}
+	previous := toolEditableFields(tool)
 
 	if newTool.Title != "" {
 		tool.Title = newTool.Title
@@ -188,9 +379,6 @@ func (a *API) editTool(id int64, newTool *Tool) error {
 		tool.Weight = newTool.Weight
 	}
 	if newTool.Category != 0 {
-		if newTool.Category < 0 || newTool.Category >= len(a.toolCategories()) {
-			return ErrInvalidToolCategory
-		}
 		tool.ToolCategory = newTool.Category
 	}
 	if newTool.Location.Latitude != 0 && newTool.Location.Longitude != 0 {
@@ -199,6 +387,27 @@ func (a *API) editTool(id int64, newTool *Tool) error {
 	if newTool.IsAvailable != nil {
 		tool.IsAvailable = *newTool.IsAvailable
 	}
+	if newTool.MinLeadHours != nil {
+		tool.MinLeadHours = *newTool.MinLeadHours
+	}
+	if newTool.DepositTokens != nil {
+		tool.DepositTokens = *newTool.DepositTokens
+	}
+	if newTool.AutoReturn != nil {
+		tool.AutoReturn = *newTool.AutoReturn
+	}
+	if newTool.IsNomadic != nil {
+		tool.IsNomadic = *newTool.IsNomadic
+	}
+	if newTool.Terms != nil {
+		tool.Terms = *newTool.Terms
+	}
+	if newTool.ResponseDeadlineHours != nil {
+		tool.ResponseDeadlineHours = *newTool.ResponseDeadlineHours
+	}
+	if newTool.Condition != "" {
+		tool.Condition = db.ToolCondition(newTool.Condition)
+	}
 	if len(newTool.Images) > 0 {
 		images, err := a.imageListFromSlice(newTool.Images)
 		if err != nil {
@@ -214,67 +423,117 @@ func (a *API) editTool(id int64, newTool *Tool) error {
 		tool.Images = dbImages
 	}
 	if len(newTool.TransportOptions) > 0 {
-		// Validate and convert transport options
-		transports, err := a.database.TransportService.GetAllTransports(context.Background())
-		if err != nil {
-			return ErrInternalServerError
-		}
-		validTransportIDs := make(map[int64]bool)
-		for _, t := range transports {
-			validTransportIDs[t.ID] = true
-		}
-
 		transportOptions := make([]db.Transport, len(newTool.TransportOptions))
 		for i, id := range newTool.TransportOptions {
-			if !validTransportIDs[int64(id)] {
-				return ErrInvalidTransportOption
-			}
 			transportOptions[i] = db.Transport{ID: int64(id)}
 		}
 		tool.TransportOptions = transportOptions
 	}
-	updates := map[string]interface{}{
-		"title":            tool.Title,
-		"description":      tool.Description,
-		"isAvailable":      tool.IsAvailable,
-		"mayBeFree":        tool.MayBeFree,
-		"askWithFee":       tool.AskWithFee,
-		"cost":             tool.Cost,
-		"toolCategory":     tool.ToolCategory,
-		"estimatedValue":   tool.EstimatedValue,
-		"height":           tool.Height,
-		"weight":           tool.Weight,
-		"images":           tool.Images,
-		"location":         tool.Location,
-		"transportOptions": tool.TransportOptions,
-	}
+	updates := toolEditableFields(tool)
 	err = a.database.ToolService.UpdateToolFields(context.Background(), id, updates)
 	if err != nil {
 		return ErrInternalServerError
 	}
+	if err := a.database.ToolService.AddToolEdits(context.Background(), id,
+		toolEdits(previous, updates, editorID)); err != nil {
+		log.Warn().Err(err).Msgf("could not store history of tool %d", id)
+	}
+	return nil
+}
+
+// validate checks the bounds of the search filters sent by the client, before the defaults are applied:
+// a zero Distance or AvailableFrom is unset.
+func (q *ToolSearch) validate() error {
+	if q.Distance != 0 && (q.Distance < minSearchDistance || q.Distance > maxSearchDistance) {
+		return ErrInvalidSearchDistance
+	}
+	if q.MaxCost != nil && *q.MaxCost > maxToolCost {
+		return ErrInvalidMaxCost
+	}
+	if q.AvailableFrom < 0 {
+		return ErrInvalidAvailableFrom
+	}
+	if q.AvailableTo < 0 {
+		return ErrInvalidAvailableTo
+	}
+	if q.AvailableTo != 0 && q.AvailableFrom > q.AvailableTo {
+		return ErrInvalidBookingDates
+	}
 	return nil
 }
 
 func (a *API) toolSearch(query *ToolSearch, userLocation *db.Location) ([]db.Tool, error) {
 	opts := db.SearchToolsOptions{
+		Term:             query.Term,
 		Categories:       query.Categories,
 		MayBeFree:        query.MayBeFree,
 		MaxCost:          query.MaxCost,
 		Distance:         query.Distance,
 		Location:         userLocation,
 		TransportOptions: query.TransportOptions,
+		MinCondition:     db.ToolCondition(query.MinCondition),
+	}
+	if query.Community != "" {
+		opts.Communities = []string{query.Community}
 	}
 	tools, err := a.database.ToolService.SearchTools(context.Background(), opts)
 	if err != nil {
 		return nil, ErrInternalServerError
 	}
-	result := make([]db.Tool, len(tools))
-	for i, t := range tools {
-		result[i] = *t
+	result := make([]db.Tool, 0, len(tools))
+	if query.AvailableFrom == 0 && query.AvailableTo == 0 {
+		for _, t := range tools {
+			result = append(result, *t)
+		}
+		return result, nil
+	}
+
+	// Only the tools that could be booked for the whole period
+	from, to := time.Now(), time.Unix(int64(query.AvailableTo), 0)
+	if query.AvailableFrom != 0 {
+		from = time.Unix(int64(query.AvailableFrom), 0)
+	}
+	if query.AvailableTo == 0 {
+		to = from
+	}
+	booked, err := a.database.BookingService.BookedToolIDs(context.Background(), from, to)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	for _, t := range tools {
+		if !booked[strconv.FormatInt(t.ID, 10)] && !t.UnavailableDuring(from, to) {
+			result = append(result, *t)
+		}
 	}
 	return result, nil
 }
 
+// communityFilter returns the community the tool search of the user is restricted to, from the
+// community query parameter: empty (the default) searches every community and "mine" only the
+// community of the user.
+func communityFilter(r *Request, user *db.User) (string, error) {
+	switch r.Context.QueryParam("community") {
+	case "":
+		return "", nil
+	case communityFilterMine:
+		if user.Community == "" {
+			return "", ErrNoCommunity
+		}
+		return user.Community, nil
+	default:
+		return "", ErrInvalidCommunityFilter
+	}
+}
+
+// searchDistance returns the default tool search radius (in meters) for the users of the
+// given community, falling back to the global default if the community has no override.
+func (a *API) searchDistance(community string) int {
+	if distance, ok := a.opts.CommunitySearchDistance[community]; ok && distance > 0 {
+		return distance
+	}
+	return a.opts.DefaultSearchDistance
+}
+
 func (a *API) deleteTool(id int64) error {
 	filter := bson.M{"_id": id}
 	_, err := a.database.ToolService.Collection.DeleteOne(context.Background(), filter)
@@ -289,55 +548,265 @@ func (a *API) ownToolsHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	bookedFilter, err := bookingCountFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	if bookedFilter == nil {
+		response, err := a.toolsPageByUserID(r.UserID, page, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.setTimesBooked(r.Context.Request.Context(), response.Tools); err != nil {
+			return nil, err
+		}
+		return response, nil
+	}
+
+	// The booking counts are not stored, so the tools are filtered before paginating
 	tools, err := a.toolsByUerID(r.UserID)
 	if err != nil {
 		return nil, err
 	}
-	return &ToolsWrapper{Tools: tools}, nil
+	if err := a.setTimesBooked(r.Context.Request.Context(), tools); err != nil {
+		return nil, err
+	}
+	filtered := []db.Tool{}
+	for i := range tools {
+		if bookedFilter(&tools[i]) {
+			filtered = append(filtered, tools[i])
+		}
+	}
+	return toolsPage(filtered, page, pageSize), nil
+}
+
+// toolsPage returns the page of the tools along with its pagination information.
+func toolsPage(tools []db.Tool, page, pageSize int) *ToolsWrapper {
+	paged := paginate(tools, page, pageSize)
+	setPrimaryImages(paged)
+	return &ToolsWrapper{
+		Tools: paged,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    int64(len(tools)),
+		},
+	}
+}
+
+// setPrimaryImages fills the PrimaryImage of the tools with the first of their images, if any.
+func setPrimaryImages(tools []db.Tool) {
+	for i := range tools {
+		if len(tools[i].Images) > 0 {
+			tools[i].PrimaryImage = tools[i].Images[0].Hash
+		}
+	}
+}
+
+// setTimesBooked fills the TimesBooked count of the tools.
+func (a *API) setTimesBooked(ctx context.Context, tools []db.Tool) error {
+	counts, err := a.database.BookingService.CountBookedByTool(ctx)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	for i := range tools {
+		tools[i].TimesBooked = counts[strconv.FormatInt(tools[i].ID, 10)]
+	}
+	return nil
+}
+
+// setDistances fills the Distance of the tools to the given location, rounded to meters.
+// It is left unset on the tools without location, or on all of them if the location is not set.
+func setDistances(tools []db.Tool, from db.Location) {
+	if from == (db.Location{}) {
+		return
+	}
+	for i := range tools {
+		if tools[i].Location == (db.Location{}) {
+			continue
+		}
+		distance := int64(math.Round(db.Distance(from, tools[i].Location)))
+		tools[i].Distance = &distance
+	}
+}
+
+// setTransportCosts fills the TransportCost of the tools with the cheapest of the transport options
+// they offer among the given ones, over their Distance, see setDistances.
+func (a *API) setTransportCosts(ctx context.Context, tools []db.Tool, transportIDs []int) error {
+	transports, err := a.database.TransportService.GetAllTransports(ctx)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	searched := make(map[int64]*db.Transport, len(transportIDs))
+	for _, transport := range transports {
+		for _, id := range transportIDs {
+			if transport.ID == int64(id) {
+				searched[transport.ID] = transport
+			}
+		}
+	}
+	for i := range tools {
+		var distance float64
+		if tools[i].Distance != nil {
+			distance = float64(*tools[i].Distance)
+		}
+		for _, option := range tools[i].TransportOptions {
+			transport, ok := searched[option.ID]
+			if !ok {
+				continue
+			}
+			cost := transport.EstimateCost(distance)
+			if tools[i].TransportCost == nil || cost < *tools[i].TransportCost {
+				tools[i].TransportCost = &cost
+			}
+		}
+	}
+	return nil
+}
+
+// bookingCountFilter parses the neverBooked and minBookings query parameters into a filter on
+// the TimesBooked count of the tools. It returns nil if none is set, both cannot be combined.
+func bookingCountFilter(r *Request) (func(*db.Tool) bool, error) {
+	neverBookedStr := r.Context.QueryParam("neverBooked")
+	minBookingsStr := r.Context.QueryParam("minBookings")
+	var neverBooked bool
+	if neverBookedStr != "" {
+		var err error
+		if neverBooked, err = strconv.ParseBool(neverBookedStr); err != nil {
+			return nil, ErrInvalidRequestBodyData
+		}
+	}
+	var minBookings int64
+	if minBookingsStr != "" {
+		var err error
+		if minBookings, err = strconv.ParseInt(minBookingsStr, 10, 64); err != nil || minBookings < 0 {
+			return nil, ErrInvalidRequestBodyData
+		}
+	}
+	switch {
+	case neverBooked && minBookings > 0:
+		return nil, ErrInvalidRequestBodyData
+	case neverBooked:
+		return func(t *db.Tool) bool { return t.TimesBooked == 0 }, nil
+	case minBookings > 0:
+		return func(t *db.Tool) bool { return t.TimesBooked >= minBookings }, nil
+	}
+	return nil, nil
 }
 
 // GET /tools/:id returns a tool by id
+// Archived tools are returned too. Admins can get any deleted tool with includeDeleted=true.
 func (a *API) toolHandler(r *Request) (interface{}, error) {
 	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
 	if err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
-	tool, err := a.tool(id)
+	tool, err := a.findTool(id, true)
 	if err != nil {
 		return nil, err
 	}
+	if tool.Deleted && !tool.Archived && !a.includeDeleted(r) {
+		return nil, ErrToolNotFound
+	}
+	ctx := r.Context.Request.Context()
+	tool.TimesBooked, err = a.database.BookingService.CountBooked(ctx, strconv.FormatInt(tool.ID, 10))
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	tool.Reputation, err = a.database.BookingService.GetToolReputation(ctx, strconv.FormatInt(tool.ID, 10))
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if len(tool.Images) > 0 {
+		tool.PrimaryImage = tool.Images[0].Hash
+	}
 	return tool, nil
 }
 
+// GET /tools/:id/preview returns the public link preview metadata of a tool.
+// It does not require authentication. Unpublished tools are reported as not found.
+func (a *API) toolPreviewHandler(r *Request) (interface{}, error) {
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrToolNotFound
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	if !tool.IsAvailable {
+		return nil, ErrToolNotFound
+	}
+
+	preview := &ToolPreview{
+		ID:          tool.ID,
+		Title:       tool.Title,
+		Description: truncateString(tool.Description, previewDescriptionLength),
+	}
+	if len(tool.Images) > 0 {
+		preview.ImageHash = tool.Images[0].Hash
+		preview.ImageURL = "/images/" + tool.Images[0].Hash.String()
+	}
+	owner, err := a.database.UserService.GetUserByID(context.Background(), tool.UserID)
+	if err == nil {
+		preview.Community = owner.Community
+	}
+	return preview, nil
+}
+
 // GET /tools/user/:id returns tools owned by the user
 func (a *API) userToolsHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
-	tools, err := a.toolsByUerID(r.Context.URLParam("id"))
+	page, pageSize, err := paginationParams(r)
 	if err != nil {
 		return nil, err
 	}
-	return &ToolsWrapper{Tools: tools}, nil
+	return a.toolsPageByUserID(r.Context.URLParam("id"), page, pageSize)
 }
 
 // GET /tools/search filters tools
+// The community parameter set to "mine" only returns the tools of owners in the community of the user.
 func (a *API) toolSearchHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
 
-	searchTerm := r.Context.URLParam("searchTerm")
-	maxCostStr := r.Context.URLParam("maxCost")
-	mayBeFreeStr := r.Context.URLParam("maybeFree")
-	availableFromStr := r.Context.URLParam("availableFrom")
-	categoriesStr := r.Context.URLParam("categories")
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cheap checks on the search term first, as search as you type sends many requests
+	searchTerm := strings.TrimSpace(r.Context.QueryParam("searchTerm"))
+	if searchTerm != "" {
+		sanitized := strings.TrimSpace(db.SanitizeString(searchTerm))
+		if sanitized == "" {
+			// Nothing searchable left in the term, so nothing can match
+			return toolsPage([]db.Tool{}, page, pageSize), nil
+		}
+		if len([]rune(sanitized)) < minSearchTermLength {
+			return nil, ErrSearchTermTooShort
+		}
+		searchTerm = sanitized
+	}
+	maxCostStr := r.Context.QueryParam("maxCost")
+	mayBeFreeStr := r.Context.QueryParam("maybeFree")
+	availableFromStr := r.Context.QueryParam("availableFrom")
+	availableToStr := r.Context.QueryParam("availableTo")
+	categoriesStr := r.Context.QueryParam("categories")
+	distanceStr := r.Context.QueryParam("distance")
 
 	var maxCost *uint64
 	if maxCostStr != "" {
 		cost, err := strconv.ParseUint(maxCostStr, 10, 64)
 		if err != nil {
-			return nil, ErrInvalidRequestBodyData
+			return nil, ErrInvalidMaxCost
 		}
 		maxCost = &cost
 	}
@@ -355,10 +824,27 @@ func (a *API) toolSearchHandler(r *Request) (interface{}, error) {
 	if availableFromStr != "" {
 		from, err := strconv.Atoi(availableFromStr)
 		if err != nil {
-			return nil, ErrInvalidRequestBodyData
+			return nil, ErrInvalidAvailableFrom
 		}
 		availableFrom = from
 	}
+	var availableTo int
+	if availableToStr != "" {
+		to, err := strconv.Atoi(availableToStr)
+		if err != nil {
+			return nil, ErrInvalidAvailableTo
+		}
+		availableTo = to
+	}
+
+	var distance int
+	if distanceStr != "" {
+		d, err := strconv.Atoi(distanceStr)
+		if err != nil {
+			return nil, ErrInvalidSearchDistance
+		}
+		distance = d
+	}
 
 	var categories []int
 	if categoriesStr != "" {
@@ -375,7 +861,7 @@ func (a *API) toolSearchHandler(r *Request) (interface{}, error) {
 	}
 
 	// Parse transport options
-	transportOptionsStr := r.Context.URLParam("transportOptions")
+	transportOptionsStr := r.Context.QueryParam("transportOptions")
 	var transportOptions []int
 	if transportOptionsStr != "" {
 		// Parse comma-separated list of transport options
@@ -390,49 +876,419 @@ func (a *API) toolSearchHandler(r *Request) (interface{}, error) {
 		}
 	}
 
+	minCondition := r.Context.QueryParam("minCondition")
+	if minCondition != "" && !db.ToolCondition(minCondition).Valid() {
+		return nil, ErrInvalidToolCondition
+	}
 	query := ToolSearch{
 		Term:             searchTerm,
 		Categories:       categories,
+		Distance:         distance,
 		MaxCost:          maxCost,
 		MayBeFree:        mayBeFree,
 		AvailableFrom:    availableFrom,
+		AvailableTo:      availableTo,
 		TransportOptions: transportOptions,
+		MinCondition:     minCondition,
+	}
+	if err := query.validate(); err != nil {
+		return nil, err
 	}
+	bookedFilter, err := bookingCountFilter(r)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := a.userByEmail(r.UserID)
 	if err != nil {
 		return nil, ErrUserNotFound
 	}
+	// If no distance is provided, use the default of the user community
+	if query.Distance == 0 {
+		query.Distance = a.searchDistance(user.Community)
+	}
+	query.Community, err = communityFilter(r, user)
+	if err != nil {
+		return nil, err
+	}
 	tools, err := a.toolSearch(&query, &user.Location)
 	if err != nil {
 		return nil, err
 	}
-	return &ToolsWrapper{Tools: tools}, nil
+	if err := a.setTimesBooked(r.Context.Request.Context(), tools); err != nil {
+		return nil, err
+	}
+	setDistances(tools, user.Location)
+	if len(transportOptions) > 0 {
+		if err := a.setTransportCosts(r.Context.Request.Context(), tools, transportOptions); err != nil {
+			return nil, err
+		}
+	}
+	if bookedFilter != nil {
+		// Filtering by bookings is meant to find the tools of others, the user's own
+		// tools with their booking counts are listed on GET /tools
+		filtered := []db.Tool{}
+		for i := range tools {
+			if tools[i].UserID != user.ID && bookedFilter(&tools[i]) {
+				filtered = append(filtered, tools[i])
+			}
+		}
+		tools = filtered
+	}
+	if err := a.featuredFirst(r.Context.Request.Context(), tools, user.Community); err != nil {
+		return nil, err
+	}
+	return toolsPage(tools, page, pageSize), nil
 }
 
-// POST /tools adds a new tool
-func (a *API) addToolHandler(r *Request) (interface{}, error) {
-	if r.UserID == "" {
-		return nil, ErrUnauthorized
+// featuredFirst moves the featured tools of the community to the front, keeping the order otherwise.
+// Tools are featured in the community of their owner.
+func (a *API) featuredFirst(ctx context.Context, tools []db.Tool, community string) error {
+	ownerIDs := []primitive.ObjectID{}
+	for _, t := range tools {
+		if t.Featured {
+			ownerIDs = append(ownerIDs, t.UserID)
+		}
 	}
-
-	t := Tool{}
-	if err := json.Unmarshal(r.Data, &t); err != nil {
-		return nil, ErrInvalidRequestBodyData
+	if len(ownerIDs) == 0 || community == "" {
+		return nil
 	}
-	id, err := a.addTool(&t, r.UserID)
+	owners, err := a.database.UserService.GetUsersByIDs(ctx, ownerIDs)
 	if err != nil {
-		return nil, err
+		return ErrInternalServerError
 	}
-	return &ToolID{ID: id}, nil
+	members := make(map[primitive.ObjectID]bool, len(owners))
+	for _, owner := range owners {
+		members[owner.ID] = owner.Community == community
+	}
+	featured := func(t *db.Tool) bool { return t.Featured && members[t.UserID] }
+	sort.SliceStable(tools, func(i, j int) bool {
+		return featured(&tools[i]) && !featured(&tools[j])
+	})
+	return nil
 }
 
-// DELETE /tools/:id deletes a tool
-func (a *API) deleteToolHandler(r *Request) (interface{}, error) {
+// GET /tools/pending-action returns the tools of the user with pending petitions to answer,
+// along with the number of petitions, the ones waiting the longest first.
+func (a *API) toolsPendingActionHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
 		return nil, ErrUnauthorized
 	}
-
-	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	pending, err := a.database.BookingService.GetOwnerPendingPetitions(r.Context.Request.Context(), user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	response := make([]ToolPendingActionResponse, len(pending))
+	for i, p := range pending {
+		response[i] = ToolPendingActionResponse{
+			Tool:          p.Tool,
+			PendingCount:  p.Count,
+			OldestPending: p.OldestPending,
+		}
+	}
+	return &ToolsPendingActionResponse{Tools: response}, nil
+}
+
+// GET /tools/on-loan returns the tools of the user lent out right now, with the borrower.
+func (a *API) toolsOnLoanHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+	loans, err := a.database.BookingService.GetOwnerOnLoan(ctx, user.ID, time.Now())
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	borrowerIDs := make([]primitive.ObjectID, len(loans))
+	for i, loan := range loans {
+		borrowerIDs[i] = loan.FromUserID
+	}
+	borrowers, err := a.database.UserService.GetUsersByIDs(ctx, borrowerIDs)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	borrowersByID := make(map[primitive.ObjectID]*db.User, len(borrowers))
+	for _, borrower := range borrowers {
+		borrowersByID[borrower.ID] = borrower
+	}
+	response := make([]ToolOnLoanResponse, len(loans))
+	for i, loan := range loans {
+		response[i] = ToolOnLoanResponse{
+			Tool:           loan.Tool,
+			BookingID:      loan.ID.Hex(),
+			ExpectedReturn: loan.EndDate.Unix(),
+		}
+		if borrower, ok := borrowersByID[loan.FromUserID]; ok {
+			response[i].Borrower = convertUserToSummary(borrower)
+		}
+	}
+	return &ToolsOnLoanResponse{Tools: response}, nil
+}
+
+// GET /tools/featured returns the tools featured in a community, sorted by distance.
+// The community query parameter defaults to the community of the user.
+func (a *API) featuredToolsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	community := r.Context.QueryParam("community")
+	if community == "" {
+		community = user.Community
+	}
+	result := []db.Tool{}
+	if community == "" {
+		return &ToolsWrapper{Tools: result}, nil
+	}
+
+	ctx := r.Context.Request.Context()
+	members, err := a.database.UserService.GetUsersByCommunity(ctx, community)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	memberIDs := make([]primitive.ObjectID, len(members))
+	for i, member := range members {
+		memberIDs[i] = member.ID
+	}
+	if len(memberIDs) == 0 {
+		return &ToolsWrapper{Tools: result}, nil
+	}
+	tools, err := a.database.ToolService.GetToolsByUserIDs(ctx, memberIDs)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	for _, t := range tools {
+		if t.Featured {
+			result = append(result, *t)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return db.Distance(user.Location, result[i].Location) < db.Distance(user.Location, result[j].Location)
+	})
+	return &ToolsWrapper{Tools: result}, nil
+}
+
+// GET /tools/category/:categoryId returns the available tools of a category, sorted by distance
+func (a *API) toolsByCategoryHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	categoryID, err := strconv.Atoi(r.Context.URLParam("categoryId"))
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.database.ToolCategoryService.GetToolCategoryByID(context.Background(), categoryID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidToolCategory
+		}
+		return nil, ErrInternalServerError
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+
+	tools, err := a.database.ToolService.GetAvailableToolsByCategory(context.Background(), categoryID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	sort.SliceStable(tools, func(i, j int) bool {
+		return db.Distance(user.Location, tools[i].Location) < db.Distance(user.Location, tools[j].Location)
+	})
+
+	result := []db.Tool{}
+	for _, t := range paginate(tools, page, pageSize) {
+		result = append(result, *t)
+	}
+	return &ToolsWrapper{
+		Tools: result,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    int64(len(tools)),
+		},
+	}, nil
+}
+
+// GET /tools/recommended returns the available tools near the user ranked by the affinity of
+// their category with the tools the user booked before and by distance. Own tools and tools
+// already booked by the user are excluded. Users without bookings get the most booked tools nearby.
+func (a *API) recommendedToolsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+
+	// Category affinity from the booking history of the user
+	petitions, err := a.database.BookingService.GetUserPetitions(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	booked := make(map[string]bool, len(petitions))
+	var bookedIDs []int64
+	for _, petition := range petitions {
+		if booked[petition.ToolID] {
+			continue
+		}
+		booked[petition.ToolID] = true
+		if id, err := strconv.ParseInt(petition.ToolID, 10, 64); err == nil {
+			bookedIDs = append(bookedIDs, id)
+		}
+	}
+	affinity := make(map[int]float64)
+	if len(bookedIDs) > 0 {
+		bookedTools, err := a.database.ToolService.GetToolsByIDs(ctx, bookedIDs)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		for _, t := range bookedTools {
+			affinity[t.ToolCategory] += 1 / float64(len(bookedTools))
+		}
+	}
+
+	distance := a.searchDistance(user.Community)
+	tools, err := a.database.ToolService.SearchTools(ctx, db.SearchToolsOptions{
+		Distance: distance,
+		Location: &user.Location,
+	})
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	bookingsCount, err := a.database.BookingService.CountByTool(ctx)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	type candidate struct {
+		tool       *db.Tool
+		score      float64
+		popularity int64
+		distance   float64
+	}
+	candidates := []candidate{}
+	for _, t := range tools {
+		if !t.IsAvailable || t.UserID == user.ID || booked[strconv.FormatInt(t.ID, 10)] {
+			continue
+		}
+		d := db.Distance(user.Location, t.Location)
+		// Category affinity weights more than proximity, both within [0,1]
+		proximity := max(0, 1-d/float64(distance))
+		candidates = append(candidates, candidate{
+			tool:       t,
+			score:      2*affinity[t.ToolCategory] + proximity,
+			popularity: bookingsCount[strconv.FormatInt(t.ID, 10)],
+			distance:   d,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if len(affinity) == 0 && candidates[i].popularity != candidates[j].popularity {
+			return candidates[i].popularity > candidates[j].popularity
+		}
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	result := []db.Tool{}
+	for _, c := range candidates[:min(len(candidates), maxRecommendedTools)] {
+		result = append(result, *c.tool)
+	}
+	return &ToolsWrapper{Tools: result}, nil
+}
+
+// GET /tools/trending returns the available tools with the most bookings in the trending window,
+// recent bookings weighting more. Ties are broken by distance to the user. Own tools are excluded.
+func (a *API) trendingToolsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+
+	scores, err := a.database.BookingService.RecentActivityByTool(ctx, time.Now().Add(-a.opts.TrendingWindow))
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	ids := []int64{}
+	for toolID := range scores {
+		if id, err := strconv.ParseInt(toolID, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	tools, err := a.database.ToolService.GetToolsByIDs(ctx, ids)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	trending := []*db.Tool{}
+	for _, t := range tools {
+		if t.IsAvailable && !t.Deleted && t.UserID != user.ID {
+			trending = append(trending, t)
+		}
+	}
+	score := func(t *db.Tool) float64 { return scores[strconv.FormatInt(t.ID, 10)] }
+	sort.SliceStable(trending, func(i, j int) bool {
+		if si, sj := score(trending[i]), score(trending[j]); si != sj {
+			return si > sj
+		}
+		return db.Distance(user.Location, trending[i].Location) < db.Distance(user.Location, trending[j].Location)
+	})
+
+	result := []db.Tool{}
+	for _, t := range trending[:min(len(trending), maxTrendingTools)] {
+		result = append(result, *t)
+	}
+	return &ToolsWrapper{Tools: result}, nil
+}
+
+// POST /tools adds a new tool
+func (a *API) addToolHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	t := Tool{}
+	if err := json.Unmarshal(r.Data, &t); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	id, err := a.addTool(&t, r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &ToolID{ID: id}, nil
+}
+
+// DELETE /tools/:id deletes a tool, or archives it if any booking references it, see db.Tool.Archived
+func (a *API) deleteToolHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
 	if err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
@@ -448,12 +1304,111 @@ func (a *API) deleteToolHandler(r *Request) (interface{}, error) {
 	if tool.UserID != user.ID {
 		return nil, ErrToolNotOwnedByUser
 	}
-	if err := a.deleteTool(id); err != nil {
+	if err := a.removeTool(r.Context.Request.Context(), id); err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
+// removeTool deletes a tool, or archives it if any booking references it, so the bookings can
+// still show it. See db.Tool.Archived.
+func (a *API) removeTool(ctx context.Context, id int64) error {
+	hasBookings, err := a.database.BookingService.HasBookings(ctx, strconv.FormatInt(id, 10))
+	if err != nil {
+		return ErrInternalServerError
+	}
+	if hasBookings {
+		if err := a.database.ToolService.UpdateToolFields(ctx, id,
+			map[string]interface{}{"deleted": true, "archived": true}); err != nil {
+			return ErrInternalServerError
+		}
+		return nil
+	}
+	return a.deleteTool(id)
+}
+
+// POST /tools/:id/unavailability marks a tool of the user as not available for a period.
+// If the period overlaps accepted bookings, they are returned along with ErrConflictsWithBookings,
+// unless force is set, in which case they are cancelled and their requesters notified.
+func (a *API) toolUnavailabilityHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	var req ToolUnavailabilityRequest
+	if err := json.Unmarshal(r.Data, &req); err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if req.From <= 0 || req.To <= req.From || req.To > math.MaxUint32 {
+		return nil, ErrInvalidBookingDates
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if tool.UserID != user.ID {
+		return nil, ErrToolNotOwnedByUser
+	}
+
+	ctx := r.Context.Request.Context()
+	conflicts, err := a.database.BookingService.GetOverlapping(ctx, strconv.FormatInt(id, 10),
+		time.Unix(req.From, 0), time.Unix(req.To, 0), []db.BookingStatus{db.BookingStatusAccepted})
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	response := &ToolUnavailabilityResponse{Conflicts: make([]BookingResponse, len(conflicts))}
+	for i, booking := range conflicts {
+		response.Conflicts[i] = convertBookingToResponse(booking)
+	}
+	if len(conflicts) > 0 && !req.Force {
+		response.Unavailability = tool.Unavailability
+		return response, ErrConflictsWithBookings
+	}
+	for _, booking := range conflicts {
+		if err := a.cancelAcceptedBooking(ctx, booking); err != nil {
+			return nil, err
+		}
+	}
+
+	period := db.DateRange{From: uint32(req.From), To: uint32(req.To)}
+	if err := a.database.ToolService.AddUnavailability(ctx, id, period); err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("tool %d marked unavailable from %d to %d, %d bookings cancelled",
+		id, req.From, req.To, len(conflicts))
+	if len(conflicts) > 0 {
+		a.audit(ctx, r, db.AuditBookingsForced, db.AuditTargetTool, strconv.FormatInt(id, 10),
+			fmt.Sprintf("%d accepted bookings cancelled to mark the tool unavailable", len(conflicts)))
+	}
+	response.Unavailability = append(tool.Unavailability, period)
+	return response, nil
+}
+
+// cancelAcceptedBooking cancels an accepted booking on behalf of the tool owner, along with the
+// rest of its kit. The deposits are given back and the requester is notified.
+func (a *API) cancelAcceptedBooking(ctx context.Context, booking *db.Booking) error {
+	group, err := a.bookingGroup(ctx, booking)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	err = a.database.BookingService.UpdateStatus(ctx, booking.ID, db.BookingStatusCancelled, booking.ToUserID)
+	if err != nil {
+		return ErrInternalServerError
+	}
+	if err := a.releaseDeposits(ctx, group, primitive.NilObjectID, 0); err != nil {
+		log.Error().Err(err).Msgf("could not give back deposits of booking %s", booking.ID.Hex())
+	}
+	a.notify(ctx, booking.FromUserID, db.NotificationBookingCancelled, booking.ID)
+	return nil
+}
+
 // PUT /tools/:id edit a tool
 func (a *API) editToolHandler(r *Request) (interface{}, error) {
 	if r.UserID == "" {
@@ -480,8 +1435,251 @@ func (a *API) editToolHandler(r *Request) (interface{}, error) {
 	if err := json.Unmarshal(r.Data, &t); err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
-	if err := a.editTool(id, &t); err != nil {
+	if err := a.editTool(id, &t, user.ID); err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
+
+// GET /tools/:id/history returns the edit history of a tool, only available to its owner and admins
+func (a *API) toolHistoryHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if tool.UserID != user.ID && !user.Admin {
+		return nil, ErrToolNotOwnedByUser
+	}
+
+	edits := make([]ToolEditResponse, len(tool.History))
+	for i, edit := range tool.History {
+		edits[i] = ToolEditResponse{
+			Field:    edit.Field,
+			OldValue: json.RawMessage(edit.OldValue),
+			NewValue: json.RawMessage(edit.NewValue),
+			EditorID: edit.EditorID.Hex(),
+			Date:     edit.Date,
+		}
+	}
+	return &ToolHistoryResponse{ToolID: tool.ID, Edits: edits}, nil
+}
+
+// toolStatsHandler returns the booking and rating statistics of a tool for its owner.
+func (a *API) toolStatsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if tool.UserID != user.ID {
+		return nil, ErrToolNotOwnedByUser
+	}
+
+	ctx := r.Context.Request.Context()
+	toolID := strconv.FormatInt(id, 10)
+	stats := &ToolStatsResponse{ToolID: id}
+	if stats.TimesBooked, err = a.database.BookingService.CountBooked(ctx, toolID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	average, err := a.database.RatingService.GetToolAverage(ctx, toolID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	stats.Rating = average.Value()
+	stats.RatingCount = average.Count
+	ratings, err := a.database.RatingService.GetToolRatings(ctx, toolID, toolStatsRatings)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if stats.Ratings, err = a.userRatingResponses(ctx, ratings); err != nil {
+		return nil, err
+	}
+
+	// The bookings from the start of the window on, DateRange limits the dates to uint32
+	now := time.Now()
+	since := now.Add(-toolStatsWindow)
+	bookings, err := a.database.BookingService.GetOverlapping(ctx, toolID, since,
+		time.Unix(math.MaxUint32, 0), db.BookedStatuses)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	var used time.Duration
+	for _, booking := range bookings {
+		start, end := booking.StartDate, booking.EndDate
+		if start.Before(since) {
+			start = since
+		}
+		if end.After(now) {
+			end = now
+		}
+		if end.After(start) {
+			used += end.Sub(start)
+		}
+		if booking.BookingStatus != db.BookingStatusAccepted {
+			continue
+		}
+		// Bookings are sorted by start date, so the first ones found are the current and next
+		response := convertBookingToResponse(booking)
+		if stats.CurrentBooking == nil && !booking.StartDate.After(now) && booking.EndDate.After(now) {
+			stats.CurrentBooking = &response
+		} else if stats.NextBooking == nil && booking.StartDate.After(now) {
+			stats.NextBooking = &response
+		}
+	}
+	stats.Utilization = int32(math.Min(100, math.Round(float64(used)*100/float64(toolStatsWindow))))
+	return stats, nil
+}
+
+// toolNextAvailableHandler returns the earliest dates the tool can be booked for the duration query
+// parameter, in seconds, starting from the from query parameter, a unix time that defaults to now.
+// The dates are looked for up to Options.AvailabilityHorizon after from; if none is free by then,
+// available is false.
+func (a *API) toolNextAvailableHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	from := time.Now()
+	if fromStr := r.Context.QueryParam("from"); fromStr != "" {
+		unix, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil || unix <= 0 || unix > math.MaxUint32 {
+			return nil, ErrInvalidBookingDates
+		}
+		from = time.Unix(unix, 0)
+	}
+	seconds, err := strconv.ParseInt(r.Context.QueryParam("duration"), 10, 64)
+	if err != nil || seconds <= 0 {
+		return nil, ErrInvalidBookingDates
+	}
+	if seconds > int64(a.opts.MaxBookingDays)*24*60*60 {
+		return nil, ErrBookingTooLong
+	}
+	return a.nextAvailable(r.Context.Request.Context(), tool, from, time.Duration(seconds)*time.Second)
+}
+
+// nextAvailable looks for the earliest dates the tool can be booked for the duration, from the later
+// of from and the minimum notice of the tool up to Options.AvailabilityHorizon after from.
+func (a *API) nextAvailable(
+	ctx context.Context,
+	tool *db.Tool,
+	from time.Time,
+	duration time.Duration,
+) (*NextAvailableResponse, error) {
+	until := from.Add(a.opts.AvailabilityHorizon)
+	// Bookings cannot start in the past nor sooner than the notice of the tool, see checkLeadTime
+	if earliest := time.Now().Add(time.Duration(tool.MinLeadHours) * time.Hour); from.Before(earliest) {
+		from = earliest
+	}
+	if from.Nanosecond() > 0 {
+		// the dates are unix times, in seconds
+		from = from.Truncate(time.Second).Add(time.Second)
+	}
+	response := &NextAvailableResponse{ToolID: tool.ID, Horizon: until.Unix()}
+	start, ok, err := a.database.BookingService.NextAvailable(ctx, strconv.FormatInt(tool.ID, 10),
+		from, duration, until)
+	if err != nil {
+		log.Error().Err(err).Int64("toolId", tool.ID).Msg("failed to look for the next available dates")
+		return nil, ErrInternalServerError
+	}
+	if ok {
+		startDate, endDate := start.Unix(), start.Add(duration).Unix()
+		response.Available = true
+		response.StartDate = &startDate
+		response.EndDate = &endDate
+	}
+	return response, nil
+}
+
+// toolBookingsHandler returns a page of the bookings of a tool in any status, newest first, for
+// its owner. The optional status query parameter is the same as on the other booking listings.
+func (a *API) toolBookingsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	id, err := strconv.ParseInt(r.Context.URLParam("id"), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestBodyData
+	}
+	tool, err := a.tool(id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if tool.UserID != user.ID {
+		return nil, ErrToolNotOwnedByUser
+	}
+	statuses, err := bookingStatusesParam(r)
+	if err != nil {
+		return nil, err
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context.Request.Context()
+	bookings, err := a.database.BookingService.GetBookingsByTool(ctx, strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	if len(statuses) > 0 {
+		bookings = slices.DeleteFunc(bookings, func(b *db.Booking) bool {
+			return !slices.Contains(statuses, b.BookingStatus)
+		})
+	}
+	total := len(bookings)
+	bookings = paginate(bookings, page, pageSize)
+	usersByID, _, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	response := &ToolBookingsResponse{
+		ToolID:   id,
+		Bookings: make([]ToolBookingResponse, len(bookings)),
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    int64(total),
+		},
+	}
+	for i, booking := range bookings {
+		response.Bookings[i] = ToolBookingResponse{BookingResponse: convertBookingToResponse(booking)}
+		if requester, ok := usersByID[booking.FromUserID]; ok {
+			response.Bookings[i].FromUser = convertUserToSummary(requester)
+		}
+	}
+	return response, nil
+}
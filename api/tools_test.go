@@ -0,0 +1,1402 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/emprius/emprius-app-backend/db"
+	"github.com/emprius/emprius-app-backend/types"
+)
+
+func TestToolSearchCommunityDistance(t *testing.T) {
+	a := testAPI(t)
+	a.opts.DefaultSearchDistance = 1000
+	a.opts.CommunitySearchDistance = map[string]int{
+		"urban": 5000,
+		"rural": 50000,
+	}
+
+	// Tool owner at testLatitudeA, tool placed 10km away
+	err := a.addUser(&testUser1)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Two searchers at the same location but in different communities
+	urbanUser := db.User{Name: "urbanite", Email: "urban@emprius.cat", Community: "urban", Location: testLatitudeA}
+	ruralUser := db.User{Name: "villager", Email: "rural@emprius.cat", Community: "rural", Location: testLatitudeA}
+	qt.Assert(t, a.addUser(&urbanUser), qt.IsNil)
+	qt.Assert(t, a.addUser(&ruralUser), qt.IsNil)
+
+	qt.Assert(t, a.searchDistance("urban"), qt.Equals, 5000)
+	qt.Assert(t, a.searchDistance("rural"), qt.Equals, 50000)
+	qt.Assert(t, a.searchDistance("unknown"), qt.Equals, 1000)
+
+	search := func(email, query string) []db.Tool {
+		req := &Request{
+			Context: &HTTPContext{Request: httptest.NewRequest("GET", "/tools/search"+query, nil)},
+			UserID:  email,
+		}
+		resp, err := a.toolSearchHandler(req)
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsWrapper).Tools
+	}
+
+	// The urban community default radius (5km) does not reach the tool
+	qt.Assert(t, search(urbanUser.Email, ""), qt.HasLen, 0)
+	// The rural community default radius (50km) does
+	qt.Assert(t, search(ruralUser.Email, ""), qt.HasLen, 1)
+	// An explicit distance overrides the community default
+	qt.Assert(t, search(urbanUser.Email, "?distance=20000"), qt.HasLen, 1)
+}
+
+func TestToolSearchResultDistance(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?distance=50000", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	tools := resp.(*ToolsWrapper).Tools
+	qt.Assert(t, tools, qt.HasLen, 1)
+	// The tool is placed 10km away from its owner
+	qt.Assert(t, tools[0].Distance, qt.Not(qt.IsNil))
+	qt.Assert(t, *tools[0].Distance > 9500 && *tools[0].Distance < 10500, qt.IsTrue,
+		qt.Commentf("distance %d", *tools[0].Distance))
+
+	// Without location on either side there is no distance to report
+	unlocated := []db.Tool{{Location: testUser1.Location}, {}}
+	setDistances(unlocated, db.Location{})
+	qt.Assert(t, unlocated[0].Distance, qt.IsNil)
+	setDistances(unlocated, testUser1.Location)
+	qt.Assert(t, *unlocated[0].Distance, qt.Equals, int64(0))
+	qt.Assert(t, unlocated[1].Distance, qt.IsNil)
+}
+
+func TestToolSearchCommunity(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	neighbour := testUser1
+	neighbour.Name, neighbour.Email, neighbour.Community = "carol", "carol@emprius.cat", "community2"
+	qt.Assert(t, a.addUser(&neighbour), qt.IsNil)
+	loner := testUser1
+	loner.Name, loner.Email, loner.Community = "dave", "dave@emprius.cat", ""
+	qt.Assert(t, a.addUser(&loner), qt.IsNil)
+	mine, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.addTool(&testTool1, neighbour.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	search := func(email, query string) ([]db.Tool, error) {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?distance=50000"+query, email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*ToolsWrapper).Tools, nil
+	}
+
+	// Every community by default
+	tools, err := search(testUser1.Email, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tools, qt.HasLen, 2)
+
+	tools, err = search(testUser1.Email, "&community=mine")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].ID, qt.Equals, mine)
+
+	_, err = search(testUser1.Email, "&community=community2")
+	qt.Assert(t, err, qt.Equals, ErrInvalidCommunityFilter)
+	_, err = search(loner.Email, "&community=mine")
+	qt.Assert(t, err, qt.Equals, ErrNoCommunity)
+
+	// The unified search applies the same filter
+	resp, err := a.searchHandler(testRequest(t, "GET", "/search?term="+url.QueryEscape(testTool1.Title)+"&community=mine",
+		neighbour.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	results := resp.(*SearchResponse).Results
+	qt.Assert(t, results[0].Type, qt.Equals, SearchResultTool)
+	qt.Assert(t, results[0].Tool.ID, qt.Not(qt.Equals), mine)
+	qt.Assert(t, len(results) < 2 || results[1].Type != SearchResultTool, qt.IsTrue)
+}
+
+func TestToolSearchAvailability(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	booked, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	other := testTool1
+	other.Title = "other tool"
+	free, err := a.addTool(&other, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	start := time.Now().Truncate(time.Hour).Add(24 * time.Hour)
+	day := func(n int) int64 { return start.Add(time.Duration(n) * 24 * time.Hour).Unix() }
+	book := func(toolID int64, from, to int) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Unix(day(from), 0),
+			EndDate:   time.Unix(day(to), 0),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return booking
+	}
+	// Accepted from day 2 to 4, pending bookings do not block the dates by default
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, book(booked, 2, 4).ID, db.BookingStatusAccepted,
+		primitive.NilObjectID), qt.IsNil)
+	book(free, 2, 4)
+
+	search := func(query string) ([]int64, error) {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?distance=50000"+query,
+			testUser1.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		ids := []int64{}
+		for _, tool := range resp.(*ToolsWrapper).Tools {
+			ids = append(ids, tool.ID)
+		}
+		return ids, nil
+	}
+	window := func(from, to int) string {
+		return fmt.Sprintf("&availableFrom=%d&availableTo=%d", day(from), day(to))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		tools []int64
+	}{
+		{"no window", "", []int64{booked, free}},
+		{"before", window(0, 1), []int64{booked, free}},
+		{"ending when the booking starts", window(1, 2), []int64{booked, free}},
+		{"overlapping the start", window(1, 3), []int64{free}},
+		{"within", window(3, 4), []int64{free}},
+		{"containing", window(0, 6), []int64{free}},
+		{"starting when the booking ends", window(4, 5), []int64{booked, free}},
+		{"only from, during the booking", fmt.Sprintf("&availableFrom=%d", day(3)), []int64{free}},
+		{"only from, after the booking", fmt.Sprintf("&availableFrom=%d", day(5)), []int64{booked, free}},
+	} {
+		ids, err := search(tc.query)
+		qt.Assert(t, err, qt.IsNil, qt.Commentf(tc.name))
+		qt.Assert(t, ids, qt.ContentEquals, tc.tools, qt.Commentf(tc.name))
+	}
+
+	// Periods marked unavailable by the owner also exclude the tool
+	qt.Assert(t, a.database.ToolService.AddUnavailability(ctx, free,
+		db.DateRange{From: uint32(day(5)), To: uint32(day(7))}), qt.IsNil)
+	ids, err := search(window(6, 8))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids, qt.DeepEquals, []int64{booked})
+
+	_, err = search(window(3, 1))
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingDates)
+}
+
+func TestToolsByCategory(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	addTool := func(title string, category int, location db.Location) int64 {
+		tool := testTool1
+		tool.Title = title
+		tool.Category = category
+		tool.Location = location
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	far := addTool("far drill", 1, testLatitudeA10km)
+	near := addTool("near drill", 1, testLatitudeA)
+	addTool("tractor", 2, testLatitudeA)
+	unavailable := addTool("broken drill", 1, testLatitudeA)
+	qt.Assert(t, a.editTool(unavailable, &Tool{IsAvailable: boolPtr(false)}, primitive.NilObjectID), qt.IsNil)
+
+	list := func(category, query string) (*ToolsWrapper, error) {
+		resp, err := a.toolsByCategoryHandler(testRequest(t, "GET", "/tools/category/"+category+query,
+			testUser1.Email, nil, map[string]string{"categoryId": category}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*ToolsWrapper), nil
+	}
+
+	// Only the available tools of the category, nearest first
+	resp, err := list("1", "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, resp.Tools, qt.HasLen, 2)
+	qt.Assert(t, resp.Tools[0].ID, qt.Equals, near)
+	qt.Assert(t, resp.Tools[1].ID, qt.Equals, far)
+
+	// Pagination keeps the total
+	resp, err = list("1", "?page=1&pageSize=1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, resp.Tools, qt.HasLen, 1)
+	qt.Assert(t, resp.Tools[0].ID, qt.Equals, far)
+
+	// Unknown categories are rejected
+	_, err = list("99", "")
+	qt.Assert(t, err, qt.Equals, ErrInvalidToolCategory)
+	_, err = list("1", "?page=-1")
+	qt.Assert(t, err, qt.Equals, ErrInvalidPagination)
+}
+
+func TestToolHistory(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", id)
+	params := map[string]string{"id": idStr}
+
+	// Change the price of the tool
+	_, err = a.editToolHandler(testRequest(t, "PUT", "/tools/"+idStr, testUser1.Email,
+		map[string]any{"cost": 25}, params))
+	qt.Assert(t, err, qt.IsNil)
+
+	// Only the owner can read the history
+	_, err = a.toolHistoryHandler(testRequest(t, "GET", "/tools/"+idStr+"/history", testUser2.Email, nil, params))
+	qt.Assert(t, err, qt.Equals, ErrToolNotOwnedByUser)
+
+	resp, err := a.toolHistoryHandler(testRequest(t, "GET", "/tools/"+idStr+"/history", testUser1.Email, nil, params))
+	qt.Assert(t, err, qt.IsNil)
+	history := resp.(*ToolHistoryResponse)
+	qt.Assert(t, history.Edits, qt.HasLen, 1)
+	qt.Assert(t, history.Edits[0].Field, qt.Equals, "cost")
+	qt.Assert(t, string(history.Edits[0].OldValue), qt.Equals, "10")
+	qt.Assert(t, string(history.Edits[0].NewValue), qt.Equals, "25")
+
+	owner, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, history.Edits[0].EditorID, qt.Equals, owner.ID.Hex())
+}
+
+func TestToolPreview(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	published, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	draftTool := testTool1
+	draftTool.Title = "draft tool"
+	draft, err := a.addTool(&draftTool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.editTool(draft, &Tool{IsAvailable: boolPtr(false)}, primitive.NilObjectID), qt.IsNil)
+
+	preview := func(id int64) (interface{}, error) {
+		idStr := fmt.Sprintf("%d", id)
+		return a.toolPreviewHandler(testRequest(t, "GET", "/tools/"+idStr+"/preview", "", nil,
+			map[string]string{"id": idStr}))
+	}
+
+	// Published tools are available without authentication
+	resp, err := preview(published)
+	qt.Assert(t, err, qt.IsNil)
+	p := resp.(*ToolPreview)
+	qt.Assert(t, p.ID, qt.Equals, published)
+	qt.Assert(t, p.Title, qt.Equals, testTool1.Title)
+	qt.Assert(t, p.Description, qt.Equals, testTool1.Description)
+	qt.Assert(t, p.Community, qt.Equals, testUser1.Community)
+
+	// Drafts and missing tools are not found
+	_, err = preview(draft)
+	qt.Assert(t, err, qt.Equals, ErrToolNotFound)
+	_, err = preview(12345)
+	qt.Assert(t, err, qt.Equals, ErrToolNotFound)
+}
+
+func TestToolSearchShortTerm(t *testing.T) {
+	// Without a database, any query would panic
+	a := New("secret", "", nil, nil)
+	search := func(term string) (interface{}, error) {
+		return a.toolSearchHandler(testRequest(t, "GET", "/tools/search?searchTerm="+term,
+			testUser1.Email, nil, nil))
+	}
+
+	_, err := search("a")
+	qt.Assert(t, err, qt.Equals, ErrSearchTermTooShort)
+	_, err = search("%20b%20")
+	qt.Assert(t, err, qt.Equals, ErrSearchTermTooShort)
+
+	// Terms with nothing searchable return an empty result
+	resp, err := search("%24%25")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*ToolsWrapper).Tools, qt.HasLen, 0)
+}
+
+func TestToolSearchFilterValidation(t *testing.T) {
+	// The filters are checked before any query, so no database is needed
+	a := New("secret", "", nil, nil)
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		err   error
+	}{
+		{"negative distance", "distance=-1", ErrInvalidSearchDistance},
+		{"distance too short", fmt.Sprintf("distance=%d", minSearchDistance-1), ErrInvalidSearchDistance},
+		{"distance too long", fmt.Sprintf("distance=%d", maxSearchDistance+1), ErrInvalidSearchDistance},
+		{"distance not a number", "distance=far", ErrInvalidSearchDistance},
+		{"negative max cost", "maxCost=-5", ErrInvalidMaxCost},
+		{"max cost too high", fmt.Sprintf("maxCost=%d", maxToolCost+1), ErrInvalidMaxCost},
+		{"max cost overflowing", "maxCost=99999999999999999999999", ErrInvalidMaxCost},
+		{"negative available from", "availableFrom=-10", ErrInvalidAvailableFrom},
+		{"available from not a number", "availableFrom=tomorrow", ErrInvalidAvailableFrom},
+		{"negative available to", "availableTo=-10", ErrInvalidAvailableTo},
+		{"available to before from", "availableFrom=2000&availableTo=1000", ErrInvalidBookingDates},
+		{"valid distance with negative from", "distance=5000&availableFrom=-1", ErrInvalidAvailableFrom},
+		{"valid cost with long distance", "maxCost=10&distance=2000000", ErrInvalidSearchDistance},
+	} {
+		_, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?"+tc.query, testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.Equals, tc.err, qt.Commentf(tc.name))
+	}
+
+	// The bounds themselves are valid, and zero values are unset filters
+	for _, q := range []ToolSearch{
+		{Distance: minSearchDistance, MaxCost: uint64Ptr(0)},
+		{Distance: maxSearchDistance, MaxCost: uint64Ptr(maxToolCost)},
+		{AvailableFrom: 1000, AvailableTo: 1000},
+		{},
+	} {
+		qt.Assert(t, q.validate(), qt.IsNil, qt.Commentf("%+v", q))
+	}
+}
+
+func TestRecommendedTools(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := db.User{Name: "carol", Email: "carol@emprius.cat", Community: "community1", Location: testLatitudeA}
+	dave := db.User{Name: "dave", Email: "dave@emprius.cat", Community: "community1", Location: testLatitudeA}
+	qt.Assert(t, a.addUser(&carol), qt.IsNil)
+	qt.Assert(t, a.addUser(&dave), qt.IsNil)
+
+	addTool := func(title string, category int, location db.Location) int64 {
+		tool := testTool1
+		tool.Title = title
+		tool.Category = category
+		tool.Location = location
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	drill := addTool("drill", 1, testLatitudeA10km)
+	otherDrill := addTool("other drill", 1, testLatitudeA10km)
+	tractor := addTool("tractor", 2, testLatitudeA)
+
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	book := func(email string, toolID int64) {
+		user, err := a.database.UserService.GetUserByEmail(ctx, email)
+		qt.Assert(t, err, qt.IsNil)
+		_, err = a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:  fmt.Sprintf("%d", toolID),
+			Contact: email,
+		}, user.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	recommended := func(email string) []int64 {
+		resp, err := a.recommendedToolsHandler(testRequest(t, "GET", "/tools/recommended", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		ids := []int64{}
+		for _, tool := range resp.(*ToolsWrapper).Tools {
+			ids = append(ids, tool.ID)
+		}
+		return ids
+	}
+
+	// The owner gets no recommendations of their own tools
+	qt.Assert(t, recommended(testUser1.Email), qt.HasLen, 0)
+
+	// A drill borrowed by carol surfaces the other drill before the nearer tractor
+	book(carol.Email, drill)
+	qt.Assert(t, recommended(carol.Email), qt.DeepEquals, []int64{otherDrill, tractor})
+
+	// Without history the most booked tools come first
+	book(testUser2.Email, otherDrill)
+	book(testUser2.Email, otherDrill)
+	qt.Assert(t, recommended(dave.Email), qt.DeepEquals, []int64{otherDrill, drill, tractor})
+}
+
+func TestTrendingTools(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	addTool := func(title string) int64 {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	book := func(toolID int64, age time.Duration) {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID: fmt.Sprintf("%d", toolID),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		_, err = a.database.Database.Collection("bookings").UpdateOne(ctx, bson.M{"_id": booking.ID},
+			bson.M{"$set": bson.M{"createdAt": time.Now().Add(-age)}})
+		qt.Assert(t, err, qt.IsNil)
+	}
+	recent := addTool("recent")
+	older := addTool("older")
+	dormant := addTool("dormant")
+	book(recent, time.Hour)
+	book(older, 5*24*time.Hour)
+	book(older, 6*24*time.Hour)
+	// Dormant tools had many bookings, but outside of the window
+	for i := 0; i < 5; i++ {
+		book(dormant, 30*24*time.Hour)
+	}
+
+	trending := func(email string) []int64 {
+		resp, err := a.trendingToolsHandler(testRequest(t, "GET", "/tools/trending", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		ids := []int64{}
+		for _, tool := range resp.(*ToolsWrapper).Tools {
+			ids = append(ids, tool.ID)
+		}
+		return ids
+	}
+	qt.Assert(t, trending(testUser2.Email), qt.DeepEquals, []int64{recent, older})
+	// Own tools are not listed
+	qt.Assert(t, trending(testUser1.Email), qt.HasLen, 0)
+}
+
+func TestFeaturedTools(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testAdmin), qt.IsNil)
+	outsider := db.User{Name: "carol", Email: "carol@emprius.cat", Community: "community2", Location: testLatitudeA}
+	qt.Assert(t, a.addUser(&outsider), qt.IsNil)
+
+	addTool := func(email, title string) int64 {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	drill := addTool(testUser1.Email, "community drill")
+	saw := addTool(testUser1.Email, "community saw")
+	outsiderTool := addTool(outsider.Email, "outsider drill")
+
+	feature := func(email string, id int64, featured bool) error {
+		idStr := fmt.Sprintf("%d", id)
+		req := testRequest(t, "PUT", "/admin/tools/"+idStr+"/featured", email,
+			&FeaturedToolRequest{Featured: featured}, map[string]string{"id": idStr})
+		_, err := a.adminHandler(a.adminFeatureToolHandler)(req)
+		return err
+	}
+	featured := func(email, query string) []db.Tool {
+		resp, err := a.featuredToolsHandler(testRequest(t, "GET", "/tools/featured"+query, email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsWrapper).Tools
+	}
+	search := func(term string) []db.Tool {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?searchTerm="+term,
+			testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsWrapper).Tools
+	}
+
+	// Only admins can feature tools
+	qt.Assert(t, feature(testUser1.Email, drill, true), qt.Equals, ErrAdminRequired)
+	qt.Assert(t, feature(testAdmin.Email, 1, true), qt.Equals, ErrToolNotFound)
+	qt.Assert(t, featured(testUser1.Email, ""), qt.HasLen, 0)
+
+	qt.Assert(t, feature(testAdmin.Email, saw, true), qt.IsNil)
+	qt.Assert(t, feature(testAdmin.Email, outsiderTool, true), qt.IsNil)
+
+	// Each community only lists its own featured tools
+	tools := featured(testUser1.Email, "")
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].ID, qt.Equals, saw)
+	tools = featured(testUser1.Email, "?community=community2")
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].ID, qt.Equals, outsiderTool)
+
+	// Featured tools of the community rank first in search
+	tools = search("community")
+	qt.Assert(t, tools, qt.HasLen, 2)
+	qt.Assert(t, tools[0].ID, qt.Equals, saw)
+	qt.Assert(t, feature(testAdmin.Email, saw, false), qt.IsNil)
+	qt.Assert(t, feature(testAdmin.Email, drill, true), qt.IsNil)
+	tools = search("community")
+	qt.Assert(t, tools[0].ID, qt.Equals, drill)
+
+	// Tools featured in another community do not rank above the others
+	tools = search("drill")
+	qt.Assert(t, tools, qt.HasLen, 2)
+	qt.Assert(t, tools[0].ID, qt.Equals, drill)
+}
+
+func TestToolConditionSearch(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	addTool := func(title, condition string) (int64, error) {
+		tool := testTool1
+		tool.Title = title
+		tool.Condition = condition
+		return a.addTool(&tool, testUser1.Email)
+	}
+	_, err := addTool("shiny drill", "brand new")
+	qt.Assert(t, err, qt.Equals, ErrInvalidToolCondition)
+	newDrill, err := addTool("new drill", "new")
+	qt.Assert(t, err, qt.IsNil)
+	fairDrill, err := addTool("fair drill", "fair")
+	qt.Assert(t, err, qt.IsNil)
+	poorDrill, err := addTool("poor drill", "poor")
+	qt.Assert(t, err, qt.IsNil)
+	_, err = addTool("old drill", "")
+	qt.Assert(t, err, qt.IsNil)
+
+	search := func(query string) ([]int64, error) {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?searchTerm=drill"+query,
+			testUser1.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		ids := []int64{}
+		for _, tool := range resp.(*ToolsWrapper).Tools {
+			ids = append(ids, tool.ID)
+		}
+		return ids, nil
+	}
+
+	ids, err := search("")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids, qt.HasLen, 4)
+	// The poor tool and the one without condition are excluded
+	ids, err = search("&minCondition=fair")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids, qt.ContentEquals, []int64{newDrill, fairDrill})
+	ids, err = search("&minCondition=new")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids, qt.DeepEquals, []int64{newDrill})
+	_, err = search("&minCondition=shiny")
+	qt.Assert(t, err, qt.Equals, ErrInvalidToolCondition)
+
+	// The condition can be changed on edit and is returned with the tool
+	qt.Assert(t, a.editTool(poorDrill, &Tool{Condition: "good"}, primitive.NilObjectID), qt.IsNil)
+	qt.Assert(t, a.editTool(poorDrill, &Tool{Condition: "worn"}, primitive.NilObjectID),
+		qt.Equals, ErrInvalidToolCondition)
+	tool, err := a.tool(poorDrill)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tool.Condition, qt.Equals, db.ToolConditionGood)
+	ids, err = search("&minCondition=fair")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, ids, qt.HasLen, 3)
+}
+
+func TestToolsPendingAction(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string) int64 {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	drill, saw := addTool("drill"), addTool("saw")
+	addTool("ladder")
+
+	book := func(toolID int64, startOffset time.Duration) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", toolID),
+				StartDate: time.Now().Add(startOffset).Unix(),
+				EndDate:   time.Now().Add(startOffset + 24*time.Hour).Unix(),
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse).ID
+	}
+	pendingAction := func() []ToolPendingActionResponse {
+		resp, err := a.toolsPendingActionHandler(testRequest(t, "GET", "/tools/pending-action",
+			testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsPendingActionResponse).Tools
+	}
+
+	qt.Assert(t, pendingAction(), qt.HasLen, 0)
+
+	// The saw petition is the oldest one
+	sawPetition := book(saw, 24*time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	book(drill, 48*time.Hour)
+	book(drill, 96*time.Hour)
+
+	tools := pendingAction()
+	qt.Assert(t, tools, qt.HasLen, 2)
+	qt.Assert(t, tools[0].Tool.ID, qt.Equals, saw)
+	qt.Assert(t, tools[0].PendingCount, qt.Equals, int64(1))
+	qt.Assert(t, tools[1].Tool.ID, qt.Equals, drill)
+	qt.Assert(t, tools[1].PendingCount, qt.Equals, int64(2))
+
+	// Answered petitions no longer need action
+	_, err := a.HandleDenyPetition(testRequest(t, "POST", "/bookings/petitions/"+sawPetition+"/deny",
+		testUser1.Email, nil, map[string]string{"petitionId": sawPetition}))
+	qt.Assert(t, err, qt.IsNil)
+	tools = pendingAction()
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].Tool.ID, qt.Equals, drill)
+
+	// The requester has no tools needing action
+	resp, err := a.toolsPendingActionHandler(testRequest(t, "GET", "/tools/pending-action",
+		testUser2.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*ToolsPendingActionResponse).Tools, qt.HasLen, 0)
+}
+
+func TestToolSearchBookingCount(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	owner := db.User{Name: "carol", Email: "carol@emprius.cat", Community: "community1", Location: testLatitudeA}
+	qt.Assert(t, a.addUser(&owner), qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ownerID, err := a.database.UserService.GetUserByEmail(ctx, owner.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	addTool := func(email, title string) int64 {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	proven := addTool(owner.Email, "proven drill")
+	unused := addTool(owner.Email, "unused drill")
+	addTool(testUser1.Email, "own drill")
+
+	book := func(toolID int64, offset time.Duration, status db.BookingStatus) {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Now().Add(offset),
+			EndDate:   time.Now().Add(offset + 24*time.Hour),
+		}, requester.ID, ownerID.ID)
+		qt.Assert(t, err, qt.IsNil)
+		setBookingStatusForTest(t, a, booking.ID, status)
+	}
+	book(proven, 24*time.Hour, db.BookingStatusReturned)
+	book(proven, 72*time.Hour, db.BookingStatusAccepted)
+	// Bookings that did not go ahead do not count
+	book(unused, 24*time.Hour, db.BookingStatusCancelled)
+
+	search := func(query string) (map[int64]int64, error) {
+		resp, err := a.toolSearchHandler(testRequest(t, "GET", "/tools/search?searchTerm=drill"+query,
+			testUser1.Email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		counts := map[int64]int64{}
+		for _, tool := range resp.(*ToolsWrapper).Tools {
+			counts[tool.ID] = tool.TimesBooked
+		}
+		return counts, nil
+	}
+
+	counts, err := search("")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, counts, qt.HasLen, 3)
+	qt.Assert(t, counts[proven], qt.Equals, int64(2))
+	qt.Assert(t, counts[unused], qt.Equals, int64(0))
+
+	// The never booked tool is included by neverBooked and excluded by minBookings,
+	// the own tool of the user is left out of both
+	counts, err = search("&neverBooked=true")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, counts, qt.DeepEquals, map[int64]int64{unused: 0})
+	counts, err = search("&minBookings=1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, counts, qt.DeepEquals, map[int64]int64{proven: 2})
+	counts, err = search("&minBookings=3")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, counts, qt.HasLen, 0)
+	_, err = search("&neverBooked=true&minBookings=1")
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+
+	// Owners find their never booked tools in their listing
+	resp, err := a.ownToolsHandler(testRequest(t, "GET", "/tools?neverBooked=true", owner.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	tools := resp.(*ToolsWrapper).Tools
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].ID, qt.Equals, unused)
+
+	// The count is shown on the tool
+	idStr := fmt.Sprintf("%d", proven)
+	tool, err := a.toolHandler(testRequest(t, "GET", "/tools/"+idStr, testUser1.Email, nil,
+		map[string]string{"id": idStr}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tool.(*db.Tool).TimesBooked, qt.Equals, int64(2))
+}
+
+func TestDuplicateToolTitles(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	titled := func(title string) *Tool {
+		tool := testTool1
+		tool.Title = title
+		return &tool
+	}
+	_, err := a.addTool(titled("Drill"), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// By default a duplicate is only a warning, but the very same title never fits
+	_, err = a.addTool(titled("drill "), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.addTool(titled("Drill"), testUser1.Email)
+	qt.Assert(t, err, qt.Equals, ErrDuplicateToolTitle)
+
+	// Strict deployments reject it, but other owners can still use the title
+	a.opts.UniqueToolTitles = true
+	_, err = a.addTool(titled("DRILL"), testUser1.Email)
+	qt.Assert(t, err, qt.Equals, ErrDuplicateToolTitle)
+	_, err = a.addTool(titled("DRILL"), testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.addTool(titled("Hammer"), testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestToolValidation(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	for _, tc := range []struct {
+		name string
+		edit func(*Tool)
+		err  error
+	}{
+		{"blank title", func(t *Tool) { t.Title = "   " }, ErrEmptyTitleOrDescription},
+		{"unknown category", func(t *Tool) { t.Category = 99 }, ErrInvalidToolCategory},
+		{"negative category", func(t *Tool) { t.Category = -1 }, ErrInvalidToolCategory},
+		{"unknown condition", func(t *Tool) { t.Condition = "broken" }, ErrInvalidToolCondition},
+		{"unknown transport", func(t *Tool) { t.TransportOptions = []int{1, 42} }, ErrInvalidTransportOption},
+		{"overflowing cost", func(t *Tool) { t.Cost = uint64Ptr(math.MaxUint64) }, ErrToolCostTooHigh},
+		{"overflowing value", func(t *Tool) { t.EstimatedValue = math.MaxUint64 }, ErrEstimatedValueTooHigh},
+		{"overflowing deposit", func(t *Tool) { t.DepositTokens = uint64Ptr(math.MaxUint64) }, ErrToolDepositTooHigh},
+	} {
+		tool := testTool1
+		tool.Title = "invalid " + tc.name
+		tc.edit(&tool)
+		_, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.Equals, tc.err, qt.Commentf("create with %s", tc.name))
+
+		// Edits only set the fields to change
+		edit := Tool{}
+		tc.edit(&edit)
+		qt.Assert(t, a.editTool(id, &edit, primitive.NilObjectID), qt.Equals, tc.err,
+			qt.Commentf("edit with %s", tc.name))
+	}
+
+	// New tools need a category, and the last one is as valid as the first
+	tool := testTool1
+	tool.Title = "uncategorized"
+	tool.Category = 0
+	_, err = a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.Equals, ErrInvalidToolCategory)
+	categories := a.toolCategories()
+	last := categories[len(categories)-1].ID
+	qt.Assert(t, a.editTool(id, &Tool{Category: last}, primitive.NilObjectID), qt.IsNil)
+
+	// Nothing was stored by the rejected edits
+	stored, err := a.tool(id)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, stored.Title, qt.Equals, testTool1.Title)
+	qt.Assert(t, stored.Cost, qt.Equals, *testTool1.Cost)
+	qt.Assert(t, stored.ToolCategory, qt.Equals, last)
+}
+
+func TestToolUnavailability(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", toolID)
+	day := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+
+	book := func(start time.Time) (string, error) {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+			&CreateBookingRequest{
+				ToolID:    idStr,
+				StartDate: start.Unix(),
+				EndDate:   start.Add(24 * time.Hour).Unix(),
+			}, nil))
+		if err != nil {
+			return "", err
+		}
+		return resp.(BookingResponse).ID, nil
+	}
+	markUnavailable := func(email string, from, to time.Time, force bool) (*ToolUnavailabilityResponse, error) {
+		resp, err := a.toolUnavailabilityHandler(testRequest(t, "POST", "/tools/"+idStr+"/unavailability", email,
+			&ToolUnavailabilityRequest{From: from.Unix(), To: to.Unix(), Force: force},
+			map[string]string{"id": idStr}))
+		if resp == nil {
+			return nil, err
+		}
+		return resp.(*ToolUnavailabilityResponse), err
+	}
+
+	accepted, err := book(day)
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+accepted+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": accepted}))
+	qt.Assert(t, err, qt.IsNil)
+	pending, err := book(day.Add(48 * time.Hour))
+	qt.Assert(t, err, qt.IsNil)
+
+	// Only the owner can mark it, with a valid period
+	_, err = markUnavailable(testUser2.Email, day, day.Add(72*time.Hour), false)
+	qt.Assert(t, err, qt.Equals, ErrToolNotOwnedByUser)
+	_, err = markUnavailable(testUser1.Email, day, day, false)
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingDates)
+
+	// Without force, the accepted booking is reported and nothing changes
+	resp, err := markUnavailable(testUser1.Email, day, day.Add(72*time.Hour), false)
+	qt.Assert(t, err, qt.Equals, ErrConflictsWithBookings)
+	qt.Assert(t, resp.Conflicts, qt.HasLen, 1)
+	qt.Assert(t, resp.Conflicts[0].ID, qt.Equals, accepted)
+	qt.Assert(t, resp.Unavailability, qt.HasLen, 0)
+	tool, err := a.tool(toolID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tool.Unavailability, qt.HasLen, 0)
+
+	// With force, the accepted booking is cancelled and the requester notified,
+	// pending ones are left for the owner to answer
+	resp, err = markUnavailable(testUser1.Email, day, day.Add(72*time.Hour), true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Conflicts, qt.HasLen, 1)
+	qt.Assert(t, resp.Unavailability, qt.HasLen, 1)
+	booking := func(id string) BookingResponse {
+		resp, err := a.HandleGetBooking(testRequest(t, "GET", "/bookings/"+id, testUser2.Email, nil,
+			map[string]string{"bookingId": id}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(BookingResponse)
+	}
+	qt.Assert(t, booking(accepted).BookingStatus, qt.Equals, string(db.BookingStatusCancelled))
+	qt.Assert(t, booking(pending).BookingStatus, qt.Equals, string(db.BookingStatusPending))
+	requester, err := a.database.UserService.GetUserByEmail(context.Background(), testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	notifications, err := a.database.NotificationService.GetUserNotifications(context.Background(), requester.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, notifications[0].Type, qt.Equals, db.NotificationBookingCancelled)
+
+	// New bookings cannot overlap the period, but can follow it
+	_, err = book(day.Add(24 * time.Hour))
+	qt.Assert(t, err, qt.Equals, ErrToolUnavailable)
+	_, err = book(day.Add(72 * time.Hour))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestToolsOnLoan(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	addTool := func(title string) int64 {
+		tool := testTool1
+		tool.Title = title
+		id, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	lent, future := addTool("drill"), addTool("saw")
+	addTool("ladder")
+
+	book := func(toolID int64, start, end time.Time) string {
+		resp, err := a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+			&CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", toolID),
+				StartDate: start.Unix(),
+				EndDate:   end.Unix(),
+			}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		id := resp.(BookingResponse).ID
+		_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+id+"/accept",
+			testUser1.Email, nil, map[string]string{"petitionId": id}))
+		qt.Assert(t, err, qt.IsNil)
+		return id
+	}
+	end := time.Now().Add(48 * time.Hour)
+	current := book(lent, time.Now().Add(24*time.Hour), end)
+	// Bookings cannot be created in the past, so it is moved to have started already
+	moveBookingForTest(t, a, current, time.Now().Add(-24*time.Hour), end)
+	book(future, time.Now().Add(48*time.Hour), time.Now().Add(72*time.Hour))
+
+	onLoan := func(email string) []ToolOnLoanResponse {
+		resp, err := a.toolsOnLoanHandler(testRequest(t, "GET", "/tools/on-loan", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*ToolsOnLoanResponse).Tools
+	}
+
+	// Only the tool with a booking going on right now is out, the idle and future ones are not
+	tools := onLoan(testUser1.Email)
+	qt.Assert(t, tools, qt.HasLen, 1)
+	qt.Assert(t, tools[0].Tool.ID, qt.Equals, lent)
+	qt.Assert(t, tools[0].BookingID, qt.Equals, current)
+	qt.Assert(t, tools[0].Borrower.Name, qt.Equals, testUser2.Name)
+	qt.Assert(t, tools[0].ExpectedReturn, qt.Equals, end.Unix())
+
+	// Once returned it is back with the owner
+	_, err := a.HandleReturnBooking(testRequest(t, "POST", "/bookings/"+current+"/return",
+		testUser1.Email, nil, map[string]string{"bookingId": current}))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, onLoan(testUser1.Email), qt.HasLen, 0)
+
+	// Borrowers have no tools on loan
+	qt.Assert(t, onLoan(testUser2.Email), qt.HasLen, 0)
+}
+
+func TestToolListingPagination(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	for _, title := range []string{"drill one", "drill two", "drill three"} {
+		tool := testTool1
+		tool.Title = title
+		_, err := a.addTool(&tool, testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	listings := map[string]func(query string) (interface{}, error){
+		"own": func(query string) (interface{}, error) {
+			return a.ownToolsHandler(testRequest(t, "GET", "/tools"+query, testUser1.Email, nil, nil))
+		},
+		"user": func(query string) (interface{}, error) {
+			return a.userToolsHandler(testRequest(t, "GET", "/tools/user/"+testUser1.Email+query, testUser1.Email,
+				nil, map[string]string{"id": testUser1.Email}))
+		},
+		"search": func(query string) (interface{}, error) {
+			return a.toolSearchHandler(testRequest(t, "GET", "/tools/search"+query+"&searchTerm=drill",
+				testUser1.Email, nil, nil))
+		},
+	}
+	for name, list := range listings {
+		t.Run(name, func(t *testing.T) {
+			resp, err := list("?pageSize=2")
+			qt.Assert(t, err, qt.IsNil)
+			page := resp.(*ToolsWrapper)
+			qt.Assert(t, page.Tools, qt.HasLen, 2)
+			qt.Assert(t, *page.Pagination, qt.Equals, Pagination{Page: 0, PageSize: 2, Total: 3})
+
+			resp, err = list("?pageSize=2&page=1")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, resp.(*ToolsWrapper).Tools, qt.HasLen, 1)
+			qt.Assert(t, resp.(*ToolsWrapper).Tools[0].ID, qt.Not(qt.Equals), page.Tools[0].ID)
+			qt.Assert(t, resp.(*ToolsWrapper).Tools[0].ID, qt.Not(qt.Equals), page.Tools[1].ID)
+
+			// The default page size fits all of them
+			resp, err = list("?page=0")
+			qt.Assert(t, err, qt.IsNil)
+			qt.Assert(t, resp.(*ToolsWrapper).Tools, qt.HasLen, 3)
+			qt.Assert(t, resp.(*ToolsWrapper).Pagination.PageSize, qt.Equals, defaultPageSize)
+
+			_, err = list("?page=-1")
+			qt.Assert(t, err, qt.Equals, ErrInvalidPagination)
+		})
+	}
+}
+
+func TestToolStats(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", id)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	stats := func(email string) (*ToolStatsResponse, error) {
+		resp, err := a.toolStatsHandler(testRequest(t, "GET", "/tools/"+idStr+"/stats", email, nil,
+			map[string]string{"id": idStr}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*ToolStatsResponse), nil
+	}
+	accepted := func(start, end time.Time) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    idStr,
+			StartDate: time.Now().Add(24 * time.Hour),
+			EndDate:   time.Now().Add(48 * time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, booking.ID, db.BookingStatusAccepted,
+			primitive.NilObjectID), qt.IsNil)
+		moveBookingForTest(t, a, booking.ID.Hex(), start, end)
+		return booking
+	}
+
+	// Only the owner can see them, and a new tool has everything at zero
+	_, err = stats(testUser2.Email)
+	qt.Assert(t, err, qt.Equals, ErrToolNotOwnedByUser)
+	resp, err := stats(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp, qt.DeepEquals, &ToolStatsResponse{ToolID: id, Ratings: []UserRatingResponse{}})
+
+	// Lent 9 days ago and right now for a day, with another booking coming
+	day := 24 * time.Hour
+	returned := returnedBookingForTest(t, a, day)
+	moveBookingForTest(t, a, returned.ID.Hex(), time.Now().Add(-10*day), time.Now().Add(-day))
+	current := accepted(time.Now().Add(-day), time.Now().Add(day))
+	next := accepted(time.Now().Add(5*day), time.Now().Add(6*day))
+	// A pending petition is not counted
+	_, err = a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    idStr,
+		StartDate: time.Now().Add(2 * day),
+		EndDate:   time.Now().Add(3 * day),
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Only the rating of the owner counts for the tool, not the one the owner gave
+	image, err := a.addImage("scratch", pngImageForTest())
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.RatingService.Create(ctx, &db.Rating{
+		BookingID: returned.ID, FromUserID: requester.ID, ToUserID: owner.ID, Rating: 4, Comment: "scratched",
+		Images: []types.HexBytes{image.Hash},
+	})
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.RatingService.Create(ctx, &db.Rating{
+		BookingID: returned.ID, FromUserID: owner.ID, ToUserID: requester.ID, Rating: 1,
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	resp, err = stats(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.TimesBooked, qt.Equals, int64(3))
+	qt.Assert(t, resp.Rating, qt.Equals, int32(80))
+	qt.Assert(t, resp.RatingCount, qt.Equals, int64(1))
+	qt.Assert(t, resp.Ratings, qt.HasLen, 1)
+	qt.Assert(t, resp.Ratings[0].Comment, qt.Equals, "scratched")
+	qt.Assert(t, resp.Ratings[0].Images, qt.DeepEquals, []types.HexBytes{image.Hash})
+	qt.Assert(t, resp.Ratings[0].Rater.Name, qt.Equals, testUser2.Name)
+	// 10 days lent out of 90
+	qt.Assert(t, resp.Utilization, qt.Equals, int32(11))
+	qt.Assert(t, resp.CurrentBooking.ID, qt.Equals, current.ID.Hex())
+	qt.Assert(t, resp.NextBooking.ID, qt.Equals, next.ID.Hex())
+}
+
+func TestToolReputation(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", id)
+	ctx := context.Background()
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	reputation := func() *db.ToolReputation {
+		resp, err := a.toolHandler(testRequest(t, "GET", "/tools/"+idStr, testUser2.Email, nil,
+			map[string]string{"id": idStr}))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*db.Tool).Reputation
+	}
+
+	// A tool never booked has zero counts and no average
+	qt.Assert(t, reputation(), qt.DeepEquals, &db.ToolReputation{})
+
+	// Only the completed bookings count, and the ratings the owner received on them
+	day := 24 * time.Hour
+	first := returnedBookingForTest(t, a, day)
+	second := returnedBookingForTest(t, a, 3*day)
+	accepted, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    idStr,
+		StartDate: time.Now().Add(5 * day),
+		EndDate:   time.Now().Add(6 * day),
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	setBookingStatusForTest(t, a, accepted.ID, db.BookingStatusAccepted)
+	for _, rating := range []*db.Rating{
+		{BookingID: first.ID, FromUserID: requester.ID, ToUserID: owner.ID, Rating: 5},
+		{BookingID: second.ID, FromUserID: requester.ID, ToUserID: owner.ID, Rating: 3},
+		{BookingID: first.ID, FromUserID: owner.ID, ToUserID: requester.ID, Rating: 1},
+	} {
+		_, err = a.database.RatingService.Create(ctx, rating)
+		qt.Assert(t, err, qt.IsNil)
+	}
+	got := reputation()
+	qt.Assert(t, got.CompletedBookings, qt.Equals, int64(2))
+	qt.Assert(t, got.RatingCount, qt.Equals, int64(2))
+	qt.Assert(t, got.AverageRating, qt.IsNotNil)
+	qt.Assert(t, *got.AverageRating, qt.Equals, int32(80))
+
+	// The listings do not compute it
+	resp, err := a.ownToolsHandler(testRequest(t, "GET", "/tools", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*ToolsWrapper).Tools[0].Reputation, qt.IsNil)
+}
+
+func TestDeleteTool(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	unused, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	booked := testTool1
+	booked.Title = "booked tool"
+	bookedID, err := a.addTool(&booked, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", bookedID),
+		StartDate: time.Now().Add(24 * time.Hour),
+		EndDate:   time.Now().Add(48 * time.Hour),
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	setBookingStatusForTest(t, a, booking.ID, db.BookingStatusReturned)
+
+	toolRequest := func(method, email string, id int64) *Request {
+		return testRequest(t, method, fmt.Sprintf("/tools/%d", id), email, nil,
+			map[string]string{"id": fmt.Sprintf("%d", id)})
+	}
+
+	// Only the owner can delete
+	_, err = a.deleteToolHandler(toolRequest("DELETE", testUser2.Email, unused))
+	qt.Assert(t, err, qt.Equals, ErrToolNotOwnedByUser)
+
+	// A tool never booked is removed
+	_, err = a.deleteToolHandler(toolRequest("DELETE", testUser1.Email, unused))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.ToolService.GetToolByID(ctx, unused)
+	qt.Assert(t, err, qt.Equals, mongo.ErrNoDocuments)
+	_, err = a.toolHandler(toolRequest("GET", testUser2.Email, unused))
+	qt.Assert(t, err, qt.Equals, ErrToolNotFound)
+
+	// A booked tool is archived: hidden from the listings but still shown for its bookings
+	_, err = a.deleteToolHandler(toolRequest("DELETE", testUser1.Email, bookedID))
+	qt.Assert(t, err, qt.IsNil)
+	resp, err := a.toolHandler(toolRequest("GET", testUser2.Email, bookedID))
+	qt.Assert(t, err, qt.IsNil)
+	tool := resp.(*db.Tool)
+	qt.Assert(t, tool.Archived, qt.IsTrue)
+	qt.Assert(t, tool.TimesBooked, qt.Equals, int64(1))
+	tools, err := a.toolsByUerID(testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tools, qt.HasLen, 0)
+	resp, err = a.toolSearchHandler(testRequest(t, "GET", "/tools/search?distance=50000", testUser1.Email, nil, nil))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.(*ToolsWrapper).Tools, qt.HasLen, 0)
+
+	// Once archived it cannot be deleted again
+	_, err = a.deleteToolHandler(toolRequest("DELETE", testUser1.Email, bookedID))
+	qt.Assert(t, err, qt.Equals, ErrToolNotFound)
+}
+
+func TestToolImageOrder(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	var hashes []types.HexBytes
+	for i := 1; i <= 3; i++ {
+		image, err := a.addImage(fmt.Sprintf("image%d", i), testPNG(t, 10*i, 10))
+		qt.Assert(t, err, qt.IsNil)
+		hashes = append(hashes, image.Hash)
+	}
+	imageHashes := func(tool *db.Tool) []string {
+		var got []string
+		for _, image := range tool.Images {
+			got = append(got, image.Hash.String())
+		}
+		return got
+	}
+
+	// The images keep the order they were sent in, without repetitions
+	tool := testTool1
+	tool.Images = []types.HexBytes{hashes[2], hashes[0], hashes[2], hashes[1]}
+	id, err := a.addTool(&tool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	stored, err := a.findTool(id, false)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, imageHashes(stored), qt.DeepEquals,
+		[]string{hashes[2].String(), hashes[0].String(), hashes[1].String()})
+
+	// The listings return the first one as the primary image
+	primary := func() *types.HexBytes {
+		resp, err := a.ownToolsHandler(testRequest(t, "GET", "/tools", testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return &resp.(*ToolsWrapper).Tools[0].PrimaryImage
+	}
+	qt.Assert(t, primary().String(), qt.Equals, hashes[2].String())
+
+	// Editing reorders them
+	qt.Assert(t, a.editTool(id, &Tool{Images: []types.HexBytes{hashes[1], hashes[2], hashes[0]}},
+		primitive.NilObjectID), qt.IsNil)
+	qt.Assert(t, primary().String(), qt.Equals, hashes[1].String())
+
+	// Removing the primary image makes the next one primary
+	qt.Assert(t, a.editTool(id, &Tool{Images: []types.HexBytes{hashes[2], hashes[0]}},
+		primitive.NilObjectID), qt.IsNil)
+	qt.Assert(t, primary().String(), qt.Equals, hashes[2].String())
+	idStr := fmt.Sprintf("%d", id)
+	resp, err := a.toolHandler(testRequest(t, "GET", "/tools/"+idStr, testUser1.Email, nil,
+		map[string]string{"id": idStr}))
+	qt.Assert(t, err, qt.IsNil)
+	detail := resp.(*db.Tool)
+	qt.Assert(t, detail.PrimaryImage.String(), qt.Equals, hashes[2].String())
+	qt.Assert(t, imageHashes(detail), qt.HasLen, 2)
+}
+
+func TestToolBookings(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", id)
+	day := 24 * time.Hour
+	returned := returnedBookingForTest(t, a, day)
+	pending := returnedBookingForTest(t, a, 3*day)
+	setBookingStatusForTest(t, a, pending.ID, db.BookingStatusPending)
+
+	list := func(email, query string) (*ToolBookingsResponse, error) {
+		resp, err := a.toolBookingsHandler(testRequest(t, "GET", "/tools/"+idStr+"/bookings"+query, email, nil,
+			map[string]string{"id": idStr}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*ToolBookingsResponse), nil
+	}
+
+	// The owner gets all the bookings, newest first, with their requester
+	got, err := list(testUser1.Email, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, got.Bookings, qt.HasLen, 2)
+	qt.Assert(t, got.Bookings[0].ID, qt.Equals, pending.ID.Hex())
+	qt.Assert(t, got.Bookings[1].ID, qt.Equals, returned.ID.Hex())
+	qt.Assert(t, got.Bookings[0].FromUser, qt.IsNotNil)
+	qt.Assert(t, got.Bookings[0].FromUser.Name, qt.Equals, testUser2.Name)
+
+	// The status filter and pagination work as on the other listings
+	got, err = list(testUser1.Email, "?status=RETURNED")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got.Bookings, qt.HasLen, 1)
+	qt.Assert(t, got.Bookings[0].ID, qt.Equals, returned.ID.Hex())
+	got, err = list(testUser1.Email, "?page=1&pageSize=1")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, got.Bookings, qt.HasLen, 1)
+	qt.Assert(t, got.Bookings[0].ID, qt.Equals, returned.ID.Hex())
+	_, err = list(testUser1.Email, "?status=UNKNOWN")
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingStatus)
+
+	// Nobody else can list them
+	_, err = list(testUser2.Email, "")
+	qt.Assert(t, err, qt.Equals, ErrToolNotOwnedByUser)
+}
+
+func TestToolNextAvailable(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	idStr := fmt.Sprintf("%d", toolID)
+	day := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+
+	nextAvailable := func(from time.Time, duration time.Duration) (*NextAvailableResponse, error) {
+		req := testRequest(t, "GET", fmt.Sprintf("/tools/%s/next-available?from=%d&duration=%d",
+			idStr, from.Unix(), int64(duration.Seconds())), testUser2.Email, nil, map[string]string{"id": idStr})
+		resp, err := a.toolNextAvailableHandler(req)
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*NextAvailableResponse), nil
+	}
+	book := func(start time.Time, days int) (interface{}, error) {
+		return a.HandleCreateBooking(testRequest(t, "POST", "/bookings", testUser2.Email,
+			&CreateBookingRequest{
+				ToolID:    idStr,
+				StartDate: start.Unix(),
+				EndDate:   start.Add(time.Duration(days) * 24 * time.Hour).Unix(),
+			}, nil))
+	}
+
+	// Invalid durations are rejected
+	_, err = nextAvailable(day, 0)
+	qt.Assert(t, err, qt.Equals, ErrInvalidBookingDates)
+	_, err = nextAvailable(day, time.Duration(a.opts.MaxBookingDays+1)*24*time.Hour)
+	qt.Assert(t, err, qt.Equals, ErrBookingTooLong)
+
+	// A free tool is available from the requested start
+	resp, err := nextAvailable(day, 48*time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Available, qt.IsTrue)
+	qt.Assert(t, *resp.StartDate, qt.Equals, day.Unix())
+	qt.Assert(t, *resp.EndDate, qt.Equals, day.Add(48*time.Hour).Unix())
+
+	// Accepted bookings and unavailability periods are skipped, pending petitions are not
+	created, err := book(day.Add(24*time.Hour), 2)
+	qt.Assert(t, err, qt.IsNil)
+	accepted := created.(BookingResponse).ID
+	_, err = a.HandleAcceptPetition(testRequest(t, "POST", "/bookings/petitions/"+accepted+"/accept",
+		testUser1.Email, nil, map[string]string{"petitionId": accepted}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = book(day.Add(4*24*time.Hour), 1)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.ToolService.AddUnavailability(context.Background(), toolID, db.DateRange{
+		From: uint32(day.Add(3 * 24 * time.Hour).Unix()),
+		To:   uint32(day.Add(4 * 24 * time.Hour).Unix()),
+	}), qt.IsNil)
+	resp, err = nextAvailable(day, 48*time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Available, qt.IsTrue)
+	qt.Assert(t, *resp.StartDate, qt.Equals, day.Add(4*24*time.Hour).Unix())
+
+	// The conflicting booking requests get the same suggestion
+	data, err := book(day, 2)
+	qt.Assert(t, err, qt.Equals, ErrBookingDatesConflict)
+	qt.Assert(t, *data.(*NextAvailableResponse).StartDate, qt.Equals, day.Add(4*24*time.Hour).Unix())
+
+	// Nothing free within the horizon is not an error
+	resp, err = nextAvailable(day.Add(-a.opts.AvailabilityHorizon).Add(72*time.Hour), 48*time.Hour)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Available, qt.IsFalse)
+	qt.Assert(t, resp.StartDate, qt.IsNil)
+	qt.Assert(t, resp.Horizon, qt.Equals, day.Add(72*time.Hour).Unix())
+}
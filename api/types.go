@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/emprius/emprius-app-backend/db"
@@ -29,9 +30,47 @@ type Login struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+// PasswordResetRequest asks for a password reset token for the user with the given email.
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirm sets a new password using a password reset token.
+type PasswordResetConfirm struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// DeleteAccountRequest confirms the deletion of the account of the user with their password.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// ImageBatchResult is the outcome of one image of POST /images/batch, in the order they were sent.
+// Either Hash is set or the image was rejected with the ErrorCode and Error of a single upload.
+type ImageBatchResult struct {
+	Hash      types.HexBytes `json:"hash,omitempty"`
+	ErrorCode int            `json:"errorCode,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// VerifyRequest verifies the email of a user with the token sent on registration.
+type VerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// LoginResponse holds the access token, sent as bearer token on the protected routes, and the
+// refresh token exchanged on POST /refresh for new ones once it expires.
 type LoginResponse struct {
-	Token    string    `json:"token"`
-	Expirity time.Time `json:"expirity"`
+	Token        string    `json:"token"`
+	Expirity     time.Time `json:"expirity"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+}
+
+// RefreshRequest is the body of POST /refresh and POST /logout.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type UserProfile struct {
@@ -43,8 +82,88 @@ type UserProfile struct {
 	Password  string       `json:"password,omitempty"`
 }
 
+// UserResponse is the user profile returned to clients, along with the metrics computed from the
+// user activity. It never includes the password.
+type UserResponse struct {
+	ID string `json:"id"`
+	// Email is only set on the profile of the user making the request
+	Email       string         `json:"email,omitempty"`
+	Name        string         `json:"name"`
+	Community   string         `json:"community,omitempty"`
+	Tokens      uint64         `json:"tokens"`
+	Active      bool           `json:"active"`
+	Verified    bool           `json:"verified"`
+	Admin       bool           `json:"admin,omitempty"`
+	Deleted     bool           `json:"deleted,omitempty"`
+	Deactivated bool           `json:"deactivated,omitempty"`
+	AvatarHash  types.HexBytes `json:"avatarHash,omitempty"`
+	Location    db.Location    `json:"location"`
+	RatingCount int64          `json:"ratingCount"`
+	// Reliability is the 0-100 score of the user returning on time and not cancelling,
+	// nil if the user made no bookings yet or in the user listings
+	Reliability *int32 `json:"reliability"`
+	// ResponseRate is the 0-100 percentage of the petitions of the user tools answered by their
+	// response deadline, nil if there are none yet or in the user listings
+	ResponseRate *int32 `json:"responseRate"`
+	// Rating and WeightedRating are nil if the user has no ratings yet
+	Rating         *int32 `json:"rating"`
+	WeightedRating *int32 `json:"weightedRating"`
+}
+
+// UserSummaryResponse is the activity of a user shown on the profile page
+type UserSummaryResponse struct {
+	UserID string `json:"userId"`
+	// Tools is the number of tools owned by the user, without the deleted ones
+	Tools int64 `json:"tools"`
+	// CompletedBookings are the returned or transferred bookings of the user as owner and as requester
+	CompletedBookings *db.UserCompletedBookings `json:"completedBookings"`
+	// Rating is the 0-100 average of the ratings received, nil if the user has not been rated
+	Rating      *int32 `json:"rating"`
+	RatingCount int64  `json:"ratingCount"`
+}
+
+// UserReachResponse is the number of active users within the radius of the tools of the user
+type UserReachResponse struct {
+	Users  int64 `json:"users"`
+	Tools  int   `json:"tools"`
+	Radius int   `json:"radius"`
+}
+
+// DashboardObligations are the actions the user is expected to take
+type DashboardObligations struct {
+	// PendingRatings are the returned bookings the user did not rate yet
+	PendingRatings int64 `json:"pendingRatings"`
+	// OverdueReturns are the borrowed tools whose booking already ended
+	OverdueReturns int64 `json:"overdueReturns"`
+}
+
+// DashboardResponse gathers everything the app home screen shows about the user
+type DashboardResponse struct {
+	Profile             *UserResponse         `json:"profile"`
+	Tokens              uint64                `json:"tokens"`
+	UnreadNotifications int64                 `json:"unreadNotifications"`
+	Obligations         DashboardObligations  `json:"obligations"`
+	Bookings            *db.UserBookingCounts `json:"bookings"`
+}
+
 type UsersWrapper struct {
-	Users []db.User `json:"users"`
+	Users      []UserResponse `json:"users"`
+	Pagination *Pagination    `json:"pagination,omitempty"`
+}
+
+// LeaderboardEntry is a user of the leaderboards along with the activity the ranking is based on.
+type LeaderboardEntry struct {
+	*UserSummary
+	// Lends is the number of completed lends of the user tools
+	Lends int64 `json:"lends"`
+	// Ratings is the number of ratings received
+	Ratings int64 `json:"ratings"`
+}
+
+// LeaderboardResponse is a page of the users ranked by rating or completed lends
+type LeaderboardResponse struct {
+	Users      []LeaderboardEntry `json:"users"`
+	Pagination *Pagination        `json:"pagination"`
 }
 
 // Tool is the type of the tool
@@ -63,6 +182,145 @@ type Tool struct {
 	EstimatedValue   uint64           `json:"estimatedValue"`
 	Height           uint32           `json:"height"`
 	Weight           uint32           `json:"weight"`
+	// MinLeadHours is the notice in hours the owner needs before a booking starts, 0 means none
+	MinLeadHours *uint32 `json:"minLeadHours,omitempty"`
+	// DepositTokens are held from the requester while the tool is lent, 0 means no deposit
+	DepositTokens *uint64 `json:"depositTokens,omitempty"`
+	// AutoReturn marks the bookings as returned automatically once they end
+	AutoReturn *bool `json:"autoReturn,omitempty"`
+	// IsNomadic passes the tool from one borrower to the next instead of back to the owner
+	IsNomadic *bool `json:"isNomadic,omitempty"`
+	// Condition is the wear of the tool: new, good, fair or poor
+	Condition string `json:"condition,omitempty"`
+	// Terms the requesters must accept to book the tool, an empty text removes them
+	Terms *string `json:"terms,omitempty"`
+	// ResponseDeadlineHours is the time the owner commits to answer petitions in, 0 uses the default
+	ResponseDeadlineHours *uint32 `json:"responseDeadlineHours,omitempty"`
+}
+
+// ToolImportResult is the outcome of importing a row of a tool catalog.
+// Line is the line of the row in the CSV, starting at 1.
+type ToolImportResult struct {
+	Line  int    `json:"line"`
+	ID    int64  `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ToolImportResponse is the result of a tool catalog import, with one entry per row
+type ToolImportResponse struct {
+	Imported int                `json:"imported"`
+	Failed   int                `json:"failed"`
+	Results  []ToolImportResult `json:"results"`
+}
+
+// FeaturedToolRequest is the body used by admins to feature a tool in its owner community
+type FeaturedToolRequest struct {
+	Featured bool `json:"featured"`
+}
+
+// ToolUnavailabilityRequest marks a tool as not available for a period, in UNIX time
+type ToolUnavailabilityRequest struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// Force cancels the accepted bookings overlapping the period instead of failing
+	Force bool `json:"force,omitempty"`
+}
+
+// ToolUnavailabilityResponse are the unavailability periods of a tool, along with the accepted
+// bookings overlapping the requested period, which were cancelled if forced
+type ToolUnavailabilityResponse struct {
+	Unavailability []db.DateRange    `json:"unavailability"`
+	Conflicts      []BookingResponse `json:"conflicts"`
+}
+
+// NextAvailableResponse are the earliest dates a tool can be booked for the requested duration.
+// If none are free by the horizon, a unix time, available is false and there are no dates.
+type NextAvailableResponse struct {
+	ToolID    int64  `json:"toolId"`
+	Available bool   `json:"available"`
+	StartDate *int64 `json:"startDate,omitempty"`
+	EndDate   *int64 `json:"endDate,omitempty"`
+	Horizon   int64  `json:"horizon"`
+}
+
+// ToolPendingActionResponse is a tool of the user with petitions waiting for an answer
+type ToolPendingActionResponse struct {
+	Tool          db.Tool   `json:"tool"`
+	PendingCount  int64     `json:"pendingCount"`
+	OldestPending time.Time `json:"oldestPending"`
+}
+
+// ToolsPendingActionResponse lists the tools of the user needing action, oldest petitions first
+type ToolsPendingActionResponse struct {
+	Tools []ToolPendingActionResponse `json:"tools"`
+}
+
+// ToolOnLoanResponse is a tool of the user lent out right now, with who has it and until when
+type ToolOnLoanResponse struct {
+	Tool      db.Tool      `json:"tool"`
+	BookingID string       `json:"bookingId"`
+	Borrower  *UserSummary `json:"borrower,omitempty"`
+	// ExpectedReturn is the end date of the booking, in UNIX time
+	ExpectedReturn int64 `json:"expectedReturn"`
+}
+
+// ToolsOnLoanResponse lists the tools of the user lent out right now, first expected back first
+type ToolsOnLoanResponse struct {
+	Tools []ToolOnLoanResponse `json:"tools"`
+}
+
+// ToolPreview is the public metadata of a tool used to render link previews
+type ToolPreview struct {
+	ID          int64          `json:"id"`
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	ImageHash   types.HexBytes `json:"imageHash,omitempty"`
+	ImageURL    string         `json:"imageUrl,omitempty"`
+	Community   string         `json:"community,omitempty"`
+}
+
+// ToolEditResponse is an entry of the tool edit history
+type ToolEditResponse struct {
+	Field    string          `json:"field"`
+	OldValue json.RawMessage `json:"oldValue"`
+	NewValue json.RawMessage `json:"newValue"`
+	EditorID string          `json:"editorId"`
+	Date     time.Time       `json:"date"`
+}
+
+// ToolHistoryResponse is the edit history of a tool, oldest edits first
+type ToolHistoryResponse struct {
+	ToolID int64              `json:"toolId"`
+	Edits  []ToolEditResponse `json:"edits"`
+}
+
+// ToolStatsResponse are the statistics of a tool shown to its owner
+type ToolStatsResponse struct {
+	ToolID int64 `json:"toolId"`
+	// TimesBooked is the number of bookings that went ahead
+	TimesBooked int64 `json:"timesBooked"`
+	// Rating is the average rating received by the owner on the bookings of the tool, in the 0-100 range
+	Rating      int32 `json:"rating"`
+	RatingCount int64 `json:"ratingCount"`
+	// Ratings are the latest of those ratings, with their comments and images
+	Ratings []UserRatingResponse `json:"ratings"`
+	// Utilization is the percentage of time the tool was lent out over the last 90 days
+	Utilization    int32            `json:"utilization"`
+	CurrentBooking *BookingResponse `json:"currentBooking,omitempty"`
+	NextBooking    *BookingResponse `json:"nextBooking,omitempty"`
+}
+
+// ToolBookingResponse is a booking of a tool as shown to its owner, along with the requester
+type ToolBookingResponse struct {
+	BookingResponse
+	FromUser *UserSummary `json:"fromUser,omitempty"`
+}
+
+// ToolBookingsResponse is a page of the bookings of a tool, newest first
+type ToolBookingsResponse struct {
+	ToolID     int64                 `json:"toolId"`
+	Bookings   []ToolBookingResponse `json:"bookings"`
+	Pagination *Pagination           `json:"pagination"`
 }
 
 type ToolID struct {
@@ -70,18 +328,47 @@ type ToolID struct {
 }
 
 type ToolsWrapper struct {
-	Tools []db.Tool `json:"tools"`
+	Tools      []db.Tool   `json:"tools"`
+	Pagination *Pagination `json:"pagination,omitempty"`
 }
 
-// ToolSearch is the type of the tool search
+// Pagination is the pagination information of a listing response
+type Pagination struct {
+	Page     int   `json:"page"`
+	PageSize int   `json:"pageSize"`
+	Total    int64 `json:"total"`
+}
+
+// ToolSearch is the type of the tool search, see validate for the bounds of the filters
 type ToolSearch struct {
-	Term             string  `json:"term"`
-	Categories       []int   `json:"categories"`
-	Distance         int     `json:"distance"`
+	Term       string `json:"term"`
+	Categories []int  `json:"categories"`
+	// Distance is the search radius in meters around the user, zero uses the default of the user community
+	Distance int `json:"distance"`
+	// MaxCost excludes the tools costing more tokens per day, nil means any cost
 	MaxCost          *uint64 `json:"maxCost"`
 	MayBeFree        *bool   `json:"mayBeFree"`
 	AvailableFrom    int     `json:"availableFrom"`
 	TransportOptions []int   `json:"transportOptions"`
+	// AvailableTo ends the period, from AvailableFrom, the tools must be free to be booked, both
+	// in unix seconds. Without it the tools must be free at AvailableFrom, without AvailableFrom from now.
+	AvailableTo int `json:"availableTo,omitempty"`
+	// MinCondition excludes the tools in a worse condition, empty means any condition
+	MinCondition string `json:"minCondition,omitempty"`
+	// Community restricts the results to the tools of the owners in the community, empty means any community
+	Community string `json:"community,omitempty"`
+}
+
+// SearchResult is an item of the unified search, either a tool or a user depending on Type
+type SearchResult struct {
+	Type string       `json:"type"`
+	Tool *ToolSummary `json:"tool,omitempty"`
+	User *UserSummary `json:"user,omitempty"`
+}
+
+// SearchResponse is the result of the unified search, tools first and then users
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
 }
 
 type Info struct {
@@ -89,15 +376,72 @@ type Info struct {
 	Tools      int               `json:"tools"`
 	Categories []db.ToolCategory `json:"categories"`
 	Transports []db.Transport    `json:"transports"`
+	// ToolsByCategory is the number of tools of each category, indexed by category ID. Every
+	// category is present, even without tools. Deleted tools are not counted.
+	ToolsByCategory map[int]int64 `json:"toolsByCategory"`
+}
+
+// ReturnBookingRequest is the optional body of a return. The owner can claim part of the
+// deposit if the tool came back damaged.
+type ReturnBookingRequest struct {
+	DepositClaim uint64 `json:"depositClaim,omitempty"`
+}
+
+// TokenTransferRequest is the request to send tokens to another user.
+type TokenTransferRequest struct {
+	ToUserID string `json:"toUserId"`
+	Amount   uint64 `json:"amount"`
+}
+
+// TokenBalanceResponse is the token balance of the user.
+type TokenBalanceResponse struct {
+	Tokens uint64 `json:"tokens"`
 }
 
-// CreateBookingRequest represents the request to create a new booking
+// CommunityEvent is an entry of the community activity feed. Lends only show the tool,
+// the users involved are not disclosed.
+type CommunityEvent struct {
+	Type string       `json:"type"`
+	Date time.Time    `json:"date"`
+	Tool *ToolSummary `json:"tool,omitempty"`
+	User *UserSummary `json:"user,omitempty"`
+}
+
+// CommunityFeedResponse is a page of the community activity feed, newest events first
+type CommunityFeedResponse struct {
+	Community  string           `json:"community"`
+	Events     []CommunityEvent `json:"events"`
+	Pagination *Pagination      `json:"pagination"`
+}
+
+// BookingStatusesInfo lists the booking statuses and the transitions allowed between them.
+type BookingStatusesInfo struct {
+	Statuses    []string            `json:"statuses"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+// CreateBookingRequest represents the request to create a new booking.
+// Leaving both dates unset creates an open request.
 type CreateBookingRequest struct {
 	ToolID    string `json:"toolId"`
 	StartDate int64  `json:"startDate"`
 	EndDate   int64  `json:"endDate"`
 	Contact   string `json:"contact"`
 	Comments  string `json:"comments"`
+	// ToolIDs requests several tools of the same owner at once as a kit, ToolID is then ignored
+	ToolIDs []string `json:"toolIds,omitempty"`
+	// Transfer requests to keep the tool: it is handed over to the requester instead of returned
+	Transfer bool `json:"transfer,omitempty"`
+	// AcceptedTerms must be set to book tools with terms
+	AcceptedTerms bool `json:"acceptedTerms,omitempty"`
+	// Transport is the ID of the transport option to bring the tool, one of the tool options.
+	// Its cost is estimated on the booking, kits do not support it.
+	Transport int64 `json:"transport,omitempty"`
+	// Free asks to borrow the tools for free, which is only possible if they may be free
+	Free bool `json:"free,omitempty"`
+	// Cost is the cost per day offered, up to the tool cost, which is the default. Zero is the same
+	// as asking for free. Kits and transfers are always at the cost of the tools.
+	Cost *uint64 `json:"cost,omitempty"`
 }
 
 // BookingResponse represents the API response for a booking
@@ -106,11 +450,227 @@ type BookingResponse struct {
 	ToolID        string    `json:"toolId"`
 	FromUserID    string    `json:"fromUserId"`
 	ToUserID      string    `json:"toUserId"`
-	StartDate     int64     `json:"startDate"`
-	EndDate       int64     `json:"endDate"`
+	StartDate     *int64    `json:"startDate"`
+	EndDate       *int64    `json:"endDate"`
 	Contact       string    `json:"contact"`
 	Comments      string    `json:"comments"`
 	BookingStatus string    `json:"bookingStatus"`
+	GroupID       string    `json:"groupId,omitempty"`
 	CreatedAt     time.Time `json:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt"`
+	// Cost is the breakdown of the tokens charged, available once the tool is returned
+	Cost *BookingCost `json:"cost,omitempty"`
+	// Orphaned is set on bookings whose tool or users no longer exist
+	Orphaned bool `json:"orphaned,omitempty"`
+	// Transfer is set on requests to keep the tool, which are handed over instead of returned
+	Transfer bool `json:"transfer,omitempty"`
+	// RejectionReason is set on bookings rejected automatically, such as when the tool was booked
+	// by another request for the same dates
+	RejectionReason string `json:"rejectionReason,omitempty"`
+	// AcceptedTerms is the version of the tool terms accepted by the requester and when
+	AcceptedTerms *db.TermsAcceptance `json:"acceptedTerms,omitempty"`
+	// Transport is the transport option chosen by the requester with its estimated cost
+	Transport *db.BookingTransport `json:"transport,omitempty"`
+	// Free is set if the requester asked to borrow the tool for free
+	Free bool `json:"free,omitempty"`
+	// AgreedCost is the cost per day agreed when booking, unset on old bookings
+	AgreedCost *uint64 `json:"agreedCost,omitempty"`
+	// ResponseDue is when the owner is expected to answer the petition by, unset on open requests
+	ResponseDue *time.Time `json:"responseDue,omitempty"`
+	// Reschedule is the new dates proposed by one of the parties, waiting for the other one
+	Reschedule *db.RescheduleProposal `json:"reschedule,omitempty"`
+}
+
+// Roles of a user in a booking
+const (
+	BookingRoleOwner     = "owner"
+	BookingRoleRequester = "requester"
+)
+
+// BookingHistoryEntry is a booking along with the role of the user in it
+type BookingHistoryEntry struct {
+	BookingResponse
+	// Role is "owner" if the user lends the tool and "requester" if the user borrows it
+	Role string `json:"role"`
+}
+
+// BookingHistoryResponse is a page of the bookings of a user in both roles, newest first
+type BookingHistoryResponse struct {
+	Bookings   []BookingHistoryEntry `json:"bookings"`
+	Pagination *Pagination           `json:"pagination"`
+}
+
+// BookingEventResponse is the data of the events sent on GET /bookings/events
+type BookingEventResponse struct {
+	// Type is "created" for new bookings and "status" for status changes
+	Type    string          `json:"type"`
+	Booking BookingResponse `json:"booking"`
+}
+
+// BookingReceiptResponse is the summary of a returned booking shared by both parties.
+// The cost is in the booking and the ratings are the ones given by each party, if any.
+type BookingReceiptResponse struct {
+	Booking         BookingResponse `json:"booking"`
+	Tool            *ToolSummary    `json:"tool,omitempty"`
+	Requester       *UserSummary    `json:"requester,omitempty"`
+	Owner           *UserSummary    `json:"owner,omitempty"`
+	ReturnedAt      time.Time       `json:"returnedAt"`
+	RequesterRating *RatingResponse `json:"requesterRating,omitempty"`
+	OwnerRating     *RatingResponse `json:"ownerRating,omitempty"`
+}
+
+// BookingCost is the breakdown of the tokens charged for a booking
+type BookingCost struct {
+	Days       uint64 `json:"days"`
+	CostPerDay uint64 `json:"costPerDay"`
+	Total      uint64 `json:"total"`
+	Free       bool   `json:"free"`
+	AskWithFee bool   `json:"askWithFee"`
+	// Outstanding is set while the requester could not pay the total to the owner
+	Outstanding bool `json:"outstanding,omitempty"`
+}
+
+// BookingGroupResponse represents the API response for a kit, a group of bookings
+// made together and handled as a whole
+type BookingGroupResponse struct {
+	GroupID  string            `json:"groupId"`
+	Bookings []BookingResponse `json:"bookings"`
+}
+
+// BookingDatesRequest is the body used to set the dates of an open booking
+type BookingDatesRequest struct {
+	StartDate int64 `json:"startDate"`
+	EndDate   int64 `json:"endDate"`
+}
+
+// BookingMessageRequest is the body used to send a message on a booking
+type BookingMessageRequest struct {
+	Text string `json:"text"`
+}
+
+// UserSummary is the public summary of a user embedded in other responses.
+type UserSummary struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	Community  string         `json:"community,omitempty"`
+	AvatarHash types.HexBytes `json:"avatarHash,omitempty"`
+	Rating     int32          `json:"rating"`
+}
+
+// ToolSummary is the short version of a tool embedded in other responses.
+type ToolSummary struct {
+	ID           int64            `json:"id"`
+	Title        string           `json:"title"`
+	ToolCategory int              `json:"toolCategory"`
+	Images       []types.HexBytes `json:"images,omitempty"`
+}
+
+// PendingRatingResponse is a booking waiting to be rated by the user, along with
+// the information the client needs to render it.
+type PendingRatingResponse struct {
+	BookingResponse
+	Counterparty *UserSummary `json:"counterparty,omitempty"`
+	Tool         *ToolSummary `json:"tool,omitempty"`
+}
+
+// AdminBookingResponse is a booking along with the summaries of its tool and both parties
+type AdminBookingResponse struct {
+	BookingResponse
+	Tool     *ToolSummary `json:"tool,omitempty"`
+	FromUser *UserSummary `json:"fromUser,omitempty"`
+	ToUser   *UserSummary `json:"toUser,omitempty"`
+}
+
+// AdminOrphanBookingResponse is a booking whose tool or users no longer exist
+type AdminOrphanBookingResponse struct {
+	BookingResponse
+	MissingTool     bool `json:"missingTool"`
+	MissingFromUser bool `json:"missingFromUser"`
+	MissingToUser   bool `json:"missingToUser"`
+}
+
+// AdminOrphansResponse lists the orphan bookings
+type AdminOrphansResponse struct {
+	Bookings []AdminOrphanBookingResponse `json:"bookings"`
+}
+
+// AdminOrphansFixResponse is the result of fixing the orphan bookings
+type AdminOrphansFixResponse struct {
+	Orphaned  int   `json:"orphaned"`
+	Cancelled int64 `json:"cancelled"`
+}
+
+// AdminBookingsResponse is a page of bookings returned to admins
+type AdminBookingsResponse struct {
+	Bookings   []AdminBookingResponse `json:"bookings"`
+	Pagination *Pagination            `json:"pagination"`
+}
+
+// AuditLogResponse is a page of the audit log
+type AuditLogResponse struct {
+	Entries    []*db.AuditEntry `json:"entries"`
+	Pagination *Pagination      `json:"pagination"`
+}
+
+// InviteResponse is a registration invite returned to admins. The token is only returned when the
+// invite is minted, it cannot be recovered later.
+type InviteResponse struct {
+	*db.Invite
+	Token string `json:"token,omitempty"`
+}
+
+// InvitesResponse is a page of the registration invites
+type InvitesResponse struct {
+	Invites    []*db.Invite `json:"invites"`
+	Pagination *Pagination  `json:"pagination"`
+}
+
+// RatingResponse represents the API response for a rating
+type RatingResponse struct {
+	ID         string           `json:"id"`
+	BookingID  string           `json:"bookingId"`
+	FromUserID string           `json:"fromUserId"`
+	ToUserID   string           `json:"toUserId"`
+	Rating     int              `json:"rating"`
+	Comment    string           `json:"comment,omitempty"`
+	Images     []types.HexBytes `json:"images,omitempty"`
+	Anonymous  bool             `json:"anonymous,omitempty"`
+	CreatedAt  time.Time        `json:"createdAt"`
+}
+
+// UserRatingResponse is a rating received by a user, as shown on the user profile.
+// The rater is omitted if ratings are anonymous.
+type UserRatingResponse struct {
+	Rating    int              `json:"rating"`
+	Comment   string           `json:"comment,omitempty"`
+	Images    []types.HexBytes `json:"images,omitempty"`
+	Rater     *UserSummary     `json:"rater,omitempty"`
+	Anonymous bool             `json:"anonymous,omitempty"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// UserRatingsResponse is a page of the ratings received by a user
+type UserRatingsResponse struct {
+	Ratings    []UserRatingResponse `json:"ratings"`
+	Pagination *Pagination          `json:"pagination"`
+}
+
+// AcceptPreviewResponse is the projected outcome of accepting a petition, nothing is changed.
+// Tokens is what the requester pays on return (or hand over) and Deposit what is held on accepting.
+// Conflicts are the other pending petitions for the same tools and dates, which are rejected
+// when this one is accepted.
+type AcceptPreviewResponse struct {
+	Tokens    uint64            `json:"tokens"`
+	Deposit   uint64            `json:"deposit"`
+	Conflicts []BookingResponse `json:"conflicts"`
+	Warnings  []string          `json:"warnings"`
+}
+
+// RatingPreviewResponse is the projected aggregated rating of a user if a rating was submitted.
+// Ratings are in the 0-100 range of the user profile, CurrentRating is nil if the user has no ratings yet.
+type RatingPreviewResponse struct {
+	UserID        string `json:"userId"`
+	CurrentRating *int32 `json:"currentRating"`
+	NewRating     int32  `json:"newRating"`
+	RatingsCount  int64  `json:"ratingsCount"`
 }
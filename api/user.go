@@ -3,8 +3,17 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/emprius/emprius-app-backend/db"
 	"github.com/rs/zerolog/log"
@@ -13,25 +22,32 @@ import (
 )
 
 // registerHandler handles the register request. It creates a new user in the database.
+// The registration token required depends on Options.RegistrationMode.
 func (a *API) registerHandler(r *Request) (interface{}, error) {
 	userInfo := Register{}
 	if err := json.Unmarshal(r.Data, &userInfo); err != nil {
 		return nil, ErrInvalidRequestBodyData
 	}
-	if userInfo.RegisterAuthToken != a.registerAuthToken {
-		return nil, ErrInvalidRegisterAuthToken
+	userID := primitive.NewObjectID()
+	release, err := a.checkRegistration(r.Context.Request.Context(), userInfo.RegisterAuthToken, userID)
+	if err != nil {
+		return nil, err
 	}
 	user := db.User{
-		Email:    userInfo.UserEmail,
-		Password: hashPassword(userInfo.Password),
-		Name:     userInfo.Name,
-		Active:   true,
-		Rating:   50,
-		Tokens:   1000,
+		ID:             userID,
+		Email:          userInfo.UserEmail,
+		Password:       hashPassword(userInfo.Password),
+		Name:           userInfo.Name,
+		Active:         true,
+		Verified:       false, // until POST /verify
+		Rating:         db.DefaultUserRating,
+		Tokens:         1000,
+		WeightedRating: db.DefaultUserRating,
 	}
 	if userInfo.Avatar != nil {
 		image, err := a.addImage(userInfo.Name+"_avatar", userInfo.Avatar)
 		if err != nil {
+			release()
 			return nil, fmt.Errorf("could not add image: %w", err)
 		}
 		user.AvatarHash = image.Hash
@@ -41,16 +57,14 @@ func (a *API) registerHandler(r *Request) (interface{}, error) {
 	}
 
 	if err := a.addUser(&user); err != nil {
+		release()
 		return nil, fmt.Errorf("could not add user: %w", err)
 	}
-
-	// Generate a new token with the user name as the subject
-	token, err := a.makeToken(user.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+	// The user can ask for another verification on POST /verify/resend
+	if err := a.sendVerification(r.Context.Request.Context(), &user); err != nil {
+		log.Warn().Err(err).Msgf("could not send verification to %s", user.Email)
 	}
-
-	return &token, nil
+	return a.issueTokens(r.Context.Request.Context(), &user)
 }
 
 func (a *API) addUser(u *db.User) error {
@@ -76,59 +90,533 @@ func (a *API) loginHandler(r *Request) (interface{}, error) {
 	if !bytes.Equal(user.Password, hashPassword(loginInfo.Password)) {
 		return nil, fmt.Errorf("invalid credentials")
 	}
+	if user.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+	return a.issueTokens(r.Context.Request.Context(), user)
+}
+
+// passwordResetRequestHandler creates a single-use password reset token for the user with the
+// given email and sends it with Options.SendPasswordReset. It succeeds even if there is no such
+// user, so the response does not reveal which emails are registered.
+func (a *API) passwordResetRequestHandler(r *Request) (interface{}, error) {
+	req := PasswordResetRequest{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.Email == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	if a.opts.SendPasswordReset == nil {
+		return nil, ErrMailUnavailable
+	}
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("could not generate password reset token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenHash := sha256.Sum256([]byte(token))
+	err := a.database.UserService.SetPasswordReset(r.Context.Request.Context(), req.Email, tokenHash[:],
+		time.Now().Add(a.opts.PasswordResetTTL))
+	if errors.Is(err, db.ErrUserNotFound) {
+		log.Debug().Msgf("password reset requested for unknown email %s", req.Email)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not store password reset: %w", err)
+	}
+	if err := a.opts.SendPasswordReset(req.Email, token); err != nil {
+		log.Warn().Err(err).Msgf("could not send password reset to %s", req.Email)
+	}
+	return nil, nil
+}
+
+// passwordResetConfirmHandler sets the new password of the user the reset token was issued to.
+// The token can only be used once.
+func (a *API) passwordResetConfirmHandler(r *Request) (interface{}, error) {
+	req := PasswordResetConfirm{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.Token == "" || req.Password == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	ctx := r.Context.Request.Context()
+	tokenHash := sha256.Sum256([]byte(req.Token))
+	user, err := a.database.UserService.ConsumePasswordReset(ctx, tokenHash[:], time.Now())
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPasswordReset) {
+			return nil, ErrInvalidPasswordReset
+		}
+		return nil, fmt.Errorf("could not query password reset: %w", err)
+	}
+	if err := a.database.UserService.UpdatePassword(ctx, user.ID, hashPassword(req.Password)); err != nil {
+		return nil, fmt.Errorf("could not update password: %w", err)
+	}
+	// Whoever had the old password must not keep a session
+	if err := a.database.RefreshTokenService.RevokeAll(ctx, user.ID); err != nil {
+		return nil, fmt.Errorf("could not revoke refresh tokens: %w", err)
+	}
+	return nil, nil
+}
+
+// sendVerification creates a single-use email verification token for the user, replacing any previous
+// one, and sends it with Options.SendVerification.
+func (a *API) sendVerification(ctx context.Context, user *db.User) error {
+	if a.opts.SendVerification == nil {
+		return ErrMailUnavailable
+	}
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("could not generate verification token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenHash := sha256.Sum256([]byte(token))
+	if err := a.database.UserService.SetVerification(ctx, user.ID, tokenHash[:],
+		time.Now().Add(a.opts.VerificationTTL)); err != nil {
+		return fmt.Errorf("could not store verification: %w", err)
+	}
+	return a.opts.SendVerification(user.Email, token)
+}
+
+// verifyHandler marks as verified the user the verification token was sent to.
+// The token can only be used once.
+func (a *API) verifyHandler(r *Request) (interface{}, error) {
+	req := VerifyRequest{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.Token == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	ctx := r.Context.Request.Context()
+	tokenHash := sha256.Sum256([]byte(req.Token))
+	user, err := a.database.UserService.MarkVerified(ctx, tokenHash[:], time.Now())
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidVerification) {
+			return nil, ErrInvalidVerification
+		}
+		return nil, fmt.Errorf("could not verify user: %w", err)
+	}
+	// The admins of the options registering later are granted once they prove they own the email
+	if slices.Contains(a.opts.Admins, user.Email) {
+		if err := a.database.UserService.GrantAdmin(ctx, []string{user.Email}); err != nil {
+			return nil, fmt.Errorf("could not grant admin privileges: %w", err)
+		}
+	}
+	return nil, nil
+}
 
-	// Generate a new token with the user name as the subject
-	token, err := a.makeToken(user.Email)
+// verifyResendHandler sends a new verification token to the caller, the previous one stops working.
+func (a *API) verifyResendHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, ErrUserNotFound
+	}
+	if user.Verified {
+		return nil, ErrAlreadyVerified
+	}
+	if err := a.sendVerification(r.Context.Request.Context(), user); err != nil {
+		return nil, err
 	}
+	return nil, nil
+}
 
-	return &token, nil
+// verifiedHandler wraps a handler so it is only executed for users who verified their email,
+// if Options.RequireVerification is set.
+func (a *API) verifiedHandler(handlerFunc RouterHandlerFn) RouterHandlerFn {
+	return func(r *Request) (interface{}, error) {
+		if !a.opts.RequireVerification {
+			return handlerFunc(r)
+		}
+		if r.UserID == "" {
+			return nil, ErrUnauthorized
+		}
+		user, err := a.userByEmail(r.UserID)
+		if err != nil {
+			return nil, ErrUserNotFound
+		}
+		if !user.Verified {
+			return nil, ErrUserNotVerified
+		}
+		return handlerFunc(r)
+	}
 }
 
-// refresh handles the refresh request. It returns a new JWT token.
+// refreshHandler exchanges a refresh token for a new access token and a new refresh token.
+// The refresh token is consumed, so a leaked one stops working once its owner refreshes.
 func (a *API) refreshHandler(r *Request) (interface{}, error) {
-	// Generate a new token with the user name as the subject
-	token, err := a.makeToken(r.UserID)
+	req := RefreshRequest{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.RefreshToken == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	ctx := r.Context.Request.Context()
+	refreshToken, err := a.database.RefreshTokenService.Consume(ctx, refreshTokenHash(req.RefreshToken), time.Now())
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidRefreshToken) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("could not query refresh token: %w", err)
+	}
+	user, err := a.database.UserService.GetUserByID(ctx, refreshToken.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.Deactivated {
+		return nil, ErrUserDeactivated
+	}
+	return a.issueTokens(ctx, user)
+}
+
+// logoutHandler revokes the refresh token of the caller, so it can no longer be refreshed.
+// The access token stays valid until it expires.
+func (a *API) logoutHandler(r *Request) (interface{}, error) {
+	req := RefreshRequest{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.RefreshToken == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	user, err := a.userByEmail(r.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, ErrUserNotFound
+	}
+	if err := a.database.RefreshTokenService.Revoke(r.Context.Request.Context(), user.ID,
+		refreshTokenHash(req.RefreshToken)); err != nil {
+		return nil, ErrInternalServerError
 	}
+	return nil, nil
+}
 
-	return &token, nil
+// deleteAccountHandler handles DELETE /profile
+// It deletes the account of the caller, confirmed with their password, see db.UserService.DeleteAccount.
+// The sessions of the user are revoked. Accounts with accepted bookings cannot be deleted.
+func (a *API) deleteAccountHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	req := DeleteAccountRequest{}
+	if err := json.Unmarshal(r.Data, &req); err != nil || req.Password == "" {
+		return nil, ErrInvalidRequestBodyData
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if !bytes.Equal(user.Password, hashPassword(req.Password)) {
+		return nil, ErrWrongLogin
+	}
+	ctx := r.Context.Request.Context()
+	if err := a.database.UserService.DeleteAccount(ctx, user.ID); err != nil {
+		if errors.Is(err, db.ErrAccountHasAcceptedBookings) {
+			return nil, ErrAccountHasAcceptedBookings
+		}
+		return nil, ErrInternalServerError
+	}
+	if err := a.database.RefreshTokenService.RevokeAll(ctx, user.ID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	log.Info().Msgf("user %s deleted their account", user.ID.Hex())
+	return nil, nil
 }
 
-// usersHandler list the existing users.
+// usersHandler lists a page of the existing users, sorted by name. The search parameter keeps the
+// users whose name contains it, ignoring case, community the users of that community, and active
+// and verified, true or false, the users with those flags.
+// Deleted users are only listed for admins requesting them with includeDeleted=true.
 func (a *API) usersHandler(r *Request) (interface{}, error) {
-	users, err := a.database.UserService.GetAllUsers(context.Background())
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	active, err := boolParam(r, "active")
+	if err != nil {
+		return nil, err
+	}
+	verified, err := boolParam(r, "verified")
+	if err != nil {
+		return nil, err
+	}
+	search := strings.TrimSpace(r.Context.QueryParam("search"))
+	community := r.Context.QueryParam("community")
+	users, total, err := a.database.UserService.SearchUsers(r.Context.Request.Context(),
+		search, community, active, verified, a.includeDeleted(r), page, pageSize)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query users: %w", err)
 	}
-	userList := make([]db.User, len(users))
-	for i, u := range users {
-		userList[i] = *u
+	userList := make([]UserResponse, 0, len(users))
+	for _, u := range users {
+		userList = append(userList, *convertUserToResponse(u))
 	}
-	return &UsersWrapper{Users: userList}, nil
+	return &UsersWrapper{
+		Users: userList,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// Leaderboard rankings
+const (
+	leaderboardByRating = "rating"
+	leaderboardByLends  = "lends"
+)
+
+// topUsersHandler handles GET /users/top?by=rating|lends&community=
+// It returns the users ranked by rating (the default) or by completed lends, optionally within a
+// community. Only the users with at least minLeaderboardCount ratings or lends respectively are ranked.
+func (a *API) topUsersHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	by := r.Context.QueryParam("by")
+	if by == "" {
+		by = leaderboardByRating
+	}
+	if by != leaderboardByRating && by != leaderboardByLends {
+		return nil, ErrInvalidRequestBodyData
+	}
+	community := r.Context.QueryParam("community")
+	ctx := r.Context.Request.Context()
+
+	lends, err := a.database.BookingService.CountLendsByOwner(ctx)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	var users []*db.User
+	var total int64
+	if by == leaderboardByRating {
+		users, total, err = a.database.UserService.GetTopRated(ctx, community, minLeaderboardCount, page, pageSize)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+	} else {
+		ownerIDs := []primitive.ObjectID{}
+		for ownerID, count := range lends {
+			if count >= minLeaderboardCount {
+				ownerIDs = append(ownerIDs, ownerID)
+			}
+		}
+		owners, err := a.database.UserService.GetUsersByIDs(ctx, ownerIDs)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		ranked := []*db.User{}
+		for _, owner := range owners {
+			if owner.Active && !owner.Deleted && (community == "" || owner.Community == community) {
+				ranked = append(ranked, owner)
+			}
+		}
+		sort.SliceStable(ranked, func(i, j int) bool {
+			if lends[ranked[i].ID] != lends[ranked[j].ID] {
+				return lends[ranked[i].ID] > lends[ranked[j].ID]
+			}
+			return ranked[i].ID.Hex() < ranked[j].ID.Hex()
+		})
+		users, total = paginate(ranked, page, pageSize), int64(len(ranked))
+	}
+
+	entries := make([]LeaderboardEntry, len(users))
+	for i, user := range users {
+		entries[i] = LeaderboardEntry{
+			UserSummary: convertUserToSummary(user),
+			Lends:       lends[user.ID],
+			Ratings:     user.RatingCount,
+		}
+	}
+	return &LeaderboardResponse{
+		Users: entries,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
 }
 
 // getUserHandler handles GET /users/{id}
+// Admins can get a deleted user with includeDeleted=true.
 func (a *API) getUserHandler(r *Request) (interface{}, error) {
 	userID, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
 	if err != nil {
-		return nil, &HTTPError{
-			Code:    404,
-			Message: "user not found",
-		}
+		return nil, ErrUserNotFound
 	}
 
 	user, err := a.database.UserService.GetUserByID(context.Background(), userID)
+	if err != nil || (user.Deleted && !a.includeDeleted(r)) {
+		return nil, ErrUserNotFound
+	}
+
+	return a.userResponse(r.Context.Request.Context(), user)
+}
+
+// userResponse adds the computed metrics to the user profile. The ratings are nil, instead of the
+// neutral default, for the users that have not been rated.
+func (a *API) userResponse(ctx context.Context, user *db.User) (*UserResponse, error) {
+	stats, err := a.database.BookingService.GetUserReliabilityStats(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	responses, err := a.database.BookingService.GetUserResponseStats(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	response := convertUserToResponse(user)
+	response.Reliability = stats.Score(*a.opts.ReliabilityWeights)
+	response.ResponseRate = responses.Rate()
+	return response, nil
+}
+
+// convertUserToResponse converts a db.User to the UserResponse returned to clients, without the
+// computed metrics nor the email. The ratings are nil, instead of the neutral default, for the users
+// that have not been rated.
+func convertUserToResponse(user *db.User) *UserResponse {
+	response := &UserResponse{
+		ID:          user.ID.Hex(),
+		Name:        user.Name,
+		Community:   user.Community,
+		Tokens:      user.Tokens,
+		Active:      user.Active,
+		Verified:    user.Verified,
+		Admin:       user.Admin,
+		Deleted:     user.Deleted,
+		Deactivated: user.Deactivated,
+		AvatarHash:  user.AvatarHash,
+		Location:    user.Location,
+		RatingCount: user.RatingCount,
+	}
+	if user.RatingCount > 0 {
+		response.Rating, response.WeightedRating = &user.Rating, &user.WeightedRating
+	}
+	return response
+}
+
+// getUserSummaryHandler handles GET /users/{id}/summary
+// It returns the number of tools of the user, their completed bookings and their average rating.
+func (a *API) getUserSummaryHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	userID, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	ctx := r.Context.Request.Context()
+	user, err := a.database.UserService.GetUserByID(ctx, userID)
+	if err != nil || (user.Deleted && !a.includeDeleted(r)) {
+		return nil, ErrUserNotFound
+	}
+
+	summary := &UserSummaryResponse{UserID: user.ID.Hex()}
+	if summary.Tools, err = a.database.ToolService.CountUserTools(ctx, user.ID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	if summary.CompletedBookings, err = a.database.BookingService.CountUserCompleted(ctx, user.ID); err != nil {
+		return nil, ErrInternalServerError
+	}
+	average, err := a.database.RatingService.GetUserAverage(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	summary.RatingCount = average.Count
+	if average.Count > 0 {
+		rating := average.Value()
+		summary.Rating = &rating
+	}
+	return summary, nil
+}
+
+// getUserRatingsHistogramHandler handles GET /users/{id}/ratings/histogram
+func (a *API) getUserRatingsHistogramHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	userID, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
 	if err != nil {
-		return nil, &HTTPError{
-			Code:    404,
-			Message: "user not found",
+		return nil, ErrUserNotFound
+	}
+	if _, err := a.database.UserService.GetUserByID(r.Context.Request.Context(), userID); err != nil {
+		return nil, ErrUserNotFound
+	}
+	histogram, err := a.database.RatingService.GetUserHistogram(r.Context.Request.Context(), userID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return histogram, nil
+}
+
+// getUserRatingsHandler handles GET /users/{id}/ratings
+// It returns a page of the ratings received by the user, newest first.
+func (a *API) getUserRatingsHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	userID, err := primitive.ObjectIDFromHex(r.Context.URLParam("id"))
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	page, pageSize, err := paginationParams(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context.Request.Context()
+	if _, err := a.database.UserService.GetUserByID(ctx, userID); err != nil {
+		return nil, ErrUserNotFound
+	}
+	ratings, total, err := a.database.RatingService.GetUserRatings(ctx, userID, page, pageSize)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	responses, err := a.userRatingResponses(ctx, ratings)
+	if err != nil {
+		return nil, err
+	}
+	return &UserRatingsResponse{
+		Ratings: responses,
+		Pagination: &Pagination{
+			Page:     page,
+			PageSize: pageSize,
+			Total:    total,
+		},
+	}, nil
+}
+
+// userRatingResponses converts the ratings to the responses shown on the profiles, along with their
+// raters unless the ratings are anonymous, see Options.AnonymousRatings.
+func (a *API) userRatingResponses(ctx context.Context, ratings []*db.Rating) ([]UserRatingResponse, error) {
+	ratersByID := map[primitive.ObjectID]*db.User{}
+	if !a.opts.AnonymousRatings {
+		raterIDs := []primitive.ObjectID{}
+		for _, rating := range ratings {
+			if !rating.Anonymous {
+				raterIDs = append(raterIDs, rating.FromUserID)
+			}
+		}
+		raters, err := a.database.UserService.GetUsersByIDs(ctx, raterIDs)
+		if err != nil {
+			return nil, ErrInternalServerError
+		}
+		for _, rater := range raters {
+			ratersByID[rater.ID] = rater
 		}
 	}
 
-	return user, nil
+	responses := make([]UserRatingResponse, len(ratings))
+	for i, rating := range ratings {
+		responses[i] = UserRatingResponse{
+			Rating:    rating.Rating,
+			Comment:   rating.Comment,
+			Images:    rating.Images,
+			Anonymous: rating.Anonymous,
+			CreatedAt: rating.CreatedAt,
+		}
+		switch rater, ok := ratersByID[rating.FromUserID]; {
+		case rating.Anonymous:
+			responses[i].Rater = &UserSummary{Name: anonymousRaterName}
+		case ok:
+			responses[i].Rater = convertUserToSummary(rater)
+		}
+	}
+	return responses, nil
 }
 
 func (a *API) userByEmail(userID string) (*db.User, error) {
@@ -139,10 +627,92 @@ func (a *API) userByEmail(userID string) (*db.User, error) {
 	return user, nil
 }
 
+// userReachHandler handles GET /profile/reach
+// It counts the active users within the radius (in meters) of any of the tools of the user.
+// The radius query parameter defaults to the search distance of the user community.
+func (a *API) userReachHandler(r *Request) (interface{}, error) {
+	if r.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	radius := a.searchDistance(user.Community)
+	if radiusStr := r.Context.QueryParam("radius"); radiusStr != "" {
+		if radius, err = strconv.Atoi(radiusStr); err != nil || radius <= 0 {
+			return nil, ErrInvalidRequestBodyData
+		}
+	}
+
+	ctx := r.Context.Request.Context()
+	tools, err := a.database.ToolService.GetToolsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	locations := make([]db.Location, len(tools))
+	for i, tool := range tools {
+		locations[i] = tool.Location
+	}
+	count, err := a.database.UserService.CountActiveUsersNear(ctx, locations, radius, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return &UserReachResponse{Users: count, Tools: len(tools), Radius: radius}, nil
+}
+
 func (a *API) userProfileHandler(r *Request) (interface{}, error) {
-	return a.userByEmail(r.UserID)
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := a.userResponse(r.Context.Request.Context(), user)
+	if err != nil {
+		return nil, err
+	}
+	profile.Email = user.Email
+	return profile, nil
 }
 
+// dashboardHandler returns the profile of the user along with the counts of everything needing
+// the user attention, so the app can render its home screen with a single request.
+func (a *API) dashboardHandler(r *Request) (interface{}, error) {
+	user, err := a.userByEmail(r.UserID)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context.Request.Context()
+	profile, err := a.userResponse(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	profile.Email = user.Email
+	bookings, err := a.database.BookingService.CountUserBookings(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	pendingRatings, err := a.database.BookingService.CountPendingRatings(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	unread, err := a.database.NotificationService.CountUnread(ctx, user.ID)
+	if err != nil {
+		return nil, ErrInternalServerError
+	}
+	return &DashboardResponse{
+		Profile:             profile,
+		Tokens:              user.Tokens,
+		UnreadNotifications: unread,
+		Obligations: DashboardObligations{
+			PendingRatings: pendingRatings,
+			OverdueReturns: bookings.OverdueBorrows,
+		},
+		Bookings: bookings,
+	}, nil
+}
+
+// userProfileUpdateHandler applies the fields present in the request to the profile of the user.
+// Omitted or empty fields keep their current value.
 func (a *API) userProfileUpdateHandler(r *Request) (interface{}, error) {
 	newUserInfo := UserProfile{}
 	if err := json.Unmarshal(r.Data, &newUserInfo); err != nil {
@@ -152,40 +722,50 @@ func (a *API) userProfileUpdateHandler(r *Request) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to query user profile: %w", err)
 	}
+	// Only the fields present in the request are applied, the rest of the profile is left untouched
+	update := bson.M{}
 	if newUserInfo.Name != "" {
 		user.Name = newUserInfo.Name
+		update["name"] = user.Name
 	}
 	if newUserInfo.Community != "" {
 		user.Community = newUserInfo.Community
+		update["community"] = user.Community
 	}
-	var avatar *db.Image
 	if len(newUserInfo.Avatar) > 0 {
-		avatar, err = a.addImage(user.Name+"_avatar", newUserInfo.Avatar)
+		avatar, err := a.addImage(user.Name+"_avatar", newUserInfo.Avatar)
 		if err != nil {
 			return nil, fmt.Errorf("could not add image: %w", err)
 		}
 		user.AvatarHash = avatar.Hash
+		update["avatarHash"] = user.AvatarHash
 	}
 	if newUserInfo.Location != nil {
 		user.Location = *newUserInfo.Location
+		update["location"] = user.Location
 	}
 	if newUserInfo.Active != nil {
 		user.Active = *newUserInfo.Active
+		update["active"] = user.Active
 	}
 	if newUserInfo.Password != "" {
 		user.Password = hashPassword(newUserInfo.Password)
+		update["password"] = user.Password
 	}
-	update := bson.M{
-		"name":       user.Name,
-		"avatarHash": user.AvatarHash,
-		"location":   user.Location,
-		"active":     user.Active,
-		"password":   user.Password,
-		"community":  user.Community,
+	profile := convertUserToResponse(user)
+	profile.Email = user.Email
+	if len(update) == 0 {
+		return profile, nil
 	}
 	_, err = a.database.UserService.UpdateUser(context.Background(), user.ID, update)
 	if err != nil {
 		return nil, fmt.Errorf(ErrCouldNotInsertToDatabase.Error()+": %w", err)
 	}
-	return &user, nil
+	// A new password signs out the other sessions, which need to log in again
+	if newUserInfo.Password != "" {
+		if err := a.database.RefreshTokenService.RevokeAll(context.Background(), user.ID); err != nil {
+			return nil, fmt.Errorf("could not revoke refresh tokens: %w", err)
+		}
+	}
+	return profile, nil
 }
@@ -0,0 +1,671 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestUserReach(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	// bob owns a tool 10km away from his location, where carol lives.
+	// alice is 200km away and dave lives next to the tool but is not active.
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	qt.Assert(t, a.addUser(&db.User{Name: "carol", Email: "carol@emprius.cat", Location: testLatitudeA}), qt.IsNil)
+	qt.Assert(t, a.addUser(&db.User{Name: "dave", Email: "dave@emprius.cat", Location: testLatitudeA10km}), qt.IsNil)
+	dave, err := a.database.UserService.GetUserByEmail(ctx, "dave@emprius.cat")
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.UserService.UpdateUser(ctx, dave.ID, bson.M{"active": false})
+	qt.Assert(t, err, qt.IsNil)
+
+	reach := func(email, query string) (*UserReachResponse, error) {
+		resp, err := a.userReachHandler(testRequest(t, "GET", "/profile/reach"+query, email, nil, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*UserReachResponse), nil
+	}
+
+	// Users without tools reach nobody
+	resp, err := reach(testUser1.Email, "")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp, qt.DeepEquals, &UserReachResponse{Users: 0, Tools: 0, Radius: a.opts.DefaultSearchDistance})
+
+	_, err = a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// The owner and inactive users are not counted
+	resp, err = reach(testUser1.Email, "?radius=5000")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Users, qt.Equals, int64(0))
+	qt.Assert(t, resp.Tools, qt.Equals, 1)
+	resp, err = reach(testUser1.Email, "?radius=15000")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Users, qt.Equals, int64(1))
+	qt.Assert(t, resp.Radius, qt.Equals, 15000)
+	resp, err = reach(testUser1.Email, "?radius=250000")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, resp.Users, qt.Equals, int64(2))
+
+	_, err = reach(testUser1.Email, "?radius=-1")
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+}
+
+func TestTopUsers(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	owners := []*db.User{
+		{Name: "carol", Email: "carol@emprius.cat", Community: "garden"},
+		{Name: "dave", Email: "dave@emprius.cat"},
+		{Name: "erin", Email: "erin@emprius.cat"},
+	}
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	for i, lends := range []int{3, 5, 1} {
+		owners[i].Active = true
+		qt.Assert(t, a.addUser(owners[i]), qt.IsNil)
+		owner, err := a.database.UserService.GetUserByEmail(ctx, owners[i].Email)
+		qt.Assert(t, err, qt.IsNil)
+		owners[i] = owner
+		for j := 0; j < lends; j++ {
+			start := time.Now().Add(time.Duration(j+1) * 48 * time.Hour)
+			booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+				ToolID:    fmt.Sprintf("%d", 1000+i),
+				StartDate: start,
+				EndDate:   start.Add(24 * time.Hour),
+			}, requester.ID, owner.ID)
+			qt.Assert(t, err, qt.IsNil)
+			setBookingStatusForTest(t, a, booking.ID, db.BookingStatusReturned)
+		}
+	}
+
+	top := func(query string) *LeaderboardResponse {
+		resp, err := a.topUsersHandler(testRequest(t, "GET", "/users/top"+query, testUser2.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*LeaderboardResponse)
+	}
+
+	// Most lends first, erin has too few lends to be ranked
+	board := top("?by=lends")
+	qt.Assert(t, board.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, board.Users, qt.HasLen, 2)
+	qt.Assert(t, board.Users[0].ID, qt.Equals, owners[1].ID.Hex())
+	qt.Assert(t, board.Users[0].Lends, qt.Equals, int64(5))
+	qt.Assert(t, board.Users[1].ID, qt.Equals, owners[0].ID.Hex())
+	qt.Assert(t, board.Users[1].Lends, qt.Equals, int64(3))
+
+	board = top("?by=lends&community=garden")
+	qt.Assert(t, board.Users, qt.HasLen, 1)
+	qt.Assert(t, board.Users[0].Name, qt.Equals, "carol")
+
+	// Nobody has enough ratings yet
+	qt.Assert(t, top("").Users, qt.HasLen, 0)
+
+	_, err = a.topUsersHandler(testRequest(t, "GET", "/users/top?by=tools", testUser2.Email, nil, nil))
+	qt.Assert(t, err, qt.Equals, ErrInvalidRequestBodyData)
+}
+
+func TestDashboard(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, a.database.UserService.AdjustTokens(ctx, owner.ID, 300), qt.IsNil)
+
+	dashboard := func(email string) *DashboardResponse {
+		resp, err := a.dashboardHandler(testRequest(t, "GET", "/dashboard", email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*DashboardResponse)
+	}
+	book := func(start time.Time) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: start,
+			EndDate:   start.Add(24 * time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return booking
+	}
+
+	// Nothing going on yet
+	resp := dashboard(testUser1.Email)
+	qt.Assert(t, resp.Profile.Email, qt.Equals, testUser1.Email)
+	qt.Assert(t, resp.Tokens, qt.Equals, uint64(300))
+	qt.Assert(t, resp.UnreadNotifications, qt.Equals, int64(0))
+	qt.Assert(t, resp.Obligations, qt.Equals, DashboardObligations{})
+	qt.Assert(t, *resp.Bookings, qt.Equals, db.UserBookingCounts{})
+
+	// A returned booking to rate, an accepted one not returned in time and a pending petition
+	returnedBookingForTest(t, a, 24*time.Hour)
+	overdue := book(time.Now().Add(72 * time.Hour))
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, overdue.ID, db.BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+	moveBookingForTest(t, a, overdue.ID.Hex(), time.Now().Add(-72*time.Hour), time.Now().Add(-48*time.Hour))
+	book(time.Now().Add(240 * time.Hour))
+	for _, read := range []bool{false, false, true} {
+		_, err := a.database.NotificationService.Create(ctx, &db.Notification{
+			UserID: owner.ID,
+			Type:   db.NotificationBookingCreated,
+			Read:   read,
+		})
+		qt.Assert(t, err, qt.IsNil)
+	}
+
+	resp = dashboard(testUser1.Email)
+	qt.Assert(t, resp.UnreadNotifications, qt.Equals, int64(2))
+	qt.Assert(t, resp.Obligations, qt.Equals, DashboardObligations{PendingRatings: 1})
+	qt.Assert(t, *resp.Bookings, qt.Equals, db.UserBookingCounts{PendingPetitions: 1, ActiveLends: 1})
+
+	resp = dashboard(testUser2.Email)
+	qt.Assert(t, resp.UnreadNotifications, qt.Equals, int64(0))
+	qt.Assert(t, resp.Obligations, qt.Equals, DashboardObligations{PendingRatings: 1, OverdueReturns: 1})
+	qt.Assert(t, *resp.Bookings, qt.Equals, db.UserBookingCounts{
+		PendingRequests: 1,
+		ActiveBorrows:   1,
+		OverdueBorrows:  1,
+	})
+}
+
+func TestUserProfilePartialUpdate(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	update := func(body string) *db.User {
+		_, err := a.userProfileUpdateHandler(testRequest(t, "POST", "/profile", testUser1.Email, json.RawMessage(body), nil))
+		qt.Assert(t, err, qt.IsNil)
+		user, err := a.database.UserService.GetUserByEmail(context.Background(), testUser1.Email)
+		qt.Assert(t, err, qt.IsNil)
+		return user
+	}
+
+	before := update(`{"password":"secret"}`)
+	qt.Assert(t, before.Password, qt.DeepEquals, hashPassword("secret"))
+
+	// Only the community changes
+	user := update(`{"community":"community2"}`)
+	qt.Assert(t, user.Community, qt.Equals, "community2")
+	qt.Assert(t, user.Name, qt.Equals, testUser1.Name)
+	qt.Assert(t, user.Location, qt.Equals, testUser1.Location)
+	qt.Assert(t, user.Active, qt.IsTrue)
+	qt.Assert(t, user.Password, qt.DeepEquals, before.Password)
+
+	// Deactivating keeps the location, and moving keeps the user inactive
+	user = update(`{"active":false}`)
+	qt.Assert(t, user.Active, qt.IsFalse)
+	qt.Assert(t, user.Location, qt.Equals, testUser1.Location)
+	user = update(`{"location":{"latitude":1000,"longitude":2000}}`)
+	qt.Assert(t, user.Location, qt.Equals, db.Location{Latitude: 1000, Longitude: 2000})
+	qt.Assert(t, user.Active, qt.IsFalse)
+	qt.Assert(t, user.Community, qt.Equals, "community2")
+	qt.Assert(t, user.Password, qt.DeepEquals, before.Password)
+
+	// An empty update changes nothing
+	qt.Assert(t, update(`{}`), qt.DeepEquals, user)
+}
+
+func TestPasswordReset(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+
+	// Without a sender the token could not be delivered
+	_, err := a.passwordResetRequestHandler(testRequest(t, "POST", "/password/reset/request", "",
+		&PasswordResetRequest{Email: testUser1.Email}, nil))
+	qt.Assert(t, err, qt.Equals, ErrMailUnavailable)
+
+	sent := map[string]string{}
+	a.opts.SendPasswordReset = func(email, token string) error {
+		sent[email] = token
+		return nil
+	}
+	request := func(email string) {
+		resp, err := a.passwordResetRequestHandler(testRequest(t, "POST", "/password/reset/request", "",
+			&PasswordResetRequest{Email: email}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		qt.Assert(t, resp, qt.IsNil)
+	}
+	confirm := func(token, password string) error {
+		_, err := a.passwordResetConfirmHandler(testRequest(t, "POST", "/password/reset/confirm", "",
+			&PasswordResetConfirm{Token: token, Password: password}, nil))
+		return err
+	}
+	login := func(password string) error {
+		_, err := a.loginHandler(testRequest(t, "POST", "/login", "",
+			&Login{Email: testUser1.Email, Password: password}, nil))
+		return err
+	}
+
+	// Unknown emails get the same response, and nothing is sent
+	request("nobody@emprius.cat")
+	qt.Assert(t, sent, qt.HasLen, 0)
+
+	// Only the last requested token is valid
+	request(testUser1.Email)
+	first := sent[testUser1.Email]
+	request(testUser1.Email)
+	token := sent[testUser1.Email]
+	qt.Assert(t, token, qt.Not(qt.Equals), first)
+	qt.Assert(t, confirm(first, "newpassword"), qt.Equals, ErrInvalidPasswordReset)
+	qt.Assert(t, confirm("bogus", "newpassword"), qt.Equals, ErrInvalidPasswordReset)
+
+	qt.Assert(t, confirm(token, "newpassword"), qt.IsNil)
+	qt.Assert(t, login("newpassword"), qt.IsNil)
+
+	// Tokens are single use
+	qt.Assert(t, confirm(token, "another"), qt.Equals, ErrInvalidPasswordReset)
+	qt.Assert(t, login("newpassword"), qt.IsNil)
+
+	// Expired tokens are rejected
+	a.opts.PasswordResetTTL = -time.Minute
+	request(testUser1.Email)
+	qt.Assert(t, confirm(sent[testUser1.Email], "another"), qt.Equals, ErrInvalidPasswordReset)
+	qt.Assert(t, login("newpassword"), qt.IsNil)
+}
+
+func TestEmailVerification(t *testing.T) {
+	a := testAPI(t)
+	a.opts.RequireVerification = true
+	a.opts.Admins = []string{"erin@emprius.cat"}
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	sent := map[string]string{}
+	a.opts.SendVerification = func(email, token string) error {
+		sent[email] = token
+		return nil
+	}
+	verify := func(token string) error {
+		_, err := a.verifyHandler(testRequest(t, "POST", "/verify", "", &VerifyRequest{Token: token}, nil))
+		return err
+	}
+	resend := func() error {
+		_, err := a.verifyResendHandler(testRequest(t, "POST", "/verify/resend", "erin@emprius.cat", nil, nil))
+		return err
+	}
+	book := func(token string) int {
+		code, _ := testHTTPRequest(t, router, http.MethodPost, "/bookings", token, &CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: time.Now().Add(24 * time.Hour).Unix(),
+			EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+		})
+		return code
+	}
+
+	// Registered users start unverified and are sent a token
+	code, resp := testHTTPRequest(t, router, http.MethodPost, "/register", "", &Register{
+		UserEmail:         "erin@emprius.cat",
+		RegisterAuthToken: "authtoken",
+		UserProfile:       UserProfile{Name: "erin", Password: "secret"},
+	})
+	token := testHTTPData[LoginResponse](t, code, resp).Token
+	first := sent["erin@emprius.cat"]
+	qt.Assert(t, first, qt.Not(qt.Equals), "")
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/profile", token, nil)
+	profile := testHTTPData[UserResponse](t, code, resp)
+	qt.Assert(t, profile.Verified, qt.IsFalse)
+	qt.Assert(t, profile.Admin, qt.IsFalse)
+	qt.Assert(t, book(token), qt.Equals, http.StatusForbidden)
+
+	// Resending replaces the token
+	qt.Assert(t, resend(), qt.IsNil)
+	second := sent["erin@emprius.cat"]
+	qt.Assert(t, second, qt.Not(qt.Equals), first)
+	qt.Assert(t, verify(first), qt.Equals, ErrInvalidVerification)
+	qt.Assert(t, verify("bogus"), qt.Equals, ErrInvalidVerification)
+
+	// Expired tokens are rejected
+	a.opts.VerificationTTL = -time.Minute
+	qt.Assert(t, resend(), qt.IsNil)
+	qt.Assert(t, verify(sent["erin@emprius.cat"]), qt.Equals, ErrInvalidVerification)
+	a.opts.VerificationTTL = time.Hour
+	qt.Assert(t, resend(), qt.IsNil)
+
+	// A valid token verifies the user once, who can then book
+	code, _ = testHTTPRequest(t, router, http.MethodPost, "/verify", "", &VerifyRequest{Token: sent["erin@emprius.cat"]})
+	qt.Assert(t, code, qt.Equals, http.StatusOK)
+	qt.Assert(t, verify(sent["erin@emprius.cat"]), qt.Equals, ErrInvalidVerification)
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/profile", token, nil)
+	profile = testHTTPData[UserResponse](t, code, resp)
+	qt.Assert(t, profile.Verified, qt.IsTrue)
+	qt.Assert(t, profile.Admin, qt.IsTrue)
+	qt.Assert(t, book(token), qt.Equals, http.StatusOK)
+	qt.Assert(t, resend(), qt.Equals, ErrAlreadyVerified)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	a := testAPI(t)
+	user := testUser1
+	user.Password = hashPassword("secret")
+	qt.Assert(t, a.addUser(&user), qt.IsNil)
+	login := func() *LoginResponse {
+		resp, err := a.loginHandler(testRequest(t, "POST", "/login", "",
+			&Login{Email: user.Email, Password: "secret"}, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*LoginResponse)
+	}
+	refresh := func(token string) (*LoginResponse, error) {
+		resp, err := a.refreshHandler(testRequest(t, "POST", "/refresh", "",
+			&RefreshRequest{RefreshToken: token}, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*LoginResponse), nil
+	}
+
+	// The refresh token gives a new access token and is rotated
+	session := login()
+	qt.Assert(t, session.RefreshToken, qt.Not(qt.Equals), "")
+	rotated, err := refresh(session.RefreshToken)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, rotated.Token, qt.Not(qt.Equals), "")
+	qt.Assert(t, rotated.RefreshToken, qt.Not(qt.Equals), session.RefreshToken)
+
+	// The old one no longer works, nor do unknown ones
+	_, err = refresh(session.RefreshToken)
+	qt.Assert(t, err, qt.Equals, ErrInvalidRefreshToken)
+	_, err = refresh("bogus")
+	qt.Assert(t, err, qt.Equals, ErrInvalidRefreshToken)
+
+	// Logging out revokes the current refresh token only
+	other := login()
+	_, err = a.logoutHandler(testRequest(t, "POST", "/logout", user.Email,
+		&RefreshRequest{RefreshToken: rotated.RefreshToken}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = refresh(rotated.RefreshToken)
+	qt.Assert(t, err, qt.Equals, ErrInvalidRefreshToken)
+	other, err = refresh(other.RefreshToken)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Changing the password revokes all of them
+	_, err = a.userProfileUpdateHandler(testRequest(t, "POST", "/profile", user.Email,
+		&UserProfile{Password: "newsecret"}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = refresh(other.RefreshToken)
+	qt.Assert(t, err, qt.Equals, ErrInvalidRefreshToken)
+
+	// Expired tokens are rejected
+	a.opts.RefreshExpiration = -time.Minute
+	user.Password = hashPassword("newsecret")
+	resp, err := a.loginHandler(testRequest(t, "POST", "/login", "",
+		&Login{Email: user.Email, Password: "newsecret"}, nil))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = refresh(resp.(*LoginResponse).RefreshToken)
+	qt.Assert(t, err, qt.Equals, ErrInvalidRefreshToken)
+}
+
+func TestRegisterThenBook(t *testing.T) {
+	a := testAPI(t)
+	router := a.router()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// A user registered through the API can log in, see their profile and book right away
+	code, resp := testHTTPRequest(t, router, http.MethodPost, "/register", "", &Register{
+		UserEmail:         "erin@emprius.cat",
+		RegisterAuthToken: "authtoken",
+		UserProfile:       UserProfile{Name: "erin", Community: "community1", Password: "secret"},
+	})
+	qt.Assert(t, testHTTPData[LoginResponse](t, code, resp).Token, qt.Not(qt.Equals), "")
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/login", "",
+		&Login{Email: "erin@emprius.cat", Password: "secret"})
+	token := testHTTPData[LoginResponse](t, code, resp).Token
+	code, resp = testHTTPRequest(t, router, http.MethodGet, "/profile", token, nil)
+	qt.Assert(t, testHTTPData[UserResponse](t, code, resp).Name, qt.Equals, "erin")
+
+	code, resp = testHTTPRequest(t, router, http.MethodPost, "/bookings", token, &CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", toolID),
+		StartDate: time.Now().Add(24 * time.Hour).Unix(),
+		EndDate:   time.Now().Add(48 * time.Hour).Unix(),
+	})
+	booking := testHTTPData[BookingResponse](t, code, resp)
+	erin, err := a.database.UserService.GetUserByEmail(context.Background(), "erin@emprius.cat")
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.FromUserID, qt.Equals, erin.ID.Hex())
+}
+
+func TestUserSearch(t *testing.T) {
+	a := testAPI(t)
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	carol := testUser1
+	carol.Name, carol.Email, carol.Community = "Carol", "carol@emprius.cat", "community2"
+	carol.Password = hashPassword("secret")
+	carol.Active, carol.Verified = false, false
+	qt.Assert(t, a.addUser(&carol), qt.IsNil)
+
+	list := func(query string) *UsersWrapper {
+		resp, err := a.usersHandler(testRequest(t, "GET", "/users"+query, testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.IsNil)
+		return resp.(*UsersWrapper)
+	}
+	names := func(w *UsersWrapper) []string {
+		result := []string{}
+		for _, u := range w.Users {
+			result = append(result, u.Name)
+		}
+		return result
+	}
+
+	// Sorted by name, with the search matched ignoring case
+	qt.Assert(t, names(list("")), qt.DeepEquals, []string{"Carol", "alice", "bob"})
+	qt.Assert(t, names(list("?search=AL")), qt.DeepEquals, []string{"alice"})
+	qt.Assert(t, names(list("?search=o")), qt.DeepEquals, []string{"Carol", "bob"})
+	qt.Assert(t, names(list("?community=community2")), qt.DeepEquals, []string{"Carol"})
+	qt.Assert(t, names(list("?search=o&community=community1")), qt.DeepEquals, []string{"bob"})
+	qt.Assert(t, names(list("?search=.*")), qt.HasLen, 0)
+
+	page := list("?pageSize=2&page=1")
+	qt.Assert(t, names(page), qt.DeepEquals, []string{"bob"})
+	qt.Assert(t, page.Pagination.Total, qt.Equals, int64(3))
+	qt.Assert(t, list("").Pagination.PageSize, qt.Equals, defaultPageSize)
+	qt.Assert(t, list("?pageSize=1000").Pagination.PageSize, qt.Equals, maxPageSize)
+
+	// Filtered by the active and verified flags, counted in the total
+	page = list("?active=true")
+	qt.Assert(t, names(page), qt.DeepEquals, []string{"alice", "bob"})
+	qt.Assert(t, page.Pagination.Total, qt.Equals, int64(2))
+	qt.Assert(t, names(list("?verified=false")), qt.DeepEquals, []string{"Carol"})
+	qt.Assert(t, names(list("?active=false&search=o")), qt.DeepEquals, []string{"Carol"})
+	qt.Assert(t, names(list("?active=true&verified=false")), qt.HasLen, 0)
+
+	// Invalid parameters are rejected
+	for _, query := range []string{"?page=-1", "?pageSize=0", "?pageSize=x", "?active=maybe", "?verified=2"} {
+		_, err := a.usersHandler(testRequest(t, "GET", "/users"+query, testUser1.Email, nil, nil))
+		qt.Assert(t, err, qt.Not(qt.IsNil), qt.Commentf(query))
+	}
+
+	// The password is never returned
+	data, err := json.Marshal(list("?community=community2"))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, strings.Contains(strings.ToLower(string(data)), "password"), qt.IsFalse)
+}
+
+func TestUserResponsesWithoutPassword(t *testing.T) {
+	a := testAPI(t)
+	user := testUser1
+	user.Password = hashPassword("secret")
+	qt.Assert(t, a.addUser(&user), qt.IsNil)
+	stored, err := a.database.UserService.GetUserByEmail(context.Background(), user.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	fields := func(resp interface{}, err error) map[string]any {
+		qt.Assert(t, err, qt.IsNil)
+		data, err := json.Marshal(resp)
+		qt.Assert(t, err, qt.IsNil)
+		result := map[string]any{}
+		qt.Assert(t, json.Unmarshal(data, &result), qt.IsNil)
+		return result
+	}
+	for name, profile := range map[string]map[string]any{
+		"profile": fields(a.userProfileHandler(testRequest(t, "GET", "/profile", user.Email, nil, nil))),
+		"user": fields(a.getUserHandler(testRequest(t, "GET", "/users/"+stored.ID.Hex(), user.Email, nil,
+			map[string]string{"id": stored.ID.Hex()}))),
+		"update": fields(a.userProfileUpdateHandler(testRequest(t, "POST", "/profile", user.Email,
+			&UserProfile{Community: "community2"}, nil))),
+	} {
+		qt.Assert(t, profile["name"], qt.Equals, user.Name, qt.Commentf(name))
+		qt.Assert(t, profile["id"], qt.Equals, stored.ID.Hex(), qt.Commentf(name))
+		_, ok := profile["password"]
+		qt.Assert(t, ok, qt.IsFalse, qt.Commentf(name))
+	}
+}
+
+func TestUserSummary(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	_, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	oldTool := testTool1
+	oldTool.Title = "old tool"
+	oldID, err := a.addTool(&oldTool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	summary := func(id string) (*UserSummaryResponse, error) {
+		resp, err := a.getUserSummaryHandler(testRequest(t, "GET", "/users/"+id+"/summary", testUser2.Email, nil,
+			map[string]string{"id": id}))
+		if err != nil {
+			return nil, err
+		}
+		return resp.(*UserSummaryResponse), nil
+	}
+
+	// A user without activity
+	got, err := summary(requester.ID.Hex())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got, qt.DeepEquals, &UserSummaryResponse{
+		UserID:            requester.ID.Hex(),
+		CompletedBookings: &db.UserCompletedBookings{},
+	})
+
+	// Only the completed bookings count, and the tools that are not archived
+	returned := returnedBookingForTest(t, a, 24*time.Hour)
+	cancelled := returnedBookingForTest(t, a, 3*24*time.Hour)
+	setBookingStatusForTest(t, a, cancelled.ID, db.BookingStatusCancelled)
+	old, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+		ToolID:    fmt.Sprintf("%d", oldID),
+		StartDate: time.Now().Add(24 * time.Hour),
+		EndDate:   time.Now().Add(48 * time.Hour),
+	}, requester.ID, owner.ID)
+	qt.Assert(t, err, qt.IsNil)
+	setBookingStatusForTest(t, a, old.ID, db.BookingStatusReturned)
+	_, err = a.deleteToolHandler(testRequest(t, "DELETE", fmt.Sprintf("/tools/%d", oldID), testUser1.Email, nil,
+		map[string]string{"id": fmt.Sprintf("%d", oldID)}))
+	qt.Assert(t, err, qt.IsNil)
+	_, err = a.database.RatingService.Create(ctx, &db.Rating{
+		BookingID: returned.ID, FromUserID: requester.ID, ToUserID: owner.ID, Rating: 4,
+	})
+	qt.Assert(t, err, qt.IsNil)
+
+	got, err = summary(owner.ID.Hex())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, got.Tools, qt.Equals, int64(1))
+	qt.Assert(t, *got.CompletedBookings, qt.Equals, db.UserCompletedBookings{AsOwner: 2})
+	qt.Assert(t, got.RatingCount, qt.Equals, int64(1))
+	qt.Assert(t, *got.Rating, qt.Equals, int32(80))
+	got, err = summary(requester.ID.Hex())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, *got.CompletedBookings, qt.Equals, db.UserCompletedBookings{AsRequester: 2})
+	qt.Assert(t, got.Rating, qt.IsNil)
+
+	_, err = summary(primitive.NewObjectID().Hex())
+	qt.Assert(t, err, qt.Equals, ErrUserNotFound)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	a := testAPI(t)
+	owner := testUser1
+	owner.Password = hashPassword("password1")
+	qt.Assert(t, a.addUser(&owner), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	id, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	ctx := context.Background()
+	user, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	deleteAccount := func(password string) error {
+		_, err := a.deleteAccountHandler(testRequest(t, "DELETE", "/profile", testUser1.Email,
+			&DeleteAccountRequest{Password: password}, nil))
+		return err
+	}
+	book := func(offset time.Duration) *db.Booking {
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", id),
+			StartDate: time.Now().Add(offset),
+			EndDate:   time.Now().Add(offset + 24*time.Hour),
+			Contact:   "alice@emprius.cat",
+		}, requester.ID, user.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return booking
+	}
+	returned := returnedBookingForTest(t, a, 24*time.Hour)
+	pending := book(10 * 24 * time.Hour)
+	accepted := book(20 * 24 * time.Hour)
+	setBookingStatusForTest(t, a, accepted.ID, db.BookingStatusAccepted)
+
+	// The password confirms the deletion
+	qt.Assert(t, deleteAccount(""), qt.Equals, ErrInvalidRequestBodyData)
+	qt.Assert(t, deleteAccount("wrong"), qt.Equals, ErrWrongLogin)
+
+	// Accepted bookings must end first, nothing is changed meanwhile
+	qt.Assert(t, deleteAccount("password1"), qt.Equals, ErrAccountHasAcceptedBookings)
+	booking, err := a.database.BookingService.Get(ctx, pending.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, db.BookingStatusPending)
+
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, accepted.ID, db.BookingStatusReturned, primitive.NilObjectID), qt.IsNil)
+	qt.Assert(t, deleteAccount("password1"), qt.IsNil)
+
+	// The personal data is gone and the user can no longer log in
+	deleted, err := a.database.UserService.GetUserByID(ctx, user.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, deleted.Deleted, qt.IsTrue)
+	qt.Assert(t, deleted.Email, qt.Equals, db.DeletedUserName+user.ID.Hex())
+	qt.Assert(t, deleted.Name, qt.Equals, deleted.Email)
+	qt.Assert(t, deleted.Location, qt.Equals, db.Location{})
+	qt.Assert(t, deleted.Community, qt.Equals, "")
+	_, err = a.loginHandler(testRequest(t, "POST", "/login", "",
+		&Login{Email: testUser1.Email, Password: "password1"}, nil))
+	qt.Assert(t, err, qt.Not(qt.IsNil))
+
+	// The pending bookings are cancelled and the completed ones kept for the counterpart
+	booking, err = a.database.BookingService.Get(ctx, pending.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, db.BookingStatusCancelled)
+	booking, err = a.database.BookingService.Get(ctx, returned.ID)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, booking.BookingStatus, qt.Equals, db.BookingStatusReturned)
+	qt.Assert(t, booking.ToUserID, qt.Equals, user.ID)
+	qt.Assert(t, booking.FromUserID, qt.Equals, requester.ID)
+
+	// The tools are archived
+	tool, err := a.findTool(id, true)
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, tool.Deleted, qt.IsTrue)
+	qt.Assert(t, tool.Archived, qt.IsTrue)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StartWorker runs the background tasks every WorkerInterval until the context is done (non blocking).
+func (a *API) StartWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(a.opts.WorkerInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				a.runWorker(ctx, now)
+			}
+		}
+	}()
+}
+
+// runWorker runs each background task once.
+func (a *API) runWorker(ctx context.Context, now time.Time) {
+	returned, err := a.autoReturnBookings(ctx, now)
+	a.metrics.workerRun("autoReturn", returned, err)
+	if err != nil {
+		log.Error().Err(err).Msg("could not auto-return ended bookings")
+	}
+	if returned > 0 {
+		log.Info().Msgf("auto-returned %d ended bookings", returned)
+	}
+	expired, err := a.database.BookingService.ExpireStalePetitions(ctx, now.Add(-a.opts.PetitionTTL))
+	a.metrics.workerRun("expirePetitions", int(expired), err)
+	if err != nil {
+		log.Error().Err(err).Msg("could not expire stale petitions")
+	}
+	if expired > 0 {
+		log.Info().Msgf("expired %d stale petitions", expired)
+	}
+	if a.opts.AutoRejectUnanswered {
+		rejected, err := a.database.BookingService.RejectUnansweredPetitions(ctx, now)
+		a.metrics.workerRun("rejectUnanswered", int(rejected), err)
+		if err != nil {
+			log.Error().Err(err).Msg("could not reject unanswered petitions")
+		}
+		if rejected > 0 {
+			log.Info().Msgf("rejected %d unanswered petitions", rejected)
+		}
+	}
+	collected, err := a.collectCharges(ctx)
+	a.metrics.workerRun("collectCharges", collected, err)
+	if err != nil {
+		log.Error().Err(err).Msg("could not collect outstanding charges")
+	}
+	if collected > 0 {
+		log.Info().Msgf("collected %d outstanding charges", collected)
+	}
+	// After the auto-returns, so the returned bookings are not reminded as overdue
+	reminded, err := a.sendReminders(ctx, now)
+	a.metrics.workerRun("reminders", reminded, err)
+	if err != nil {
+		log.Error().Err(err).Msg("could not send booking reminders")
+	}
+	if reminded > 0 {
+		log.Info().Msgf("sent %d booking reminders", reminded)
+	}
+}
+
+// autoReturnBookings marks as returned the accepted bookings of auto-return tools that ended
+// AutoReturnDelay before now, as if the owner returned them without claiming the deposit.
+// It returns the number of bookings returned.
+func (a *API) autoReturnBookings(ctx context.Context, now time.Time) (int, error) {
+	bookings, err := a.database.BookingService.GetEnded(ctx, now.Add(-a.opts.AutoReturnDelay))
+	if err != nil {
+		return 0, err
+	}
+	if len(bookings) == 0 {
+		return 0, nil
+	}
+	_, toolsByID, err := a.bookingRelations(ctx, bookings)
+	if err != nil {
+		return 0, err
+	}
+	returned := 0
+	for _, booking := range bookings {
+		tool, ok := toolsByID[booking.ToolID]
+		if !ok || !tool.AutoReturn {
+			continue
+		}
+		if err := a.returnBooking(ctx, booking, primitive.NilObjectID, 0); err != nil {
+			return returned, err
+		}
+		returned++
+	}
+	return returned, nil
+}
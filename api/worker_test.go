@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/emprius/emprius-app-backend/db"
+)
+
+func TestWorkerAutoReturn(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	autoTool := testTool1
+	autoTool.Title = "cheap shovel"
+	autoTool.AutoReturn = boolPtr(true)
+	autoID, err := a.addTool(&autoTool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	manualID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Created in the future, each on their own dates, and then moved to end at the given time
+	created := 0
+	acceptedBooking := func(toolID int64, end time.Time) *db.Booking {
+		created++
+		start := time.Now().Add(time.Duration(created) * 72 * time.Hour)
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: start,
+			EndDate:   start.Add(48 * time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		moveBookingForTest(t, a, booking.ID.Hex(), end.Add(-48*time.Hour), end)
+		qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, booking.ID, db.BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+		return booking
+	}
+	status := func(booking *db.Booking) db.BookingStatus {
+		b, err := a.database.BookingService.Get(ctx, booking.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return b.BookingStatus
+	}
+
+	ended := acceptedBooking(autoID, time.Now().Add(-2*a.opts.AutoReturnDelay))
+	// Still within the delay after the end date
+	justEnded := acceptedBooking(autoID, time.Now().Add(-a.opts.AutoReturnDelay/2))
+	// Manual return tools are never returned by the worker
+	manual := acceptedBooking(manualID, time.Now().Add(-2*a.opts.AutoReturnDelay))
+
+	a.runWorker(ctx, time.Now())
+	qt.Assert(t, status(ended), qt.Equals, db.BookingStatusReturned)
+	qt.Assert(t, status(justEnded), qt.Equals, db.BookingStatusAccepted)
+	qt.Assert(t, status(manual), qt.Equals, db.BookingStatusAccepted)
+
+	// Running again does not change anything
+	returned, err := a.autoReturnBookings(ctx, time.Now())
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, returned, qt.Equals, 0)
+
+	// The auto-returned booking can be rated
+	_, err = a.HandleRateBooking(testRequest(t, "POST", "/bookings/rates", testUser2.Email,
+		&RateRequest{BookingID: ended.ID.Hex(), Rating: 4}, nil))
+	qt.Assert(t, err, qt.IsNil)
+}
+
+func TestWorkerExpirePetitions(t *testing.T) {
+	a := testAPI(t)
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+	toolID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Created in the future and then moved to the given creation and start dates
+	created := 0
+	petition := func(createdAt, start time.Time) *db.Booking {
+		created++
+		future := time.Now().Add(time.Duration(created) * 72 * time.Hour)
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: future,
+			EndDate:   future.Add(24 * time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		moveBookingForTest(t, a, booking.ID.Hex(), start, start.Add(24*time.Hour))
+		_, err = a.database.Database.Collection("bookings").UpdateOne(ctx,
+			bson.M{"_id": booking.ID}, bson.M{"$set": bson.M{"createdAt": createdAt}})
+		qt.Assert(t, err, qt.IsNil)
+		return booking
+	}
+	get := func(booking *db.Booking) *db.Booking {
+		b, err := a.database.BookingService.Get(ctx, booking.ID)
+		qt.Assert(t, err, qt.IsNil)
+		return b
+	}
+
+	old := time.Now().Add(-2 * a.opts.PetitionTTL)
+	stale := petition(old, time.Now().Add(-24*time.Hour))
+	// Not old enough yet
+	recent := petition(time.Now().Add(-a.opts.PetitionTTL/2), time.Now().Add(-24*time.Hour))
+	// Old but can still go ahead
+	upcoming := petition(old, time.Now().Add(24*time.Hour))
+	// Accepted bookings are never expired
+	accepted := petition(old, time.Now().Add(-48*time.Hour))
+	qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, accepted.ID, db.BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+
+	a.runWorker(ctx, time.Now())
+	qt.Assert(t, get(stale).BookingStatus, qt.Equals, db.BookingStatusRejected)
+	qt.Assert(t, get(stale).RejectionReason, qt.Equals, db.RejectionReasonExpired)
+	qt.Assert(t, get(recent).BookingStatus, qt.Equals, db.BookingStatusPending)
+	qt.Assert(t, get(upcoming).BookingStatus, qt.Equals, db.BookingStatusPending)
+	qt.Assert(t, get(accepted).BookingStatus, qt.Equals, db.BookingStatusAccepted)
+
+	// Running again does not expire anything else
+	expired, err := a.database.BookingService.ExpireStalePetitions(ctx, time.Now().Add(-a.opts.PetitionTTL))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, expired, qt.Equals, int64(0))
+}
+
+// recordingNotifier keeps the reminders it is sent
+type recordingNotifier struct {
+	reminders []*Reminder
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, reminder *Reminder) error {
+	n.reminders = append(n.reminders, reminder)
+	return nil
+}
+
+func TestWorkerReminders(t *testing.T) {
+	a := testAPI(t)
+	notifier := &recordingNotifier{}
+	a.opts.Notifier = notifier
+	ctx := context.Background()
+	qt.Assert(t, a.addUser(&testUser1), qt.IsNil)
+	qt.Assert(t, a.addUser(&testUser2), qt.IsNil)
+	owner, err := a.database.UserService.GetUserByEmail(ctx, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	requester, err := a.database.UserService.GetUserByEmail(ctx, testUser2.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	autoTool := testTool1
+	autoTool.Title = "cheap shovel"
+	autoTool.AutoReturn = boolPtr(true)
+	autoID, err := a.addTool(&autoTool, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+	manualID, err := a.addTool(&testTool1, testUser1.Email)
+	qt.Assert(t, err, qt.IsNil)
+
+	// Created in the future, each on their own dates, and then moved to start at the given time
+	created := 0
+	booking := func(toolID int64, start time.Time, status db.BookingStatus) *db.Booking {
+		created++
+		future := time.Now().Add(time.Duration(created) * 72 * time.Hour)
+		booking, err := a.database.BookingService.Create(ctx, &db.CreateBookingRequest{
+			ToolID:    fmt.Sprintf("%d", toolID),
+			StartDate: future,
+			EndDate:   future.Add(48 * time.Hour),
+		}, requester.ID, owner.ID)
+		qt.Assert(t, err, qt.IsNil)
+		moveBookingForTest(t, a, booking.ID.Hex(), start, start.Add(48*time.Hour))
+		if status != db.BookingStatusPending {
+			qt.Assert(t, a.database.BookingService.UpdateStatus(ctx, booking.ID, status, primitive.NilObjectID), qt.IsNil)
+		}
+		return booking
+	}
+	now := time.Now()
+	startingSoon := booking(manualID, now.Add(a.opts.ReminderWindow/2), db.BookingStatusAccepted)
+	// Starting after the window, or not accepted
+	booking(manualID, now.Add(2*a.opts.ReminderWindow), db.BookingStatusAccepted)
+	booking(manualID, now.Add(a.opts.ReminderWindow/2), db.BookingStatusPending)
+	// Ended without a return, the auto-return tool is about to be returned by the worker instead
+	overdue := booking(manualID, now.Add(-72*time.Hour), db.BookingStatusAccepted)
+	booking(autoID, now.Add(-48*time.Hour-a.opts.AutoReturnDelay/2), db.BookingStatusAccepted)
+
+	sent := func() map[db.BookingReminder][]string {
+		sent := map[db.BookingReminder][]string{}
+		for _, r := range notifier.reminders {
+			sent[r.Type] = append(sent[r.Type], r.Booking.ID.Hex())
+		}
+		return sent
+	}
+	a.runWorker(ctx, now)
+	qt.Assert(t, sent(), qt.DeepEquals, map[db.BookingReminder][]string{
+		db.BookingReminderUpcoming: {startingSoon.ID.Hex()},
+		db.BookingReminderOverdue:  {overdue.ID.Hex()},
+	})
+
+	// Running again does not repeat them
+	a.runWorker(ctx, now.Add(time.Minute))
+	qt.Assert(t, notifier.reminders, qt.HasLen, 2)
+
+	// New dates get a new reminder
+	qt.Assert(t, a.database.BookingService.ProposeReschedule(ctx, startingSoon.ID,
+		now.Add(a.opts.ReminderWindow/4), now.Add(a.opts.ReminderWindow), startingSoon.FromUserID), qt.IsNil)
+	_, err = a.database.BookingService.AcceptReschedule(ctx, startingSoon.ID, startingSoon.ToUserID)
+	qt.Assert(t, err, qt.IsNil)
+	reminded, err := a.sendReminders(ctx, now.Add(2*time.Minute))
+	qt.Assert(t, err, qt.IsNil)
+	qt.Assert(t, reminded, qt.Equals, 1)
+	qt.Assert(t, sent()[db.BookingReminderUpcoming], qt.DeepEquals, []string{startingSoon.ID.Hex(), startingSoon.ID.Hex()})
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditAction identifies the action recorded by an audit entry.
+type AuditAction string
+
+const (
+	AuditToolFeatured    AuditAction = "TOOL_FEATURED"
+	AuditToolRestored    AuditAction = "TOOL_RESTORED"
+	AuditToolsImported   AuditAction = "TOOLS_IMPORTED"
+	AuditUserRestored    AuditAction = "USER_RESTORED"
+	AuditUserDeactivated AuditAction = "USER_DEACTIVATED"
+	AuditToolDeleted     AuditAction = "TOOL_DELETED"
+	AuditOrphansFixed    AuditAction = "ORPHANS_FIXED"
+	AuditBookingsForced  AuditAction = "BOOKINGS_FORCE_CANCELLED"
+	AuditInviteCreated   AuditAction = "INVITE_CREATED"
+	AuditInviteRevoked   AuditAction = "INVITE_REVOKED"
+)
+
+// AuditActions are all the known audit actions.
+var AuditActions = []AuditAction{
+	AuditToolFeatured,
+	AuditToolRestored,
+	AuditToolsImported,
+	AuditUserRestored,
+	AuditUserDeactivated,
+	AuditToolDeleted,
+	AuditOrphansFixed,
+	AuditBookingsForced,
+	AuditInviteCreated,
+	AuditInviteRevoked,
+}
+
+// IsValidAuditAction returns true if a is a known audit action.
+func IsValidAuditAction(a AuditAction) bool {
+	for _, action := range AuditActions {
+		if action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditTargetType is the kind of entity an audit entry is about.
+type AuditTargetType string
+
+const (
+	AuditTargetTool    AuditTargetType = "tool"
+	AuditTargetUser    AuditTargetType = "user"
+	AuditTargetBooking AuditTargetType = "booking"
+	AuditTargetInvite  AuditTargetType = "invite"
+)
+
+// AuditEntry represents the schema for the "audit" collection.
+type AuditEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ActorID    primitive.ObjectID `bson:"actorId" json:"actorId"`
+	Action     AuditAction        `bson:"action" json:"action"`
+	TargetType AuditTargetType    `bson:"targetType,omitempty" json:"targetType,omitempty"`
+	TargetID   string             `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	Details    string             `bson:"details,omitempty" json:"details,omitempty"`
+	Timestamp  time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+// AuditFilter selects audit entries. Zero values match any entry.
+type AuditFilter struct {
+	ActorID    primitive.ObjectID
+	Action     AuditAction
+	TargetType AuditTargetType
+	TargetID   string
+	// From and To limit the timestamp of the entries, both inclusive
+	From time.Time
+	To   time.Time
+}
+
+// bson returns the query matching the filter.
+func (f *AuditFilter) bson() bson.M {
+	filter := bson.M{}
+	if !f.ActorID.IsZero() {
+		filter["actorId"] = f.ActorID
+	}
+	if f.Action != "" {
+		filter["action"] = f.Action
+	}
+	if f.TargetType != "" {
+		filter["targetType"] = f.TargetType
+	}
+	if f.TargetID != "" {
+		filter["targetId"] = f.TargetID
+	}
+	timestamp := bson.M{}
+	if !f.From.IsZero() {
+		timestamp["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		timestamp["$lte"] = f.To
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	return filter
+}
+
+// AuditService provides methods to interact with the "audit" collection.
+type AuditService struct {
+	Collection *mongo.Collection
+}
+
+// NewAuditService creates a new AuditService.
+func NewAuditService(db *Database) *AuditService {
+	return &AuditService{
+		Collection: db.Database.Collection("audit"),
+	}
+}
+
+// Create stores a new audit entry, timestamped now if not set.
+func (s *AuditService) Create(ctx context.Context, entry *AuditEntry) error {
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	result, err := s.Collection.InsertOne(ctx, entry)
+	if err != nil {
+		return err
+	}
+	entry.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Get returns a page of the audit entries matching the filter, newest first, along with the
+// total number of matching entries.
+func (s *AuditService) Get(ctx context.Context, f *AuditFilter, page, pageSize int) ([]*AuditEntry, int64, error) {
+	filter := f.bson()
+	total, err := s.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	entries := []*AuditEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
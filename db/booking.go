@@ -1,8 +1,13 @@
 package db
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -21,27 +26,210 @@ const (
 	BookingStatusRejected  BookingStatus = "REJECTED"
 	BookingStatusCancelled BookingStatus = "CANCELLED"
 	BookingStatusReturned  BookingStatus = "RETURNED"
+	// BookingStatusOpen is a petition without dates, waiting for the owner to propose them
+	BookingStatusOpen BookingStatus = "OPEN"
+	// BookingStatusTransferred is a transfer booking whose tool was handed over to the requester
+	BookingStatusTransferred BookingStatus = "TRANSFERRED"
 )
 
-// Booking represents a tool booking in the system
+// BookingStatuses lists all the booking statuses.
+var BookingStatuses = []BookingStatus{
+	BookingStatusOpen,
+	BookingStatusPending,
+	BookingStatusAccepted,
+	BookingStatusRejected,
+	BookingStatusCancelled,
+	BookingStatusReturned,
+	BookingStatusTransferred,
+}
+
+// BookingTransitions is the booking state machine: the statuses each status can move to.
+// Statuses not present are final.
+var BookingTransitions = map[BookingStatus][]BookingStatus{
+	BookingStatusOpen:     {BookingStatusPending, BookingStatusRejected, BookingStatusCancelled},
+	BookingStatusPending:  {BookingStatusAccepted, BookingStatusRejected, BookingStatusCancelled},
+	BookingStatusAccepted: {BookingStatusReturned, BookingStatusTransferred, BookingStatusCancelled},
+}
+
+// IsValidBookingStatus returns true if status is a known booking status.
+func IsValidBookingStatus(status BookingStatus) bool {
+	for _, s := range BookingStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CanTransition reports whether a booking in the from status can move to the to status.
+func CanTransition(from, to BookingStatus) bool {
+	for _, status := range BookingTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveBookingStatuses are the statuses of the bookings whose tool is currently lent out or
+// about to be.
+var ActiveBookingStatuses = []BookingStatus{BookingStatusAccepted}
+
+// BookedStatuses are the statuses of the bookings that went ahead, so the tool was actually lent.
+var BookedStatuses = []BookingStatus{BookingStatusAccepted, BookingStatusReturned, BookingStatusTransferred}
+
+// CompletedStatuses are the statuses of the bookings that ended with the tool back or handed over.
+var CompletedStatuses = []BookingStatus{BookingStatusReturned, BookingStatusTransferred}
+
+// Booking represents a tool booking in the system. Open bookings have no dates.
 type Booking struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	ToolID        string             `bson:"toolId" json:"toolId"`
 	FromUserID    primitive.ObjectID `bson:"fromUserId" json:"fromUserId"`
 	ToUserID      primitive.ObjectID `bson:"toUserId" json:"toUserId"`
-	StartDate     time.Time          `bson:"startDate" json:"startDate"`
-	EndDate       time.Time          `bson:"endDate" json:"endDate"`
+	StartDate     time.Time          `bson:"startDate,omitempty" json:"startDate"`
+	EndDate       time.Time          `bson:"endDate,omitempty" json:"endDate"`
 	Contact       string             `bson:"contact" json:"contact"`
 	Comments      string             `bson:"comments" json:"comments"`
 	BookingStatus BookingStatus      `bson:"bookingStatus" json:"bookingStatus"`
-	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt     time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// GroupID links the bookings of a kit, which are accepted, denied and returned together
+	GroupID   primitive.ObjectID `bson:"groupId,omitempty" json:"groupId,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// Charge is the token cost of the booking, recorded when the tool is returned
+	Charge *BookingCharge `bson:"charge,omitempty" json:"charge,omitempty"`
+	// Orphaned marks bookings whose tool or users no longer exist
+	Orphaned bool `bson:"orphaned,omitempty" json:"orphaned,omitempty"`
+	// Transfer marks a request to keep the tool: once accepted it is handed over, moving the
+	// ownership to the requester, instead of being returned
+	Transfer bool `bson:"transfer,omitempty" json:"transfer,omitempty"`
+	// RejectionReason tells the requester why the booking was rejected, if not by the owner
+	RejectionReason string `bson:"rejectionReason,omitempty" json:"rejectionReason,omitempty"`
+	// AcceptedTerms records the tool terms the requester accepted, if the tool had any
+	AcceptedTerms *TermsAcceptance `bson:"acceptedTerms,omitempty" json:"acceptedTerms,omitempty"`
+	// Transport is the transport option chosen by the requester, if any, with its estimated cost
+	Transport *BookingTransport `bson:"transport,omitempty" json:"transport,omitempty"`
+	// Reminded are the reminders already sent for the booking, see MarkReminded
+	Reminded []BookingReminder `bson:"reminded,omitempty" json:"-"`
+	// Free is set if the requester asked to borrow the tool for free, which only tools that may be
+	// free allow. The booking is not charged then.
+	Free bool `bson:"free,omitempty" json:"free,omitempty"`
+	// AgreedCost is the cost per day agreed when booking, the cost of the tool unless the requester
+	// offered less or asked for it free. It is nil on the bookings created before it was recorded,
+	// which are charged as the tool says.
+	AgreedCost *uint64 `bson:"agreedCost,omitempty" json:"agreedCost,omitempty"`
+	// ResponseDue is when the owner is expected to have answered the petition by, see
+	// Tool.ResponseDeadlineHours. It is zero on open requests and on the bookings created before.
+	ResponseDue time.Time `bson:"responseDue,omitempty" json:"responseDue,omitempty"`
+	// RespondedAt is when the owner accepted or denied the petition, zero if they did not.
+	RespondedAt time.Time `bson:"respondedAt,omitempty" json:"respondedAt,omitempty"`
+	// Reschedule is the new dates proposed by one of the parties, if any, see ProposeReschedule.
+	Reschedule *RescheduleProposal `bson:"reschedule,omitempty" json:"reschedule,omitempty"`
+}
+
+// RescheduleProposal is the new dates of a booking proposed by one of its parties, which only apply
+// once the other party accepts them.
+type RescheduleProposal struct {
+	StartDate  time.Time          `bson:"startDate" json:"startDate"`
+	EndDate    time.Time          `bson:"endDate" json:"endDate"`
+	ProposedBy primitive.ObjectID `bson:"proposedBy" json:"proposedBy"`
+	ProposedAt time.Time          `bson:"proposedAt" json:"proposedAt"`
+}
+
+// BookingReminder is an event of an accepted booking its parties are reminded of
+type BookingReminder string
+
+const (
+	// BookingReminderUpcoming is sent when the booking is about to start
+	BookingReminderUpcoming BookingReminder = "upcoming"
+	// BookingReminderOverdue is sent when the booking ended and the tool was not returned
+	BookingReminderOverdue BookingReminder = "overdue"
+)
+
+// BookingTransport is the transport option of a booking, with the cost estimated when the booking
+// was requested over the distance between the requester and the tool, see Transport.EstimateCost.
+type BookingTransport struct {
+	ID       int64  `bson:"id" json:"id"`
+	Name     string `bson:"name" json:"name"`
+	Distance int64  `bson:"distance" json:"distance"`
+	Cost     uint64 `bson:"cost" json:"cost"`
+}
+
+// RejectionReasonToolBooked is the reason of the pending bookings rejected because another
+// booking of the same tool and dates was accepted.
+const RejectionReasonToolBooked = "tool booked by another request"
+
+// RejectionReasonExpired is the reason of the pending bookings rejected because the owner did not
+// answer them before they were due to start, see ExpireStalePetitions.
+const RejectionReasonExpired = "petition expired without an answer"
+
+// RejectionReasonUnanswered is the reason of the pending bookings rejected because the owner did not
+// answer them by their response deadline, see RejectUnansweredPetitions.
+const RejectionReasonUnanswered = "owner did not answer in time"
+
+// BookingCharge is the breakdown of the tokens charged for a booking.
+type BookingCharge struct {
+	Days       uint64 `bson:"days" json:"days"`
+	CostPerDay uint64 `bson:"costPerDay" json:"costPerDay"`
+	Total      uint64 `bson:"total" json:"total"`
+	Free       bool   `bson:"free" json:"free"`
+	AskWithFee bool   `bson:"askWithFee" json:"askWithFee"`
+	// Outstanding is set while the requester could not pay the charge, until it is collected
+	Outstanding bool `bson:"outstanding,omitempty" json:"outstanding,omitempty"`
+}
+
+// NewBookingCharge computes the charge of the booking of the tool for its dates. Every started
+// day counts as a full day, with a minimum of one, at the cost agreed on the booking. Free
+// bookings are not charged. Bookings without an agreed cost are charged the cost of the tool,
+// unless it may be free.
+func NewBookingCharge(tool *Tool, booking *Booking) *BookingCharge {
+	days := uint64(math.Ceil(booking.EndDate.Sub(booking.StartDate).Hours() / 24))
+	if days == 0 {
+		days = 1
+	}
+	charge := &BookingCharge{
+		Days:       days,
+		CostPerDay: tool.Cost,
+		Free:       tool.MayBeFree,
+		AskWithFee: tool.AskWithFee,
+	}
+	if booking.AgreedCost != nil {
+		charge.CostPerDay, charge.Free = *booking.AgreedCost, booking.Free
+	}
+	if !charge.Free {
+		charge.Total = charge.Days * charge.CostPerDay
+	}
+	return charge
+}
+
+// NewTransferCharge computes the charge of a transfer booking, which is the estimated value of the
+// tool, not depending on the days. Free bookings are not charged, nor the ones without an agreed
+// cost of tools that may be free.
+func NewTransferCharge(tool *Tool, booking *Booking) *BookingCharge {
+	charge := &BookingCharge{
+		Free:       tool.MayBeFree,
+		AskWithFee: tool.AskWithFee,
+	}
+	if booking.AgreedCost != nil {
+		charge.Free = booking.Free
+	}
+	if !charge.Free {
+		charge.Total = tool.EstimatedValue
+	}
+	return charge
 }
 
 // BookingService handles all booking related database operations
 type BookingService struct {
 	collection *mongo.Collection
 	database   *mongo.Database
+	// ExclusivePending makes pending bookings block their dates, so a new booking is rejected
+	// if its dates overlap a pending one. By default only accepted bookings block dates.
+	ExclusivePending bool
+	// MaxDuration is the longest a booking can last, zero means no limit.
+	MaxDuration time.Duration
+	// Events receives a BookingEvent whenever a booking is created or changes its status.
+	Events *BookingEventHub
 }
 
 // NewBookingService creates a new BookingService instance
@@ -77,73 +265,261 @@ func NewBookingService(db *mongo.Database) *BookingService {
 	return &BookingService{
 		collection: collection,
 		database:   db,
+		Events:     NewBookingEventHub(),
 	}
 }
 
-// CreateBookingRequest represents the request to create a new booking
+// TermsAcceptance is the acceptance of the terms of a tool by a requester, see Tool.TermsVersion.
+type TermsAcceptance struct {
+	Version    string    `bson:"version" json:"version"`
+	AcceptedAt time.Time `bson:"acceptedAt" json:"acceptedAt"`
+}
+
+// CreateBookingRequest represents the request to create a new booking.
+// If both dates are zero, an open booking is created.
 type CreateBookingRequest struct {
 	ToolID    string    `bson:"toolId" json:"toolId"`
 	StartDate time.Time `bson:"startDate" json:"startDate"`
 	EndDate   time.Time `bson:"endDate" json:"endDate"`
 	Contact   string    `bson:"contact" json:"contact"`
 	Comments  string    `bson:"comments" json:"comments"`
+	// Transfer requests to keep the tool, see Booking.Transfer
+	Transfer bool `bson:"transfer,omitempty" json:"transfer,omitempty"`
+	// AcceptedTerms are the terms accepted by the requester, indexed by tool ID
+	AcceptedTerms map[string]*TermsAcceptance `bson:"-" json:"-"`
+	// Transport is the transport option chosen by the requester, if any
+	Transport *BookingTransport `bson:"-" json:"-"`
+	// Free asks to borrow the tools for free, see Booking.Free
+	Free bool `bson:"-" json:"-"`
+	// AgreedCosts are the costs per day agreed for the tools, indexed by tool ID
+	AgreedCosts map[string]uint64 `bson:"-" json:"-"`
+	// ResponseDue are the response deadlines of the owner for the tools, indexed by tool ID
+	ResponseDue map[string]time.Time `bson:"-" json:"-"`
 }
 
-// Create creates a new booking
+// Create creates a new booking. It returns ErrInvalidBookingDates or ErrBookingTooLong if the dates
+// are not valid, see validateDates, ErrToolUnavailable if the owner marked the tool as unavailable
+// during the dates, or ErrBookingDatesConflict if they overlap another booking.
 func (s *BookingService) Create(
 	ctx context.Context,
 	req *CreateBookingRequest,
 	fromUserID, toUserID primitive.ObjectID,
 ) (*Booking, error) {
-	// Set timestamps
-	now := time.Now()
+	booking := newBooking(req, req.ToolID, fromUserID, toUserID)
+
+	if booking.StartDate.IsZero() && booking.EndDate.IsZero() {
+		// Open bookings do not take part in conflict checks until dates are set
+		booking.BookingStatus = BookingStatusOpen
+	} else {
+		if err := s.validateDates(booking.StartDate, booking.EndDate); err != nil {
+			return nil, err
+		}
+		if err := s.checkAvailability(ctx, booking.ToolID, booking.StartDate, booking.EndDate,
+			primitive.NilObjectID); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := s.collection.InsertOne(ctx, booking)
+	if err != nil {
+		return nil, err
+	}
+
+	booking.ID = result.InsertedID.(primitive.ObjectID)
+	s.Events.Publish(&BookingEvent{Type: BookingEventCreated, Booking: booking})
+	return booking, nil
+}
+
+// CreateGroup creates a kit: one booking for each of the tools, with the dates of the request,
+// linked by a common group ID. The tool ID of the request is ignored. If any of the tools has a
+// date conflict, no booking is created and ErrToolUnavailable or ErrBookingDatesConflict is returned.
+func (s *BookingService) CreateGroup(
+	ctx context.Context,
+	req *CreateBookingRequest,
+	toolIDs []string,
+	fromUserID, toUserID primitive.ObjectID,
+) ([]*Booking, error) {
+	if req.StartDate.IsZero() || req.EndDate.IsZero() {
+		return nil, ErrInvalidBookingDates
+	}
+	if err := s.validateDates(req.StartDate, req.EndDate); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(toolIDs))
+	for _, toolID := range toolIDs {
+		if seen[toolID] {
+			return nil, ErrInvalidBookingGroup
+		}
+		seen[toolID] = true
+	}
+	if len(toolIDs) < 2 {
+		return nil, ErrInvalidBookingGroup
+	}
+
+	// Check all the tools before creating anything, so the group fails as a whole
+	for _, toolID := range toolIDs {
+		if err := s.checkAvailability(ctx, toolID, req.StartDate, req.EndDate, primitive.NilObjectID); err != nil {
+			return nil, err
+		}
+	}
+
+	groupID := primitive.NewObjectID()
+	bookings := make([]*Booking, len(toolIDs))
+	documents := make([]interface{}, len(toolIDs))
+	for i, toolID := range toolIDs {
+		bookings[i] = newBooking(req, toolID, fromUserID, toUserID)
+		bookings[i].GroupID = groupID
+		documents[i] = bookings[i]
+	}
+	result, err := s.collection.InsertMany(ctx, documents)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range result.InsertedIDs {
+		bookings[i].ID = id.(primitive.ObjectID)
+		s.Events.Publish(&BookingEvent{Type: BookingEventCreated, Booking: bookings[i]})
+	}
+	return bookings, nil
+}
 
+// newBooking returns a pending booking of the tool from the request data.
+func newBooking(req *CreateBookingRequest, toolID string, fromUserID, toUserID primitive.ObjectID) *Booking {
+	now := time.Now()
 	booking := &Booking{
-		ToolID:        req.ToolID,
+		ToolID:        toolID,
 		FromUserID:    fromUserID,
 		ToUserID:      toUserID,
 		StartDate:     req.StartDate,
 		EndDate:       req.EndDate,
 		Contact:       req.Contact,
 		Comments:      req.Comments,
+		Transfer:      req.Transfer,
+		AcceptedTerms: req.AcceptedTerms[toolID],
+		Transport:     req.Transport,
+		ResponseDue:   req.ResponseDue[toolID],
 		BookingStatus: BookingStatusPending,
 		CreatedAt:     now,
 		UpdatedAt:     now,
 	}
+	if cost, ok := req.AgreedCosts[toolID]; ok {
+		booking.Free, booking.AgreedCost = req.Free, &cost
+	}
+	return booking
+}
 
-	// Check for date conflicts
-	conflictExists, err := s.checkDateConflicts(ctx, booking.ToolID, booking.StartDate, booking.EndDate, primitive.NilObjectID)
+// GetGroup returns the bookings of a kit.
+func (s *BookingService) GetGroup(ctx context.Context, groupID primitive.ObjectID) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{"groupId": groupID})
 	if err != nil {
 		return nil, err
 	}
-	if conflictExists {
-		return nil, ErrBookingDatesConflict
-	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
 
-	result, err := s.collection.InsertOne(ctx, booking)
-	if err != nil {
+	var bookings []*Booking
+	if err = cursor.All(ctx, &bookings); err != nil {
 		return nil, err
 	}
-
-	booking.ID = result.InsertedID.(primitive.ObjectID)
-	return booking, nil
+	return bookings, nil
 }
 
 // Get retrieves a booking by ID
 func (s *BookingService) Get(ctx context.Context, id primitive.ObjectID) (*Booking, error) {
 	var booking Booking
 	err := s.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&booking)
-	if err == mongo.ErrNoDocuments {
+	if errors.Is(err, mongo.ErrNoDocuments) {
 		return nil, ErrBookingNotFound
 	}
-	return &booking, err
+	if err != nil {
+		return nil, err
+	}
+	return &booking, nil
 }
 
 // GetUserRequests gets all booking requests for tools owned by the user
 func (s *BookingService) GetUserRequests(ctx context.Context, userID primitive.ObjectID) ([]*Booking, error) {
-	cursor, err := s.collection.Find(ctx, bson.M{
-		"toUserId": userID,
-	}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	return s.GetUserRequestsByStatus(ctx, userID, nil)
+}
+
+// GetUserRequestsByStatus gets the booking requests for tools owned by the user in any of the
+// statuses, or in any status if none is given, newest first.
+func (s *BookingService) GetUserRequestsByStatus(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	statuses []BookingStatus,
+) ([]*Booking, error) {
+	return s.findByStatus(ctx, bson.M{"toUserId": userID}, statuses)
+}
+
+// GetUserPetitions gets all bookings made by the user
+func (s *BookingService) GetUserPetitions(ctx context.Context, userID primitive.ObjectID) ([]*Booking, error) {
+	return s.GetUserPetitionsByStatus(ctx, userID, nil)
+}
+
+// GetUserPetitionsByStatus gets the bookings made by the user in any of the statuses, or in any
+// status if none is given, newest first.
+func (s *BookingService) GetUserPetitionsByStatus(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	statuses []BookingStatus,
+) ([]*Booking, error) {
+	return s.findByStatus(ctx, bson.M{"fromUserId": userID}, statuses)
+}
+
+// GetBookingsByTool gets all the bookings of the tool, in any status, newest first.
+func (s *BookingService) GetBookingsByTool(ctx context.Context, toolID string) ([]*Booking, error) {
+	return s.findByStatus(ctx, bson.M{"toolId": toolID}, nil)
+}
+
+// GetUserBookings gets a page of the bookings where the user is the requester or the owner, in
+// any of the statuses or in any status if none is given, newest first. It also returns the total.
+func (s *BookingService) GetUserBookings(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	statuses []BookingStatus,
+	page, pageSize int,
+) ([]*Booking, int64, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"fromUserId": userID}, bson.M{"toUserId": userID}}}
+	if len(statuses) > 0 {
+		filter["bookingStatus"] = bson.M{"$in": statuses}
+	}
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	bookings := []*Booking{}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, 0, err
+	}
+	return bookings, total, nil
+}
+
+// findByStatus gets the bookings matching the filter in any of the statuses, all of them if no
+// status is given, newest first.
+func (s *BookingService) findByStatus(ctx context.Context, filter bson.M, statuses []BookingStatus) ([]*Booking, error) {
+	if len(statuses) > 0 {
+		filter["bookingStatus"] = bson.M{"$in": statuses}
+	}
+	cursor, err := s.collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}))
 	if err != nil {
 		return nil, err
 	}
@@ -160,11 +536,37 @@ func (s *BookingService) GetUserRequests(ctx context.Context, userID primitive.O
 	return bookings, nil
 }
 
-// GetUserPetitions gets all bookings made by the user
-func (s *BookingService) GetUserPetitions(ctx context.Context, userID primitive.ObjectID) ([]*Booking, error) {
-	cursor, err := s.collection.Find(ctx, bson.M{
-		"fromUserId": userID,
-	}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+// GetUserPetitionsByCategory gets the bookings made by the user whose tool belongs to the category,
+// in any of the statuses or in any status if none is given.
+func (s *BookingService) GetUserPetitionsByCategory(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	category int,
+	statuses []BookingStatus,
+) ([]*Booking, error) {
+	match := bson.M{"fromUserId": userID}
+	if len(statuses) > 0 {
+		match["bookingStatus"] = bson.M{"$in": statuses}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		// Bookings reference the tool by its ID as a string
+		{{Key: "$lookup", Value: bson.M{
+			"from": "tools",
+			"let": bson.M{"toolId": bson.M{"$convert": bson.M{
+				"input": "$toolId", "to": "long", "onError": nil, "onNull": nil,
+			}}},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$toolId"}}}},
+				bson.M{"$project": bson.M{"toolCategory": 1}},
+			},
+			"as": "tool",
+		}}},
+		{{Key: "$match", Value: bson.M{"tool.toolCategory": category}}},
+		{{Key: "$unset", Value: "tool"}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -174,105 +576,335 @@ func (s *BookingService) GetUserPetitions(ctx context.Context, userID primitive.
 		}
 	}()
 
-	var bookings []*Booking
+	bookings := []*Booking{}
 	if err = cursor.All(ctx, &bookings); err != nil {
 		return nil, err
 	}
 	return bookings, nil
 }
 
-// UpdateStatus updates the booking status and handles any related updates
-func (s *BookingService) UpdateStatus(ctx context.Context, id primitive.ObjectID, status BookingStatus) error {
-	booking, err := s.Get(ctx, id)
-	if err != nil {
-		return err
+// ToolPendingPetitions is a tool along with the pending petitions waiting for its owner.
+type ToolPendingPetitions struct {
+	Tool          Tool      `bson:"tool"`
+	Count         int64     `bson:"count"`
+	OldestPending time.Time `bson:"oldestPending"`
+}
+
+// GetOwnerPendingPetitions returns the tools of the owner with pending petitions, along with
+// the number of petitions, sorted by the oldest pending petition first.
+func (s *BookingService) GetOwnerPendingPetitions(
+	ctx context.Context,
+	ownerID primitive.ObjectID,
+) ([]*ToolPendingPetitions, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"toUserId": ownerID, "bookingStatus": BookingStatusPending}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$toolId",
+			"count":         bson.M{"$sum": 1},
+			"oldestPending": bson.M{"$min": "$createdAt"},
+		}}},
+		// Bookings reference the tool by its ID as a string
+		{{Key: "$lookup", Value: bson.M{
+			"from": "tools",
+			"let": bson.M{"toolId": bson.M{"$convert": bson.M{
+				"input": "$_id", "to": "long", "onError": nil, "onNull": nil,
+			}}},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$toolId"}}}},
+			},
+			"as": "tool",
+		}}},
+		// Petitions of deleted tools are left out
+		{{Key: "$unwind", Value: "$tool"}},
+		{{Key: "$sort", Value: bson.D{{Key: "oldestPending", Value: 1}, {Key: "_id", Value: 1}}}},
 	}
-	if booking == nil {
-		return ErrBookingNotFound
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
 
-	update := bson.M{
-		"$set": bson.M{
-			"bookingStatus": status,
-			"updatedAt":     time.Now(),
-		},
+	tools := []*ToolPendingPetitions{}
+	if err = cursor.All(ctx, &tools); err != nil {
+		return nil, err
 	}
+	return tools, nil
+}
 
-	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+// ToolOnLoan is an active booking along with the tool it lends.
+type ToolOnLoan struct {
+	Booking `bson:",inline"`
+	Tool    Tool `bson:"tool"`
+}
+
+// GetOwnerOnLoan returns the tools of the owner lent out at the given time, that is, with an
+// active booking (see ActiveBookingStatuses) whose dates include it, sorted by end date.
+func (s *BookingService) GetOwnerOnLoan(ctx context.Context, ownerID primitive.ObjectID, at time.Time) ([]*ToolOnLoan, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"toUserId":      ownerID,
+			"bookingStatus": bson.M{"$in": ActiveBookingStatuses},
+			"startDate":     bson.M{"$lte": at},
+			"endDate":       bson.M{"$gt": at},
+		}}},
+		// Bookings reference the tool by its ID as a string
+		{{Key: "$lookup", Value: bson.M{
+			"from": "tools",
+			"let": bson.M{"toolId": bson.M{"$convert": bson.M{
+				"input": "$toolId", "to": "long", "onError": nil, "onNull": nil,
+			}}},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$toolId"}}}},
+			},
+			"as": "tool",
+		}}},
+		{{Key: "$unwind", Value: "$tool"}},
+		{{Key: "$sort", Value: bson.D{{Key: "endDate", Value: 1}, {Key: "_id", Value: 1}}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if result.MatchedCount == 0 {
-		return ErrBookingNotFound
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	loans := []*ToolOnLoan{}
+	if err = cursor.All(ctx, &loans); err != nil {
+		return nil, err
 	}
+	return loans, nil
+}
 
-	// If accepting booking, update tool's reserved dates
-	if status == BookingStatusAccepted {
-		// Get tool service from database
-		toolService := s.database.Collection("tools")
+// OrphanBooking is a booking referencing a tool or users that no longer exist.
+type OrphanBooking struct {
+	Booking         `bson:",inline"`
+	MissingTool     bool `bson:"missingTool"`
+	MissingFromUser bool `bson:"missingFromUser"`
+	MissingToUser   bool `bson:"missingToUser"`
+}
 
-		// Add reserved dates to tool
-		update := bson.M{
-			"$push": bson.M{
-				"reservedDates": bson.M{
-					"from": booking.StartDate,
-					"to":   booking.EndDate,
-				},
+// GetOrphans returns the bookings not yet marked as orphaned whose tool, requester or
+// owner cannot be found.
+func (s *BookingService) GetOrphans(ctx context.Context) ([]*OrphanBooking, error) {
+	lookupUser := func(field, as string) bson.D {
+		return bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   field,
+			"foreignField": "_id",
+			"as":           as,
+		}}}
+	}
+	isEmpty := func(field string) bson.M {
+		return bson.M{"$eq": bson.A{bson.M{"$size": field}, 0}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"orphaned": bson.M{"$ne": true}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "tools",
+			"let": bson.M{"toolId": bson.M{"$convert": bson.M{
+				"input": "$toolId", "to": "long", "onError": nil, "onNull": nil,
+			}}},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$toolId"}}}},
+				bson.M{"$project": bson.M{"_id": 1}},
 			},
+			"as": "tool",
+		}}},
+		lookupUser("fromUserId", "fromUser"),
+		lookupUser("toUserId", "toUser"),
+		{{Key: "$set", Value: bson.M{
+			"missingTool":     isEmpty("$tool"),
+			"missingFromUser": isEmpty("$fromUser"),
+			"missingToUser":   isEmpty("$toUser"),
+		}}},
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"missingTool": true},
+			bson.M{"missingFromUser": true},
+			bson.M{"missingToUser": true},
+		}}}},
+		{{Key: "$unset", Value: bson.A{"tool", "fromUser", "toUser"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: 1}}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
 		}
-		_, err = toolService.UpdateOne(ctx, bson.M{"_id": booking.ToolID}, update)
-		if err != nil {
-			return fmt.Errorf("could not update tool reserved dates: %w", err)
-		}
+	}()
+
+	orphans := []*OrphanBooking{}
+	if err := cursor.All(ctx, &orphans); err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// MarkOrphans flags the bookings as orphaned and cancels the ones not in a final status.
+// It returns the number of bookings cancelled.
+func (s *BookingService) MarkOrphans(ctx context.Context, ids []primitive.ObjectID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	if _, err := s.collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}}, bson.M{
+		"$set": bson.M{"orphaned": true, "updatedAt": now},
+	}); err != nil {
+		return 0, err
 	}
+	pending := []BookingStatus{}
+	for status := range BookingTransitions {
+		pending = append(pending, status)
+	}
+	return moveBookings(ctx, s.database, bson.M{
+		"_id":           bson.M{"$in": ids},
+		"bookingStatus": bson.M{"$in": pending},
+	}, BookingStatusCancelled, nil, primitive.NilObjectID)
+}
 
-	return nil
+// ExpireStalePetitions rejects with RejectionReasonExpired the pending bookings created before the
+// given time whose start date has already passed, since they can no longer go ahead as requested.
+// It returns the number of bookings expired.
+func (s *BookingService) ExpireStalePetitions(ctx context.Context, before time.Time) (int64, error) {
+	return moveBookings(ctx, s.database, bson.M{
+		"bookingStatus": BookingStatusPending,
+		"createdAt":     bson.M{"$lt": before},
+		"startDate":     bson.M{"$lt": time.Now()},
+	}, BookingStatusRejected, bson.M{"rejectionReason": RejectionReasonExpired}, primitive.NilObjectID)
 }
 
-// checkDateConflicts checks if there are any conflicting bookings for the given tool and dates.
-// It takes a tool ID, start and end times, and an optional booking ID to exclude from the check.
-func (s *BookingService) checkDateConflicts(
+// RejectUnansweredPetitions rejects with RejectionReasonUnanswered the pending bookings whose response
+// deadline passed before the given time, see Booking.ResponseDue. It returns the number of bookings
+// rejected.
+func (s *BookingService) RejectUnansweredPetitions(ctx context.Context, before time.Time) (int64, error) {
+	return moveBookings(ctx, s.database, bson.M{
+		"bookingStatus": BookingStatusPending,
+		"responseDue":   bson.M{"$lt": before},
+	}, BookingStatusRejected, bson.M{"rejectionReason": RejectionReasonUnanswered}, primitive.NilObjectID)
+}
+
+// GetOverduePetitions gets the pending petitions of the tools of the owner whose response deadline
+// passed before the given time, the most overdue first.
+func (s *BookingService) GetOverduePetitions(
 	ctx context.Context,
-	toolID string,
-	start, end time.Time,
-	excludeID primitive.ObjectID,
-) (bool, error) {
-	filter := bson.M{
-		"toolId":        toolID,
-		"bookingStatus": BookingStatusAccepted,
-		"$or": []bson.M{
-			{
-				"startDate": bson.M{"$lte": end},
-				"endDate":   bson.M{"$gte": start},
-			},
-		},
+	ownerID primitive.ObjectID,
+	before time.Time,
+) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"toUserId":      ownerID,
+		"bookingStatus": BookingStatusPending,
+		"responseDue":   bson.M{"$lt": before},
+	}, options.Find().SetSort(bson.D{{Key: "responseDue", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
 	}
+	bookings := []*Booking{}
+	if err := cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
 
-	// Exclude the current booking if updating
-	if excludeID != primitive.NilObjectID {
-		filter["_id"] = bson.M{"$ne": excludeID}
+// ResponseStats are how the owner answered the petitions of their tools with a response deadline,
+// used to compute how responsive the user is as a lender.
+type ResponseStats struct {
+	// InTime are the petitions answered by their deadline
+	InTime int64 `bson:"inTime" json:"inTime"`
+	// Late are the petitions answered after their deadline
+	Late int64 `bson:"late" json:"late"`
+	// Missed are the petitions still pending past their deadline, or closed unanswered after it
+	Missed int64 `bson:"missed" json:"missed"`
+}
+
+// Rate returns the percentage of the petitions answered in time, nil if there are none to compute
+// it from.
+func (s *ResponseStats) Rate() *int32 {
+	total := s.InTime + s.Late + s.Missed
+	if total == 0 {
+		return nil
 	}
+	rate := int32(math.Round(100 * float64(s.InTime) / float64(total)))
+	return &rate
+}
 
-	count, err := s.collection.CountDocuments(ctx, filter)
+// GetUserResponseStats counts how the user answered the petitions of their tools. The petitions
+// cancelled by the requester, or rejected by the platform, before their deadline are not counted.
+func (s *BookingService) GetUserResponseStats(ctx context.Context, userID primitive.ObjectID) (*ResponseStats, error) {
+	now := time.Now()
+	countIf := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	answered := bson.M{"$eq": bson.A{bson.M{"$type": "$respondedAt"}, "date"}}
+	// The pending petitions are still open, the rest were closed on their last update
+	closedAt := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{"$bookingStatus", BookingStatusPending}}, now, "$updatedAt",
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"toUserId": userID, "responseDue": bson.M{"$exists": true}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"inTime": countIf(bson.M{"$and": bson.A{
+				answered,
+				bson.M{"$lte": bson.A{"$respondedAt", "$responseDue"}},
+			}}),
+			"late": countIf(bson.M{"$and": bson.A{
+				answered,
+				bson.M{"$gt": bson.A{"$respondedAt", "$responseDue"}},
+			}}),
+			"missed": countIf(bson.M{"$and": bson.A{
+				bson.M{"$not": bson.A{answered}},
+				bson.M{"$gt": bson.A{closedAt, "$responseDue"}},
+			}}),
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
 
-	return count > 0, nil
+	stats := &ResponseStats{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, cursor.Err()
 }
 
-// GetPendingRatings gets bookings that need to be rated by the user
-func (s *BookingService) GetPendingRatings(ctx context.Context, userID primitive.ObjectID) ([]*Booking, error) {
-	filter := bson.M{
-		"$or": []bson.M{
-			{"fromUserId": userID},
-			{"toUserId": userID},
-		},
-		"bookingStatus": BookingStatusReturned,
-		// Add additional conditions for unrated bookings
+// RecentActivityByTool returns, for each tool booked since the given time, the number of bookings
+// weighted by their recency: a booking made now counts 1 and its weight decreases linearly down
+// to 0 for the bookings made at since.
+func (s *BookingService) RecentActivityByTool(ctx context.Context, since time.Time) (map[string]float64, error) {
+	now := time.Now()
+	window := now.Sub(since).Milliseconds()
+	if window <= 0 {
+		return map[string]float64{}, nil
 	}
-
-	cursor, err := s.collection.Find(ctx, filter)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createdAt": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": "$toolId",
+			"score": bson.M{"$sum": bson.M{"$subtract": bson.A{1, bson.M{"$divide": bson.A{
+				bson.M{"$subtract": bson.A{now, "$createdAt"}},
+				window,
+			}}}}},
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -282,9 +914,1482 @@ func (s *BookingService) GetPendingRatings(ctx context.Context, userID primitive
 		}
 	}()
 
-	var bookings []*Booking
-	if err = cursor.All(ctx, &bookings); err != nil {
+	var groups []struct {
+		ToolID string  `bson:"_id"`
+		Score  float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
 		return nil, err
 	}
-	return bookings, nil
+	scores := make(map[string]float64, len(groups))
+	for _, group := range groups {
+		scores[group.ToolID] = group.Score
+	}
+	return scores, nil
+}
+
+// ReliabilityStats are the outcomes of the bookings made by a user, used to compute how
+// reliable the user is as a borrower.
+type ReliabilityStats struct {
+	// OnTime are the bookings returned before the end date plus the ReturnGracePeriod
+	OnTime int64 `bson:"onTime" json:"onTime"`
+	// Late are the bookings returned after the end date plus the ReturnGracePeriod
+	Late int64 `bson:"late" json:"late"`
+	// Cancelled are the bookings cancelled by the user
+	Cancelled int64 `bson:"cancelled" json:"cancelled"`
+	// Overdue are the accepted bookings whose end date passed without being returned
+	Overdue int64 `bson:"overdue" json:"overdue"`
+}
+
+// ReturnGracePeriod is the time after the end date a tool can be returned and still be on time.
+const ReturnGracePeriod = 24 * time.Hour
+
+// ReliabilityWeights configure how each booking outcome affects the reliability score.
+// An on-time return counts as a full success with weight 1.
+type ReliabilityWeights struct {
+	// LateCredit is the success credited to a late return, between 0 and 1
+	LateCredit float64
+	// CancellationWeight is how much a cancellation counts as a failure compared to a return
+	CancellationWeight float64
+	// OverdueWeight is how much an overdue booking counts as a failure compared to a return
+	OverdueWeight float64
+}
+
+// DefaultReliabilityWeights are the weights used when none are configured.
+var DefaultReliabilityWeights = ReliabilityWeights{
+	LateCredit:         0.5,
+	CancellationWeight: 0.5,
+	OverdueWeight:      1,
+}
+
+// Score returns the reliability in the 0-100 range:
+//
+//	100 * (OnTime + Late*LateCredit) /
+//	      (OnTime + Late + Cancelled*CancellationWeight + Overdue*OverdueWeight)
+//
+// It returns nil if there are no outcomes to compute it from.
+func (s *ReliabilityStats) Score(w ReliabilityWeights) *int32 {
+	total := float64(s.OnTime+s.Late) + float64(s.Cancelled)*w.CancellationWeight +
+		float64(s.Overdue)*w.OverdueWeight
+	if total == 0 {
+		return nil
+	}
+	score := int32(math.Round(100 * (float64(s.OnTime) + float64(s.Late)*w.LateCredit) / total))
+	return &score
+}
+
+// GetUserReliabilityStats counts the outcomes of the bookings made by the user.
+// The return date is the last update of a returned booking. Transfer bookings are never overdue.
+func (s *BookingService) GetUserReliabilityStats(ctx context.Context, userID primitive.ObjectID) (*ReliabilityStats, error) {
+	now := time.Now()
+	countIf := func(cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{cond, 1, 0}}}
+	}
+	returnedBy := bson.M{"$add": bson.A{"$endDate", ReturnGracePeriod.Milliseconds()}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"fromUserId": userID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": nil,
+			"onTime": countIf(bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$bookingStatus", BookingStatusReturned}},
+				bson.M{"$lte": bson.A{"$updatedAt", returnedBy}},
+			}}),
+			"late": countIf(bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$bookingStatus", BookingStatusReturned}},
+				bson.M{"$gt": bson.A{"$updatedAt", returnedBy}},
+			}}),
+			"cancelled": countIf(bson.M{"$eq": bson.A{"$bookingStatus", BookingStatusCancelled}}),
+			"overdue": countIf(bson.M{"$and": bson.A{
+				bson.M{"$eq": bson.A{"$bookingStatus", BookingStatusAccepted}},
+				bson.M{"$lt": bson.A{"$endDate", now}},
+				bson.M{"$ne": bson.A{"$transfer", true}},
+			}}),
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	stats := &ReliabilityStats{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(stats); err != nil {
+			return nil, err
+		}
+	}
+	return stats, cursor.Err()
+}
+
+// GetEnded gets the accepted bookings whose end date is before the given time. Transfer bookings,
+// which are never returned, are left out.
+func (s *BookingService) GetEnded(ctx context.Context, before time.Time) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"bookingStatus": BookingStatusAccepted,
+		"endDate":       bson.M{"$lt": before},
+		"transfer":      bson.M{"$ne": true},
+	}, options.Find().SetSort(bson.D{{Key: "endDate", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var bookings []*Booking
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// GetUpcoming gets the accepted bookings starting from from to before to that were not reminded
+// with BookingReminderUpcoming yet, the earliest first.
+func (s *BookingService) GetUpcoming(ctx context.Context, from, to time.Time) ([]*Booking, error) {
+	return s.findReminders(ctx, bson.M{
+		"bookingStatus": BookingStatusAccepted,
+		"startDate":     bson.M{"$gte": from, "$lt": to},
+		"reminded":      bson.M{"$ne": BookingReminderUpcoming},
+	}, "startDate")
+}
+
+// GetOverdue gets the accepted bookings whose end date is before the given time that were not
+// reminded with BookingReminderOverdue yet, the earliest first. Transfer bookings, which are never
+// returned, are left out.
+func (s *BookingService) GetOverdue(ctx context.Context, before time.Time) ([]*Booking, error) {
+	return s.findReminders(ctx, bson.M{
+		"bookingStatus": BookingStatusAccepted,
+		"endDate":       bson.M{"$lt": before},
+		"transfer":      bson.M{"$ne": true},
+		"reminded":      bson.M{"$ne": BookingReminderOverdue},
+	}, "endDate")
+}
+
+// findReminders gets the bookings matching the filter sorted by the given date field.
+func (s *BookingService) findReminders(ctx context.Context, filter bson.M, sortBy string) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: sortBy, Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	bookings := []*Booking{}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// MarkReminded records the reminder as sent for the booking. It returns false if it already was,
+// so concurrent senders agree on which one sends it.
+func (s *BookingService) MarkReminded(ctx context.Context, id primitive.ObjectID, reminder BookingReminder) (bool, error) {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "reminded": bson.M{"$ne": reminder}},
+		bson.M{"$addToSet": bson.M{"reminded": reminder}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// GetOverlapping gets the bookings of the tool in any of the statuses whose dates overlap the period
+// from start to end, see DatesOverlap.
+func (s *BookingService) GetOverlapping(
+	ctx context.Context,
+	toolID string,
+	start, end time.Time,
+	statuses []BookingStatus,
+) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"toolId":        toolID,
+		"bookingStatus": bson.M{"$in": statuses},
+		"startDate":     bson.M{"$lt": end},
+		"endDate":       bson.M{"$gt": start},
+	}, options.Find().SetSort(bson.D{{Key: "startDate", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	bookings := []*Booking{}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// BookedToolIDs returns the IDs of the tools with a booking overlapping the period from start to
+// end that blocks new bookings: the accepted ones, and the pending ones with ExclusivePending.
+func (s *BookingService) BookedToolIDs(ctx context.Context, start, end time.Time) (map[string]bool, error) {
+	ids, err := s.collection.Distinct(ctx, "toolId", bson.M{
+		"bookingStatus": bson.M{"$in": s.blockingStatuses()},
+		"startDate":     bson.M{"$lt": end},
+		"endDate":       bson.M{"$gt": start},
+	})
+	if err != nil {
+		return nil, err
+	}
+	booked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if toolID, ok := id.(string); ok {
+			booked[toolID] = true
+		}
+	}
+	return booked, nil
+}
+
+// GetReturnedByOwners gets the returned bookings of the tools owned by any of the given users,
+// most recently returned first.
+func (s *BookingService) GetReturnedByOwners(ctx context.Context, ownerIDs []primitive.ObjectID) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{
+		"toUserId":      bson.M{"$in": ownerIDs},
+		"bookingStatus": BookingStatusReturned,
+	}, options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var bookings []*Booking
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// CountByTool returns the number of bookings of each tool, indexed by tool ID.
+// Tools without bookings are not included.
+func (s *BookingService) CountByTool(ctx context.Context) (map[string]int64, error) {
+	return s.countByTool(ctx, bson.M{})
+}
+
+// CountBookedByTool returns the number of bookings of each tool that went ahead, see BookedStatuses,
+// indexed by tool ID. Tools never booked are not included.
+func (s *BookingService) CountBookedByTool(ctx context.Context) (map[string]int64, error) {
+	return s.countByTool(ctx, bson.M{"bookingStatus": bson.M{"$in": BookedStatuses}})
+}
+
+// ToolReputation is the aggregation of the completed bookings of a tool, see CompletedStatuses, and
+// of the ratings the owners received on them, that is how the borrowers rated the tool.
+type ToolReputation struct {
+	CompletedBookings int64 `bson:"completedBookings" json:"completedBookings"`
+	RatingCount       int64 `bson:"ratingCount" json:"ratingCount"`
+	// AverageRating is in the 0-100 range of User.Rating, nil if the tool has no ratings
+	AverageRating *int32 `bson:"-" json:"averageRating"`
+	RatingSum     int64  `bson:"ratingSum" json:"-"`
+}
+
+// GetToolReputation aggregates the completed bookings of the tool and their ratings. A tool never
+// booked has zero counts and no average.
+func (s *BookingService) GetToolReputation(ctx context.Context, toolID string) (*ToolReputation, error) {
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"toolId": toolID, "bookingStatus": bson.M{"$in": CompletedStatuses}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "ratings",
+			"localField":   "_id",
+			"foreignField": "bookingId",
+			"as":           "ratings",
+		}}},
+		// Only the ratings received by the owner, the borrower is rated too
+		{{Key: "$set", Value: bson.M{"ratings": bson.M{"$filter": bson.M{
+			"input": "$ratings",
+			"cond":  bson.M{"$eq": bson.A{"$$this.toUserId", "$toUserId"}},
+		}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               nil,
+			"completedBookings": bson.M{"$sum": 1},
+			"ratingCount":       bson.M{"$sum": bson.M{"$size": "$ratings"}},
+			"ratingSum":         bson.M{"$sum": bson.M{"$sum": "$ratings.rating"}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	reputation := &ToolReputation{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(reputation); err != nil {
+			return nil, err
+		}
+	}
+	if reputation.RatingCount > 0 {
+		average := int32(math.Round(float64(reputation.RatingSum) * 100 / float64(reputation.RatingCount*MaxRating)))
+		reputation.AverageRating = &average
+	}
+	return reputation, cursor.Err()
+}
+
+// HasBookings returns true if any booking, whatever its status, references the tool.
+func (s *BookingService) HasBookings(ctx context.Context, toolID string) (bool, error) {
+	count, err := s.collection.CountDocuments(ctx, bson.M{"toolId": toolID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CountBooked returns the number of bookings of the tool that went ahead, see BookedStatuses.
+func (s *BookingService) CountBooked(ctx context.Context, toolID string) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{
+		"toolId":        toolID,
+		"bookingStatus": bson.M{"$in": BookedStatuses},
+	})
+}
+
+// CountActiveBookings returns the number of bookings requested by the user that are active,
+// see ActiveBookingStatuses.
+func (s *BookingService) CountActiveBookings(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.collection.CountDocuments(ctx, bson.M{
+		"fromUserId":    userID,
+		"bookingStatus": bson.M{"$in": ActiveBookingStatuses},
+	})
+}
+
+// countByTool returns the number of bookings matching the filter of each tool, indexed by tool ID.
+func (s *BookingService) countByTool(ctx context.Context, filter bson.M) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$toolId",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		ToolID string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(groups))
+	for _, group := range groups {
+		counts[group.ToolID] = group.Count
+	}
+	return counts, nil
+}
+
+// CountLendsByOwner returns the number of completed lends of each tool owner, returned or handed
+// over bookings, indexed by owner ID.
+func (s *BookingService) CountLendsByOwner(ctx context.Context) (map[primitive.ObjectID]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"bookingStatus": bson.M{"$in": []BookingStatus{
+			BookingStatusReturned,
+			BookingStatusTransferred,
+		}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$toUserId",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		OwnerID primitive.ObjectID `bson:"_id"`
+		Count   int64              `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[primitive.ObjectID]int64, len(groups))
+	for _, group := range groups {
+		counts[group.OwnerID] = group.Count
+	}
+	return counts, nil
+}
+
+// GetActive returns a page of the active bookings of all users sorted by start date,
+// along with the total number of active bookings.
+func (s *BookingService) GetActive(
+	ctx context.Context,
+	page, pageSize int,
+	ascending bool,
+) ([]*Booking, int64, error) {
+	filter := bson.M{"bookingStatus": bson.M{"$in": ActiveBookingStatuses}}
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	order := -1
+	if ascending {
+		order = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "startDate", Value: order}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(page * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	bookings := []*Booking{}
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, 0, err
+	}
+	return bookings, total, nil
+}
+
+// maxAcceptAttempts is how many times Accept tries again when other acceptances of the same tools
+// run concurrently, before giving up with ErrBookingDatesConflict.
+const maxAcceptAttempts = 5
+
+// Accept accepts the booking, or all the bookings of its kit, and rejects the other pending bookings
+// of the same tools overlapping their dates with RejectionReasonToolBooked, since they can no longer
+// go ahead. Kits with any conflicting booking are rejected as a whole. It returns the rejected bookings.
+// If an accepted booking of the same tools overlaps the dates, even one accepted concurrently, it
+// returns ErrBookingDatesConflict and the booking is left as it was. Likewise, it returns
+// ErrTooManyActiveBookings if the requester would have more than maxActive active bookings, see
+// CountActiveBookings, unless maxActive is zero.
+// The actor is the user accepting it, recorded in the status history of all the bookings changed.
+func (s *BookingService) Accept(ctx context.Context, id, actor primitive.ObjectID, maxActive int64) ([]*Booking, error) {
+	var accepted []*Booking
+	for attempt := 1; ; attempt++ {
+		var err error
+		accepted, err = s.acceptOnce(ctx, id, maxActive)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errConcurrentAccept) {
+			return nil, err
+		}
+		if attempt == maxAcceptAttempts {
+			return nil, ErrBookingDatesConflict
+		}
+	}
+	// Only pending bookings can be accepted, see BookingTransitions
+	recordStatusChanges(ctx, s.database, statusChanges(accepted, BookingStatusPending, BookingStatusAccepted, actor))
+	if err := s.statusChanged(ctx, accepted, BookingStatusAccepted); err != nil {
+		return nil, err
+	}
+
+	conflictIDs, groupIDs := []primitive.ObjectID{}, []primitive.ObjectID{}
+	for _, b := range accepted {
+		overlapping, err := s.GetOverlapping(ctx, b.ToolID, b.StartDate, b.EndDate,
+			[]BookingStatus{BookingStatusPending})
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range overlapping {
+			conflictIDs = append(conflictIDs, other.ID)
+			if !other.GroupID.IsZero() {
+				groupIDs = append(groupIDs, other.GroupID)
+			}
+		}
+	}
+	if len(conflictIDs) == 0 {
+		return []*Booking{}, nil
+	}
+
+	// Only the bookings still pending are rejected, so concurrent changes are not overwritten
+	filter := bson.M{
+		"$or": []bson.M{
+			{"_id": bson.M{"$in": conflictIDs}},
+			{"groupId": bson.M{"$in": groupIDs}},
+		},
+		"bookingStatus": BookingStatusPending,
+	}
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	rejected := []*Booking{}
+	if err := cursor.All(ctx, &rejected); err != nil {
+		return nil, err
+	}
+	if len(rejected) == 0 {
+		return rejected, nil
+	}
+	rejectedIDs := make([]primitive.ObjectID, len(rejected))
+	now := time.Now()
+	for i, b := range rejected {
+		rejectedIDs[i] = b.ID
+		b.BookingStatus = BookingStatusRejected
+		b.RejectionReason = RejectionReasonToolBooked
+		b.UpdatedAt = now
+		b.RespondedAt = now
+	}
+	// Accepting another petition answers these ones too
+	if _, err := s.collection.UpdateMany(ctx, bson.M{
+		"_id":           bson.M{"$in": rejectedIDs},
+		"bookingStatus": BookingStatusPending,
+	}, bson.M{"$set": bson.M{
+		"bookingStatus":   BookingStatusRejected,
+		"rejectionReason": RejectionReasonToolBooked,
+		"updatedAt":       now,
+		"respondedAt":     now,
+	}}); err != nil {
+		return nil, err
+	}
+	recordStatusChanges(ctx, s.database, statusChanges(rejected, BookingStatusPending, BookingStatusRejected, actor))
+	for _, b := range rejected {
+		s.Events.Publish(&BookingEvent{Type: BookingEventStatusChanged, Booking: b})
+	}
+	return rejected, nil
+}
+
+// acceptOnce accepts the booking, or all the bookings of its kit, unless an accepted booking
+// overlaps the dates of any of their tools, in which case it returns ErrBookingDatesConflict, or
+// the requester would have more than maxActive active bookings, ErrTooManyActiveBookings.
+//
+// Concurrent acceptances of bookings of the same tool are detected with the accept version of the
+// tool: it is read before looking for overlapping bookings and increased once the booking is
+// accepted, only if nobody else increased it meanwhile. Otherwise the acceptance is undone and
+// errConcurrentAccept returned, so it is tried again and finds the other accepted booking. The
+// accept version of the requester detects the concurrent acceptances of their bookings the same way,
+// so they cannot exceed maxActive together.
+func (s *BookingService) acceptOnce(ctx context.Context, id primitive.ObjectID, maxActive int64) ([]*Booking, error) {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	bookings := []*Booking{booking}
+	if !booking.GroupID.IsZero() {
+		if bookings, err = s.GetGroup(ctx, booking.GroupID); err != nil {
+			return nil, err
+		}
+	}
+	versions, err := s.acceptVersions(ctx, bookings)
+	if err != nil {
+		return nil, err
+	}
+	var requester User
+	if err := s.database.Collection("users").FindOne(ctx, bson.M{"_id": booking.FromUserID},
+		options.FindOne().SetProjection(bson.M{"acceptVersion": 1})).Decode(&requester); err != nil {
+		return nil, err
+	}
+	for _, b := range bookings {
+		overlapping, err := s.GetOverlapping(ctx, b.ToolID, b.StartDate, b.EndDate,
+			[]BookingStatus{BookingStatusAccepted})
+		if err != nil {
+			return nil, err
+		}
+		if len(overlapping) > 0 {
+			return nil, ErrBookingDatesConflict
+		}
+	}
+	if maxActive > 0 {
+		active, err := s.CountActiveBookings(ctx, booking.FromUserID)
+		if err != nil {
+			return nil, err
+		}
+		if active+int64(len(bookings)) > maxActive {
+			return nil, ErrTooManyActiveBookings
+		}
+	}
+
+	accepted, previous, err := s.setStatus(ctx, id, BookingStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := s.bumpAcceptVersions(ctx, versions)
+	if err == nil && ok {
+		ok, err = bumpAcceptVersion(ctx, s.database.Collection("users"), booking.FromUserID, requester.AcceptVersion)
+	}
+	if err == nil && ok {
+		return accepted, nil
+	}
+	ids := make([]primitive.ObjectID, len(accepted))
+	for i, b := range accepted {
+		ids[i] = b.ID
+	}
+	if _, uerr := s.collection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": ids}, "bookingStatus": BookingStatusAccepted},
+		bson.M{
+			"$set":   bson.M{"bookingStatus": previous, "updatedAt": booking.UpdatedAt},
+			"$unset": bson.M{"respondedAt": ""},
+		},
+	); uerr != nil {
+		return nil, fmt.Errorf("could not undo the acceptance of booking %s: %w", id.Hex(), uerr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errConcurrentAccept
+}
+
+// bumpAcceptVersions increases the accept version of the tools, as read by acceptVersions, only if
+// nobody else increased it meanwhile. It returns false if any of them was increased concurrently.
+func (s *BookingService) bumpAcceptVersions(ctx context.Context, versions map[int64]int64) (bool, error) {
+	tools := s.database.Collection("tools")
+	for toolID, version := range versions {
+		ok, err := bumpAcceptVersion(ctx, tools, toolID, version)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// bumpAcceptVersion increases the accept version of the document with the given id in the
+// collection, only if it is still the given one. It returns false if it was increased concurrently.
+func bumpAcceptVersion(ctx context.Context, collection *mongo.Collection, id interface{}, version int64) (bool, error) {
+	filter := bson.M{"_id": id, "acceptVersion": version}
+	if version == 0 {
+		// Documents never accepted before have no version yet
+		filter["acceptVersion"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"acceptVersion": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// acceptVersions returns the accept version of the tools of the bookings, see Tool.AcceptVersion.
+// The tools that no longer exist are left out.
+func (s *BookingService) acceptVersions(ctx context.Context, bookings []*Booking) (map[int64]int64, error) {
+	versions := map[int64]int64{}
+	for _, b := range bookings {
+		toolID, err := strconv.ParseInt(b.ToolID, 10, 64)
+		if err != nil {
+			continue
+		}
+		var tool Tool
+		err = s.database.Collection("tools").FindOne(ctx, bson.M{"_id": toolID},
+			options.FindOne().SetProjection(bson.M{"acceptVersion": 1})).Decode(&tool)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions[toolID] = tool.AcceptVersion
+	}
+	return versions, nil
+}
+
+// UpdateStatus updates the booking status and handles any related updates.
+// If the booking is part of a kit, all the bookings of the group are updated.
+// It returns ErrInvalidTransition if BookingTransitions does not allow moving from the
+// current status, or if the status changed concurrently. The actor is the user changing the status,
+// recorded in the status history, or zero for the changes made by the platform.
+func (s *BookingService) UpdateStatus(
+	ctx context.Context,
+	id primitive.ObjectID,
+	status BookingStatus,
+	actor primitive.ObjectID,
+) error {
+	bookings, previous, err := s.setStatus(ctx, id, status)
+	if err != nil {
+		return err
+	}
+	recordStatusChanges(ctx, s.database, statusChanges(bookings, previous, status, actor))
+	return s.statusChanged(ctx, bookings, status)
+}
+
+// moveBookings moves the bookings matching the filter to the status, setting the fields of set too,
+// and records the changes in the status history as made by the actor. Each booking is only moved if
+// it still matches the filter and its status did not change meanwhile. It returns the number of
+// bookings moved.
+func moveBookings(
+	ctx context.Context,
+	database *mongo.Database,
+	filter bson.M,
+	status BookingStatus,
+	set bson.M,
+	actor primitive.ObjectID,
+) (int64, error) {
+	collection := database.Collection("bookings")
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"bookingStatus": 1}))
+	if err != nil {
+		return 0, err
+	}
+	matching := []*Booking{}
+	if err := cursor.All(ctx, &matching); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	update := bson.M{"bookingStatus": status, "updatedAt": now}
+	for field, value := range set {
+		update[field] = value
+	}
+	changes := []*BookingStatusHistory{}
+	defer func() {
+		recordStatusChanges(ctx, database, changes)
+	}()
+	for _, b := range matching {
+		current := bson.M{}
+		for field, value := range filter {
+			current[field] = value
+		}
+		current["_id"], current["bookingStatus"] = b.ID, b.BookingStatus
+		result, err := collection.UpdateOne(ctx, current, bson.M{"$set": update})
+		if err != nil {
+			return int64(len(changes)), err
+		}
+		if result.ModifiedCount > 0 {
+			changes = append(changes, &BookingStatusHistory{
+				BookingID:   b.ID,
+				FromStatus:  b.BookingStatus,
+				ToStatus:    status,
+				ActorUserID: actor,
+				Timestamp:   now,
+			})
+		}
+	}
+	return int64(len(changes)), nil
+}
+
+// setStatus moves the booking, or all the bookings of its kit, to the status. It returns the
+// updated bookings and the status they had, or ErrInvalidTransition if BookingTransitions does not
+// allow the move or the status changed concurrently.
+func (s *BookingService) setStatus(
+	ctx context.Context,
+	id primitive.ObjectID,
+	status BookingStatus,
+) ([]*Booking, BookingStatus, error) {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	previous := booking.BookingStatus
+	if !CanTransition(previous, status) {
+		return nil, "", ErrInvalidTransition
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"bookingStatus": status,
+		"updatedAt":     now,
+	}
+	// Accepting or denying a petition is the answer of the owner, see Booking.RespondedAt
+	answered := previous == BookingStatusPending &&
+		(status == BookingStatusAccepted || status == BookingStatusRejected)
+	if answered {
+		set["respondedAt"] = now
+	}
+	update := bson.M{"$set": set}
+
+	bookings := []*Booking{booking}
+	filter := bson.M{"_id": id, "bookingStatus": previous}
+	if !booking.GroupID.IsZero() {
+		if bookings, err = s.GetGroup(ctx, booking.GroupID); err != nil {
+			return nil, "", err
+		}
+		filter = bson.M{"groupId": booking.GroupID, "bookingStatus": previous}
+	}
+
+	result, err := s.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, "", err
+	}
+	if result.MatchedCount == 0 {
+		return nil, "", ErrInvalidTransition
+	}
+	for _, b := range bookings {
+		b.BookingStatus = status
+		b.UpdatedAt = now
+		if answered {
+			b.RespondedAt = now
+		}
+	}
+	return bookings, previous, nil
+}
+
+// statusChanged publishes the new status of the bookings and, on acceptance, reserves their
+// dates on the tools.
+func (s *BookingService) statusChanged(ctx context.Context, bookings []*Booking, status BookingStatus) error {
+	for _, b := range bookings {
+		s.Events.Publish(&BookingEvent{Type: BookingEventStatusChanged, Booking: b})
+	}
+
+	// If accepting booking, update tool's reserved dates
+	if status == BookingStatusAccepted {
+		// Get tool service from database
+		toolService := s.database.Collection("tools")
+
+		for _, b := range bookings {
+			// Add reserved dates to tool
+			update := bson.M{
+				"$push": bson.M{
+					"reservedDates": bson.M{
+						"from": b.StartDate,
+						"to":   b.EndDate,
+					},
+				},
+			}
+			_, err := toolService.UpdateOne(ctx, bson.M{"_id": b.ToolID}, update)
+			if err != nil {
+				return fmt.Errorf("could not update tool reserved dates: %w", err)
+			}
+		}
+	}
+
+	// Nomadic tools go on to their next borrower when returned
+	if status == BookingStatusReturned {
+		for _, b := range bookings {
+			if err := s.passNomadicTool(ctx, b); err != nil {
+				return fmt.Errorf("could not update tool holder: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// passNomadicTool hands the tool of the returned booking, if nomadic, to the requester of its
+// next accepted booking, or back to its owner if there is none. Other tools are left untouched.
+func (s *BookingService) passNomadicTool(ctx context.Context, returned *Booking) error {
+	toolID, err := strconv.ParseInt(returned.ToolID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	tools := s.database.Collection("tools")
+	var tool Tool
+	if err := tools.FindOne(ctx, bson.M{"_id": toolID}).Decode(&tool); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	if !tool.IsNomadic {
+		return nil
+	}
+
+	update := bson.M{"$unset": bson.M{"holderId": ""}}
+	var next Booking
+	err = s.collection.FindOne(ctx, bson.M{
+		"toolId":        returned.ToolID,
+		"bookingStatus": BookingStatusAccepted,
+		"_id":           bson.M{"$ne": returned.ID},
+	}, options.FindOne().SetSort(bson.D{{Key: "startDate", Value: 1}})).Decode(&next)
+	switch {
+	case err == nil:
+		update = bson.M{"$set": bson.M{"holderId": next.FromUserID}}
+	case !errors.Is(err, mongo.ErrNoDocuments):
+		return err
+	}
+	_, err = tools.UpdateOne(ctx, bson.M{"_id": toolID}, update)
+	return err
+}
+
+// SetCharge records the token charge of a booking. The charge is recorded only once, it returns
+// ErrBookingCharged if the booking already has one, so the tokens are not charged twice.
+func (s *BookingService) SetCharge(ctx context.Context, id primitive.ObjectID, charge *BookingCharge) error {
+	result, err := s.collection.UpdateOne(ctx, bson.M{"_id": id, "charge": nil}, bson.M{
+		"$set": bson.M{
+			"charge":    charge,
+			"updatedAt": time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := s.collection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrBookingNotFound
+		}
+		return ErrBookingCharged
+	}
+	return nil
+}
+
+// SetChargeOutstanding marks the charge of the booking as outstanding or not. Clearing it only
+// succeeds if it was outstanding, so a single caller collects it: it returns false otherwise.
+func (s *BookingService) SetChargeOutstanding(ctx context.Context, id primitive.ObjectID, outstanding bool) (bool, error) {
+	filter := bson.M{"_id": id, "charge": bson.M{"$ne": nil}}
+	if !outstanding {
+		filter["charge.outstanding"] = true
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"charge.outstanding": outstanding, "updatedAt": time.Now()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// GetOutstandingCharges gets the bookings whose charge the requester could not pay yet, the oldest
+// first.
+func (s *BookingService) GetOutstandingCharges(ctx context.Context) ([]*Booking, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{"charge.outstanding": true},
+		options.Find().SetSort(bson.D{{Key: "endDate", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var bookings []*Booking
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// SetDates sets the dates of an open booking, turning it into a pending one. The actor is the user
+// setting them, recorded in the status history.
+func (s *BookingService) SetDates(ctx context.Context, id, actor primitive.ObjectID, start, end time.Time) error {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if booking.BookingStatus != BookingStatusOpen {
+		return ErrBookingNotOpen
+	}
+
+	if err := s.validateDates(start, end); err != nil {
+		return err
+	}
+	if err := s.checkAvailability(ctx, booking.ToolID, start, end, id); err != nil {
+		return err
+	}
+
+	booking.UpdatedAt = time.Now()
+	result, err := s.collection.UpdateOne(ctx, bson.M{
+		"_id":           id,
+		"bookingStatus": BookingStatusOpen,
+	}, bson.M{
+		"$set": bson.M{
+			"startDate":     start,
+			"endDate":       end,
+			"bookingStatus": BookingStatusPending,
+			"updatedAt":     booking.UpdatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrBookingNotOpen
+	}
+	recordStatusChanges(ctx, s.database,
+		statusChanges([]*Booking{booking}, BookingStatusOpen, BookingStatusPending, actor))
+	return nil
+}
+
+// reschedulable returns the booking, the bookings of its kit and the filter matching them while they
+// can still be rescheduled. It returns ErrCannotReschedule unless the booking is pending or accepted.
+func (s *BookingService) reschedulable(ctx context.Context, id primitive.ObjectID) (*Booking, []*Booking, bson.M, error) {
+	booking, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if booking.BookingStatus != BookingStatusPending && booking.BookingStatus != BookingStatusAccepted {
+		return nil, nil, nil, ErrCannotReschedule
+	}
+	bookings := []*Booking{booking}
+	filter := bson.M{"_id": id}
+	if !booking.GroupID.IsZero() {
+		if bookings, err = s.GetGroup(ctx, booking.GroupID); err != nil {
+			return nil, nil, nil, err
+		}
+		filter = bson.M{"groupId": booking.GroupID}
+	}
+	filter["bookingStatus"] = bson.M{"$in": []BookingStatus{BookingStatusPending, BookingStatusAccepted}}
+	return booking, bookings, filter, nil
+}
+
+// ProposeReschedule records the proposal of the user to move a pending or accepted booking, along
+// with the rest of its kit, to new dates, replacing any previous one. The dates only change once the
+// other party accepts it, see AcceptReschedule. They go through the same validation and availability
+// checks as new bookings, ignoring the rescheduled bookings themselves. It returns ErrCannotReschedule
+// for bookings in any other status.
+func (s *BookingService) ProposeReschedule(
+	ctx context.Context,
+	id primitive.ObjectID,
+	start, end time.Time,
+	proposedBy primitive.ObjectID,
+) error {
+	_, bookings, filter, err := s.reschedulable(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.validateDates(start, end); err != nil {
+		return err
+	}
+	for _, b := range bookings {
+		if err := s.checkAvailability(ctx, b.ToolID, start, end, b.ID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	result, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"reschedule": &RescheduleProposal{StartDate: start, EndDate: end, ProposedBy: proposedBy, ProposedAt: now},
+		"updatedAt":  now,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrCannotReschedule
+	}
+	return nil
+}
+
+// AcceptReschedule moves the booking, along with the rest of its kit, to the dates the other party
+// proposed, and returns the proposal applied. It returns ErrNoReschedule if there is no proposal or
+// the actor made it. The dates are checked again as when proposed, and concurrent acceptances of
+// bookings of the same tools are detected with their accept version, as Accept does, so they cannot
+// both take the same dates.
+func (s *BookingService) AcceptReschedule(
+	ctx context.Context,
+	id, actor primitive.ObjectID,
+) (*RescheduleProposal, error) {
+	for attempt := 1; ; attempt++ {
+		proposal, err := s.acceptRescheduleOnce(ctx, id, actor)
+		if !errors.Is(err, errConcurrentAccept) {
+			return proposal, err
+		}
+		if attempt == maxAcceptAttempts {
+			return nil, ErrBookingDatesConflict
+		}
+	}
+}
+
+// acceptRescheduleOnce applies the proposal of the booking unless the dates are no longer available.
+// As acceptOnce, it returns errConcurrentAccept, undoing the new dates, if the accept version of any
+// of the tools changed meanwhile.
+func (s *BookingService) acceptRescheduleOnce(
+	ctx context.Context,
+	id, actor primitive.ObjectID,
+) (*RescheduleProposal, error) {
+	booking, bookings, filter, err := s.reschedulable(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	proposal := booking.Reschedule
+	if proposal == nil || proposal.ProposedBy == actor {
+		return nil, ErrNoReschedule
+	}
+	if err := s.validateDates(proposal.StartDate, proposal.EndDate); err != nil {
+		return nil, err
+	}
+	versions, err := s.acceptVersions(ctx, bookings)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bookings {
+		if err := s.checkAvailability(ctx, b.ToolID, proposal.StartDate, proposal.EndDate, b.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the proposal read is applied, not one replacing it meanwhile
+	filter["reschedule.proposedAt"] = proposal.ProposedAt
+	result, err := s.collection.UpdateMany(ctx, filter, bson.M{
+		"$set": bson.M{
+			"startDate": proposal.StartDate,
+			"endDate":   proposal.EndDate,
+			"updatedAt": time.Now(),
+		},
+		// The new dates get their own reminders
+		"$unset": bson.M{"reschedule": "", "reminded": ""},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		// Tried again to tell why
+		return nil, errConcurrentAccept
+	}
+	ok, err := s.bumpAcceptVersions(ctx, versions)
+	if err == nil && ok {
+		return proposal, nil
+	}
+	for _, b := range bookings {
+		if _, uerr := s.collection.UpdateOne(ctx,
+			bson.M{"_id": b.ID, "startDate": proposal.StartDate, "endDate": proposal.EndDate},
+			bson.M{"$set": bson.M{
+				"startDate":  b.StartDate,
+				"endDate":    b.EndDate,
+				"updatedAt":  b.UpdatedAt,
+				"reschedule": proposal,
+				"reminded":   b.Reminded,
+			}},
+		); uerr != nil {
+			return nil, fmt.Errorf("could not undo the reschedule of booking %s: %w", b.ID.Hex(), uerr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, errConcurrentAccept
+}
+
+// toolUnavailability returns the tool with only its unavailability periods. A tool that does not
+// exist has none.
+func (s *BookingService) toolUnavailability(ctx context.Context, toolID string) (*Tool, error) {
+	id, err := strconv.ParseInt(toolID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tool ID %q: %w", toolID, err)
+	}
+	var tool Tool
+	err = s.database.Collection("tools").FindOne(ctx, bson.M{"_id": id},
+		options.FindOne().SetProjection(bson.M{"unavailability": 1})).Decode(&tool)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	return &tool, nil
+}
+
+// NextAvailable returns the earliest start, not before from, of a period of the given duration that
+// ends by until and that checkAvailability accepts: it overlaps neither the unavailability periods of
+// the tool nor its bookings blocking new ones. It returns false if there is no such period.
+func (s *BookingService) NextAvailable(
+	ctx context.Context,
+	toolID string,
+	from time.Time,
+	duration time.Duration,
+	until time.Time,
+) (time.Time, bool, error) {
+	tool, err := s.toolUnavailability(ctx, toolID)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	bookings, err := s.GetOverlapping(ctx, toolID, from, until, s.blockingStatuses())
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	busy := slices.Clone(tool.Unavailability)
+	for _, booking := range bookings {
+		busy = append(busy, DateRange{From: uint32(booking.StartDate.Unix()), To: uint32(booking.EndDate.Unix())})
+	}
+	start, ok := FirstFreePeriod(busy, from, duration, until)
+	return start, ok, nil
+}
+
+// FirstFreePeriod returns the earliest start, not before from, of a period of the given duration that
+// ends by until and overlaps none of the busy ones, see DatesOverlap. It returns false if there is none.
+func FirstFreePeriod(busy []DateRange, from time.Time, duration time.Duration, until time.Time) (time.Time, bool) {
+	busy = slices.Clone(busy)
+	slices.SortFunc(busy, func(a, b DateRange) int {
+		return cmp.Compare(a.From, b.From)
+	})
+	start := from
+	for _, period := range busy {
+		if !time.Unix(int64(period.From), 0).Before(start.Add(duration)) {
+			// this one and the rest start after the candidate period ends
+			break
+		}
+		if period.Overlaps(start, start.Add(duration)) {
+			start = time.Unix(int64(period.To), 0)
+		}
+	}
+	if start.Add(duration).After(until) {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// blockingStatuses returns the statuses of the bookings that block their dates for new bookings.
+func (s *BookingService) blockingStatuses() []BookingStatus {
+	if s.ExclusivePending {
+		return []BookingStatus{BookingStatusAccepted, BookingStatusPending}
+	}
+	return []BookingStatus{BookingStatusAccepted}
+}
+
+// DatesOverlap reports whether the booking periods [aStart, aEnd) and [bStart, bEnd) overlap.
+// Periods are end-exclusive, so a booking ending at the same instant another starts does not
+// overlap it and back-to-back bookings are allowed.
+func DatesOverlap(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// validateDates returns ErrInvalidBookingDates if the booking does not end after it starts or starts
+// in the past, and ErrBookingTooLong if it lasts longer than MaxDuration.
+func (s *BookingService) validateDates(start, end time.Time) error {
+	if !end.After(start) || start.Unix() < time.Now().Unix() {
+		return ErrInvalidBookingDates
+	}
+	if s.MaxDuration > 0 && end.Sub(start) > s.MaxDuration {
+		return ErrBookingTooLong
+	}
+	return nil
+}
+
+// checkAvailability checks that a tool can be booked for the given dates. It returns
+// ErrToolUnavailable if the owner marked the tool as unavailable during the dates and
+// ErrBookingDatesConflict if they conflict with another booking, excluding the one with excludeID.
+func (s *BookingService) checkAvailability(
+	ctx context.Context,
+	toolID string,
+	start, end time.Time,
+	excludeID primitive.ObjectID,
+) error {
+	tool, err := s.toolUnavailability(ctx, toolID)
+	if err != nil {
+		return err
+	}
+	if tool.UnavailableDuring(start, end) {
+		return ErrToolUnavailable
+	}
+
+	conflictExists, err := s.checkDateConflicts(ctx, toolID, start, end, excludeID, s.blockingStatuses())
+	if err != nil {
+		return err
+	}
+	if conflictExists {
+		return ErrBookingDatesConflict
+	}
+	return nil
+}
+
+// checkDateConflicts checks if there are any conflicting bookings for the given tool and dates.
+// It takes a tool ID, start and end times, an optional booking ID to exclude from the check and
+// the statuses of the bookings to take into account. Dates conflict as defined by DatesOverlap.
+func (s *BookingService) checkDateConflicts(
+	ctx context.Context,
+	toolID string,
+	start, end time.Time,
+	excludeID primitive.ObjectID,
+	statuses []BookingStatus,
+) (bool, error) {
+	// Any overlap conflicts, see DatesOverlap: the existing booking starts before the new one
+	// ends and ends after the new one starts
+	filter := bson.M{
+		"toolId":        toolID,
+		"bookingStatus": bson.M{"$in": statuses},
+		"startDate":     bson.M{"$lt": end},
+		"endDate":       bson.M{"$gt": start},
+	}
+
+	// Exclude the current booking if updating
+	if excludeID != primitive.NilObjectID {
+		filter["_id"] = bson.M{"$ne": excludeID}
+	}
+
+	count, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetPendingRatings gets the returned bookings of the user that the user did not rate yet.
+func (s *BookingService) GetPendingRatings(ctx context.Context, userID primitive.ObjectID) ([]*Booking, error) {
+	filter, err := s.pendingRatingsFilter(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var bookings []*Booking
+	if err = cursor.All(ctx, &bookings); err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// CountPendingRatings returns the number of bookings GetPendingRatings would return.
+func (s *BookingService) CountPendingRatings(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	filter, err := s.pendingRatingsFilter(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return s.collection.CountDocuments(ctx, filter)
+}
+
+// pendingRatingsFilter returns the filter of the returned bookings of the user not rated by the user.
+func (s *BookingService) pendingRatingsFilter(ctx context.Context, userID primitive.ObjectID) (bson.M, error) {
+	rated, err := s.database.Collection("ratings").Distinct(ctx, "bookingId", bson.M{"fromUserId": userID})
+	if err != nil {
+		return nil, err
+	}
+	if rated == nil {
+		rated = []interface{}{}
+	}
+	return bson.M{
+		"$or": []bson.M{
+			{"fromUserId": userID},
+			{"toUserId": userID},
+		},
+		"bookingStatus": BookingStatusReturned,
+		"_id":           bson.M{"$nin": rated},
+	}, nil
+}
+
+// UserCompletedBookings are the number of completed bookings of a user, see CompletedStatuses.
+type UserCompletedBookings struct {
+	// AsOwner are the bookings of the tools of the user
+	AsOwner int64 `json:"asOwner"`
+	// AsRequester are the bookings requested by the user
+	AsRequester int64 `json:"asRequester"`
+}
+
+// CountUserCompleted returns the number of completed bookings of the user as owner and as
+// requester, counted with a single aggregation. Cancelled, rejected and ongoing bookings are left out.
+func (s *BookingService) CountUserCompleted(ctx context.Context, userID primitive.ObjectID) (*UserCompletedBookings, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or": []bson.M{
+				{"fromUserId": userID},
+				{"toUserId": userID},
+			},
+			"bookingStatus": bson.M{"$in": CompletedStatuses},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$eq": bson.A{"$toUserId", userID}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		Owner bool  `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	completed := &UserCompletedBookings{}
+	for _, group := range groups {
+		if group.Owner {
+			completed.AsOwner = group.Count
+		} else {
+			completed.AsRequester = group.Count
+		}
+	}
+	return completed, nil
+}
+
+// UserBookingCounts are the number of ongoing bookings of a user, both as owner and as requester.
+type UserBookingCounts struct {
+	// PendingPetitions are the petitions for the tools of the user waiting for an answer
+	PendingPetitions int64 `json:"pendingPetitions"`
+	// PendingRequests are the requests of the user waiting for an answer
+	PendingRequests int64 `json:"pendingRequests"`
+	// ActiveLends are the accepted bookings of the tools of the user
+	ActiveLends int64 `json:"activeLends"`
+	// ActiveBorrows are the accepted bookings requested by the user
+	ActiveBorrows int64 `json:"activeBorrows"`
+	// OverdueBorrows are the ActiveBorrows whose end date already passed
+	OverdueBorrows int64 `json:"overdueBorrows"`
+}
+
+// CountUserBookings returns the number of pending and accepted bookings of the user, counted with
+// a single aggregation.
+func (s *BookingService) CountUserBookings(ctx context.Context, userID primitive.ObjectID) (*UserBookingCounts, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"$or": []bson.M{
+				{"fromUserId": userID},
+				{"toUserId": userID},
+			},
+			"bookingStatus": bson.M{"$in": []BookingStatus{BookingStatusPending, BookingStatusAccepted}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"status":  "$bookingStatus",
+				"owner":   bson.M{"$eq": bson.A{"$toUserId", userID}},
+				"overdue": bson.M{"$lt": bson.A{"$endDate", time.Now()}},
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		ID struct {
+			Status  BookingStatus `bson:"status"`
+			Owner   bool          `bson:"owner"`
+			Overdue bool          `bson:"overdue"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := &UserBookingCounts{}
+	for _, group := range groups {
+		switch {
+		case group.ID.Status == BookingStatusPending && group.ID.Owner:
+			counts.PendingPetitions += group.Count
+		case group.ID.Status == BookingStatusPending:
+			counts.PendingRequests += group.Count
+		case group.ID.Owner:
+			counts.ActiveLends += group.Count
+		default:
+			counts.ActiveBorrows += group.Count
+			if group.ID.Overdue {
+				counts.OverdueBorrows += group.Count
+			}
+		}
+	}
+	return counts, nil
 }
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BookingEventType is the kind of change a booking event reports
+type BookingEventType string
+
+const (
+	BookingEventCreated       BookingEventType = "created"
+	BookingEventStatusChanged BookingEventType = "status"
+)
+
+// bookingEventBuffer is how many events a subscriber can fall behind before new ones are dropped.
+const bookingEventBuffer = 32
+
+// BookingEvent is published when a booking is created or changes its status
+type BookingEvent struct {
+	Type    BookingEventType `json:"type"`
+	Booking *Booking         `json:"booking"`
+}
+
+// BookingEventHub is an in-process publish/subscribe hub of booking events. Each subscriber
+// receives the events of the bookings where it is the requester or the owner.
+type BookingEventHub struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[chan *BookingEvent]struct{}
+	delivered   atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+// BookingEventStats are the counters of a BookingEventHub
+type BookingEventStats struct {
+	// Delivered is the number of events sent to a subscriber
+	Delivered uint64
+	// Dropped is the number of events missed by subscribers whose buffer was full
+	Dropped uint64
+	// Subscribers is the number of current subscribers
+	Subscribers int
+}
+
+// NewBookingEventHub creates an empty hub
+func NewBookingEventHub() *BookingEventHub {
+	return &BookingEventHub{subscribers: make(map[primitive.ObjectID]map[chan *BookingEvent]struct{})}
+}
+
+// Subscribe registers a subscriber for the events of the user. The returned function
+// unsubscribes it and closes the channel, it must be called once the subscriber is done.
+func (h *BookingEventHub) Subscribe(userID primitive.ObjectID) (<-chan *BookingEvent, func()) {
+	ch := make(chan *BookingEvent, bookingEventBuffer)
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan *BookingEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subscribers[userID], ch)
+			if len(h.subscribers[userID]) == 0 {
+				delete(h.subscribers, userID)
+			}
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the event to the subscribers of both parties of the booking. It never blocks:
+// subscribers whose buffer is full miss the event.
+func (h *BookingEventHub) Publish(event *BookingEvent) {
+	if h == nil {
+		return
+	}
+	// Subscribers get their own copy, so the caller can keep using the booking
+	booking := *event.Booking
+	event = &BookingEvent{Type: event.Type, Booking: &booking}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	users := []primitive.ObjectID{booking.FromUserID}
+	if booking.ToUserID != booking.FromUserID {
+		users = append(users, booking.ToUserID)
+	}
+	for _, userID := range users {
+		for ch := range h.subscribers[userID] {
+			select {
+			case ch <- event:
+				h.delivered.Add(1)
+			default:
+				h.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Stats returns the counters of the hub
+func (h *BookingEventHub) Stats() BookingEventStats {
+	h.mu.Lock()
+	subscribers := 0
+	for _, chans := range h.subscribers {
+		subscribers += len(chans)
+	}
+	h.mu.Unlock()
+	return BookingEventStats{
+		Delivered:   h.delivered.Load(),
+		Dropped:     h.dropped.Load(),
+		Subscribers: subscribers,
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BookingStatusHistory represents the schema for the "booking_status_history" collection.
+// An entry is appended on every change of the status of a booking, so disputes can tell who
+// changed it and when.
+type BookingStatusHistory struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID  primitive.ObjectID `bson:"bookingId" json:"bookingId"`
+	FromStatus BookingStatus      `bson:"fromStatus" json:"fromStatus"`
+	ToStatus   BookingStatus      `bson:"toStatus" json:"toStatus"`
+	// ActorUserID is the user who changed the status, zero for the changes made by the platform,
+	// such as the petitions expired or returned by the background worker.
+	ActorUserID primitive.ObjectID `bson:"actorUserId,omitempty" json:"actorUserId"`
+	Timestamp   time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+// statusChanges returns the history entries of the bookings moved from the from status to the
+// to status by the actor, timestamped with their last update.
+func statusChanges(bookings []*Booking, from, to BookingStatus, actor primitive.ObjectID) []*BookingStatusHistory {
+	changes := make([]*BookingStatusHistory, len(bookings))
+	for i, b := range bookings {
+		changes[i] = &BookingStatusHistory{
+			BookingID:   b.ID,
+			FromStatus:  from,
+			ToStatus:    to,
+			ActorUserID: actor,
+			Timestamp:   b.UpdatedAt,
+		}
+	}
+	return changes
+}
+
+// recordStatusChanges appends the entries to the status history. The statuses are already
+// changed by then, so failures are logged but not returned.
+func recordStatusChanges(ctx context.Context, database *mongo.Database, changes []*BookingStatusHistory) {
+	if len(changes) == 0 {
+		return
+	}
+	docs := make([]interface{}, len(changes))
+	for i, change := range changes {
+		docs[i] = change
+	}
+	if _, err := database.Collection("booking_status_history").InsertMany(ctx, docs); err != nil {
+		log.Warn().Err(err).Msgf("could not record %d booking status changes", len(changes))
+	}
+}
+
+// GetStatusHistory returns the status changes of the booking, oldest first.
+func (s *BookingService) GetStatusHistory(
+	ctx context.Context,
+	bookingID primitive.ObjectID,
+) ([]*BookingStatusHistory, error) {
+	cursor, err := s.database.Collection("booking_status_history").Find(ctx, bson.M{"bookingId": bookingID},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	changes := []*BookingStatusHistory{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BookingMessage represents the schema for the "booking_messages" collection.
+// Messages are exchanged by the owner and the requester of a booking.
+type BookingMessage struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID primitive.ObjectID `bson:"bookingId" json:"bookingId"`
+	AuthorID  primitive.ObjectID `bson:"authorId" json:"authorId"`
+	Text      string             `bson:"text" json:"text"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// BookingMessageService provides methods to interact with the "booking_messages" collection.
+type BookingMessageService struct {
+	Collection *mongo.Collection
+}
+
+// NewBookingMessageService creates a new BookingMessageService.
+func NewBookingMessageService(db *Database) *BookingMessageService {
+	return &BookingMessageService{
+		Collection: db.Database.Collection("booking_messages"),
+	}
+}
+
+// Create stores a new message of a booking.
+func (s *BookingMessageService) Create(ctx context.Context, message *BookingMessage) (*BookingMessage, error) {
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now()
+	}
+	result, err := s.Collection.InsertOne(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	message.ID = result.InsertedID.(primitive.ObjectID)
+	return message, nil
+}
+
+// GetBookingMessages returns the messages of the booking, oldest first.
+func (s *BookingMessageService) GetBookingMessages(ctx context.Context, bookingID primitive.ObjectID) ([]*BookingMessage, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"bookingId": bookingID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	messages := []*BookingMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
@@ -66,33 +66,114 @@ func TestBookingService(t *testing.T) {
 	})
 
 	c.Run("Date Conflict Detection", func(c *qt.C) {
-		toolID := "123456"
-		fromUserID := primitive.NewObjectID()
+		toolID := "123457"
 		toUserID := primitive.NewObjectID()
+		now := time.Now().Truncate(time.Second)
+		day := func(n int) time.Time { return now.Add(time.Duration(n) * 24 * time.Hour) }
+		book := func(from, to int) (*Booking, error) {
+			return bookingService.Create(ctx, &CreateBookingRequest{
+				ToolID:    toolID,
+				StartDate: day(from),
+				EndDate:   day(to),
+				Contact:   "test@example.com",
+			}, primitive.NewObjectID(), toUserID)
+		}
 
-		// Create and accept initial booking
-		req1 := &CreateBookingRequest{
-			ToolID:    toolID,
+		// Create and accept the booking of days 4 to 6
+		booking, err := book(4, 6)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create first booking"))
+		err = bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted, primitive.NilObjectID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to accept first booking"))
+
+		for _, tc := range []struct {
+			name     string
+			from, to int
+			conflict bool
+		}{
+			{"same dates", 4, 6, true},
+			{"overlapping the start", 3, 5, true},
+			{"overlapping the end", 5, 7, true},
+			{"contained", 5, 6, true},
+			{"containing", 2, 8, true},
+			{"adjacent before", 2, 4, false},
+			{"adjacent after", 6, 8, false},
+			{"disjoint", 9, 10, false},
+		} {
+			created, err := book(tc.from, tc.to)
+			if tc.conflict {
+				c.Assert(err, qt.Equals, ErrBookingDatesConflict, qt.Commentf(tc.name))
+				continue
+			}
+			c.Assert(err, qt.IsNil, qt.Commentf(tc.name))
+			// Keep the allowed bookings pending, so they do not block the next cases
+			c.Assert(created.BookingStatus, qt.Equals, BookingStatusPending)
+		}
+	})
+
+	c.Run("Pending Bookings Conflicts", func(c *qt.C) {
+		toolID := "246810"
+		toUserID := primitive.NewObjectID()
+		newRequest := func(startOffset time.Duration) *CreateBookingRequest {
+			return &CreateBookingRequest{
+				ToolID:    toolID,
+				StartDate: time.Now().Add(startOffset),
+				EndDate:   time.Now().Add(startOffset + 24*time.Hour),
+				Contact:   "test@example.com",
+			}
+		}
+		_, err := bookingService.Create(ctx, newRequest(24*time.Hour), primitive.NewObjectID(), toUserID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create first booking"))
+
+		// By default, overlapping pending bookings are allowed
+		_, err = bookingService.Create(ctx, newRequest(36*time.Hour), primitive.NewObjectID(), toUserID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Overlapping pending booking should be allowed"))
+
+		// In exclusive mode, pending bookings block their dates
+		bookingService.ExclusivePending = true
+		defer func() { bookingService.ExclusivePending = false }()
+		_, err = bookingService.Create(ctx, newRequest(36*time.Hour), primitive.NewObjectID(), toUserID)
+		c.Assert(err, qt.Equals, ErrBookingDatesConflict)
+
+		// Non overlapping bookings are still allowed
+		_, err = bookingService.Create(ctx, newRequest(240*time.Hour), primitive.NewObjectID(), toUserID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Non overlapping booking should be allowed"))
+	})
+
+	c.Run("Booking Group Partial Conflict", func(c *qt.C) {
+		toUserID := primitive.NewObjectID()
+		req := &CreateBookingRequest{
+			ToolID:    "111111",
 			StartDate: time.Now().Add(24 * time.Hour),
 			EndDate:   time.Now().Add(48 * time.Hour),
-			Contact:   "test1@example.com",
+			Contact:   "test@example.com",
 		}
-		booking1, err := bookingService.Create(ctx, req1, fromUserID, toUserID)
-		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create first booking"))
+		booking, err := bookingService.Create(ctx, req, primitive.NewObjectID(), toUserID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create booking"))
+		err = bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted, primitive.NilObjectID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to accept booking"))
 
-		// Accept first booking
-		err = bookingService.UpdateStatus(ctx, booking1.ID, BookingStatusAccepted)
-		c.Assert(err, qt.IsNil, qt.Commentf("Failed to accept first booking"))
+		// One of the tools conflicts, so no booking of the group is created
+		fromUserID := primitive.NewObjectID()
+		_, err = bookingService.CreateGroup(ctx, req, []string{"222222", "111111"}, fromUserID, toUserID)
+		c.Assert(err, qt.Equals, ErrBookingDatesConflict)
+		petitions, err := bookingService.GetUserPetitions(ctx, fromUserID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(petitions, qt.HasLen, 0)
 
-		// Try to create overlapping booking (should fail since first booking is accepted)
-		req2 := &CreateBookingRequest{
-			ToolID:    toolID,
-			StartDate: time.Now().Add(36 * time.Hour),
-			EndDate:   time.Now().Add(60 * time.Hour),
-			Contact:   "test2@example.com",
+		bookings, err := bookingService.CreateGroup(ctx, req, []string{"222222", "333333"}, fromUserID, toUserID)
+		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create booking group"))
+		c.Assert(bookings, qt.HasLen, 2)
+		c.Assert(bookings[0].GroupID, qt.Not(qt.Equals), primitive.NilObjectID)
+		c.Assert(bookings[1].GroupID, qt.Equals, bookings[0].GroupID)
+
+		// Status changes apply to the whole group
+		err = bookingService.UpdateStatus(ctx, bookings[1].ID, BookingStatusRejected, primitive.NilObjectID)
+		c.Assert(err, qt.IsNil)
+		group, err := bookingService.GetGroup(ctx, bookings[0].GroupID)
+		c.Assert(err, qt.IsNil)
+		for _, b := range group {
+			c.Assert(b.BookingStatus, qt.Equals, BookingStatusRejected)
 		}
-		_, err = bookingService.Create(ctx, req2, primitive.NewObjectID(), toUserID)
-		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("Expected error for overlapping booking"))
 	})
 
 	c.Run("Get User Requests", func(c *qt.C) {
@@ -148,7 +229,7 @@ func TestBookingService(t *testing.T) {
 		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create booking"))
 
 		// Update status
-		err = bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted)
+		err = bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted, primitive.NilObjectID)
 		c.Assert(err, qt.IsNil, qt.Commentf("Failed to update booking status"))
 
 		// Verify update
@@ -163,8 +244,8 @@ func TestBookingService(t *testing.T) {
 		// Create returned booking
 		req := &CreateBookingRequest{
 			ToolID:    "567890",
-			StartDate: time.Now().Add(-48 * time.Hour),
-			EndDate:   time.Now().Add(-24 * time.Hour),
+			StartDate: time.Now().Add(24 * time.Hour),
+			EndDate:   time.Now().Add(48 * time.Hour),
 			Contact:   "test@example.com",
 		}
 		booking, err := bookingService.Create(ctx, req, userID, primitive.NewObjectID())
@@ -173,12 +254,299 @@ func TestBookingService(t *testing.T) {
 		}
 
 		// Update status to returned
-		err = bookingService.UpdateStatus(ctx, booking.ID, BookingStatusReturned)
-		c.Assert(err, qt.IsNil, qt.Commentf("Failed to create test booking"))
+		setStatusForTest(c, bookingService, booking.ID, BookingStatusReturned)
 
 		// Get pending ratings
 		ratings, err := bookingService.GetPendingRatings(ctx, userID)
 		c.Assert(err, qt.IsNil, qt.Commentf("Failed to get pending ratings"))
 		c.Assert(len(ratings), qt.Not(qt.Equals), 0, qt.Commentf("Expected at least one pending rating"))
 	})
+
+	c.Run("Adjacent Bookings", func(c *qt.C) {
+		toolID := "135791"
+		start := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+		end := start.Add(24 * time.Hour)
+		book := func(from, to time.Time) (*Booking, error) {
+			return bookingService.Create(ctx, &CreateBookingRequest{
+				ToolID:    toolID,
+				StartDate: from,
+				EndDate:   to,
+			}, primitive.NewObjectID(), primitive.NewObjectID())
+		}
+
+		booking, err := book(start, end)
+		c.Assert(err, qt.IsNil)
+		c.Assert(bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+
+		// Exactly adjacent bookings are allowed on both sides
+		_, err = book(end, end.Add(24*time.Hour))
+		c.Assert(err, qt.IsNil)
+		_, err = book(start.Add(-24*time.Hour), start)
+		c.Assert(err, qt.IsNil)
+
+		// One second of overlap is rejected on both sides
+		_, err = book(end.Add(-time.Second), end.Add(24*time.Hour))
+		c.Assert(err, qt.Equals, ErrBookingDatesConflict)
+		_, err = book(start.Add(-24*time.Hour), start.Add(time.Second))
+		c.Assert(err, qt.Equals, ErrBookingDatesConflict)
+	})
+
+	c.Run("Unavailable Tool vs Booking Conflict", func(c *qt.C) {
+		start := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+		_, err := database.Collection("tools").InsertOne(ctx, &Tool{
+			ID: 246810,
+			Unavailability: []DateRange{{
+				From: uint32(start.Add(72 * time.Hour).Unix()),
+				To:   uint32(start.Add(96 * time.Hour).Unix()),
+			}},
+		})
+		c.Assert(err, qt.IsNil)
+		book := func(from time.Time) (*Booking, error) {
+			return bookingService.Create(ctx, &CreateBookingRequest{
+				ToolID:    "246810",
+				StartDate: from,
+				EndDate:   from.Add(24 * time.Hour),
+			}, primitive.NewObjectID(), primitive.NewObjectID())
+		}
+
+		booking, err := book(start)
+		c.Assert(err, qt.IsNil)
+		c.Assert(bookingService.UpdateStatus(ctx, booking.ID, BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+
+		// Each cause is reported with its own error
+		_, err = book(start)
+		c.Assert(err, qt.Equals, ErrBookingDatesConflict)
+		_, err = book(start.Add(72 * time.Hour))
+		c.Assert(err, qt.Equals, ErrToolUnavailable)
+		_, err = bookingService.CreateGroup(ctx, &CreateBookingRequest{
+			StartDate: start.Add(72 * time.Hour),
+			EndDate:   start.Add(96 * time.Hour),
+		}, []string{"135790", "246810"}, primitive.NewObjectID(), primitive.NewObjectID())
+		c.Assert(err, qt.Equals, ErrToolUnavailable)
+		_, err = book(start.Add(48 * time.Hour))
+		c.Assert(err, qt.IsNil)
+	})
+
+	c.Run("Invalid Dates", func(c *qt.C) {
+		bookingService.MaxDuration = 7 * 24 * time.Hour
+		defer func() { bookingService.MaxDuration = 0 }()
+		book := func(from, to time.Time) error {
+			_, err := bookingService.Create(ctx, &CreateBookingRequest{
+				ToolID:    "112233",
+				StartDate: from,
+				EndDate:   to,
+			}, primitive.NewObjectID(), primitive.NewObjectID())
+			return err
+		}
+		start := time.Now().Add(24 * time.Hour)
+		c.Assert(book(start, start), qt.Equals, ErrInvalidBookingDates)
+		c.Assert(book(start, start.Add(-time.Hour)), qt.Equals, ErrInvalidBookingDates)
+		c.Assert(book(time.Now().Add(-time.Hour), start), qt.Equals, ErrInvalidBookingDates)
+		c.Assert(book(start, start.Add(8*24*time.Hour)), qt.Equals, ErrBookingTooLong)
+		c.Assert(book(start, start.Add(7*24*time.Hour)), qt.IsNil)
+	})
+
+	c.Run("Status Transitions", func(c *qt.C) {
+		booking, err := bookingService.Create(ctx, &CreateBookingRequest{
+			ToolID:    "246810",
+			StartDate: time.Now().Add(24 * time.Hour),
+			EndDate:   time.Now().Add(48 * time.Hour),
+		}, primitive.NewObjectID(), primitive.NewObjectID())
+		c.Assert(err, qt.IsNil)
+
+		// A pending booking cannot be returned before it is accepted
+		c.Assert(bookingService.UpdateStatus(ctx, booking.ID, BookingStatusReturned,
+			primitive.NilObjectID), qt.Equals, ErrInvalidTransition)
+		c.Assert(bookingService.UpdateStatus(ctx, booking.ID, BookingStatusRejected, primitive.NilObjectID), qt.IsNil)
+
+		// Final statuses cannot change, not even to themselves
+		for _, status := range []BookingStatus{BookingStatusAccepted, BookingStatusReturned, BookingStatusRejected} {
+			c.Assert(bookingService.UpdateStatus(ctx, booking.ID, status, primitive.NilObjectID), qt.Equals, ErrInvalidTransition)
+		}
+		stored, err := bookingService.Get(ctx, booking.ID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stored.BookingStatus, qt.Equals, BookingStatusRejected)
+	})
+
+	c.Run("Reliability Stats", func(c *qt.C) {
+		userID := primitive.NewObjectID()
+		book := func(status BookingStatus) {
+			booking, err := bookingService.Create(ctx, &CreateBookingRequest{
+				ToolID:    "123456",
+				StartDate: time.Now().Add(24 * time.Hour),
+				EndDate:   time.Now().Add(48 * time.Hour),
+			}, userID, primitive.NewObjectID())
+			c.Assert(err, qt.IsNil)
+			setStatusForTest(c, bookingService, booking.ID, status)
+		}
+
+		stats, err := bookingService.GetUserReliabilityStats(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stats.Score(DefaultReliabilityWeights), qt.IsNil)
+
+		book(BookingStatusReturned)
+		book(BookingStatusReturned)
+		stats, err = bookingService.GetUserReliabilityStats(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stats, qt.DeepEquals, &ReliabilityStats{OnTime: 2})
+		c.Assert(*stats.Score(DefaultReliabilityWeights), qt.Equals, int32(100))
+
+		// Frequent cancellations lower the reliability
+		for i := 0; i < 4; i++ {
+			book(BookingStatusCancelled)
+		}
+		stats, err = bookingService.GetUserReliabilityStats(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(stats.Cancelled, qt.Equals, int64(4))
+		c.Assert(*stats.Score(DefaultReliabilityWeights), qt.Equals, int32(50))
+	})
+}
+
+// setStatusForTest moves the booking to the status through the legal transitions,
+// accepting it first for the statuses only reachable from accepted.
+func setStatusForTest(c *qt.C, s *BookingService, id primitive.ObjectID, status BookingStatus) {
+	if !CanTransition(BookingStatusPending, status) {
+		c.Assert(s.UpdateStatus(context.Background(), id, BookingStatusAccepted, primitive.NilObjectID), qt.IsNil)
+	}
+	c.Assert(s.UpdateStatus(context.Background(), id, status, primitive.NilObjectID), qt.IsNil)
+}
+
+func TestDatesOverlap(t *testing.T) {
+	c := qt.New(t)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	// Exactly adjacent periods do not overlap, in both orders
+	c.Assert(DatesOverlap(start, end, end, end.Add(24*time.Hour)), qt.IsFalse)
+	c.Assert(DatesOverlap(start.Add(-24*time.Hour), start, start, end), qt.IsFalse)
+	// One second of overlap is enough
+	c.Assert(DatesOverlap(start, end, end.Add(-time.Second), end.Add(24*time.Hour)), qt.IsTrue)
+	c.Assert(DatesOverlap(start, end, start.Add(-24*time.Hour), start.Add(time.Second)), qt.IsTrue)
+	// Contained and equal periods overlap
+	c.Assert(DatesOverlap(start, end, start.Add(time.Hour), end.Add(-time.Hour)), qt.IsTrue)
+	c.Assert(DatesOverlap(start, end, start, end), qt.IsTrue)
+}
+
+func TestFirstFreePeriod(t *testing.T) {
+	c := qt.New(t)
+	day := 24 * time.Hour
+	from := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	until := from.Add(10 * day)
+	period := func(startDay, endDay int) DateRange {
+		return DateRange{
+			From: uint32(from.Add(time.Duration(startDay) * day).Unix()),
+			To:   uint32(from.Add(time.Duration(endDay) * day).Unix()),
+		}
+	}
+
+	// Nothing busy, or busy only before or after, starts right away
+	start, ok := FirstFreePeriod(nil, from, 2*day, until)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(start.Equal(from), qt.IsTrue)
+	start, ok = FirstFreePeriod([]DateRange{period(-3, 0), period(2, 4)}, from, 2*day, until)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(start.Equal(from), qt.IsTrue)
+
+	// Busy periods are skipped in any order, back to back and until one gap is long enough
+	busy := []DateRange{period(6, 7), period(-1, 1), period(1, 2), period(3, 4)}
+	start, ok = FirstFreePeriod(busy, from, 2*day, until)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(start.Equal(from.Add(4*day)), qt.IsTrue)
+	start, ok = FirstFreePeriod(busy, from, day, until)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(start.Equal(from.Add(2*day)), qt.IsTrue)
+
+	// The period must end by until
+	start, ok = FirstFreePeriod(busy, from, 3*day, until)
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(start.Equal(from.Add(7*day)), qt.IsTrue)
+	_, ok = FirstFreePeriod(busy, from, 4*day, until)
+	c.Assert(ok, qt.IsFalse)
+	_, ok = FirstFreePeriod(nil, from, 11*day, until)
+	c.Assert(ok, qt.IsFalse)
+}
+
+func TestReliabilityScore(t *testing.T) {
+	c := qt.New(t)
+	w := DefaultReliabilityWeights
+
+	c.Assert((&ReliabilityStats{}).Score(w), qt.IsNil)
+	c.Assert(*(&ReliabilityStats{OnTime: 4}).Score(w), qt.Equals, int32(100))
+	// A late return counts half as a success
+	c.Assert(*(&ReliabilityStats{OnTime: 1, Late: 1}).Score(w), qt.Equals, int32(75))
+	// Two cancellations weigh as much as one failed return
+	c.Assert(*(&ReliabilityStats{OnTime: 1, Cancelled: 2}).Score(w), qt.Equals, int32(50))
+	c.Assert(*(&ReliabilityStats{OnTime: 1, Overdue: 1}).Score(w), qt.Equals, int32(50))
+	// Cancellations can be ignored
+	w.CancellationWeight = 0
+	c.Assert(*(&ReliabilityStats{OnTime: 1, Cancelled: 2}).Score(w), qt.Equals, int32(100))
+}
+
+func TestNewBookingCharge(t *testing.T) {
+	c := qt.New(t)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tool := &Tool{Cost: 15, AskWithFee: true}
+	booking := func(d time.Duration) *Booking {
+		return &Booking{StartDate: start, EndDate: start.Add(d)}
+	}
+
+	charge := NewBookingCharge(tool, booking(48*time.Hour))
+	c.Assert(charge, qt.DeepEquals, &BookingCharge{Days: 2, CostPerDay: 15, Total: 30, AskWithFee: true})
+
+	// Started days count as full days, with a minimum of one
+	charge = NewBookingCharge(tool, booking(49*time.Hour))
+	c.Assert(charge.Days, qt.Equals, uint64(3))
+	c.Assert(charge.Total, qt.Equals, uint64(45))
+	charge = NewBookingCharge(tool, booking(0))
+	c.Assert(charge.Days, qt.Equals, uint64(1))
+
+	// Free tools are not charged without an agreed cost
+	tool.MayBeFree = true
+	charge = NewBookingCharge(tool, booking(48*time.Hour))
+	c.Assert(charge.Free, qt.IsTrue)
+	c.Assert(charge.Total, qt.Equals, uint64(0))
+
+	// With an agreed cost, only free bookings are not charged
+	agreed := booking(48 * time.Hour)
+	agreed.AgreedCost = new(uint64)
+	*agreed.AgreedCost = 10
+	charge = NewBookingCharge(tool, agreed)
+	c.Assert(charge, qt.DeepEquals, &BookingCharge{Days: 2, CostPerDay: 10, Total: 20, AskWithFee: true})
+	agreed.Free, *agreed.AgreedCost = true, 0
+	charge = NewBookingCharge(tool, agreed)
+	c.Assert(charge, qt.DeepEquals, &BookingCharge{Days: 2, Free: true, AskWithFee: true})
+}
+
+func TestBookingEventHub(t *testing.T) {
+	c := qt.New(t)
+	hub := NewBookingEventHub()
+	requester, owner, other := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	requesterEvents, unsubscribeRequester := hub.Subscribe(requester)
+	ownerEvents, unsubscribeOwner := hub.Subscribe(owner)
+	otherEvents, unsubscribeOther := hub.Subscribe(other)
+	defer unsubscribeRequester()
+	defer unsubscribeOther()
+
+	booking := &Booking{ID: primitive.NewObjectID(), FromUserID: requester, ToUserID: owner}
+	hub.Publish(&BookingEvent{Type: BookingEventCreated, Booking: booking})
+	for _, events := range []<-chan *BookingEvent{requesterEvents, ownerEvents} {
+		event := <-events
+		c.Assert(event.Type, qt.Equals, BookingEventCreated)
+		c.Assert(event.Booking.ID, qt.Equals, booking.ID)
+	}
+	c.Assert(otherEvents, qt.HasLen, 0)
+
+	// Unsubscribing closes the channel and can be done twice
+	unsubscribeOwner()
+	unsubscribeOwner()
+	_, ok := <-ownerEvents
+	c.Assert(ok, qt.IsFalse)
+	hub.Publish(&BookingEvent{Type: BookingEventStatusChanged, Booking: booking})
+	c.Assert((<-requesterEvents).Type, qt.Equals, BookingEventStatusChanged)
+
+	// A subscriber not reading does not block the publisher, it misses the events instead
+	for i := 0; i < 2*bookingEventBuffer; i++ {
+		hub.Publish(&BookingEvent{Type: BookingEventStatusChanged, Booking: booking})
+	}
+	c.Assert(requesterEvents, qt.HasLen, bookingEventBuffer)
 }
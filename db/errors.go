@@ -5,6 +5,36 @@ import "errors"
 // Database-specific errors
 var (
 	ErrBookingDatesConflict = errors.New("booking dates conflict with existing booking")
+	ErrToolUnavailable      = errors.New("tool marked as unavailable for the booking dates")
 	ErrBookingNotFound      = errors.New("booking not found")
 	ErrInvalidBookingDates  = errors.New("invalid booking dates")
+	ErrBookingTooLong       = errors.New("booking exceeds the maximum duration")
+	ErrAlreadyRated         = errors.New("booking already rated by user")
+	ErrRatingNotFound       = errors.New("rating not found")
+	ErrBookingNotOpen       = errors.New("booking is not an open request")
+	ErrInvalidBookingGroup  = errors.New("a booking group needs at least two different tools")
+	ErrInsufficientTokens   = errors.New("insufficient tokens")
+	ErrInvalidTokenAmount   = errors.New("token amount out of range")
+	ErrTransferCapExceeded  = errors.New("daily transfer cap exceeded")
+	ErrUserNotFound         = errors.New("user not found")
+	ErrDepositNotHeld       = errors.New("no deposit held for the booking")
+	ErrDepositAlreadyHeld   = errors.New("a deposit is already held for the booking")
+	ErrInvalidDepositClaim  = errors.New("deposit claim exceeds the deposit held")
+	ErrBookingCharged       = errors.New("booking already charged")
+	ErrInvalidPasswordReset = errors.New("invalid or expired password reset token")
+	ErrCannotReschedule     = errors.New("only pending or accepted bookings can be rescheduled")
+	ErrNoReschedule         = errors.New("no reschedule proposed by the other party")
+	ErrInvalidTransition    = errors.New("invalid booking status transition")
+	ErrInvalidRefreshToken  = errors.New("invalid or expired refresh token")
+	ErrInvalidVerification  = errors.New("invalid or expired verification token")
+	ErrInvalidInvite        = errors.New("invalid, used or revoked invite")
+	ErrInviteNotFound       = errors.New("invite not found")
+	ErrInviteNotAvailable   = errors.New("invite already used or revoked")
+	// ErrAccountHasAcceptedBookings is returned when deleting the account of a user with accepted bookings.
+	ErrAccountHasAcceptedBookings = errors.New("the account has accepted bookings")
+	// ErrTooManyActiveBookings is returned when accepting a booking of a requester at the cap of active bookings.
+	ErrTooManyActiveBookings = errors.New("the requester has too many active bookings")
+
+	// errConcurrentAccept is returned when another booking of the same tool was accepted meanwhile
+	errConcurrentAccept = errors.New("concurrent booking acceptance")
 )
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Favorite represents the schema for the "favorites" collection, a tool bookmarked by a user.
+// There is at most one favorite for each user and tool.
+type Favorite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	ToolID    int64              `bson:"toolId" json:"toolId"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// FavoriteService provides methods to interact with the "favorites" collection.
+type FavoriteService struct {
+	Collection *mongo.Collection
+}
+
+// NewFavoriteService creates a new FavoriteService.
+func NewFavoriteService(db *Database) *FavoriteService {
+	return &FavoriteService{
+		Collection: db.Database.Collection("favorites"),
+	}
+}
+
+// Add marks the tool as a favorite of the user. Adding it again keeps the existing favorite untouched.
+func (s *FavoriteService) Add(ctx context.Context, userID primitive.ObjectID, toolID int64) error {
+	_, err := s.Collection.UpdateOne(ctx,
+		bson.M{"userId": userID, "toolId": toolID},
+		bson.M{"$setOnInsert": bson.M{"createdAt": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		// added concurrently by another request
+		return nil
+	}
+	return err
+}
+
+// Remove unmarks the tool as a favorite of the user, if it was.
+func (s *FavoriteService) Remove(ctx context.Context, userID primitive.ObjectID, toolID int64) error {
+	_, err := s.Collection.DeleteOne(ctx, bson.M{"userId": userID, "toolId": toolID})
+	return err
+}
+
+// GetUserFavorites returns the favorites of the user, newest first.
+func (s *FavoriteService) GetUserFavorites(ctx context.Context, userID primitive.ObjectID) ([]*Favorite, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	favorites := []*Favorite{}
+	if err := cursor.All(ctx, &favorites); err != nil {
+		return nil, err
+	}
+	return favorites, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Image represents the schema for the "images" collection.
@@ -34,6 +35,21 @@ func (s *ImageService) InsertImage(ctx context.Context, image *Image) (*mongo.In
 	return s.Collection.InsertOne(ctx, image)
 }
 
+// InsertImageIfNotExists inserts the Image unless another one with the same hash is already
+// stored. Since images are content addressed, the existing entry is kept untouched.
+// It returns true if the image has been inserted.
+func (s *ImageService) InsertImageIfNotExists(ctx context.Context, image *Image) (bool, error) {
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{"hash": image.Hash},
+		bson.M{"$setOnInsert": image},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
 // GetImage retrieves an Image by its hash.
 func (s *ImageService) GetImage(ctx context.Context, hash []byte) (*Image, error) {
 	var image Image
@@ -19,10 +19,10 @@ var defaultToolCategories = []string{
 	"communication",
 }
 
-var defaultTransports = []string{
-	"Car",
-	"Van",
-	"Truck",
+var defaultTransports = []Transport{
+	{Name: "Car", BaseCost: 1, CostPerKm: 1},
+	{Name: "Van", BaseCost: 2, CostPerKm: 2},
+	{Name: "Truck", BaseCost: 5, CostPerKm: 3},
 }
 
 // InitializeDatabase sets up the database with default data and ensures collections are ready for use.
@@ -46,11 +46,9 @@ func InitializeDatabase(db *Database) error {
 
 	// Initialize Transports
 	transportService := NewTransportService(db)
-	for i, name := range defaultTransports {
-		_, err := transportService.InsertTransport(ctx, &Transport{
-			ID:   int64(i + 1),
-			Name: name,
-		})
+	for i, transport := range defaultTransports {
+		transport.ID = int64(i + 1)
+		_, err := transportService.InsertTransport(ctx, &transport)
 		if err != nil && !mongo.IsDuplicateKeyError(err) {
 			log.Printf("Error initializing transports: %v\n", err)
 			return err
@@ -136,12 +134,162 @@ func createUniqueIndexes(db *Database, ctx context.Context) error {
 			Keys:    bson.D{{Key: "title", Value: 1}},
 			Options: options.Index(),
 		},
+		{
+			// Full-text search, the title matches weigh more than the description ones
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "description", Value: "text"}},
+			Options: options.Index().SetName("tools_text").
+				SetWeights(bson.D{{Key: "title", Value: 10}, {Key: "description", Value: 1}}),
+		},
 	})
 	if err != nil {
 		log.Printf("Error creating tool indexes: %v\n", err)
 		return err
 	}
 
+	// Rating collection indexes
+	ratingColl := db.Database.Collection("ratings")
+	_, err = ratingColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "toUserId", Value: 1}},
+			Options: options.Index(),
+		},
+		{
+			Keys:    bson.D{{Key: "bookingId", Value: 1}},
+			Options: options.Index(),
+		},
+		{
+			// A user can rate each booking only once, even with concurrent submissions
+			Keys:    bson.D{{Key: "bookingId", Value: 1}, {Key: "fromUserId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating rating indexes: %v\n", err)
+		return err
+	}
+
+	// Notification collection indexes
+	notificationColl := db.Database.Collection("notifications")
+	_, err = notificationColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+		Options: options.Index(),
+	})
+	if err != nil {
+		log.Printf("Error creating notification indexes: %v\n", err)
+		return err
+	}
+
+	// Booking message collection indexes
+	bookingMessageColl := db.Database.Collection("booking_messages")
+	_, err = bookingMessageColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookingId", Value: 1}, {Key: "createdAt", Value: 1}},
+		Options: options.Index(),
+	})
+	if err != nil {
+		log.Printf("Error creating booking message indexes: %v\n", err)
+		return err
+	}
+
+	// Booking status history collection indexes
+	bookingHistoryColl := db.Database.Collection("booking_status_history")
+	_, err = bookingHistoryColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "bookingId", Value: 1}, {Key: "timestamp", Value: 1}},
+		Options: options.Index(),
+	})
+	if err != nil {
+		log.Printf("Error creating booking status history indexes: %v\n", err)
+		return err
+	}
+
+	// Favorite collection indexes
+	favoriteColl := db.Database.Collection("favorites")
+	_, err = favoriteColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+		// A tool is a favorite of each user only once, even with concurrent requests
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "toolId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Printf("Error creating favorite indexes: %v\n", err)
+		return err
+	}
+
+	// Refresh token collection indexes
+	refreshTokenColl := db.Database.Collection("refresh_tokens")
+	_, err = refreshTokenColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index(),
+		},
+		{
+			// Expired tokens are removed by MongoDB
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating refresh token indexes: %v\n", err)
+		return err
+	}
+
+	// Token ledger indexes
+	tokenColl := db.Database.Collection("token_transactions")
+	_, err = tokenColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index(),
+		},
+		{
+			// A single deposit is held per booking, even if it is accepted twice at the same time
+			Keys: bson.D{{Key: "bookingId", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{
+				"type":   TokenTransactionDepositHold,
+				"escrow": EscrowHeld,
+			}),
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating token transaction indexes: %v\n", err)
+		return err
+	}
+
+	// Audit log indexes
+	auditColl := db.Database.Collection("audit")
+	_, err = auditColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "timestamp", Value: -1}},
+			Options: options.Index(),
+		},
+		{
+			Keys:    bson.D{{Key: "actorId", Value: 1}, {Key: "timestamp", Value: -1}},
+			Options: options.Index(),
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating audit indexes: %v\n", err)
+		return err
+	}
+
+	// Invite collection indexes
+	inviteColl := db.Database.Collection("invites")
+	_, err = inviteColl.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "createdAt", Value: -1}},
+			Options: options.Index(),
+		},
+	})
+	if err != nil {
+		log.Printf("Error creating invite indexes: %v\n", err)
+		return err
+	}
+
 	log.Println("All indexes created successfully")
 	return nil
 }
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Invite is a single-use registration token minted by an admin. Only the hash of the token is
+// stored. An invite is consumed by the user who registers with it, unless it was revoked before.
+type Invite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenHash []byte             `bson:"tokenHash" json:"-"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UsedBy    primitive.ObjectID `bson:"usedBy,omitempty" json:"usedBy,omitempty"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// InviteService provides methods to interact with the "invites" collection.
+type InviteService struct {
+	Collection *mongo.Collection
+}
+
+// NewInviteService creates a new InviteService.
+func NewInviteService(db *Database) *InviteService {
+	return &InviteService{
+		Collection: db.Database.Collection("invites"),
+	}
+}
+
+// availableInvites is the filter of the invites neither used nor revoked.
+func availableInvites() bson.M {
+	return bson.M{"usedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}}
+}
+
+// Create stores an invite with the given token hash minted by the admin.
+func (s *InviteService) Create(ctx context.Context, createdBy primitive.ObjectID, tokenHash []byte) (*Invite, error) {
+	invite := &Invite{
+		TokenHash: tokenHash,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	result, err := s.Collection.InsertOne(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+	invite.ID = result.InsertedID.(primitive.ObjectID)
+	return invite, nil
+}
+
+// Get returns a page of the invites, newest first, along with the total number of invites.
+func (s *InviteService) Get(ctx context.Context, page, pageSize int) ([]*Invite, int64, error) {
+	total, err := s.Collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.Collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	invites := []*Invite{}
+	if err = cursor.All(ctx, &invites); err != nil {
+		return nil, 0, err
+	}
+	return invites, total, nil
+}
+
+// Consume marks the invite with the given token hash as used by the user and returns it. It returns
+// ErrInvalidInvite if there is no such invite or it was already used or revoked, so each invite
+// registers a single user.
+func (s *InviteService) Consume(ctx context.Context, tokenHash []byte, userID primitive.ObjectID) (*Invite, error) {
+	filter := availableInvites()
+	filter["tokenHash"] = tokenHash
+	var invite Invite
+	err := s.Collection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"usedBy": userID, "usedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invite)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// Release makes the invite consumed by the user available again, for when the registration fails
+// after consuming it.
+func (s *InviteService) Release(ctx context.Context, id, userID primitive.ObjectID) error {
+	_, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id, "usedBy": userID},
+		bson.M{"$unset": bson.M{"usedBy": "", "usedAt": ""}})
+	return err
+}
+
+// Revoke marks the invite as revoked, so it can no longer be used, and returns it. It returns
+// ErrInviteNotFound if there is no such invite and ErrInviteNotAvailable if it was already used
+// or revoked.
+func (s *InviteService) Revoke(ctx context.Context, id primitive.ObjectID) (*Invite, error) {
+	filter := availableInvites()
+	filter["_id"] = id
+	var invite Invite
+	err := s.Collection.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invite)
+	if err == nil {
+		return &invite, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	count, err := s.Collection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrInviteNotFound
+	}
+	return nil, ErrInviteNotAvailable
+}
@@ -15,14 +15,22 @@ const (
 
 // Database struct encapsulates MongoDB client and database.
 type Database struct {
-	Client              *mongo.Client
-	Database            *mongo.Database
-	ToolService         *ToolService
-	ToolCategoryService *ToolCategoryService
-	ImageService        *ImageService
-	TransportService    *TransportService
-	UserService         *UserService
-	BookingService      *BookingService
+	Client                *mongo.Client
+	Database              *mongo.Database
+	ToolService           *ToolService
+	ToolCategoryService   *ToolCategoryService
+	ImageService          *ImageService
+	TransportService      *TransportService
+	UserService           *UserService
+	BookingService        *BookingService
+	RatingService         *RatingService
+	NotificationService   *NotificationService
+	TokenService          *TokenService
+	AuditService          *AuditService
+	BookingMessageService *BookingMessageService
+	FavoriteService       *FavoriteService
+	RefreshTokenService   *RefreshTokenService
+	InviteService         *InviteService
 }
 
 // New initializes a new MongoDB connection.
@@ -51,6 +59,15 @@ func New(uri string) (*Database, error) {
 	database.TransportService = NewTransportService(database)
 	database.UserService = NewUserService(database)
 	database.BookingService = NewBookingService(database.Database)
+	database.RatingService = NewRatingService(database)
+	database.UserService.Ratings = database.RatingService
+	database.NotificationService = NewNotificationService(database)
+	database.TokenService = NewTokenService(database)
+	database.AuditService = NewAuditService(database)
+	database.BookingMessageService = NewBookingMessageService(database)
+	database.FavoriteService = NewFavoriteService(database)
+	database.RefreshTokenService = NewRefreshTokenService(database)
+	database.InviteService = NewInviteService(database)
 	return database, nil
 }
 
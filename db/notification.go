@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NotificationType identifies the event a notification is about.
+type NotificationType string
+
+const (
+	NotificationBookingCreated   NotificationType = "BOOKING_CREATED"
+	NotificationBookingAccepted  NotificationType = "BOOKING_ACCEPTED"
+	NotificationBookingRejected  NotificationType = "BOOKING_REJECTED"
+	NotificationBookingCancelled NotificationType = "BOOKING_CANCELLED"
+	NotificationBookingReturned  NotificationType = "BOOKING_RETURNED"
+	NotificationBookingReminder  NotificationType = "BOOKING_REMINDER"
+	// NotificationBookingHandedOver tells the requester of a transfer booking the tool is now theirs
+	NotificationBookingHandedOver NotificationType = "BOOKING_HANDED_OVER"
+	// NotificationBookingRescheduled tells one party of a booking the other one accepted the new
+	// dates it proposed
+	NotificationBookingRescheduled NotificationType = "BOOKING_RESCHEDULED"
+	// NotificationRescheduleProposed tells one party of a booking the other one proposed new dates
+	NotificationRescheduleProposed NotificationType = "BOOKING_RESCHEDULE_PROPOSED"
+)
+
+// NotificationTypes are all the known notification types.
+var NotificationTypes = []NotificationType{
+	NotificationBookingCreated,
+	NotificationBookingAccepted,
+	NotificationBookingRejected,
+	NotificationBookingCancelled,
+	NotificationBookingReturned,
+	NotificationBookingReminder,
+	NotificationBookingHandedOver,
+	NotificationBookingRescheduled,
+	NotificationRescheduleProposed,
+}
+
+// IsValidNotificationType returns true if t is a known notification type.
+func IsValidNotificationType(t NotificationType) bool {
+	for _, nt := range NotificationTypes {
+		if nt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification represents the schema for the "notifications" collection.
+type Notification struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Type      NotificationType   `bson:"type" json:"type"`
+	BookingID primitive.ObjectID `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+	Read      bool               `bson:"read" json:"read"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// NotificationService provides methods to interact with the "notifications" collection.
+type NotificationService struct {
+	Collection *mongo.Collection
+}
+
+// NewNotificationService creates a new NotificationService.
+func NewNotificationService(db *Database) *NotificationService {
+	return &NotificationService{
+		Collection: db.Database.Collection("notifications"),
+	}
+}
+
+// Create stores a new notification.
+func (s *NotificationService) Create(ctx context.Context, notification *Notification) (*Notification, error) {
+	if notification.CreatedAt.IsZero() {
+		notification.CreatedAt = time.Now()
+	}
+	result, err := s.Collection.InsertOne(ctx, notification)
+	if err != nil {
+		return nil, err
+	}
+	notification.ID = result.InsertedID.(primitive.ObjectID)
+	return notification, nil
+}
+
+// GetUserNotifications returns the notifications of the user, newest first.
+func (s *NotificationService) GetUserNotifications(ctx context.Context, userID primitive.ObjectID) ([]*Notification, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	notifications := []*Notification{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// CountUnread returns the number of notifications of the user not read yet.
+func (s *NotificationService) CountUnread(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.Collection.CountDocuments(ctx, bson.M{"userId": userID, "read": false})
+}
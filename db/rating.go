@@ -0,0 +1,347 @@
+package db
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/emprius/emprius-app-backend/types"
+)
+
+const (
+	// MinRating is the lowest rating value a user can give.
+	MinRating = 1
+	// MaxRating is the highest rating value a user can give.
+	MaxRating = 5
+)
+
+// Rating represents the schema for the "ratings" collection.
+type Rating struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	BookingID  primitive.ObjectID `bson:"bookingId" json:"bookingId"`
+	FromUserID primitive.ObjectID `bson:"fromUserId" json:"fromUserId"`
+	ToUserID   primitive.ObjectID `bson:"toUserId" json:"toUserId"`
+	Rating     int                `bson:"rating" json:"rating"`
+	Comment    string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+	// Anonymous hides the rater in the public listings, the rater is still stored for moderation
+	Anonymous bool `bson:"anonymous,omitempty" json:"anonymous,omitempty"`
+	// Images are the hashes of the photos, from the image store, documenting the condition of the tool
+	Images []types.HexBytes `bson:"images,omitempty" json:"images,omitempty"`
+}
+
+// UserRatingAverage holds the aggregation of the ratings received by a user.
+// The weighted fields hold the time decayed aggregation, which equals the simple one
+// if the rating service has no decay half-life.
+type UserRatingAverage struct {
+	Count       int64   `bson:"count" json:"count"`
+	Sum         int64   `bson:"sum" json:"sum"`
+	WeightedSum float64 `bson:"weightedSum" json:"weightedSum"`
+	WeightSum   float64 `bson:"weightSum" json:"weightSum"`
+}
+
+// Value returns the average rating scaled to the 0-100 range used by User.Rating.
+// It returns 0 if there are no ratings.
+func (a *UserRatingAverage) Value() int32 {
+	if a.Count == 0 {
+		return 0
+	}
+	return int32(math.Round(float64(a.Sum) * 100 / float64(a.Count*MaxRating)))
+}
+
+// WeightedValue returns the time decayed average rating scaled to the 0-100 range.
+// It returns 0 if there are no ratings.
+func (a *UserRatingAverage) WeightedValue() int32 {
+	if a.WeightSum == 0 {
+		return 0
+	}
+	return int32(math.Round(a.WeightedSum * 100 / (a.WeightSum * MaxRating)))
+}
+
+// With returns the average that would result from adding the given rating now.
+func (a *UserRatingAverage) With(rating int) *UserRatingAverage {
+	return &UserRatingAverage{
+		Count:       a.Count + 1,
+		Sum:         a.Sum + int64(rating),
+		WeightedSum: a.WeightedSum + float64(rating),
+		WeightSum:   a.WeightSum + 1,
+	}
+}
+
+// RatingService provides methods to interact with the "ratings" collection.
+type RatingService struct {
+	Collection *mongo.Collection
+	// DecayHalfLife is the age at which a rating weights half in the weighted average.
+	// Zero disables the decay, so all the ratings weight the same.
+	DecayHalfLife time.Duration
+}
+
+// NewRatingService creates a new RatingService.
+func NewRatingService(db *Database) *RatingService {
+	return &RatingService{
+		Collection: db.Database.Collection("ratings"),
+	}
+}
+
+// Create stores a new rating. It returns ErrAlreadyRated if the user already rated the booking.
+// Uniqueness is enforced by an index on the booking and the rater, so concurrent duplicates fail too.
+func (s *RatingService) Create(ctx context.Context, rating *Rating) (*Rating, error) {
+	count, err := s.Collection.CountDocuments(ctx, bson.M{
+		"bookingId":  rating.BookingID,
+		"fromUserId": rating.FromUserID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrAlreadyRated
+	}
+	rating.CreatedAt = time.Now()
+	result, err := s.Collection.InsertOne(ctx, rating)
+	if err != nil {
+		// A concurrent submission may have been inserted after the check above
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAlreadyRated
+		}
+		return nil, err
+	}
+	rating.ID = result.InsertedID.(primitive.ObjectID)
+	return rating, nil
+}
+
+// Get returns the rating given by the user for the booking, or ErrRatingNotFound.
+func (s *RatingService) Get(ctx context.Context, bookingID, fromUserID primitive.ObjectID) (*Rating, error) {
+	var rating Rating
+	err := s.Collection.FindOne(ctx, bson.M{
+		"bookingId":  bookingID,
+		"fromUserId": fromUserID,
+	}).Decode(&rating)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRatingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// Update changes the value, comment and images of a rating.
+func (s *RatingService) Update(
+	ctx context.Context,
+	id primitive.ObjectID,
+	rating int,
+	comment string,
+	images []types.HexBytes,
+) error {
+	result, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"rating":    rating,
+			"comment":   comment,
+			"images":    images,
+			"updatedAt": time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRatingNotFound
+	}
+	return nil
+}
+
+// Delete removes a rating.
+func (s *RatingService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.Collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrRatingNotFound
+	}
+	return nil
+}
+
+// GetUserRatings returns a page of the ratings received by the user, newest first,
+// along with the total number of ratings received.
+func (s *RatingService) GetUserRatings(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	page, pageSize int,
+) ([]*Rating, int64, error) {
+	filter := bson.M{"toUserId": userID}
+	total, err := s.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(page * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	ratings := []*Rating{}
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, 0, err
+	}
+	return ratings, total, nil
+}
+
+// GetUserAverage aggregates all the ratings received by the user, both as a simple average and
+// weighted by the age of the ratings according to DecayHalfLife.
+func (s *RatingService) GetUserAverage(ctx context.Context, userID primitive.ObjectID) (*UserRatingAverage, error) {
+	return s.average(ctx, mongo.Pipeline{{{Key: "$match", Value: bson.M{"toUserId": userID}}}})
+}
+
+// GetToolAverage aggregates the ratings received by the owners on the bookings of the tool, that is
+// how the borrowers rated lending it, in the same way as GetUserAverage.
+func (s *RatingService) GetToolAverage(ctx context.Context, toolID string) (*UserRatingAverage, error) {
+	return s.average(ctx, toolRatings(toolID))
+}
+
+// GetToolRatings returns the latest ratings, up to limit, received by the owners on the bookings of
+// the tool, newest first. See GetToolAverage.
+func (s *RatingService) GetToolRatings(ctx context.Context, toolID string, limit int) ([]*Rating, error) {
+	pipeline := append(toolRatings(toolID),
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}}},
+		bson.D{{Key: "$limit", Value: limit}},
+		bson.D{{Key: "$project", Value: bson.M{"booking": 0}}},
+	)
+	cursor, err := s.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	ratings := []*Rating{}
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+// toolRatings returns the stages selecting the ratings received by the owners on the bookings of the tool.
+func toolRatings(toolID string) mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "bookings",
+			"localField":   "bookingId",
+			"foreignField": "_id",
+			"as":           "booking",
+		}}},
+		{{Key: "$match", Value: bson.M{
+			"booking.toolId": toolID,
+			"$expr":          bson.M{"$in": bson.A{"$toUserId", "$booking.toUserId"}},
+		}}},
+	}
+}
+
+// average aggregates the ratings selected by the given stages, see UserRatingAverage.
+func (s *RatingService) average(ctx context.Context, match mongo.Pipeline) (*UserRatingAverage, error) {
+	var weight interface{} = 1
+	if s.DecayHalfLife > 0 {
+		// 0.5^(age/halfLife), dates subtract to milliseconds
+		weight = bson.M{"$pow": bson.A{0.5, bson.M{"$divide": bson.A{
+			bson.M{"$subtract": bson.A{time.Now(), "$createdAt"}},
+			s.DecayHalfLife.Milliseconds(),
+		}}}}
+	}
+	pipeline := append(match,
+		bson.D{{Key: "$set", Value: bson.M{"weight": weight}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":         nil,
+			"count":       bson.M{"$sum": 1},
+			"sum":         bson.M{"$sum": "$rating"},
+			"weightedSum": bson.M{"$sum": bson.M{"$multiply": bson.A{"$rating", "$weight"}}},
+			"weightSum":   bson.M{"$sum": "$weight"},
+		}}},
+	)
+	cursor, err := s.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	average := &UserRatingAverage{}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(average); err != nil {
+			return nil, err
+		}
+	}
+	return average, cursor.Err()
+}
+
+// RatingHistogram is the distribution of the ratings received by a user.
+type RatingHistogram struct {
+	// Counts holds the number of ratings for each value, from MinRating to MaxRating
+	Counts  map[int]int64 `json:"counts"`
+	Total   int64         `json:"total"`
+	Average float64       `json:"average"`
+}
+
+// GetUserHistogram returns the number of ratings received by the user for each rating value.
+// All the counts are zero if the user has not been rated.
+func (s *RatingService) GetUserHistogram(ctx context.Context, userID primitive.ObjectID) (*RatingHistogram, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"toUserId": userID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$rating",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		Rating int   `bson:"_id"`
+		Count  int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	histogram := &RatingHistogram{Counts: make(map[int]int64, MaxRating-MinRating+1)}
+	for value := MinRating; value <= MaxRating; value++ {
+		histogram.Counts[value] = 0
+	}
+	var sum int64
+	for _, group := range groups {
+		histogram.Counts[group.Rating] += group.Count
+		histogram.Total += group.Count
+		sum += int64(group.Rating) * group.Count
+	}
+	if histogram.Total > 0 {
+		histogram.Average = float64(sum) / float64(histogram.Total)
+	}
+	return histogram, nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestRatingService(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+
+	// Start MongoDB container
+	container, err := StartMongoContainer(ctx)
+	c.Assert(err, qt.IsNil, qt.Commentf("Failed to start MongoDB container"))
+	defer func() { _ = container.Terminate(ctx) }()
+
+	// Get MongoDB connection string
+	mongoURI, err := container.Endpoint(ctx, "mongodb")
+	c.Assert(err, qt.IsNil, qt.Commentf("Failed to get MongoDB connection string"))
+
+	// Create a MongoDB client
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	c.Assert(err, qt.IsNil, qt.Commentf("Failed to create MongoDB client"))
+	defer func() { _ = client.Disconnect(ctx) }()
+
+	// Use a random database name for isolation
+	dbName := RandomDatabaseName()
+	database := client.Database(dbName)
+
+	// Initialize RatingService
+	ratingService := NewRatingService(&Database{
+		Client:   client,
+		Database: database,
+	})
+
+	c.Run("Rating Histogram", func(c *qt.C) {
+		userID := primitive.NewObjectID()
+
+		// An unrated user has all the counts to zero
+		histogram, err := ratingService.GetUserHistogram(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(histogram.Counts, qt.DeepEquals, map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0})
+		c.Assert(histogram.Total, qt.Equals, int64(0))
+		c.Assert(histogram.Average, qt.Equals, 0.0)
+
+		for _, value := range []int{5, 5, 4, 2, 5} {
+			_, err := ratingService.Create(ctx, &Rating{
+				BookingID:  primitive.NewObjectID(),
+				FromUserID: primitive.NewObjectID(),
+				ToUserID:   userID,
+				Rating:     value,
+			})
+			c.Assert(err, qt.IsNil)
+		}
+		// Ratings of other users are not counted
+		_, err = ratingService.Create(ctx, &Rating{
+			BookingID:  primitive.NewObjectID(),
+			FromUserID: userID,
+			ToUserID:   primitive.NewObjectID(),
+			Rating:     1,
+		})
+		c.Assert(err, qt.IsNil)
+
+		histogram, err = ratingService.GetUserHistogram(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(histogram.Counts, qt.DeepEquals, map[int]int64{1: 0, 2: 1, 3: 0, 4: 1, 5: 3})
+		c.Assert(histogram.Total, qt.Equals, int64(5))
+		c.Assert(histogram.Average, qt.Equals, 4.2)
+	})
+	c.Run("Time Decayed Average", func(c *qt.C) {
+		userID := primitive.NewObjectID()
+		addRating := func(value int, age time.Duration) {
+			_, err := ratingService.Collection.InsertOne(ctx, &Rating{
+				BookingID:  primitive.NewObjectID(),
+				FromUserID: primitive.NewObjectID(),
+				ToUserID:   userID,
+				Rating:     value,
+				CreatedAt:  time.Now().Add(-age),
+			})
+			c.Assert(err, qt.IsNil)
+		}
+		// Bad ratings a year ago, good ones recently
+		for i := 0; i < 3; i++ {
+			addRating(1, 365*24*time.Hour)
+		}
+		addRating(5, 24*time.Hour)
+		addRating(5, 48*time.Hour)
+
+		// Without decay both averages are the same
+		average, err := ratingService.GetUserAverage(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(average.Count, qt.Equals, int64(5))
+		c.Assert(average.Value(), qt.Equals, int32(52))
+		c.Assert(average.WeightedValue(), qt.Equals, average.Value())
+
+		// With a 30 days half-life the recent ratings pull the weighted average up
+		ratingService.DecayHalfLife = 30 * 24 * time.Hour
+		defer func() { ratingService.DecayHalfLife = 0 }()
+		average, err = ratingService.GetUserAverage(ctx, userID)
+		c.Assert(err, qt.IsNil)
+		c.Assert(average.Value(), qt.Equals, int32(52))
+		c.Assert(average.WeightedValue() >= 99, qt.IsTrue, qt.Commentf("weighted %d", average.WeightedValue()))
+	})
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RefreshToken is a single-use token a user exchanges for a new access token and a new refresh
+// token. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	TokenHash []byte             `bson:"tokenHash"`
+	Expires   time.Time          `bson:"expires"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// RefreshTokenService provides methods to interact with the "refresh_tokens" collection.
+type RefreshTokenService struct {
+	Collection *mongo.Collection
+}
+
+// NewRefreshTokenService creates a new RefreshTokenService.
+func NewRefreshTokenService(db *Database) *RefreshTokenService {
+	return &RefreshTokenService{
+		Collection: db.Database.Collection("refresh_tokens"),
+	}
+}
+
+// Create stores a refresh token issued to the user.
+func (s *RefreshTokenService) Create(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	tokenHash []byte,
+	expires time.Time,
+) error {
+	_, err := s.Collection.InsertOne(ctx, &RefreshToken{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		Expires:   expires,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// Consume removes the refresh token with the given hash, if it has not expired at now, and returns
+// it. Otherwise it returns ErrInvalidRefreshToken, so each token is only exchanged once.
+func (s *RefreshTokenService) Consume(ctx context.Context, tokenHash []byte, now time.Time) (*RefreshToken, error) {
+	var token RefreshToken
+	err := s.Collection.FindOneAndDelete(ctx,
+		bson.M{"tokenHash": tokenHash, "expires": bson.M{"$gt": now}},
+	).Decode(&token)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Revoke removes the refresh token with the given hash if it was issued to the user.
+func (s *RefreshTokenService) Revoke(ctx context.Context, userID primitive.ObjectID, tokenHash []byte) error {
+	_, err := s.Collection.DeleteOne(ctx, bson.M{"userId": userID, "tokenHash": tokenHash})
+	return err
+}
+
+// RevokeAll removes all the refresh tokens issued to the user.
+func (s *RefreshTokenService) RevokeAll(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.Collection.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
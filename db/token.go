@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenTransactionType identifies the reason of a token movement.
+type TokenTransactionType string
+
+const (
+	// TokenTransactionTransfer is a direct transfer between users.
+	TokenTransactionTransfer TokenTransactionType = "TRANSFER"
+	// TokenTransactionDepositHold is a booking deposit taken from the requester into escrow.
+	TokenTransactionDepositHold TokenTransactionType = "DEPOSIT_HOLD"
+	// TokenTransactionDepositRelease is the part of a deposit given back to the requester.
+	TokenTransactionDepositRelease TokenTransactionType = "DEPOSIT_RELEASE"
+	// TokenTransactionDepositClaim is the part of a deposit claimed by the tool owner.
+	TokenTransactionDepositClaim TokenTransactionType = "DEPOSIT_CLAIM"
+	// TokenTransactionBookingCharge is the cost of a returned booking paid to the tool owner.
+	TokenTransactionBookingCharge TokenTransactionType = "BOOKING_CHARGE"
+)
+
+// EscrowState is the state of the tokens of a deposit hold.
+type EscrowState string
+
+const (
+	EscrowHeld     EscrowState = "HELD"
+	EscrowReleased EscrowState = "RELEASED"
+	EscrowClaimed  EscrowState = "CLAIMED"
+)
+
+// TokenTransaction is an entry of the token ledger of a user. Each movement between two users
+// is stored as two entries, a negative one for the sender and a positive one for the receiver.
+type TokenTransaction struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID         primitive.ObjectID   `bson:"userId" json:"userId"`
+	CounterpartyID primitive.ObjectID   `bson:"counterpartyId" json:"counterpartyId"`
+	Type           TokenTransactionType `bson:"type" json:"type"`
+	Amount         int64                `bson:"amount" json:"amount"`
+	BookingID      primitive.ObjectID   `bson:"bookingId,omitempty" json:"bookingId,omitempty"`
+	CreatedAt      time.Time            `bson:"createdAt" json:"createdAt"`
+	// Escrow is the state of a deposit hold, the tokens are in escrow while it is HELD
+	Escrow EscrowState `bson:"escrow,omitempty" json:"escrow,omitempty"`
+	// Claimed is the part of a settled deposit hold paid to the tool owner
+	Claimed uint64 `bson:"claimed,omitempty" json:"claimed,omitempty"`
+}
+
+// TokenService provides methods to interact with the "token_transactions" collection.
+type TokenService struct {
+	Collection *mongo.Collection
+}
+
+// NewTokenService creates a new TokenService.
+func NewTokenService(db *Database) *TokenService {
+	return &TokenService{
+		Collection: db.Database.Collection("token_transactions"),
+	}
+}
+
+// Create stores the ledger entries of a token movement.
+func (s *TokenService) Create(ctx context.Context, transactions ...*TokenTransaction) error {
+	docs := make([]interface{}, len(transactions))
+	now := time.Now()
+	for i, tx := range transactions {
+		if tx.CreatedAt.IsZero() {
+			tx.CreatedAt = now
+		}
+		docs[i] = tx
+	}
+	result, err := s.Collection.InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+	for i, id := range result.InsertedIDs {
+		transactions[i].ID = id.(primitive.ObjectID)
+	}
+	return nil
+}
+
+// Hold records the deposit of a booking taken from the user into escrow. The counterparty is
+// the tool owner, who may claim part of it when the booking is settled. Amounts over math.MaxInt64
+// return ErrInvalidTokenAmount, and ErrDepositAlreadyHeld is returned if the booking already has a
+// deposit in escrow.
+func (s *TokenService) Hold(
+	ctx context.Context,
+	userID, counterpartyID, bookingID primitive.ObjectID,
+	amount uint64,
+) (*TokenTransaction, error) {
+	if amount > math.MaxInt64 {
+		return nil, ErrInvalidTokenAmount
+	}
+	hold := &TokenTransaction{
+		UserID:         userID,
+		CounterpartyID: counterpartyID,
+		Type:           TokenTransactionDepositHold,
+		Amount:         -int64(amount),
+		BookingID:      bookingID,
+		Escrow:         EscrowHeld,
+	}
+	if err := s.Create(ctx, hold); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDepositAlreadyHeld
+		}
+		return nil, err
+	}
+	return hold, nil
+}
+
+// GetHold returns the deposit held in escrow for the booking, or ErrDepositNotHeld.
+func (s *TokenService) GetHold(ctx context.Context, bookingID primitive.ObjectID) (*TokenTransaction, error) {
+	var hold TokenTransaction
+	err := s.Collection.FindOne(ctx, bson.M{
+		"bookingId": bookingID,
+		"type":      TokenTransactionDepositHold,
+		"escrow":    EscrowHeld,
+	}).Decode(&hold)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDepositNotHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// Settle takes the deposit hold out of escrow, giving the claimed tokens to the counterparty and
+// the rest back to the user, and records both movements in the ledger. The balances are not
+// changed. It returns ErrDepositNotHeld if the hold was already settled.
+func (s *TokenService) Settle(ctx context.Context, hold *TokenTransaction, claimed uint64) error {
+	amount := uint64(-hold.Amount)
+	if claimed > amount {
+		return ErrInvalidDepositClaim
+	}
+	state := EscrowReleased
+	if claimed > 0 {
+		state = EscrowClaimed
+	}
+	result, err := s.Collection.UpdateOne(ctx, bson.M{
+		"_id":    hold.ID,
+		"escrow": EscrowHeld,
+	}, bson.M{
+		"$set": bson.M{"escrow": state, "claimed": claimed},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrDepositNotHeld
+	}
+	hold.Escrow, hold.Claimed = state, claimed
+
+	entries := []*TokenTransaction{}
+	if released := amount - claimed; released > 0 {
+		entries = append(entries, &TokenTransaction{
+			UserID:         hold.UserID,
+			CounterpartyID: hold.CounterpartyID,
+			Type:           TokenTransactionDepositRelease,
+			Amount:         int64(released),
+			BookingID:      hold.BookingID,
+		})
+	}
+	if claimed > 0 {
+		entries = append(entries, &TokenTransaction{
+			UserID:         hold.CounterpartyID,
+			CounterpartyID: hold.UserID,
+			Type:           TokenTransactionDepositClaim,
+			Amount:         int64(claimed),
+			BookingID:      hold.BookingID,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return s.Create(ctx, entries...)
+}
+
+// GetUserTransactions returns the ledger entries of the user, newest first.
+func (s *TokenService) GetUserTransactions(ctx context.Context, userID primitive.ObjectID) ([]*TokenTransaction, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"userId": userID},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	transactions := []*TokenTransaction{}
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
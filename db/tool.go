@@ -2,13 +2,21 @@ package db
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"math"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/emprius/emprius-app-backend/types"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -50,6 +58,131 @@ type Tool struct {
 	Height           uint32             `bson:"height" json:"height"`
 	Weight           uint32             `bson:"weight" json:"weight"`
 	ReservedDates    []DateRange        `bson:"reservedDates" json:"reservedDates"`
+	MinLeadHours     uint32             `bson:"minLeadHours,omitempty" json:"minLeadHours,omitempty"`
+	DepositTokens    uint64             `bson:"depositTokens,omitempty" json:"depositTokens,omitempty"`
+	CreatedAt        time.Time          `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	AutoReturn       bool               `bson:"autoReturn,omitempty" json:"autoReturn,omitempty"`
+	Featured         bool               `bson:"featured,omitempty" json:"featured,omitempty"`
+	Condition        ToolCondition      `bson:"condition,omitempty" json:"condition,omitempty"`
+	Deleted          bool               `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	History          []ToolEdit         `bson:"history,omitempty" json:"-"`
+	// Archived is set on the tools deleted by their owner while bookings still referenced them.
+	// They are also Deleted, so searches and listings leave them out, but GET /tools/{id} still
+	// returns them for the bookings to show.
+	Archived bool `bson:"archived,omitempty" json:"archived,omitempty"`
+	// AcceptVersion increases every time a booking of the tool is accepted, so concurrent
+	// acceptances can detect each other. See BookingService.Accept.
+	AcceptVersion int64 `bson:"acceptVersion,omitempty" json:"-"`
+	// IsNomadic marks a tool that passes directly from one borrower to the next instead of
+	// coming back to its owner between bookings.
+	IsNomadic bool `bson:"isNomadic,omitempty" json:"isNomadic,omitempty"`
+	// HolderID is the user keeping a nomadic tool once its bookings are returned, the requester
+	// of the next accepted booking. It is empty while the owner keeps it.
+	HolderID primitive.ObjectID `bson:"holderId,omitempty" json:"holderId,omitempty"`
+	// TimesBooked is the number of bookings that went ahead, computed on the responses that show it
+	TimesBooked int64 `bson:"-" json:"timesBooked"`
+	// Reputation summarizes the completed bookings of the tool and their ratings, computed on the
+	// tool detail only.
+	Reputation *ToolReputation `bson:"-" json:"reputation,omitempty"`
+	// Distance is the distance in meters from the user searching to the tool, computed on the
+	// search results. It is nil if the location of the tool or the user is not set.
+	Distance *int64 `bson:"-" json:"distance,omitempty"`
+	// TransportCost is the estimated cost in tokens of bringing the tool to the user searching, with
+	// the cheapest of the transport options searched. It is only computed when searching by transport.
+	TransportCost *uint64 `bson:"-" json:"transportCost,omitempty"`
+	// PrimaryImage is the hash of the cover image of the tool, the first of its Images, computed on
+	// the listings so they do not need all the hashes. As it is not stored, reordering or removing the
+	// images never leaves it pointing to an image the tool no longer has.
+	PrimaryImage types.HexBytes `bson:"-" json:"primaryImage,omitempty"`
+	// Unavailability are the periods the owner marked the tool as not available
+	Unavailability []DateRange `bson:"unavailability,omitempty" json:"unavailability,omitempty"`
+	// Terms are the conditions of use the requesters must accept to book the tool, if any
+	Terms string `bson:"terms,omitempty" json:"terms,omitempty"`
+	// ResponseDeadlineHours is the time the owner commits to answer the petitions of the tool in,
+	// see Booking.ResponseDue. Zero uses the default of the platform.
+	ResponseDeadlineHours uint32 `bson:"responseDeadlineHours,omitempty" json:"responseDeadlineHours,omitempty"`
+}
+
+// TermsVersion identifies the current text of the tool terms, so the acceptances recorded on the
+// bookings tell which terms were accepted. It is empty if the tool has no terms.
+func (t *Tool) TermsVersion() string {
+	if t.Terms == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(t.Terms))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Overlaps returns true if the range overlaps the period from start to end, see DatesOverlap.
+func (d DateRange) Overlaps(start, end time.Time) bool {
+	return DatesOverlap(time.Unix(int64(d.From), 0), time.Unix(int64(d.To), 0), start, end)
+}
+
+// UnavailableDuring returns true if any of the unavailability periods of the tool overlaps
+// the period from start to end.
+func (t *Tool) UnavailableDuring(start, end time.Time) bool {
+	for _, period := range t.Unavailability {
+		if period.Overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// notDeleted matches the documents whose deleted flag is not set.
+var notDeleted = bson.M{"$ne": true}
+
+// ToolCondition is the wear of a tool, as declared by its owner.
+type ToolCondition string
+
+const (
+	ToolConditionNew  ToolCondition = "new"
+	ToolConditionGood ToolCondition = "good"
+	ToolConditionFair ToolCondition = "fair"
+	ToolConditionPoor ToolCondition = "poor"
+)
+
+// ToolConditions lists the valid tool conditions, from best to worst.
+var ToolConditions = []ToolCondition{
+	ToolConditionNew,
+	ToolConditionGood,
+	ToolConditionFair,
+	ToolConditionPoor,
+}
+
+// Valid returns true if the condition is one of ToolConditions.
+func (c ToolCondition) Valid() bool {
+	return c.rank() >= 0
+}
+
+// AtLeast returns true if the condition is the same or better than minimum.
+// Unknown conditions, including unset ones, are never at least another condition.
+func (c ToolCondition) AtLeast(minimum ToolCondition) bool {
+	rank := c.rank()
+	return rank >= 0 && rank <= minimum.rank()
+}
+
+// rank returns the position of the condition in ToolConditions, or -1 if unknown.
+func (c ToolCondition) rank() int {
+	for i, condition := range ToolConditions {
+		if c == condition {
+			return i
+		}
+	}
+	return -1
+}
+
+// MaxToolHistory is the maximum number of edits kept in the tool history, older ones are dropped.
+const MaxToolHistory = 100
+
+// ToolEdit is a change of a tool field, stored in the tool history.
+// The old and new values are stored JSON encoded.
+type ToolEdit struct {
+	Field    string             `bson:"field" json:"field"`
+	OldValue string             `bson:"oldValue" json:"oldValue"`
+	NewValue string             `bson:"newValue" json:"newValue"`
+	EditorID primitive.ObjectID `bson:"editorId" json:"editorId"`
+	Date     time.Time          `bson:"date" json:"date"`
 }
 
 // SanitizeString removes all non-alphanumeric characters from a string, except for commas, dots, minus signs, and underscores.
@@ -93,9 +226,10 @@ func (s *ToolService) UpdateTool(ctx context.Context, id int64, update bson.M) (
 	return s.Collection.UpdateOne(ctx, filter, bson.M{"$set": update})
 }
 
-// SearchToolsByLocation retrieves tools within a specified radius (in meters) from a given location.
+// SearchToolsByLocation retrieves the tools, except the deleted ones, within a specified radius (in meters)
+// from a given location.
 func (s *ToolService) SearchToolsByLocation(ctx context.Context, location Location, radiusMeters int) ([]*Tool, error) {
-	cursor, err := s.Collection.Find(ctx, bson.M{})
+	cursor, err := s.Collection.Find(ctx, bson.M{"deleted": notDeleted})
 	if err != nil {
 		return nil, err
 	}
@@ -141,9 +275,111 @@ func (s *ToolService) GetAllTools(ctx context.Context) ([]*Tool, error) {
 	return tools, nil
 }
 
-// GetToolsByUserID retrieves all tools owned by a specific user.
+// GetToolsByUserID retrieves all the tools owned by a specific user, except the deleted ones.
 func (s *ToolService) GetToolsByUserID(ctx context.Context, userID primitive.ObjectID) ([]*Tool, error) {
-	cursor, err := s.Collection.Find(ctx, bson.M{"userId": userID})
+	cursor, err := s.Collection.Find(ctx, bson.M{"userId": userID, "deleted": notDeleted})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var tools []*Tool
+	for cursor.Next(ctx) {
+		var tool Tool
+		if err := cursor.Decode(&tool); err != nil {
+			return nil, err
+		}
+		tools = append(tools, &tool)
+	}
+	return tools, nil
+}
+
+// GetToolsByUserIDPage retrieves a page of the tools owned by a specific user, except the deleted
+// ones, sorted by ID, along with the total number of tools of the user.
+func (s *ToolService) GetToolsByUserIDPage(
+	ctx context.Context,
+	userID primitive.ObjectID,
+	page, pageSize int,
+) ([]*Tool, int64, error) {
+	filter := bson.M{"userId": userID, "deleted": notDeleted}
+	total, err := s.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.Collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(page*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	tools := []*Tool{}
+	if err := cursor.All(ctx, &tools); err != nil {
+		return nil, 0, err
+	}
+	return tools, total, nil
+}
+
+// GetToolsByUserIDs retrieves all the tools owned by any of the given users in a single query,
+// except the deleted ones.
+func (s *ToolService) GetToolsByUserIDs(ctx context.Context, userIDs []primitive.ObjectID) ([]*Tool, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"userId": bson.M{"$in": userIDs}, "deleted": notDeleted})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var tools []*Tool
+	for cursor.Next(ctx) {
+		var tool Tool
+		if err := cursor.Decode(&tool); err != nil {
+			return nil, err
+		}
+		tools = append(tools, &tool)
+	}
+	return tools, nil
+}
+
+// GetToolsByIDs retrieves all the tools matching the given IDs in a single query.
+func (s *ToolService) GetToolsByIDs(ctx context.Context, ids []int64) ([]*Tool, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var tools []*Tool
+	for cursor.Next(ctx) {
+		var tool Tool
+		if err := cursor.Decode(&tool); err != nil {
+			return nil, err
+		}
+		tools = append(tools, &tool)
+	}
+	return tools, nil
+}
+
+// GetAvailableToolsByCategory retrieves all the available tools of a category, except the deleted ones.
+func (s *ToolService) GetAvailableToolsByCategory(ctx context.Context, category int) ([]*Tool, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"toolCategory": category, "isAvailable": true, "deleted": notDeleted})
 	if err != nil {
 		return nil, err
 	}
@@ -172,17 +408,69 @@ func (s *ToolService) UpdateToolFields(ctx context.Context, id int64, updates ma
 	return err
 }
 
+// TransferOwnership moves a tool to a new owner, placed at the given location.
+func (s *ToolService) TransferOwnership(ctx context.Context, id int64, userID primitive.ObjectID, location Location) error {
+	result, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"userId":   userID,
+		"location": location,
+		"featured": false,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AddUnavailability adds a period to the unavailability periods of a tool.
+func (s *ToolService) AddUnavailability(ctx context.Context, id int64, period DateRange) error {
+	result, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$push": bson.M{"unavailability": period}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AddToolEdits appends the edits to the tool history, keeping only the last MaxToolHistory entries.
+func (s *ToolService) AddToolEdits(ctx context.Context, id int64, edits []ToolEdit) error {
+	if len(edits) == 0 {
+		return nil
+	}
+	filter := bson.M{"_id": id}
+	update := bson.M{"$push": bson.M{"history": bson.M{
+		"$each":  edits,
+		"$slice": -MaxToolHistory,
+	}}}
+	_, err := s.Collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
 // SearchToolsOptions represents the search criteria for tools.
 type SearchToolsOptions struct {
+	// Term is split in words, each of them matched case insensitively against the tool title or
+	// description, see termRelevance. The results are sorted by relevance.
+	Term             string
 	Categories       []int
 	MayBeFree        *bool
 	MaxCost          *uint64
 	Distance         int
 	Location         *Location
 	TransportOptions []int
+	// MinCondition excludes the tools in a worse or unknown condition, if set.
+	MinCondition ToolCondition
+	// Communities restricts the results to the tools whose owner belongs to any of the communities.
+	// Empty means any community.
+	Communities []string
 }
 
-// SearchTools searches for tools based on various criteria.
+// SearchTools searches for tools based on various criteria. Deleted tools and the tools of
+// deactivated users are never returned.
 func (s *ToolService) SearchTools(ctx context.Context, opts SearchToolsOptions) ([]*Tool, error) {
 	// Start with all tools
 	tools, err := s.GetAllTools(ctx)
@@ -190,9 +478,51 @@ func (s *ToolService) SearchTools(ctx context.Context, opts SearchToolsOptions)
 		return nil, err
 	}
 
+	words := termWords(opts.Term)
+	relevance := make(map[int64]int)
+
+	var owners map[primitive.ObjectID]bool
+	if len(opts.Communities) > 0 {
+		if owners, err = s.communityMembers(ctx, opts.Communities); err != nil {
+			return nil, err
+		}
+	}
+	deactivated, err := s.deactivatedUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Filter tools based on criteria
 	var filteredTools []*Tool
 	for _, tool := range tools {
+		if tool.Deleted {
+			continue
+		}
+
+		// Check search term
+		if len(words) > 0 {
+			score, ok := termRelevance(tool, words)
+			if !ok {
+				continue
+			}
+			relevance[tool.ID] = score
+		}
+
+		// Check the community of the owner
+		if owners != nil && !owners[tool.UserID] {
+			continue
+		}
+
+		// Hide the tools of the deactivated owners
+		if deactivated[tool.UserID] {
+			continue
+		}
+
+		// Check condition
+		if opts.MinCondition != "" && !tool.Condition.AtLeast(opts.MinCondition) {
+			continue
+		}
+
 		// Check categories
 		if len(opts.Categories) > 0 {
 			found := false
@@ -246,14 +576,157 @@ func (s *ToolService) SearchTools(ctx context.Context, opts SearchToolsOptions)
 		filteredTools = append(filteredTools, tool)
 	}
 
+	// The most relevant first, the ties keep the stored order
+	sort.SliceStable(filteredTools, func(i, j int) bool {
+		return relevance[filteredTools[i].ID] > relevance[filteredTools[j].ID]
+	})
 	return filteredTools, nil
 }
 
+// termWords splits the search term in lower case words.
+func termWords(term string) []string {
+	return strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// termRelevance reports whether all the words of the search term are found in the title or the
+// description of the tool, and how relevant the tool is: 3 if the title is the term, 2 if all the
+// words are in the title, 1 if some are and 0 if they are only in the description. The words match
+// partially and regardless of a plural "s", so "drill bits" matches "Bit drill".
+func termRelevance(tool *Tool, words []string) (int, bool) {
+	title := strings.Join(termWords(tool.Title), " ")
+	description := strings.ToLower(tool.Description)
+	contains := func(text, word string) bool {
+		if strings.Contains(text, word) {
+			return true
+		}
+		singular := strings.TrimSuffix(word, "s")
+		return len(singular) >= 3 && len(singular) < len(word) && strings.Contains(text, singular)
+	}
+	inTitle := 0
+	for _, word := range words {
+		switch {
+		case contains(title, word):
+			inTitle++
+		case !contains(description, word):
+			return 0, false
+		}
+	}
+	switch {
+	case title == strings.Join(words, " "):
+		return 3, true
+	case inTitle == len(words):
+		return 2, true
+	case inTitle > 0:
+		return 1, true
+	}
+	return 0, true
+}
+
+// communityMembers returns the IDs of the not deleted users of any of the communities.
+func (s *ToolService) communityMembers(ctx context.Context, communities []string) (map[primitive.ObjectID]bool, error) {
+	cursor, err := s.Collection.Database().Collection("users").Find(ctx,
+		bson.M{"community": bson.M{"$in": communities}, "deleted": notDeleted},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	members := make(map[primitive.ObjectID]bool, len(users))
+	for _, u := range users {
+		members[u.ID] = true
+	}
+	return members, nil
+}
+
+// deactivatedUsers returns the IDs of the users deactivated by the admins.
+func (s *ToolService) deactivatedUsers(ctx context.Context) (map[primitive.ObjectID]bool, error) {
+	cursor, err := s.Collection.Database().Collection("users").Find(ctx,
+		bson.M{"deactivated": true}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	deactivated := make(map[primitive.ObjectID]bool, len(users))
+	for _, u := range users {
+		deactivated[u.ID] = true
+	}
+	return deactivated, nil
+}
+
 // CountTools returns the total number of tools.
 func (s *ToolService) CountTools(ctx context.Context) (int64, error) {
 	return s.Collection.CountDocuments(ctx, bson.M{})
 }
 
+// CountUserTools returns the number of tools owned by the user. Deleted tools, archived ones
+// included, are not counted.
+func (s *ToolService) CountUserTools(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return s.Collection.CountDocuments(ctx, bson.M{"userId": userID, "deleted": notDeleted})
+}
+
+// CountToolsByCategory returns the number of tools of each category, indexed by category ID.
+// Deleted tools are not counted and the categories without tools are not present.
+func (s *ToolService) CountToolsByCategory(ctx context.Context) (map[int]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"deleted": notDeleted}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$toolCategory",
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cursor, err := s.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var groups []struct {
+		Category int   `bson:"_id"`
+		Count    int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int64, len(groups))
+	for _, group := range groups {
+		counts[group.Category] = group.Count
+	}
+	return counts, nil
+}
+
+// Approximate returns the center of the cell of a grid of the given size, in meters, the location
+// falls in. All the locations of a cell share the same approximate location, so the exact one cannot
+// be told from it. The cells span the same meters of longitude at any latitude. The zero location,
+// meaning it is not set, is returned as is.
+func (l Location) Approximate(gridMeters int) Location {
+	if l == (Location{}) || gridMeters <= 0 {
+		return l
+	}
+	center := func(value, step float64) int64 {
+		return int64(math.Round((math.Floor(value/step) + 0.5) * step))
+	}
+	latStep := float64(gridMeters) / 1000 / kilometersInDegree * microdegreesInDegree
+	latitude := center(float64(l.Latitude), latStep)
+	// The meridians converge to the poles, where the longitude step is capped
+	cos := math.Max(math.Cos(float64(latitude)/microdegreesInDegree*math.Pi/180), 0.01)
+	return Location{
+		Latitude:  latitude,
+		Longitude: center(float64(l.Longitude), latStep/cos),
+	}
+}
+
 // WithinCircumference calculates if two Location points are within the same geographic circumference
 // of diameter equal to the specified distance.
 // The function takes in three arguments:
@@ -263,6 +736,12 @@ func (s *ToolService) CountTools(ctx context.Context) (int64, error) {
 // The function returns a boolean value indicating whether the two Location points are within the same
 // circumference of diameter equal to the distance.
 func WithinCircumference(point1, point2 Location, distance int) bool {
+	// Check if the distance between the two points is within the given circumference
+	return Distance(point1, point2) <= float64(distance)
+}
+
+// Distance returns the distance in meters between two Location points, using the Haversine formula.
+func Distance(point1, point2 Location) float64 {
 	// Convert the latitude and longitude of both points to radians
 	lat1 := float64(point1.Latitude) / microdegreesInDegree * (math.Pi / 180)
 	long1 := float64(point1.Longitude) / microdegreesInDegree * (math.Pi / 180)
@@ -274,10 +753,7 @@ func WithinCircumference(point1, point2 Location, distance int) bool {
 		math.Cos(lat1)*math.Cos(lat2)*
 			math.Sin((long2-long1)/2)*math.Sin((long2-long1)/2)
 	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	d := earthRadius * c * 1000 // distance in meters
-
-	// Check if the distance between the two points is within the given circumference
-	return d <= float64(distance)
+	return earthRadius * c * 1000 // distance in meters
 }
 
 // NewLocation creates a new location that is a certain distance (in kilometers)
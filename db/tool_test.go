@@ -229,3 +229,49 @@ func TestToolService(t *testing.T) {
 		c.Assert(err, qt.Equals, mongo.ErrNoDocuments, qt.Commentf("Expected no documents error"))
 	})
 }
+
+func TestLocationApproximate(t *testing.T) {
+	c := qt.New(t)
+	home := Location{Latitude: 41688407, Longitude: 2491027}
+
+	// The approximate location is within the grid cell of the exact one
+	approx := home.Approximate(1000)
+	c.Assert(approx, qt.Not(qt.Equals), home)
+	c.Assert(Distance(home, approx) < 1000, qt.IsTrue, qt.Commentf("distance %f", Distance(home, approx)))
+	c.Assert(approx.Approximate(1000), qt.Equals, approx)
+
+	// Nearby locations share the same approximate location, so it does not tell them apart
+	c.Assert(NewLocation(approx, 0.1, 0.1).Approximate(1000), qt.Equals, approx)
+	c.Assert(NewLocation(approx, -0.1, -0.1).Approximate(1000), qt.Equals, approx)
+
+	// Unset locations and grids are left as is
+	c.Assert(Location{}.Approximate(1000), qt.Equals, Location{})
+	c.Assert(home.Approximate(0), qt.Equals, home)
+}
+
+func TestTermRelevance(t *testing.T) {
+	tool := func(title, description string) *Tool {
+		return &Tool{Title: title, Description: description}
+	}
+	for _, tc := range []struct {
+		term     string
+		tool     *Tool
+		score    int
+		matching bool
+	}{
+		{"drill", tool("Drill", ""), 3, true},
+		{"DRILL  bits", tool("Drill-bits", ""), 3, true},
+		{"drill bits", tool("Bit drill", ""), 2, true},
+		{"dri", tool("Cordless drill", ""), 2, true},
+		{"drill bits", tool("Drill", "A box of wood bits"), 1, true},
+		{"drill", tool("Hammer", "Comes with a drill"), 0, true},
+		{"drill saw", tool("Drill", "Nothing else"), 0, false},
+		{"saw", tool("Hammer", ""), 0, false},
+		// Too short to be a plural
+		{"as", tool("A", ""), 0, false},
+	} {
+		score, ok := termRelevance(tc.tool, termWords(tc.term))
+		qt.Assert(t, ok, qt.Equals, tc.matching, qt.Commentf("%q", tc.term))
+		qt.Assert(t, score, qt.Equals, tc.score, qt.Commentf("%q", tc.term))
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"math"
 
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,9 +10,22 @@ import (
 )
 
 // Transport represents the schema for the "transports" collection.
+// The tools only keep the ID of their transport options, the costs are the ones of the collection.
 type Transport struct {
 	ID   int64  `bson:"id" json:"id"`
 	Name string `bson:"name" json:"name"`
+	// BaseCost is the fixed amount of tokens of a trip, whatever its distance
+	BaseCost uint64 `bson:"baseCost,omitempty" json:"baseCost"`
+	// CostPerKm is the amount of tokens added for every started kilometer of the trip
+	CostPerKm uint64 `bson:"costPerKm,omitempty" json:"costPerKm"`
+}
+
+// EstimateCost returns the tokens the transport costs over the distance, in meters.
+func (t *Transport) EstimateCost(distance float64) uint64 {
+	if distance < 0 {
+		distance = 0
+	}
+	return t.BaseCost + uint64(math.Ceil(distance/1000))*t.CostPerKm
 }
 
 // TransportService provides methods to interact with the "transports" collection.
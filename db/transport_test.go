@@ -121,3 +121,13 @@ func TestTransportService(t *testing.T) {
 		c.Assert(err, qt.Not(qt.IsNil), qt.Commentf("Expected error when inserting duplicate transport ID"))
 	})
 }
+
+func TestTransportEstimateCost(t *testing.T) {
+	c := qt.New(t)
+	transport := &Transport{BaseCost: 5, CostPerKm: 2}
+	c.Assert(transport.EstimateCost(0), qt.Equals, uint64(5))
+	// Every started kilometer counts
+	c.Assert(transport.EstimateCost(1), qt.Equals, uint64(7))
+	c.Assert(transport.EstimateCost(1000), qt.Equals, uint64(7))
+	c.Assert(transport.EstimateCost(12500), qt.Equals, uint64(31))
+}
@@ -2,15 +2,23 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"time"
 
 	"github.com/emprius/emprius-app-backend/types"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// DefaultUserRating is the neutral rating stored for the users without ratings.
+const DefaultUserRating = 50
+
 // User represents the schema for the "users" collection.
 type User struct {
 	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
@@ -24,6 +32,47 @@ type User struct {
 	AvatarHash types.HexBytes     `bson:"avatarHash,omitempty" json:"avatarHash,omitempty"`
 	Location   Location           `bson:"location" json:"location"`
 	Verified   bool               `bson:"verified" json:"verified" default:"false"`
+	Admin      bool               `bson:"admin,omitempty" json:"admin,omitempty"`
+	Deleted    bool               `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	// Deactivated is set by the admins on abusive accounts, which can no longer log in and whose
+	// tools are hidden from the search. Unlike Active, the user cannot change it.
+	Deactivated bool `bson:"deactivated,omitempty" json:"deactivated,omitempty"`
+	// WeightedRating is the rating where recent ratings count more, see RatingService.DecayHalfLife.
+	WeightedRating int32 `bson:"weightedRating" json:"weightedRating" default:"50"`
+	// RatingCount is the number of ratings received, Rating and WeightedRating are neutral while it is zero.
+	RatingCount int64 `bson:"ratingCount" json:"ratingCount"`
+	// NotificationPreferences holds the notification types the user enabled or muted.
+	// Types not present are enabled.
+	NotificationPreferences map[NotificationType]bool `bson:"notificationPreferences,omitempty" json:"notificationPreferences,omitempty"`
+	// PasswordReset is the pending password reset of the user, if any.
+	PasswordReset *PasswordReset `bson:"passwordReset,omitempty" json:"-"`
+	// Verification is the pending email verification of the user, if any.
+	Verification *Verification `bson:"verification,omitempty" json:"-"`
+	// Transferred is the amount of tokens the user transferred to other users on TransferDay, a UTC
+	// date, see TransferTokensCapped.
+	Transferred uint64 `bson:"transferred,omitempty" json:"-"`
+	TransferDay string `bson:"transferDay,omitempty" json:"-"`
+	// AcceptVersion increases every time a booking requested by the user is accepted, so concurrent
+	// acceptances can detect each other. See BookingService.Accept.
+	AcceptVersion int64 `bson:"acceptVersion,omitempty" json:"-"`
+}
+
+// PasswordReset is a single-use password reset token. Only the hash of the token is stored.
+type PasswordReset struct {
+	TokenHash []byte    `bson:"tokenHash"`
+	Expires   time.Time `bson:"expires"`
+}
+
+// Verification is a single-use email verification token. Only the hash of the token is stored.
+type Verification struct {
+	TokenHash []byte    `bson:"tokenHash"`
+	Expires   time.Time `bson:"expires"`
+}
+
+// NotificationEnabled returns true if the user wants to receive notifications of the given type.
+func (u *User) NotificationEnabled(t NotificationType) bool {
+	enabled, ok := u.NotificationPreferences[t]
+	return !ok || enabled
 }
 
 // Validate checks if the user data meets the required constraints
@@ -43,6 +92,8 @@ func (u *User) Validate() error {
 // UserService provides methods to interact with the "users" collection.
 type UserService struct {
 	Collection *mongo.Collection
+	// Ratings aggregates the ratings received by the users, see RecalculateRating.
+	Ratings *RatingService
 }
 
 // NewUserService creates a new UserService.
@@ -74,6 +125,257 @@ func (s *UserService) UpdateUser(ctx context.Context, id primitive.ObjectID, upd
 	return s.Collection.UpdateOne(ctx, filter, bson.M{"$set": update})
 }
 
+// GrantAdmin gives admin privileges to the existing users with any of the emails. Only the users who
+// verified their email are granted, since anybody can register with any email.
+func (s *UserService) GrantAdmin(ctx context.Context, emails []string) error {
+	_, err := s.Collection.UpdateMany(ctx,
+		bson.M{"email": bson.M{"$in": emails}, "verified": true},
+		bson.M{"$set": bson.M{"admin": true}})
+	return err
+}
+
+// RecalculateRating updates the rating of the user with the average of all the ratings received,
+// scaled to the 0-100 range. Users without ratings get DefaultUserRating.
+func (s *UserService) RecalculateRating(ctx context.Context, id primitive.ObjectID) error {
+	average, err := s.Ratings.GetUserAverage(ctx, id)
+	if err != nil {
+		return err
+	}
+	rating, weighted := int32(DefaultUserRating), int32(DefaultUserRating)
+	if average.Count > 0 {
+		rating, weighted = average.Value(), average.WeightedValue()
+	}
+	result, err := s.UpdateUser(ctx, id, bson.M{
+		"rating":         rating,
+		"weightedRating": weighted,
+		"ratingCount":    average.Count,
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AdjustTokens adds delta tokens to the balance of the user, or removes them if delta is negative.
+// The balance never goes below zero: removing more tokens than available returns
+// ErrInsufficientTokens without changing the balance.
+func (s *UserService) AdjustTokens(ctx context.Context, id primitive.ObjectID, delta int64) error {
+	filter := bson.M{"_id": id}
+	if delta < 0 {
+		filter["tokens"] = bson.M{"$gte": -delta}
+	}
+	result, err := s.Collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"tokens": delta}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := s.Collection.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrUserNotFound
+		}
+		return ErrInsufficientTokens
+	}
+	return nil
+}
+
+// TransferTokens moves the amount of tokens from one user to the other. If the sender does not have
+// enough tokens it returns ErrInsufficientTokens and no balance changes, and if crediting the
+// receiver fails the sender is refunded. Amounts over math.MaxInt64 return ErrInvalidTokenAmount.
+func (s *UserService) TransferTokens(ctx context.Context, from, to primitive.ObjectID, amount uint64) error {
+	if amount > math.MaxInt64 {
+		return ErrInvalidTokenAmount
+	}
+	if err := s.AdjustTokens(ctx, from, -int64(amount)); err != nil {
+		return err
+	}
+	if err := s.AdjustTokens(ctx, to, int64(amount)); err != nil {
+		if rerr := s.AdjustTokens(ctx, from, int64(amount)); rerr != nil {
+			log.Error().Err(rerr).Msgf("could not refund %d tokens to user %s", amount, from.Hex())
+		}
+		return err
+	}
+	return nil
+}
+
+// transferDay returns the UTC date the daily transfer cap of a transfer at now counts on.
+func transferDay(now time.Time) string {
+	return now.UTC().Format(time.DateOnly)
+}
+
+// TransferTokensCapped is TransferTokens for the transfers between users, which add up to at most
+// limit tokens per user and UTC day. The cap is checked in the same write that takes the tokens from
+// the sender, so concurrent transfers cannot exceed it together. It returns ErrTransferCapExceeded
+// if the amount does not fit in what is left of the cap at now, and no balance changes.
+func (s *UserService) TransferTokensCapped(
+	ctx context.Context,
+	from, to primitive.ObjectID,
+	amount, limit uint64,
+	now time.Time,
+) error {
+	if amount > math.MaxInt64 {
+		return ErrInvalidTokenAmount
+	}
+	if amount > limit {
+		return ErrTransferCapExceeded
+	}
+	day := transferDay(now)
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{
+			"_id":    from,
+			"tokens": bson.M{"$gte": amount},
+			"$or": bson.A{
+				bson.M{"transferDay": bson.M{"$ne": day}},
+				bson.M{"transferred": bson.M{"$lte": limit - amount}},
+			},
+		},
+		// The expressions see the user before the update, so the previous transferDay
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$subtract": bson.A{"$tokens", int64(amount)}},
+			"transferred": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$transferDay", day}},
+				bson.M{"$add": bson.A{"$transferred", int64(amount)}},
+				int64(amount),
+			}},
+			"transferDay": day,
+		}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		user, err := s.GetUserByID(ctx, from)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if user.Tokens < amount {
+			return ErrInsufficientTokens
+		}
+		return ErrTransferCapExceeded
+	}
+	if err := s.AdjustTokens(ctx, to, int64(amount)); err != nil {
+		if rerr := s.releaseTransferred(ctx, from, amount, day); rerr != nil {
+			log.Error().Err(rerr).Msgf("could not refund %d tokens to user %s", amount, from.Hex())
+		}
+		return err
+	}
+	return nil
+}
+
+// UndoTransferCapped moves back the tokens of a transfer made with TransferTokensCapped at now, which
+// no longer count towards the daily cap of the sender.
+func (s *UserService) UndoTransferCapped(ctx context.Context, from, to primitive.ObjectID, amount uint64, now time.Time) error {
+	if err := s.AdjustTokens(ctx, to, -int64(amount)); err != nil {
+		return err
+	}
+	return s.releaseTransferred(ctx, from, amount, transferDay(now))
+}
+
+// releaseTransferred gives the amount back to the user, and back to what is left of the daily cap of
+// the day if it is still the one counted.
+func (s *UserService) releaseTransferred(ctx context.Context, id primitive.ObjectID, amount uint64, day string) error {
+	_, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id}, mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tokens": bson.M{"$add": bson.A{"$tokens", int64(amount)}},
+		"transferred": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$transferDay", day}},
+			bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{"$transferred", int64(amount)}}}},
+			"$transferred",
+		}},
+	}}}})
+	return err
+}
+
+// SetPasswordReset stores the password reset token hash of the not deleted user with the given email,
+// replacing any previous one. It returns ErrUserNotFound if there is no such user.
+func (s *UserService) SetPasswordReset(ctx context.Context, email string, tokenHash []byte, expires time.Time) error {
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{"email": email, "deleted": notDeleted},
+		bson.M{"$set": bson.M{"passwordReset": &PasswordReset{TokenHash: tokenHash, Expires: expires}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// ConsumePasswordReset removes the password reset with the given token hash, if it has not expired
+// at now, and returns the user it belonged to. Otherwise it returns ErrInvalidPasswordReset.
+func (s *UserService) ConsumePasswordReset(ctx context.Context, tokenHash []byte, now time.Time) (*User, error) {
+	var user User
+	err := s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"passwordReset.tokenHash": tokenHash, "passwordReset.expires": bson.M{"$gt": now}},
+		bson.M{"$unset": bson.M{"passwordReset": ""}},
+	).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidPasswordReset
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetVerification stores the email verification token hash of the not verified user, replacing any
+// previous one. It returns ErrUserNotFound if there is no such user or it is already verified.
+func (s *UserService) SetVerification(ctx context.Context, id primitive.ObjectID, tokenHash []byte, expires time.Time) error {
+	result, err := s.Collection.UpdateOne(ctx,
+		bson.M{"_id": id, "verified": false},
+		bson.M{"$set": bson.M{"verification": &Verification{TokenHash: tokenHash, Expires: expires}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkVerified marks as verified the user with the given verification token hash, if it has not expired
+// at now, and returns the user. The token is removed, so it can only be used once. Otherwise it returns
+// ErrInvalidVerification.
+func (s *UserService) MarkVerified(ctx context.Context, tokenHash []byte, now time.Time) (*User, error) {
+	var user User
+	err := s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"verification.tokenHash": tokenHash, "verification.expires": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"verified": true}, "$unset": bson.M{"verification": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrInvalidVerification
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdatePassword replaces the password hash of the user and discards any pending password reset.
+func (s *UserService) UpdatePassword(ctx context.Context, id primitive.ObjectID, password []byte) error {
+	result, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set":   bson.M{"password": password},
+		"$unset": bson.M{"passwordReset": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 // GetAllUsers retrieves all User documents.
 func (s *UserService) GetAllUsers(ctx context.Context) ([]*User, error) {
 	cursor, err := s.Collection.Find(ctx, bson.M{})
@@ -103,6 +405,88 @@ func (s *UserService) DeleteUser(ctx context.Context, id primitive.ObjectID) (*m
 	return s.Collection.DeleteOne(ctx, filter)
 }
 
+// DeletedUserName is the name and email prefix of the accounts deleted by their users, followed by
+// the user ID so they stay unique, see DeleteAccount.
+const DeletedUserName = "deleted-"
+
+// DeleteAccount deletes the account of the user on their request. The user is marked as deleted
+// and their personal data removed, but the document is kept: the bookings still reference it, so
+// the history of their counterparts stays intact. The pending and open bookings of the user, as
+// requester or owner, are cancelled and their tools archived, see Tool.Archived.
+// It returns ErrAccountHasAcceptedBookings without changing anything if the user takes part in any
+// accepted booking, and ErrUserNotFound if there is no such user. A pending booking accepted while
+// the account is deleted is found once the pending ones are cancelled, which they stay.
+func (s *UserService) DeleteAccount(ctx context.Context, id primitive.ObjectID) error {
+	database := s.Collection.Database()
+	bookings := database.Collection("bookings")
+	involved := bson.A{bson.M{"fromUserId": id}, bson.M{"toUserId": id}}
+	hasAccepted := func() error {
+		accepted, err := bookings.CountDocuments(ctx, bson.M{
+			"$or":           involved,
+			"bookingStatus": BookingStatusAccepted,
+		})
+		if err != nil {
+			return err
+		}
+		if accepted > 0 {
+			return ErrAccountHasAcceptedBookings
+		}
+		return nil
+	}
+	if err := hasAccepted(); err != nil {
+		return err
+	}
+
+	// Only the bookings still pending are cancelled, so none can be accepted afterwards, and those
+	// accepted meanwhile are found by checking again
+	if _, err := moveBookings(ctx, database, bson.M{
+		"$or":           involved,
+		"bookingStatus": bson.M{"$in": []BookingStatus{BookingStatusPending, BookingStatusOpen}},
+	}, BookingStatusCancelled, nil, id); err != nil {
+		return err
+	}
+	if err := hasAccepted(); err != nil {
+		return err
+	}
+	// The contact and comments of the requests are written by the requester
+	if _, err := bookings.UpdateMany(ctx, bson.M{"fromUserId": id},
+		bson.M{"$set": bson.M{"contact": "", "comments": ""}}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("tools").UpdateMany(ctx,
+		bson.M{"userId": id, "deleted": notDeleted},
+		bson.M{"$set": bson.M{"deleted": true, "archived": true}}); err != nil {
+		return err
+	}
+
+	// The user goes last, so a failed deletion can be retried with the same credentials
+	anonymous := DeletedUserName + id.Hex()
+	result, err := s.Collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{
+			"email":    anonymous,
+			"name":     anonymous,
+			"password": []byte{},
+			"location": Location{},
+			"active":   false,
+			"deleted":  true,
+		},
+		"$unset": bson.M{
+			"avatarHash":              "",
+			"community":               "",
+			"notificationPreferences": "",
+			"passwordReset":           "",
+			"verification":            "",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
 // CountUsers returns the total number of users.
 func (s *UserService) CountUsers(ctx context.Context) (int64, error) {
 	return s.Collection.CountDocuments(ctx, bson.M{})
@@ -118,3 +502,202 @@ func (s *UserService) GetUserByID(ctx context.Context, id primitive.ObjectID) (*
 	}
 	return &user, nil
 }
+
+// SearchUsersByName returns up to limit active and not deleted users whose name contains the term,
+// case insensitively, sorted by name.
+func (s *UserService) SearchUsersByName(ctx context.Context, term string, limit int) ([]*User, error) {
+	filter := bson.M{
+		"name":    bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"},
+		"active":  true,
+		"deleted": notDeleted,
+	}
+	cursor, err := s.Collection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	users := []*User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SearchUsers returns a page of the users whose name contains the term, ignoring case, and that
+// belong to the community, sorted by name, along with the total number of matches. An empty term
+// or community matches any user. If not nil, active and verified keep the users with those flags.
+// Deleted users are only included if includeDeleted is set. The password fields are never loaded.
+func (s *UserService) SearchUsers(
+	ctx context.Context,
+	term, community string,
+	active, verified *bool,
+	includeDeleted bool,
+	page, pageSize int,
+) ([]*User, int64, error) {
+	filter := bson.M{}
+	if term != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(term), "$options": "i"}
+	}
+	if community != "" {
+		filter["community"] = community
+	}
+	if active != nil {
+		filter["active"] = *active
+	}
+	if verified != nil {
+		filter["verified"] = *verified
+	}
+	if !includeDeleted {
+		filter["deleted"] = notDeleted
+	}
+	total, err := s.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.Collection.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{"password": 0, "passwordReset": 0}).
+		SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(page*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	users := []*User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// CountActiveUsersNear returns the number of active and not deleted users, other than the excluded one,
+// located within the radius in meters of any of the locations.
+func (s *UserService) CountActiveUsersNear(
+	ctx context.Context,
+	locations []Location,
+	radiusMeters int,
+	excludeID primitive.ObjectID,
+) (int64, error) {
+	if len(locations) == 0 {
+		return 0, nil
+	}
+	filter := bson.M{"active": true, "deleted": notDeleted, "_id": bson.M{"$ne": excludeID}}
+	cursor, err := s.Collection.Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"location": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	count := int64(0)
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return 0, err
+		}
+		for _, location := range locations {
+			if WithinCircumference(user.Location, location, radiusMeters) {
+				count++
+				break
+			}
+		}
+	}
+	return count, cursor.Err()
+}
+
+// GetTopRated returns a page of the active and not deleted users with at least minRatings ratings,
+// highest rated first, along with the total number of such users. The community is optional.
+func (s *UserService) GetTopRated(
+	ctx context.Context,
+	community string,
+	minRatings int64,
+	page, pageSize int,
+) ([]*User, int64, error) {
+	filter := bson.M{"active": true, "deleted": notDeleted, "ratingCount": bson.M{"$gte": minRatings}}
+	if community != "" {
+		filter["community"] = community
+	}
+	total, err := s.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	cursor, err := s.Collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "rating", Value: -1}, {Key: "ratingCount", Value: -1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(page*pageSize)).
+		SetLimit(int64(pageSize)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	users := []*User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// GetUsersByCommunity retrieves all the users of a community, except the deleted ones.
+func (s *UserService) GetUsersByCommunity(ctx context.Context, community string) ([]*User, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"community": community, "deleted": notDeleted})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var users []*User
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+// GetUsersByIDs retrieves all the Users matching the given IDs in a single query.
+func (s *UserService) GetUsersByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*User, error) {
+	cursor, err := s.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := cursor.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Error closing cursor")
+		}
+	}()
+
+	var users []*User
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, nil
+}
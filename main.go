@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
+	"github.com/emprius/emprius-app-backend/api"
+	"github.com/emprius/emprius-app-backend/db"
 	"github.com/emprius/emprius-app-backend/service"
 
 	"github.com/rs/zerolog/log"
@@ -23,6 +26,62 @@ func main() {
 	flag.String("secret", "", "sets the secret for JWT")
 	flag.String("mongo", "mongodb://localhost:27017", "sets the mongo URI")
 	flag.String("registerAuthToken", "", "sets the registerAuthToken new users need to provide")
+	flag.String("registrationMode", string(api.RegistrationToken),
+		"sets how new users register: token (the shared registerAuthToken), invite (single-use invites) or open")
+	flag.Duration("jwtExpiration", time.Hour, "sets the lifetime of the issued JWT access tokens")
+	flag.Duration("refreshExpiration", 720*time.Hour, "sets the lifetime of the issued refresh tokens")
+	flag.Int("searchDistance", 50000, "sets the default tool search radius in meters")
+	flag.StringToInt("communitySearchDistance", nil,
+		"sets the default tool search radius in meters per community (community=meters,...)")
+	flag.Bool("exclusivePending", false, "rejects new bookings overlapping a pending booking of the same tool")
+	flag.Bool("anonymousRatings", false, "hides who wrote the ratings shown on user profiles")
+	flag.Duration("ratingGracePeriod", 24*time.Hour, "sets how long ratings can be edited or deleted after creation")
+	flag.Duration("ratingHalfLife", 0, "sets the age at which a rating weights half in the weighted user rating (0 disables it)")
+	flag.Uint64("dailyTransferCap", 500, "sets the maximum amount of tokens a user can transfer to others per day")
+	flag.Duration("trendingWindow", 7*24*time.Hour, "sets how far back bookings count to rank the trending tools")
+	flag.Duration("workerInterval", 10*time.Minute, "sets how often the background tasks run")
+	flag.Duration("autoReturnDelay", time.Hour,
+		"sets the time after the end date the bookings of auto-return tools are marked as returned")
+	flag.Duration("reminderWindow", 24*time.Hour, "sets how long before the start date accepted bookings are reminded")
+	flag.Duration("overdueReminderDelay", 0,
+		"sets the time after the end date bookings not returned are reminded as overdue")
+	flag.Bool("uniqueToolTitles", false, "rejects new tools titled as another tool of the same owner")
+	flag.Int("maxBookingDays", 90, "sets the maximum duration of a booking in days")
+	flag.Duration("availabilityHorizon", 90*24*time.Hour,
+		"sets how far from the requested start the next available dates of a tool are looked for")
+	flag.Int("maxActiveBookings", 50, "sets the maximum number of accepted bookings a user can have as requester")
+	flag.Duration("petitionTTL", 7*24*time.Hour,
+		"sets the age after which pending petitions whose start date passed are expired")
+	flag.Duration("responseDeadline", 48*time.Hour,
+		"sets the time owners have to answer petitions, unless their tool sets its own deadline")
+	flag.Bool("autoRejectUnanswered", false, "rejects the petitions not answered by their response deadline")
+	flag.Int("maxImageBytes", 5<<20, "sets the maximum size in bytes of the uploaded images")
+	flag.Int("maxBodyBytes", 1<<20,
+		"sets the maximum size in bytes of the request bodies, raised by the images on the upload routes")
+	flag.Int("maxImageDimension", 4096, "sets the maximum width or height in pixels of the uploaded images")
+	flag.Duration("passwordResetTTL", time.Hour, "sets the time a password reset token can be used")
+	flag.Duration("verificationTTL", 48*time.Hour, "sets the time an email verification token can be used")
+	flag.Bool("requireVerification", false, "only lets users who verified their email create bookings")
+	flag.String("smtpAddr", "", "sets the host:port of the SMTP server sending the password resets and verifications")
+	flag.String("smtpUsername", "", "sets the username of the SMTP server")
+	flag.String("smtpPassword", "", "sets the password of the SMTP server")
+	flag.String("smtpFrom", "", "sets the sender address of the emails")
+	flag.String("appURL", "", "sets the frontend URL the links of the emails point to")
+	flag.Int("rateLimit", 300,
+		"sets the requests each user, or IP address on public routes, can make per window (-1 disables it)")
+	flag.Duration("rateLimitWindow", time.Minute, "sets the window of time the rate limit applies to")
+	flag.StringSlice("admins", nil, "sets the emails of the users granted admin privileges")
+	flag.StringSlice("corsOrigins", nil, "sets the origins allowed to make cross-origin requests (any if empty)")
+	flag.String("metricsAddr", "127.0.0.1:9090",
+		"sets the address the metrics endpoint listens on (empty serves it on the API port)")
+	flag.Int("locationPrecision", 1000,
+		"sets the grid in meters the locations shown to other users are snapped to (-1 shows the exact ones)")
+	flag.Float64("reliabilityLateCredit", db.DefaultReliabilityWeights.LateCredit,
+		"sets the success credited to a late return in the reliability score (0-1)")
+	flag.Float64("reliabilityCancellationWeight", db.DefaultReliabilityWeights.CancellationWeight,
+		"sets how much a cancellation lowers the reliability score compared to a return")
+	flag.Float64("reliabilityOverdueWeight", db.DefaultReliabilityWeights.OverdueWeight,
+		"sets how much an overdue booking lowers the reliability score compared to a return")
 	flag.Parse()
 
 	// Initialize Viper
@@ -36,7 +95,54 @@ func main() {
 	secret := viper.GetString("secret")
 	mongoURI := viper.GetString("mongo")
 	registerAuthToken := viper.GetString("registerAuthToken")
+	registrationMode := api.RegistrationMode(viper.GetString("registrationMode"))
 	debug := viper.GetBool("debug")
+	jwtExpiration := viper.GetDuration("jwtExpiration")
+	refreshExpiration := viper.GetDuration("refreshExpiration")
+	searchDistance := viper.GetInt("searchDistance")
+	exclusivePending := viper.GetBool("exclusivePending")
+	anonymousRatings := viper.GetBool("anonymousRatings")
+	ratingGracePeriod := viper.GetDuration("ratingGracePeriod")
+	ratingHalfLife := viper.GetDuration("ratingHalfLife")
+	dailyTransferCap := viper.GetUint64("dailyTransferCap")
+	trendingWindow := viper.GetDuration("trendingWindow")
+	workerInterval := viper.GetDuration("workerInterval")
+	autoReturnDelay := viper.GetDuration("autoReturnDelay")
+	reminderWindow := viper.GetDuration("reminderWindow")
+	overdueReminderDelay := viper.GetDuration("overdueReminderDelay")
+	uniqueToolTitles := viper.GetBool("uniqueToolTitles")
+	maxBookingDays := viper.GetInt("maxBookingDays")
+	availabilityHorizon := viper.GetDuration("availabilityHorizon")
+	maxActiveBookings := viper.GetInt("maxActiveBookings")
+	petitionTTL := viper.GetDuration("petitionTTL")
+	responseDeadline := viper.GetDuration("responseDeadline")
+	autoRejectUnanswered := viper.GetBool("autoRejectUnanswered")
+	maxImageBytes := viper.GetInt("maxImageBytes")
+	maxImageDimension := viper.GetInt("maxImageDimension")
+	maxBodyBytes := viper.GetInt("maxBodyBytes")
+	passwordResetTTL := viper.GetDuration("passwordResetTTL")
+	verificationTTL := viper.GetDuration("verificationTTL")
+	requireVerification := viper.GetBool("requireVerification")
+	smtpAddr := viper.GetString("smtpAddr")
+	rateLimit := viper.GetInt("rateLimit")
+	rateLimitWindow := viper.GetDuration("rateLimitWindow")
+	admins := viper.GetStringSlice("admins")
+	corsOrigins := viper.GetStringSlice("corsOrigins")
+	metricsAddr := viper.GetString("metricsAddr")
+	locationPrecision := viper.GetInt("locationPrecision")
+	reliabilityWeights := db.ReliabilityWeights{
+		LateCredit:         viper.GetFloat64("reliabilityLateCredit"),
+		CancellationWeight: viper.GetFloat64("reliabilityCancellationWeight"),
+		OverdueWeight:      viper.GetFloat64("reliabilityOverdueWeight"),
+	}
+	communitySearchDistance := make(map[string]int)
+	for community, distance := range viper.GetStringMap("communitySearchDistance") {
+		d, err := strconv.Atoi(fmt.Sprint(distance))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("invalid search distance for community %s", community)
+		}
+		communitySearchDistance[community] = d
+	}
 
 	// if no secret is provided, generate a random one
 	if secret == "" {
@@ -48,7 +154,10 @@ func main() {
 		log.Warn().Msgf("no secret provided, using %s", secret)
 	}
 
-	if registerAuthToken == "" {
+	if !registrationMode.Valid() {
+		log.Fatal().Msgf("invalid registration mode %q", registrationMode)
+	}
+	if registrationMode == api.RegistrationToken && registerAuthToken == "" {
 		sb := make([]byte, 20)
 		if _, err := rand.Read(sb); err != nil {
 			log.Fatal().Err(err).Msg("failed to generate random registerAuthToken")
@@ -57,9 +166,63 @@ func main() {
 		log.Warn().Msgf("no registerAuthToken provided, using %s", registerAuthToken)
 	}
 
+	var sendPasswordReset, sendVerification func(email, token string) error
+	if smtpAddr != "" {
+		mailer := &api.SMTPMailer{
+			Addr:     smtpAddr,
+			Username: viper.GetString("smtpUsername"),
+			Password: viper.GetString("smtpPassword"),
+			From:     viper.GetString("smtpFrom"),
+			AppURL:   viper.GetString("appURL"),
+		}
+		sendPasswordReset, sendVerification = mailer.SendPasswordReset, mailer.SendVerification
+	} else if requireVerification {
+		log.Fatal().Msg("requireVerification needs smtpAddr to send the verifications")
+	} else {
+		log.Warn().Msg("no smtpAddr provided, password resets and verifications are not available")
+	}
+
 	// create service
 	log.Info().Msgf("connecting to database at %s", mongoURI)
-	s, err := service.New(mongoURI, secret, registerAuthToken, debug)
+	s, err := service.New(mongoURI, secret, registerAuthToken, debug, &api.Options{
+		JWTExpiration:            jwtExpiration,
+		RefreshExpiration:        refreshExpiration,
+		DefaultSearchDistance:    searchDistance,
+		CommunitySearchDistance:  communitySearchDistance,
+		ExclusivePendingBookings: exclusivePending,
+		AnonymousRatings:         anonymousRatings,
+		RatingGracePeriod:        ratingGracePeriod,
+		RatingDecayHalfLife:      ratingHalfLife,
+		DailyTransferCap:         dailyTransferCap,
+		TrendingWindow:           trendingWindow,
+		ReliabilityWeights:       &reliabilityWeights,
+		WorkerInterval:           workerInterval,
+		AutoReturnDelay:          autoReturnDelay,
+		ReminderWindow:           reminderWindow,
+		OverdueReminderDelay:     overdueReminderDelay,
+		UniqueToolTitles:         uniqueToolTitles,
+		MaxBookingDays:           maxBookingDays,
+		AvailabilityHorizon:      availabilityHorizon,
+		MaxActiveBookings:        maxActiveBookings,
+		PetitionTTL:              petitionTTL,
+		ResponseDeadline:         responseDeadline,
+		AutoRejectUnanswered:     autoRejectUnanswered,
+		MaxImageBytes:            maxImageBytes,
+		MaxImageDimension:        maxImageDimension,
+		MaxBodyBytes:             maxBodyBytes,
+		PasswordResetTTL:         passwordResetTTL,
+		VerificationTTL:          verificationTTL,
+		RequireVerification:      requireVerification,
+		SendPasswordReset:        sendPasswordReset,
+		SendVerification:         sendVerification,
+		RateLimit:                rateLimit,
+		RateLimitWindow:          rateLimitWindow,
+		RegistrationMode:         registrationMode,
+		Admins:                   admins,
+		AllowedOrigins:           corsOrigins,
+		MetricsAddr:              metricsAddr,
+		LocationPrecision:        locationPrecision,
+	})
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create service")
 	}
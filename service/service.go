@@ -17,17 +17,25 @@ type Service struct {
 	API           *api.API
 	jwtSecret     string
 	registerToken string
+	apiOptions    *api.Options
+	stopWorker    context.CancelFunc
 }
 
 // Start starts the API service.
 func (s *Service) Start(host string, port int) {
-	s.API = api.New(s.jwtSecret, s.registerToken, s.Database)
+	s.API = api.New(s.jwtSecret, s.registerToken, s.Database, s.apiOptions)
 	s.API.Start(host, port)
+	var ctx context.Context
+	ctx, s.stopWorker = context.WithCancel(context.Background())
+	s.API.StartWorker(ctx)
 	log.Info().Msgf("api service started at %s:%d", host, port)
 }
 
 // Close closes the API service database.
 func (s *Service) Close() {
+	if s.stopWorker != nil {
+		s.stopWorker()
+	}
 	if err := s.Database.Close(context.Background()); err != nil {
 		log.Warn().Err(err).Msg("failed to close database")
 	}
@@ -37,7 +45,8 @@ func (s *Service) Close() {
 // It also sets the global log level to InfoLevel or DebugLevel if debug is true.
 // The service must be started with Service.Start().
 // The database must be closed with Service.Close().
-func New(dbPath, jwtSecret, registerToken string, debug bool) (*Service, error) {
+// The apiOptions are passed to the API on start, if nil the defaults are used.
+func New(dbPath, jwtSecret, registerToken string, debug bool, apiOptions *api.Options) (*Service, error) {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout}).With().Caller().Logger()
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if debug {
@@ -56,5 +65,6 @@ func New(dbPath, jwtSecret, registerToken string, debug bool) (*Service, error)
 		Database:      database,
 		jwtSecret:     jwtSecret,
 		registerToken: registerToken,
+		apiOptions:    apiOptions,
 	}, nil
 }
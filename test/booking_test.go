@@ -18,6 +18,7 @@ func TestBookings(t *testing.T) {
 	// Create two users: tool owner and renter
 	ownerJWT := c.RegisterAndLogin("owner@test.com", "owner", "ownerpass")
 	renterJWT := c.RegisterAndLogin("renter@test.com", "renter", "renterpass")
+	strangerJWT := c.RegisterAndLogin("stranger@test.com", "stranger", "strangerpass")
 
 	// Owner creates a tool
 	toolID := c.CreateTool(ownerJWT, "Test Tool")
@@ -73,6 +74,10 @@ func TestBookings(t *testing.T) {
 		_, code = c.Request(http.MethodPost, ownerJWT, nil, "bookings", "petitions", bookingID, "accept")
 		qt.Assert(t, code, qt.Equals, 200)
 
+		// Accepting again is a no-op (should succeed)
+		_, code = c.Request(http.MethodPost, ownerJWT, nil, "bookings", "petitions", bookingID, "accept")
+		qt.Assert(t, code, qt.Equals, 200)
+
 		// Try to create another overlapping booking (should fail since there's an accepted booking)
 		_, code = c.Request(http.MethodPost, renterJWT,
 			map[string]interface{}{
@@ -141,6 +146,10 @@ func TestBookings(t *testing.T) {
 		qt.Assert(t, err, qt.IsNil)
 		qt.Assert(t, bookingResp.Data.ID, qt.Equals, bookingID)
 
+		// Try to get the booking as a user not involved in it (should fail)
+		_, code = c.Request(http.MethodGet, strangerJWT, nil, "bookings", bookingID)
+		qt.Assert(t, code, qt.Equals, 403)
+
 		// Try to mark as returned by renter (should fail)
 		_, code = c.Request(http.MethodPost, renterJWT, nil, "bookings", bookingID, "return")
 		qt.Assert(t, code, qt.Equals, 403)
@@ -153,12 +162,20 @@ func TestBookings(t *testing.T) {
 		resp, code = c.Request(http.MethodGet, renterJWT, nil, "bookings", "rates")
 		qt.Assert(t, code, qt.Equals, 200)
 		var ratingsResp struct {
-			Data []api.BookingResponse `json:"data"`
+			Data []api.PendingRatingResponse `json:"data"`
 		}
 		err = json.Unmarshal(resp, &ratingsResp)
 		qt.Assert(t, err, qt.IsNil)
 		qt.Assert(t, len(ratingsResp.Data), qt.Equals, 1)
 
+		// Verify the counterparty and tool are embedded in the pending rating
+		qt.Assert(t, ratingsResp.Data[0].ID, qt.Equals, bookingID)
+		qt.Assert(t, ratingsResp.Data[0].Counterparty, qt.Not(qt.IsNil))
+		qt.Assert(t, ratingsResp.Data[0].Counterparty.Name, qt.Equals, "owner")
+		qt.Assert(t, ratingsResp.Data[0].Tool, qt.Not(qt.IsNil))
+		qt.Assert(t, ratingsResp.Data[0].Tool.Title, qt.Equals, "Test Tool")
+		qt.Assert(t, ratingsResp.Data[0].Tool.ID, qt.Equals, toolID)
+
 		// Submit rating
 		_, code = c.Request(http.MethodPost, renterJWT,
 			map[string]interface{}{